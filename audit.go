@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"flag"
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+
+	"pii-encrypt-example/pkg/audit"
+)
+
+// exampleAuditKeys are the keys once shipped in .env.example, anyone could forge a chain or a checkpoint with them.
+var exampleAuditKeys = []string{
+	"12345678901234567890123456789012",
+	"MDEyMzQ1Njc4OTAxMjM0NTY3ODkwMTIzNDU2Nzg5MDE=",
+}
+
+func isExampleAuditKey(key string) bool {
+	for _, example := range exampleAuditKeys {
+		if key == example {
+			return true
+		}
+	}
+	return false
+}
+
+// newAuditChain returns the chain of the audit log, the plaintext PII is never revealed without it.
+func newAuditChain(logger *logrus.Logger) *audit.Chain {
+	if cfg.Audit.ChainKey == "" {
+		logger.Fatal("AUDIT_CHAIN_KEY is required to record the pii accesses")
+	}
+	if isExampleAuditKey(cfg.Audit.ChainKey) {
+		logger.Fatal("AUDIT_CHAIN_KEY must not be the example value, generate a random one")
+	}
+	return audit.NewChain([]byte(cfg.Audit.ChainKey))
+}
+
+// auditSigningKey returns the private key signing the checkpoints, nil when it is not configured.
+func auditSigningKey() (privateKey ed25519.PrivateKey, err error) {
+	if cfg.Audit.SigningKey == "" {
+		return nil, nil
+	}
+	if isExampleAuditKey(cfg.Audit.SigningKey) {
+		return nil, fmt.Errorf("AUDIT_SIGNING_KEY must not be the example value, generate a random seed")
+	}
+	seed, err := base64.StdEncoding.DecodeString(cfg.Audit.SigningKey)
+	if err != nil || len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("AUDIT_SIGNING_KEY must be the base64 of a %d bytes seed", ed25519.SeedSize)
+	}
+	return ed25519.NewKeyFromSeed(seed), nil
+}
+
+// auditVerifyKey returns the public key verifying the checkpoints, nil when it is not configured.
+// It is never derived from the signing key, whoever holds the signing key could forge checkpoints verifying against it.
+func auditVerifyKey() (publicKey ed25519.PublicKey, err error) {
+	if cfg.Audit.VerifyKey == "" {
+		return nil, nil
+	}
+	key, err := base64.StdEncoding.DecodeString(cfg.Audit.VerifyKey)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("AUDIT_VERIFY_KEY must be the base64 of a %d bytes public key", ed25519.PublicKeySize)
+	}
+	return ed25519.PublicKey(key), nil
+}
+
+// runVerifyAudit walks the chain of the audit log and reports its first broken link, it fails when there is one.
+func runVerifyAudit(logger *logrus.Logger, args []string) (err error) {
+	flags := flag.NewFlagSet("verify-audit", flag.ContinueOnError)
+	unsigned := flags.Bool("unsigned", false, "walk the chain without verifying the signatures of the checkpoints")
+	if err = flags.Parse(args); err != nil {
+		return
+	}
+
+	publicKey, err := auditVerifyKey()
+	if err != nil {
+		return
+	}
+	if publicKey == nil {
+		if !*unsigned {
+			return fmt.Errorf("verify-audit: AUDIT_VERIFY_KEY is required to verify the checkpoints, or -unsigned to walk the chain only")
+		}
+		logger.Warn("verify-audit: the signatures of the checkpoints are not verified")
+	}
+
+	databases := openUserDatabases(logger)
+	defer databases.Close()
+
+	report, err := audit.Verify(context.Background(), databases.auditRepository, newAuditChain(logger), publicKey)
+	if err != nil {
+		return
+	}
+
+	fmt.Printf("records: %d, written before the chain: %d, checkpoints: %d\n", report.Accesses, report.Unchained, report.Checkpoints)
+	if report.Broken != nil {
+		fmt.Printf("first broken link: %s\n", report.Broken)
+		return fmt.Errorf("verify-audit: the audit log has been tampered with")
+	}
+	fmt.Println("the audit log is intact")
+	return
+}
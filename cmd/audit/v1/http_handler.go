@@ -0,0 +1,62 @@
+package audit
+
+import (
+	"net/http"
+	"pii-encrypt-example/pkg/audit"
+	"pii-encrypt-example/pkg/exception"
+	"pii-encrypt-example/pkg/middleware"
+	"pii-encrypt-example/pkg/response"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+)
+
+type AuditHTTPHandler struct {
+	logger       *logrus.Logger
+	auditUsecase AuditUsecase
+}
+
+// NewAuditHTTPHandler registers the endpoints of the audit log, they must be guarded by the admin credentials.
+func NewAuditHTTPHandler(logger *logrus.Logger, router *mux.Router, adminAuth middleware.RouteMiddleware, auditUsecase AuditUsecase) {
+	handler := &AuditHTTPHandler{
+		logger:       logger,
+		auditUsecase: auditUsecase,
+	}
+	router.HandleFunc("/api/v1/audit/pii-access", adminAuth.Verify(handler.GetManyPIIAccess)).Methods(http.MethodGet)
+}
+
+// GetManyPIIAccess lists the records of the audit log, filtered by principal, user_uuid, from and to (RFC 3339) and limit.
+func (h AuditHTTPHandler) GetManyPIIAccess(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	queryString := r.URL.Query()
+
+	filter := audit.AccessFilter{
+		Principal: queryString.Get("principal"),
+		UserUUID:  queryString.Get("user_uuid"),
+	}
+
+	var err error
+	if from := queryString.Get("from"); from != "" {
+		if filter.From, err = time.Parse(time.RFC3339, from); err != nil {
+			response.JSON(w, response.NewErrorResponse(exception.ErrBadRequest, http.StatusBadRequest, nil, response.StatusInvalidPayload, "from must be a RFC 3339 time"))
+			return
+		}
+	}
+	if to := queryString.Get("to"); to != "" {
+		if filter.To, err = time.Parse(time.RFC3339, to); err != nil {
+			response.JSON(w, response.NewErrorResponse(exception.ErrBadRequest, http.StatusBadRequest, nil, response.StatusInvalidPayload, "to must be a RFC 3339 time"))
+			return
+		}
+	}
+	if limit := queryString.Get("limit"); limit != "" {
+		if filter.Limit, err = strconv.Atoi(limit); err != nil || filter.Limit < 1 {
+			response.JSON(w, response.NewErrorResponse(exception.ErrBadRequest, http.StatusBadRequest, nil, response.StatusInvalidPayload, "limit must be a positive number"))
+			return
+		}
+	}
+
+	resp := h.auditUsecase.GetManyPIIAccess(ctx, filter)
+	response.JSON(w, resp)
+}
@@ -0,0 +1,18 @@
+package audit
+
+import "time"
+
+// PIIAccessResponse is a record of the audit log of the accesses to plaintext PII.
+type PIIAccessResponse struct {
+	ID            int64     `json:"id,omitempty"`
+	Principal     string    `json:"principal"`
+	RemoteAddress string    `json:"remoteAddress"`
+	XForwardedFor string    `json:"xForwardedFor"`
+	XRealIP       string    `json:"xRealIp"`
+	UserAgent     string    `json:"userAgent"`
+	ClientIP      string    `json:"clientIp"`
+	UserUUIDs     []string  `json:"userUuids"`
+	Fields        []string  `json:"fields"`
+	Purpose       string    `json:"purpose"`
+	CreatedAt     time.Time `json:"createdAt"`
+}
@@ -0,0 +1,64 @@
+package audit
+
+import (
+	"context"
+	"net/http"
+	"pii-encrypt-example/pkg/audit"
+	"pii-encrypt-example/pkg/response"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	defaultLimit = 100
+	maxLimit     = 1000
+)
+
+type AuditUsecase interface {
+	GetManyPIIAccess(ctx context.Context, filter audit.AccessFilter) (resp response.Response)
+}
+
+type auditUsecase struct {
+	logger          *logrus.Logger
+	auditRepository audit.AuditRepository
+}
+
+func NewAuditUsecase(logger *logrus.Logger, auditRepository audit.AuditRepository) AuditUsecase {
+	return &auditUsecase{
+		logger:          logger,
+		auditRepository: auditRepository,
+	}
+}
+
+// GetManyPIIAccess implements AuditUsecase, the latest records first.
+func (u *auditUsecase) GetManyPIIAccess(ctx context.Context, filter audit.AccessFilter) (resp response.Response) {
+	if filter.Limit <= 0 {
+		filter.Limit = defaultLimit
+	}
+	filter.Limit = min(filter.Limit, maxLimit)
+
+	result, err := u.auditRepository.FindManyAccess(ctx, filter)
+	if err != nil {
+		u.logger.WithContext(ctx).Error(err)
+		return response.NewErrorResponse(err, http.StatusInternalServerError, nil, response.StatUnexpectedError, "")
+	}
+
+	accessesResponse := make([]PIIAccessResponse, len(result))
+	for i, v := range result {
+		accessesResponse[i] = PIIAccessResponse{
+			ID:            v.ID,
+			Principal:     v.Principal,
+			RemoteAddress: v.RemoteAddress,
+			XForwardedFor: v.XForwardedFor,
+			XRealIP:       v.XRealIP,
+			UserAgent:     v.UserAgent,
+			ClientIP:      v.ClientIP,
+			UserUUIDs:     v.UserUUIDs,
+			Fields:        v.Fields,
+			Purpose:       v.Purpose,
+			CreatedAt:     v.CreatedAt,
+		}
+	}
+
+	return response.NewSuccessResponse(accessesResponse, response.StatOK, "")
+}
@@ -0,0 +1,140 @@
+package document
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"pii-encrypt-example/pkg/exception"
+	"pii-encrypt-example/pkg/middleware"
+	"pii-encrypt-example/pkg/response"
+	customvalidator "pii-encrypt-example/pkg/validator"
+	"strconv"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// maxDocumentSize is the largest document accepted, in bytes.
+	maxDocumentSize = 10 << 20
+
+	invalidPayloadMessage = "invalid payload"
+	missingFileMessage    = "the multipart form must have a file part"
+)
+
+type DocumentHTTPHandler struct {
+	logger          *logrus.Logger
+	validator       *validator.Validate
+	documentUsecase DocumentUsecase
+}
+
+func NewDocumentHTTPHandler(logger *logrus.Logger, router *mux.Router, basicAuth middleware.RouteMiddleware, validator *validator.Validate, documentUsecase DocumentUsecase) {
+	handler := &DocumentHTTPHandler{
+		logger:          logger,
+		validator:       validator,
+		documentUsecase: documentUsecase,
+	}
+	router.HandleFunc("/api/v1/user/{userUuid}/document", basicAuth.Verify(handler.GetManyDocuments)).Methods(http.MethodGet)
+	router.HandleFunc("/api/v1/user/{userUuid}/document", basicAuth.Verify(handler.UploadDocument)).Methods(http.MethodPost)
+	router.HandleFunc("/api/v1/user/{userUuid}/document/{uuid}", basicAuth.Verify(handler.DownloadDocument)).Methods(http.MethodGet)
+}
+
+// UploadDocument reads the document either from the "file" part of a multipart form or from the raw request body,
+// its type is given by the "type" query string.
+func (h DocumentHTTPHandler) UploadDocument(w http.ResponseWriter, r *http.Request) {
+	var resp response.Response
+
+	ctx := r.Context()
+	payload := DocumentRequest{
+		UserUUID: mux.Vars(r)["userUuid"],
+		Type:     r.URL.Query().Get("type"),
+	}
+
+	if err := h.validator.Struct(payload); err != nil {
+		resp = response.NewErrorResponse(exception.ErrBadRequest, http.StatusBadRequest, customvalidator.FieldErrors(err), response.StatusInvalidPayload, invalidPayloadMessage)
+		response.JSON(w, resp)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxDocumentSize)
+	file, err := documentFile(r)
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		resp = response.NewErrorResponse(exception.ErrPayloadTooLarge, http.StatusRequestEntityTooLarge, nil, response.StatPayloadTooLarge, fmt.Sprintf("document must not be larger than %d bytes", maxBytesErr.Limit))
+		response.JSON(w, resp)
+		return
+	}
+	if err != nil {
+		resp = response.NewErrorResponse(exception.ErrBadRequest, http.StatusBadRequest, nil, response.StatusInvalidPayload, err.Error())
+		response.JSON(w, resp)
+		return
+	}
+
+	resp = h.documentUsecase.UploadDocument(ctx, payload, file)
+	response.JSON(w, resp)
+}
+
+func (h DocumentHTTPHandler) GetManyDocuments(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	resp := h.documentUsecase.GetManyDocuments(ctx, mux.Vars(r)["userUuid"])
+	response.JSON(w, resp)
+}
+
+func (h DocumentHTTPHandler) DownloadDocument(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	vars := mux.Vars(r)
+
+	file, document, resp := h.documentUsecase.OpenDocument(ctx, vars["userUuid"], vars["uuid"])
+	if resp != nil {
+		response.JSON(w, resp)
+		return
+	}
+	defer file.Close()
+
+	extensions, _ := mime.ExtensionsByType(document.ContentType)
+	filename := document.UUID
+	if len(extensions) > 0 {
+		filename += extensions[0]
+	}
+
+	w.Header().Set("Content-Type", document.ContentType)
+	w.Header().Set("Content-Length", strconv.FormatInt(document.Size, 10))
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+
+	// the status is already sent, the response is aborted so the client sees a truncated download,
+	// the file withholds its last bytes when their digest does not match.
+	if _, err := io.Copy(w, file); err != nil {
+		h.logger.WithContext(ctx).Error(err)
+		panic(http.ErrAbortHandler)
+	}
+}
+
+func documentFile(r *http.Request) (io.Reader, error) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "multipart/form-data" {
+		return r.Body, nil
+	}
+
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return nil, errors.New(missingFileMessage)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if part.FormName() == "file" {
+			return part, nil
+		}
+	}
+}
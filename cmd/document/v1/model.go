@@ -0,0 +1,26 @@
+package document
+
+import "time"
+
+// Types of document.
+const (
+	DocumentTypeIDCard = "ID_CARD"
+	DocumentTypeSelfie = "SELFIE"
+)
+
+// DocumentRequest describes a document being uploaded, its content is streamed separately.
+type DocumentRequest struct {
+	UserUUID string `json:"userUuid" validate:"required,uuid"`
+	Type     string `json:"type" validate:"required,oneof=ID_CARD SELFIE"`
+}
+
+type DocumentResponse struct {
+	UUID        string    `json:"uuid"`
+	UserUUID    string    `json:"userUuid"`
+	Type        string    `json:"type"`
+	ContentType string    `json:"contentType"`
+	Size        int64     `json:"size"`
+	SHA256      string    `json:"sha256"`
+	KeyID       string    `json:"keyId"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
@@ -0,0 +1,202 @@
+package document
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"pii-encrypt-example/entity"
+	"pii-encrypt-example/pkg/database"
+	"pii-encrypt-example/pkg/exception"
+
+	"github.com/sirupsen/logrus"
+)
+
+const documentSelectColumns = `d.uuid, d.user_uuid, d.type, d.content_type, d.size, d.sha256, d.key_id, d.wrapped_key, d.object_path, d.created_at`
+
+type DocumentRepository interface {
+	SaveDocument(ctx context.Context, document entity.Document, tx *sql.Tx) (err error)
+	FindOneDocument(ctx context.Context, userUUID string, uuid string) (document entity.Document, err error)
+	FindManyDocumentByUser(ctx context.Context, userUUID string) (documents []entity.Document, err error)
+	UserExists(ctx context.Context, userUUID string) (exists bool, err error)
+	FindManyDocumentByOtherKey(ctx context.Context, keyID string) (documents []entity.Document, err error)
+	UpdateDocumentKey(ctx context.Context, document entity.Document, tx *sql.Tx) (err error)
+}
+
+type sqlCommand interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	PrepareContext(ctx context.Context, query string) (*sql.Stmt, error)
+}
+
+type documentRepository struct {
+	logger        *logrus.Logger
+	dialect       database.Dialect
+	dbReadOnly    *sql.DB
+	dbReadWrite   *sql.DB
+	tableName     string
+	userTableName string
+}
+
+// NewDocumentRepository is a constructor, the queries are written for the dialect of the databases.
+func NewDocumentRepository(logger *logrus.Logger, dialect database.Dialect, dbReadOnly *sql.DB, dbReadWrite *sql.DB, tableName string, userTableName string) DocumentRepository {
+	return &documentRepository{
+		logger:        logger,
+		dialect:       dialect,
+		dbReadOnly:    dbReadOnly,
+		dbReadWrite:   dbReadWrite,
+		tableName:     tableName,
+		userTableName: userTableName,
+	}
+}
+
+// SaveDocument records the metadata of an uploaded document.
+func (r *documentRepository) SaveDocument(ctx context.Context, document entity.Document, tx *sql.Tx) (err error) {
+	var cmd sqlCommand = r.dbReadWrite
+	if tx != nil {
+		cmd = tx
+	}
+
+	command := fmt.Sprintf(`INSERT INTO %s (uuid, user_uuid, type, content_type, size, sha256, key_id, wrapped_key, object_path, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`, r.tableName)
+	_, err = r.exec(ctx, cmd, command, document.UUID, document.UserUUID, document.Type, document.ContentType, document.Size, document.SHA256, document.KeyID, document.WrappedKey, document.ObjectPath, document.CreatedAt)
+	if err != nil {
+		err = r.wrapError(err)
+		return
+	}
+
+	return
+}
+
+func (r *documentRepository) FindOneDocument(ctx context.Context, userUUID string, uuid string) (document entity.Document, err error) {
+	var cmd sqlCommand = r.dbReadOnly
+
+	q := fmt.Sprintf(`SELECT %s FROM %s d WHERE d.user_uuid = ? AND d.uuid = ?`, documentSelectColumns, r.tableName)
+	documents, err := r.query(ctx, cmd, q, userUUID, uuid)
+	if err != nil {
+		err = r.wrapError(err)
+		return
+	}
+	if len(documents) == 0 {
+		err = exception.ErrNotFound
+		return
+	}
+
+	return documents[0], nil
+}
+
+func (r *documentRepository) FindManyDocumentByUser(ctx context.Context, userUUID string) (documents []entity.Document, err error) {
+	var cmd sqlCommand = r.dbReadOnly
+
+	q := fmt.Sprintf(`SELECT %s FROM %s d WHERE d.user_uuid = ? ORDER BY d.created_at`, documentSelectColumns, r.tableName)
+	documents, err = r.query(ctx, cmd, q, userUUID)
+	if err != nil {
+		err = r.wrapError(err)
+		return
+	}
+	return
+}
+
+// FindManyDocumentByOtherKey returns the documents whose data key is wrapped by another key than keyID.
+func (r *documentRepository) FindManyDocumentByOtherKey(ctx context.Context, keyID string) (documents []entity.Document, err error) {
+	var cmd sqlCommand = r.dbReadWrite
+
+	q := fmt.Sprintf(`SELECT %s FROM %s d WHERE d.key_id <> ? ORDER BY d.created_at`, documentSelectColumns, r.tableName)
+	documents, err = r.query(ctx, cmd, q, keyID)
+	if err != nil {
+		err = r.wrapError(err)
+		return
+	}
+	return
+}
+
+// UpdateDocumentKey replaces the wrapped data key of the document and the id of the key wrapping it.
+func (r *documentRepository) UpdateDocumentKey(ctx context.Context, document entity.Document, tx *sql.Tx) (err error) {
+	var cmd sqlCommand = r.dbReadWrite
+	if tx != nil {
+		cmd = tx
+	}
+
+	command := fmt.Sprintf(`UPDATE %s SET key_id = ?, wrapped_key = ? WHERE uuid = ?`, r.tableName)
+	_, err = r.exec(ctx, cmd, command, document.KeyID, document.WrappedKey, document.UUID)
+	if err != nil {
+		err = r.wrapError(err)
+		return
+	}
+
+	return
+}
+
+// UserExists tells whether the owner of a document exists, before anything gets uploaded.
+func (r *documentRepository) UserExists(ctx context.Context, userUUID string) (exists bool, err error) {
+	var cmd sqlCommand = r.dbReadOnly
+	var one int
+
+	q := fmt.Sprintf(`SELECT 1 FROM %s WHERE uuid = ?`, r.userTableName)
+	err = cmd.QueryRowContext(ctx, r.dialect.Rebind(q), userUUID).Scan(&one)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		r.logger.WithContext(ctx).Error(q, err)
+		return false, r.wrapError(err)
+	}
+	return true, nil
+}
+
+func (r *documentRepository) query(ctx context.Context, cmd sqlCommand, query string, args ...interface{}) (documents []entity.Document, err error) {
+	var rows *sql.Rows
+	if rows, err = cmd.QueryContext(ctx, r.dialect.Rebind(query), args...); err != nil {
+		r.logger.WithContext(ctx).Error(query, err)
+		return
+	}
+
+	defer func() {
+		if err := rows.Close(); err != nil {
+			r.logger.WithContext(ctx).Error(query, err)
+		}
+	}()
+
+	for rows.Next() {
+		var document entity.Document
+
+		err = rows.Scan(&document.UUID, &document.UserUUID, &document.Type, &document.ContentType, &document.Size, &document.SHA256, &document.KeyID, &document.WrappedKey, &document.ObjectPath, &document.CreatedAt)
+
+		if err != nil {
+			r.logger.WithContext(ctx).Error(query, err)
+			return
+		}
+
+		documents = append(documents, document)
+	}
+
+	return
+}
+
+func (r *documentRepository) exec(ctx context.Context, cmd sqlCommand, command string, args ...interface{}) (result sql.Result, err error) {
+	var stmt *sql.Stmt
+	if stmt, err = cmd.PrepareContext(ctx, r.dialect.Rebind(command)); err != nil {
+		r.logger.WithContext(ctx).Error(command, err)
+		return
+	}
+	defer func() {
+		if err := stmt.Close(); err != nil {
+			r.logger.WithContext(ctx).Error(command, err)
+		}
+	}()
+
+	if result, err = stmt.ExecContext(ctx, args...); err != nil {
+		r.logger.WithContext(ctx).Error(command, err)
+	}
+
+	return
+}
+
+func (r *documentRepository) wrapError(e error) (err error) {
+	if e == sql.ErrNoRows {
+		return exception.ErrNotFound
+	}
+	if _, ok := r.dialect.DuplicateKey(e); ok {
+		return exception.ErrConflict
+	}
+	return exception.ErrInternalServer
+}
@@ -0,0 +1,310 @@
+package document
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"pii-encrypt-example/entity"
+	"pii-encrypt-example/pkg/audit"
+	"pii-encrypt-example/pkg/crypto"
+	"pii-encrypt-example/pkg/exception"
+	"pii-encrypt-example/pkg/response"
+	"pii-encrypt-example/pkg/storage"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	documentObjectPrefix   = "documents/"
+	documentObjectEncoding = "piis-v1"
+)
+
+var errDigestMismatch = errors.New("document digest does not match its content")
+
+// allowedContentTypes are the content types accepted for a document, detected from the content itself.
+var allowedContentTypes = map[string]struct{}{
+	"image/jpeg":      {},
+	"image/png":       {},
+	"application/pdf": {},
+}
+
+type DocumentUsecase interface {
+	UploadDocument(ctx context.Context, documentRequest DocumentRequest, file io.Reader) (resp response.Response)
+	GetManyDocuments(ctx context.Context, userUUID string) (resp response.Response)
+	// OpenDocument returns the decrypted content of a document, resp is only set when it cannot be opened.
+	// The access is recorded in the audit log before the content is returned.
+	OpenDocument(ctx context.Context, userUUID string, uuid string) (file io.ReadCloser, documentResponse DocumentResponse, resp response.Response)
+	// RotateKeys wraps again with the primary key every data key wrapped with another key, the objects are left untouched.
+	RotateKeys(ctx context.Context) (rotated int, err error)
+}
+
+type documentUsecase struct {
+	logger             *logrus.Logger
+	location           *time.Location
+	crypto             crypto.Crypto
+	storage            storage.Storage
+	bucketName         string
+	auditor            *audit.Auditor
+	documentRepository DocumentRepository
+}
+
+// NewDocumentUsecase is a constructor, every document opened is recorded by the auditor.
+func NewDocumentUsecase(logger *logrus.Logger, location *time.Location, crypto crypto.Crypto, storage storage.Storage, bucketName string, auditor *audit.Auditor, documentRepository DocumentRepository) DocumentUsecase {
+	return &documentUsecase{
+		logger:             logger,
+		location:           location,
+		crypto:             crypto,
+		storage:            storage,
+		bucketName:         bucketName,
+		auditor:            auditor,
+		documentRepository: documentRepository,
+	}
+}
+
+// UploadDocument implements DocumentUsecase. The file is encrypted chunk by chunk while it is uploaded,
+// so neither the plaintext nor the whole file is ever held in memory or written to disk.
+func (u *documentUsecase) UploadDocument(ctx context.Context, documentRequest DocumentRequest, file io.Reader) (resp response.Response) {
+	exists, err := u.documentRepository.UserExists(ctx, documentRequest.UserUUID)
+	if err != nil {
+		u.logger.WithContext(ctx).Error(err)
+		return response.NewErrorResponse(exception.ErrInternalServer, http.StatusInternalServerError, nil, response.StatUnexpectedError, "")
+	}
+	if !exists {
+		return response.NewErrorResponse(exception.ErrNotFound, http.StatusNotFound, nil, response.StatNotFound, "user is not found")
+	}
+
+	buffered := bufio.NewReader(file)
+	head, _ := buffered.Peek(512)
+	contentType := http.DetectContentType(head)
+	if _, ok := allowedContentTypes[contentType]; !ok {
+		return response.NewErrorResponse(exception.ErrUnprocessableEntity, http.StatusUnprocessableEntity, nil, response.StatusInvalidPayload, "document must be a jpeg, png or pdf file")
+	}
+
+	dataKey, err := crypto.NewDataKey()
+	if err != nil {
+		u.logger.WithContext(ctx).Error(err)
+		return response.NewErrorResponse(exception.ErrInternalServer, http.StatusInternalServerError, nil, response.StatUnexpectedError, "")
+	}
+	wrappedKey, err := u.crypto.Encrypt(string(dataKey))
+	if err != nil {
+		u.logger.WithContext(ctx).Error(err)
+		return response.NewErrorResponse(exception.ErrInternalServer, http.StatusInternalServerError, nil, response.StatUnexpectedError, "")
+	}
+
+	document := entity.Document{
+		UUID:        uuid.New().String(),
+		UserUUID:    documentRequest.UserUUID,
+		Type:        documentRequest.Type,
+		ContentType: contentType,
+		KeyID:       crypto.PrimaryKeyID(u.crypto),
+		WrappedKey:  wrappedKey,
+		CreatedAt:   time.Now().In(u.location),
+	}
+	document.ObjectPath = documentObjectPrefix + document.UserUUID + "/" + document.UUID + ".enc"
+
+	digest := sha256.New()
+	counter := &countingWriter{}
+	plainText := io.TeeReader(buffered, io.MultiWriter(digest, counter))
+
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(encrypt(pw, plainText, dataKey))
+	}()
+
+	meta := map[string]string{
+		"document-id": document.UUID,
+		"key-id":      document.KeyID,
+		"encoding":    documentObjectEncoding,
+	}
+	err = u.storage.PutObject(ctx, u.bucketName, document.ObjectPath, pr, "application/octet-stream", meta)
+	pr.CloseWithError(err)
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		return response.NewErrorResponse(exception.ErrPayloadTooLarge, http.StatusRequestEntityTooLarge, nil, response.StatPayloadTooLarge, fmt.Sprintf("document must not be larger than %d bytes", maxBytesErr.Limit))
+	}
+	if err != nil {
+		u.logger.WithContext(ctx).Error(err)
+		return response.NewErrorResponse(exception.ErrInternalServer, http.StatusInternalServerError, nil, response.StatUnexpectedError, "")
+	}
+
+	document.Size = counter.n
+	document.SHA256 = digest.Sum(nil)
+
+	if err = u.documentRepository.SaveDocument(ctx, document, nil); err != nil {
+		u.logger.WithContext(ctx).Error(err)
+		if err := u.storage.DeleteObject(ctx, u.bucketName, document.ObjectPath); err != nil {
+			u.logger.WithContext(ctx).Error(err)
+		}
+		return response.NewErrorResponse(exception.ErrInternalServer, http.StatusInternalServerError, nil, response.StatUnexpectedError, "")
+	}
+
+	return response.NewSuccessResponse(toDocumentResponse(document), response.StatCreated, "")
+}
+
+// GetManyDocuments implements DocumentUsecase.
+func (u *documentUsecase) GetManyDocuments(ctx context.Context, userUUID string) (resp response.Response) {
+	documents, err := u.documentRepository.FindManyDocumentByUser(ctx, userUUID)
+	if err != nil {
+		u.logger.WithContext(ctx).Error(err)
+		return response.NewErrorResponse(exception.ErrInternalServer, http.StatusInternalServerError, nil, response.StatUnexpectedError, "")
+	}
+
+	documentsResponse := make([]DocumentResponse, len(documents))
+	for i, document := range documents {
+		documentsResponse[i] = toDocumentResponse(document)
+	}
+
+	return response.NewSuccessResponse(documentsResponse, response.StatOK, "")
+}
+
+// OpenDocument implements DocumentUsecase. Every chunk is authenticated while it is read,
+// and the digest of the plaintext is checked against the recorded one before the last bytes are read.
+func (u *documentUsecase) OpenDocument(ctx context.Context, userUUID string, uuid string) (file io.ReadCloser, documentResponse DocumentResponse, resp response.Response) {
+	document, err := u.documentRepository.FindOneDocument(ctx, userUUID, uuid)
+	if err != nil {
+		if err == exception.ErrNotFound {
+			return nil, documentResponse, response.NewErrorResponse(err, http.StatusNotFound, nil, response.StatNotFound, "")
+		}
+		u.logger.WithContext(ctx).Error(err)
+		return nil, documentResponse, response.NewErrorResponse(exception.ErrInternalServer, http.StatusInternalServerError, nil, response.StatUnexpectedError, "")
+	}
+
+	dataKey, err := u.crypto.Decrypt(document.WrappedKey)
+	if err != nil {
+		u.logger.WithContext(ctx).Error(err)
+		return nil, documentResponse, response.NewErrorResponse(exception.ErrInternalServer, http.StatusInternalServerError, nil, response.StatUnexpectedError, "")
+	}
+
+	if err := u.auditor.Record(ctx, "download document", []string{"document"}, []string{document.UserUUID}); err != nil {
+		u.logger.WithContext(ctx).Error(err)
+		return nil, documentResponse, response.NewErrorResponse(exception.ErrInternalServer, http.StatusInternalServerError, nil, response.StatUnexpectedError, "")
+	}
+
+	object, _, err := u.storage.GetObject(ctx, u.bucketName, document.ObjectPath)
+	if err != nil {
+		u.logger.WithContext(ctx).Error(err)
+		return nil, documentResponse, response.NewErrorResponse(exception.ErrInternalServer, http.StatusInternalServerError, nil, response.StatUnexpectedError, "")
+	}
+
+	plainText, err := crypto.NewStreamReader(object, dataKey)
+	if err != nil {
+		object.Close()
+		u.logger.WithContext(ctx).Error(err)
+		return nil, documentResponse, response.NewErrorResponse(exception.ErrInternalServer, http.StatusInternalServerError, nil, response.StatUnexpectedError, "")
+	}
+
+	file = &verifyingReader{
+		r:        bufio.NewReader(plainText),
+		closer:   object,
+		digest:   sha256.New(),
+		expected: document.SHA256,
+	}
+	return file, toDocumentResponse(document), nil
+}
+
+func encrypt(w io.Writer, plainText io.Reader, dataKey []byte) error {
+	encrypter, err := crypto.NewStreamWriter(w, dataKey)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(encrypter, plainText); err != nil {
+		return err
+	}
+	return encrypter.Close()
+}
+
+func toDocumentResponse(document entity.Document) DocumentResponse {
+	return DocumentResponse{
+		UUID:        document.UUID,
+		UserUUID:    document.UserUUID,
+		Type:        document.Type,
+		ContentType: document.ContentType,
+		Size:        document.Size,
+		SHA256:      hex.EncodeToString(document.SHA256),
+		KeyID:       document.KeyID,
+		CreatedAt:   document.CreatedAt,
+	}
+}
+
+type countingWriter struct {
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	c.n += int64(len(p))
+	return len(p), nil
+}
+
+// verifyingReader checks the digest of the content before giving its last bytes,
+// a mismatch fails the last read without them so the content is never delivered whole.
+type verifyingReader struct {
+	r        *bufio.Reader
+	closer   io.Closer
+	digest   hash.Hash
+	expected []byte
+}
+
+func (v *verifyingReader) Read(p []byte) (n int, err error) {
+	n, err = v.r.Read(p)
+	v.digest.Write(p[:n])
+	if err == nil {
+		if _, err = v.r.Peek(1); err == nil {
+			return
+		}
+	}
+	if err == io.EOF && !bytes.Equal(v.digest.Sum(nil), v.expected) {
+		return 0, errDigestMismatch
+	}
+	return
+}
+
+func (v *verifyingReader) Close() error {
+	return v.closer.Close()
+}
+
+// RotateKeys implements DocumentUsecase, a document which cannot be rotated is logged and left for the next run.
+func (u *documentUsecase) RotateKeys(ctx context.Context) (rotated int, err error) {
+	primaryKeyID := crypto.PrimaryKeyID(u.crypto)
+	documents, err := u.documentRepository.FindManyDocumentByOtherKey(ctx, primaryKeyID)
+	if err != nil {
+		return
+	}
+
+	failed := 0
+	for _, document := range documents {
+		if err := u.rotateDocument(ctx, document, primaryKeyID); err != nil {
+			u.logger.WithContext(ctx).WithField("uuid", document.UUID).Error(err)
+			failed++
+			continue
+		}
+		rotated++
+	}
+
+	if failed > 0 {
+		err = fmt.Errorf("document: %d of %d documents could not be rotated", failed, len(documents))
+	}
+	return
+}
+
+func (u *documentUsecase) rotateDocument(ctx context.Context, document entity.Document, primaryKeyID string) (err error) {
+	dataKey, err := u.crypto.Decrypt(document.WrappedKey)
+	if err != nil {
+		return
+	}
+
+	if document.WrappedKey, err = u.crypto.Encrypt(string(dataKey)); err != nil {
+		return
+	}
+	document.KeyID = primaryKeyID
+
+	return u.documentRepository.UpdateDocumentKey(ctx, document, nil)
+}
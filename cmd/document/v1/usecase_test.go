@@ -0,0 +1,277 @@
+package document_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+
+	"pii-encrypt-example/cmd/document/v1"
+	"pii-encrypt-example/entity"
+	"pii-encrypt-example/migrations"
+	"pii-encrypt-example/pkg/audit"
+	"pii-encrypt-example/pkg/crypto"
+	"pii-encrypt-example/pkg/database"
+	"pii-encrypt-example/pkg/middleware"
+	"pii-encrypt-example/pkg/response"
+	"pii-encrypt-example/pkg/storage"
+)
+
+const testUserUUID = "5f0c6c1e-2a3b-4c5d-8e9f-0a1b2c3d4e5f"
+
+// passThrough is a route middleware letting every request through.
+type passThrough struct{}
+
+func (passThrough) Verify(next http.HandlerFunc) http.HandlerFunc { return next }
+
+// recordingAuditRepository keeps the recorded accesses in memory, or fails with err.
+type recordingAuditRepository struct {
+	audit.AuditRepository
+	accesses []entity.PIIAccess
+	err      error
+}
+
+func (r *recordingAuditRepository) SaveAccess(ctx context.Context, access entity.PIIAccess) error {
+	if r.err != nil {
+		return r.err
+	}
+	r.accesses = append(r.accesses, access)
+	return nil
+}
+
+// documentTest is a document api over a fresh sqlite database and an in memory storage.
+type documentTest struct {
+	db              *sql.DB
+	storage         storage.Storage
+	repository      document.DocumentRepository
+	auditRepository *recordingAuditRepository
+	router          *mux.Router
+}
+
+func newDocumentTest(t *testing.T, keyring *crypto.Keyring) *documentTest {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "document.db")+"?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	migrator, err := database.NewMigrator(logger, database.SQLite{}, db, migrations.FS, "schema_migrations")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := migrator.Up(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	// the documents only need the user to exist
+	if _, err := db.Exec("INSERT INTO user_encrypt (uuid, __encrypted__data_nama_crypt, __encrypted__data_nama_hash, __encrypted__data_email_crypt, __encrypted__data_email_hash) VALUES (?, x'00', x'01', x'00', x'02')", testUserUUID); err != nil {
+		t.Fatal(err)
+	}
+
+	signer, err := storage.NewURLSigner("http://localhost/storage", []byte("12345678901234567890123456789012"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	test := &documentTest{
+		db:              db,
+		storage:         storage.NewMemoryAdapter(signer),
+		repository:      document.NewDocumentRepository(logger, database.SQLite{}, db, db, "user_document", "user_encrypt"),
+		auditRepository: &recordingAuditRepository{},
+		router:          mux.NewRouter(),
+	}
+	document.NewDocumentHTTPHandler(logger, test.router, passThrough{}, validator.New(), test.usecase(keyring))
+	return test
+}
+
+func (d *documentTest) usecase(keyring *crypto.Keyring) document.DocumentUsecase {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	return document.NewDocumentUsecase(logger, time.UTC, keyring, d.storage, "bucket", audit.NewAuditor(logger, time.UTC, d.auditRepository), d.repository)
+}
+
+func (d *documentTest) upload(body io.Reader) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, "/api/v1/user/"+testUserUUID+"/document?type="+document.DocumentTypeIDCard, body)
+	w := httptest.NewRecorder()
+	d.router.ServeHTTP(w, r)
+	return w
+}
+
+func (d *documentTest) download(t *testing.T, uuid string) []byte {
+	r := httptest.NewRequest(http.MethodGet, "/api/v1/user/"+testUserUUID+"/document/"+uuid, nil)
+	w := httptest.NewRecorder()
+	d.router.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("download = %d %s", w.Code, w.Body)
+	}
+	return w.Body.Bytes()
+}
+
+func newTestKey(secret string) crypto.Crypto {
+	return crypto.NewAESGCM(secret, "1234567890123456")
+}
+
+// newPNG returns a png header followed by enough random bytes to span several encrypted chunks.
+func newPNG(t *testing.T) []byte {
+	content := make([]byte, 200<<10)
+	if _, err := rand.Read(content); err != nil {
+		t.Fatal(err)
+	}
+	return append([]byte("\x89PNG\r\n\x1a\n"), content...)
+}
+
+func uploaded(t *testing.T, w *httptest.ResponseRecorder) document.DocumentResponse {
+	if w.Code != http.StatusCreated {
+		t.Fatalf("upload = %d %s", w.Code, w.Body)
+	}
+	var body struct {
+		Data document.DocumentResponse `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	return body.Data
+}
+
+func TestDocumentRoundTrip(t *testing.T) {
+	test := newDocumentTest(t, crypto.NewKeyring("v1", newTestKey("12345678901234567890123456789012")))
+	content := newPNG(t)
+
+	created := uploaded(t, test.upload(bytes.NewReader(content)))
+	digest := sha256.Sum256(content)
+	if created.ContentType != "image/png" || created.Size != int64(len(content)) || created.SHA256 != hex.EncodeToString(digest[:]) {
+		t.Fatalf("uploaded document = %+v", created)
+	}
+
+	object, _, err := test.storage.GetObject(context.Background(), "bucket", "documents/"+testUserUUID+"/"+created.UUID+".enc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored, _ := io.ReadAll(object)
+	object.Close()
+	if bytes.Contains(stored, content[:64]) {
+		t.Fatal("the document is stored in plaintext")
+	}
+
+	if downloaded := test.download(t, created.UUID); !bytes.Equal(downloaded, content) {
+		t.Fatalf("downloaded %d bytes, want the %d uploaded", len(downloaded), len(content))
+	}
+	accesses := test.auditRepository.accesses
+	if len(accesses) != 1 || accesses[0].Purpose != "download document" || len(accesses[0].UserUUIDs) != 1 || accesses[0].UserUUIDs[0] != testUserUUID {
+		t.Fatalf("recorded accesses = %+v, want the download of the document", accesses)
+	}
+}
+
+func TestDownloadDocumentIsNotServedWithoutAudit(t *testing.T) {
+	test := newDocumentTest(t, crypto.NewKeyring("v1", newTestKey("12345678901234567890123456789012")))
+	created := uploaded(t, test.upload(bytes.NewReader(newPNG(t))))
+	test.auditRepository.err = errors.New("audit log unavailable")
+
+	r := httptest.NewRequest(http.MethodGet, "/api/v1/user/"+testUserUUID+"/document/"+created.UUID, nil)
+	w := httptest.NewRecorder()
+	test.router.ServeHTTP(w, r)
+	if w.Code != http.StatusInternalServerError || strings.Contains(w.Body.String(), "PNG") {
+		t.Fatalf("download without audit = %d, want %d and no content", w.Code, http.StatusInternalServerError)
+	}
+}
+
+func TestUploadDocumentSniffsContentType(t *testing.T) {
+	test := newDocumentTest(t, crypto.NewKeyring("v1", newTestKey("12345678901234567890123456789012")))
+
+	// the declared type is ignored, only the content counts
+	r := httptest.NewRequest(http.MethodPost, "/api/v1/user/"+testUserUUID+"/document?type="+document.DocumentTypeIDCard, strings.NewReader("<html><body>not an image</body></html>"))
+	r.Header.Set("Content-Type", "image/png")
+	w := httptest.NewRecorder()
+	test.router.ServeHTTP(w, r)
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("upload of html = %d %s, want %d", w.Code, w.Body, http.StatusUnprocessableEntity)
+	}
+
+	if created := uploaded(t, test.upload(strings.NewReader("%PDF-1.7\n%\xe2\xe3\xcf\xd3\n"))); created.ContentType != "application/pdf" {
+		t.Fatalf("content type of a pdf = %s", created.ContentType)
+	}
+}
+
+func TestUploadDocumentSizeLimit(t *testing.T) {
+	test := newDocumentTest(t, crypto.NewKeyring("v1", newTestKey("12345678901234567890123456789012")))
+
+	content := append([]byte("\x89PNG\r\n\x1a\n"), make([]byte, 10<<20)...)
+	w := test.upload(bytes.NewReader(content))
+	if w.Code != http.StatusRequestEntityTooLarge || !strings.Contains(w.Body.String(), response.StatPayloadTooLarge) {
+		t.Fatalf("upload of a document too large = %d %s, want %d", w.Code, w.Body, http.StatusRequestEntityTooLarge)
+	}
+
+	documents, err := test.repository.FindManyDocumentByUser(context.Background(), testUserUUID)
+	if err != nil || len(documents) != 0 {
+		t.Fatalf("FindManyDocumentByUser() = %d documents, %v, want none", len(documents), err)
+	}
+}
+
+func TestDocumentRotateKeys(t *testing.T) {
+	oldKey := newTestKey("12345678901234567890123456789012")
+	test := newDocumentTest(t, crypto.NewKeyring("v1", oldKey))
+	content := newPNG(t)
+	created := uploaded(t, test.upload(bytes.NewReader(content)))
+
+	rotating := test.usecase(crypto.NewKeyring("v2", newTestKey("abcdefghijabcdefghijabcdefghij12")).Add("v1", oldKey))
+	if rotated, err := rotating.RotateKeys(context.Background()); rotated != 1 || err != nil {
+		t.Fatalf("RotateKeys() = %d, %v, want 1 document rotated", rotated, err)
+	}
+	if rotated, err := rotating.RotateKeys(context.Background()); rotated != 0 || err != nil {
+		t.Fatalf("RotateKeys() again = %d, %v, want none rotated", rotated, err)
+	}
+
+	document, err := test.repository.FindOneDocument(context.Background(), testUserUUID, created.UUID)
+	if err != nil || document.KeyID != "v2" {
+		t.Fatalf("FindOneDocument() = key %s, %v, want v2", document.KeyID, err)
+	}
+
+	file, _, resp := rotating.OpenDocument(context.Background(), testUserUUID, created.UUID)
+	if resp != nil {
+		t.Fatalf("OpenDocument() after the rotation = %d %v", resp.HTTPStatusCode(), resp.Error())
+	}
+	defer file.Close()
+	if downloaded, err := io.ReadAll(file); err != nil || !bytes.Equal(downloaded, content) {
+		t.Fatalf("document after the rotation = %d bytes, %v, want the %d uploaded", len(downloaded), err, len(content))
+	}
+}
+
+func TestDownloadDocumentDigestMismatch(t *testing.T) {
+	test := newDocumentTest(t, crypto.NewKeyring("v1", newTestKey("12345678901234567890123456789012")))
+	content := newPNG(t)
+	created := uploaded(t, test.upload(bytes.NewReader(content)))
+	if _, err := test.db.Exec("UPDATE user_document SET sha256 = ? WHERE uuid = ?", make([]byte, sha256.Size), created.UUID); err != nil {
+		t.Fatal(err)
+	}
+
+	// the recovery must let the aborted response through
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	server := httptest.NewServer(middleware.NewRecovery(logger, false).Handler(test.router))
+	defer server.Close()
+	resp, err := http.Get(server.URL + "/api/v1/user/" + testUserUUID + "/document/" + created.UUID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	downloaded, err := io.ReadAll(resp.Body)
+	if err == nil || len(downloaded) >= len(content) {
+		t.Fatalf("download of a tampered document = %d bytes, %v, want it cut short", len(downloaded), err)
+	}
+}
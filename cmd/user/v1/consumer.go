@@ -0,0 +1,194 @@
+package user
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"pii-encrypt-example/pkg/response"
+	customvalidator "pii-encrypt-example/pkg/validator"
+
+	"github.com/Shopify/sarama"
+	"github.com/go-playground/validator/v10"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// Headers added to a message sent to the dead letter topic, the reason never holds the submitted values.
+const (
+	deadLetterReasonHeader    = "dead-letter-reason"
+	deadLetterErrorsHeader    = "dead-letter-errors"
+	deadLetterTopicHeader     = "dead-letter-original-topic"
+	deadLetterPartitionHeader = "dead-letter-original-partition"
+	deadLetterOffsetHeader    = "dead-letter-original-offset"
+
+	// eventIDHeader identifies a message, the same event sent twice carries the same id.
+	eventIDHeader = "event-id"
+)
+
+// messageNamespace derives the uuid of the user created by a message from the identity of the message.
+var messageNamespace = uuid.MustParse("6f1d3b8e-2c4a-4f7e-9b5d-0a8c7e6f4d21")
+
+// UserConsumer creates the users submitted to kafka, each message holds a UserRequest as json.
+// The offset of a message is marked only once the user is created, or once the message is sent to the dead letter topic
+// because it can never succeed: a malformed or invalid payload, or a conflict. Any other failure is retried.
+// A message delivered again creates nothing, the uuid of its user is derived from its event-id header,
+// or from its offset when it has none.
+type UserConsumer struct {
+	logger          *logrus.Logger
+	validator       *validator.Validate
+	userUsecase     UserUsecase
+	producer        sarama.SyncProducer
+	deadLetterTopic string
+	retryBackoff    time.Duration
+}
+
+// NewUserConsumer is a constructor, the producer is used to send the poison messages to deadLetterTopic.
+func NewUserConsumer(logger *logrus.Logger, validator *validator.Validate, userUsecase UserUsecase, producer sarama.SyncProducer, deadLetterTopic string) *UserConsumer {
+	return &UserConsumer{
+		logger:          logger,
+		validator:       validator,
+		userUsecase:     userUsecase,
+		producer:        producer,
+		deadLetterTopic: deadLetterTopic,
+		retryBackoff:    time.Second,
+	}
+}
+
+// Run joins the consumer group until ctx is done, the group is joined again after every rebalance.
+func (c *UserConsumer) Run(ctx context.Context, group sarama.ConsumerGroup, topic string) {
+	go func() {
+		for err := range group.Errors() {
+			c.logger.Error(err)
+		}
+	}()
+
+	for ctx.Err() == nil {
+		if err := group.Consume(ctx, []string{topic}, c); err != nil {
+			if errors.Is(err, sarama.ErrClosedConsumerGroup) {
+				return
+			}
+			c.logger.WithContext(ctx).Error(err)
+			select {
+			case <-ctx.Done():
+			case <-time.After(c.retryBackoff):
+			}
+		}
+	}
+}
+
+// Setup implements sarama.ConsumerGroupHandler
+func (c *UserConsumer) Setup(session sarama.ConsumerGroupSession) error {
+	return nil
+}
+
+// Cleanup implements sarama.ConsumerGroupHandler
+func (c *UserConsumer) Cleanup(session sarama.ConsumerGroupSession) error {
+	return nil
+}
+
+// ConsumeClaim implements sarama.ConsumerGroupHandler, the messages of a partition are handled in order.
+func (c *UserConsumer) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	ctx := session.Context()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case message, ok := <-claim.Messages():
+			if !ok {
+				return nil
+			}
+			if !c.handle(ctx, message) {
+				// the session ended before the message was handled, it is consumed again by the next session.
+				return nil
+			}
+			session.MarkMessage(message, "")
+		}
+	}
+}
+
+// handle retries the message until it is either done or dead lettered, it returns false when ctx ends first.
+func (c *UserConsumer) handle(ctx context.Context, message *sarama.ConsumerMessage) (done bool) {
+	backoff := c.retryBackoff
+	for {
+		err := c.consume(ctx, message)
+		if err == nil {
+			return true
+		}
+		c.logger.WithContext(ctx).WithFields(logrus.Fields{
+			"topic":     message.Topic,
+			"partition": message.Partition,
+			"offset":    message.Offset,
+		}).Error(err)
+
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(backoff):
+		}
+		if backoff < time.Minute {
+			backoff *= 2
+		}
+	}
+}
+
+// consume creates the user of the message, an error means the message has to be retried.
+func (c *UserConsumer) consume(ctx context.Context, message *sarama.ConsumerMessage) (err error) {
+	var payload UserRequest
+	if err := json.Unmarshal(message.Value, &payload); err != nil {
+		return c.deadLetter(message, response.StatusInvalidPayload, nil)
+	}
+
+	if err := c.validator.Struct(payload); err != nil {
+		return c.deadLetter(message, response.StatusInvalidPayload, customvalidator.FieldErrors(err))
+	}
+
+	resp := c.userUsecase.CreateUserOnce(ctx, messageUserUUID(message), payload)
+	switch code := resp.HTTPStatusCode(); {
+	case code < http.StatusBadRequest:
+		return nil
+	case code < http.StatusInternalServerError && code != http.StatusRequestTimeout && code != http.StatusTooManyRequests:
+		return c.deadLetter(message, resp.Status(), nil)
+	}
+	return resp.Error()
+}
+
+// messageUserUUID returns the uuid of the user created by the message, the same for every delivery of the message.
+func messageUserUUID(message *sarama.ConsumerMessage) string {
+	for _, header := range message.Headers {
+		if string(header.Key) == eventIDHeader && len(header.Value) > 0 {
+			return uuid.NewSHA1(messageNamespace, header.Value).String()
+		}
+	}
+	offset := fmt.Sprintf("%s/%d/%d", message.Topic, message.Partition, message.Offset)
+	return uuid.NewSHA1(messageNamespace, []byte(offset)).String()
+}
+
+func (c *UserConsumer) deadLetter(message *sarama.ConsumerMessage, reason string, fieldErrors []response.FieldError) (err error) {
+	headers := make([]sarama.RecordHeader, 0, len(message.Headers)+5)
+	for _, header := range message.Headers {
+		headers = append(headers, *header)
+	}
+	headers = append(headers,
+		sarama.RecordHeader{Key: []byte(deadLetterReasonHeader), Value: []byte(reason)},
+		sarama.RecordHeader{Key: []byte(deadLetterTopicHeader), Value: []byte(message.Topic)},
+		sarama.RecordHeader{Key: []byte(deadLetterPartitionHeader), Value: []byte(strconv.FormatInt(int64(message.Partition), 10))},
+		sarama.RecordHeader{Key: []byte(deadLetterOffsetHeader), Value: []byte(strconv.FormatInt(message.Offset, 10))},
+	)
+	if len(fieldErrors) > 0 {
+		errs, _ := json.Marshal(fieldErrors)
+		headers = append(headers, sarama.RecordHeader{Key: []byte(deadLetterErrorsHeader), Value: errs})
+	}
+
+	_, _, err = c.producer.SendMessage(&sarama.ProducerMessage{
+		Topic:   c.deadLetterTopic,
+		Key:     sarama.ByteEncoder(message.Key),
+		Value:   sarama.ByteEncoder(message.Value),
+		Headers: headers,
+	})
+	return
+}
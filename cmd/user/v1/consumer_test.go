@@ -0,0 +1,148 @@
+package user_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/Shopify/sarama/mocks"
+	"github.com/go-playground/validator/v10"
+
+	"pii-encrypt-example/cmd/user/v1"
+	"pii-encrypt-example/pkg/audit"
+	"pii-encrypt-example/pkg/crypto"
+	"pii-encrypt-example/pkg/database"
+	"pii-encrypt-example/pkg/outbox"
+	"pii-encrypt-example/pkg/response"
+	customvalidator "pii-encrypt-example/pkg/validator"
+)
+
+// consumerSession records the offsets marked by the consumer.
+type consumerSession struct {
+	ctx    context.Context
+	marked []int64
+}
+
+func (s *consumerSession) Claims() map[string][]int32                                           { return nil }
+func (s *consumerSession) MemberID() string                                                     { return "" }
+func (s *consumerSession) GenerationID() int32                                                  { return 0 }
+func (s *consumerSession) MarkOffset(topic string, partition int32, offset int64, meta string)  {}
+func (s *consumerSession) Commit()                                                              {}
+func (s *consumerSession) ResetOffset(topic string, partition int32, offset int64, meta string) {}
+func (s *consumerSession) MarkMessage(msg *sarama.ConsumerMessage, metadata string) {
+	s.marked = append(s.marked, msg.Offset)
+}
+func (s *consumerSession) Context() context.Context { return s.ctx }
+
+// consumerClaim hands out the messages of a single partition.
+type consumerClaim struct {
+	messages chan *sarama.ConsumerMessage
+}
+
+func (c *consumerClaim) Topic() string                            { return "user-create" }
+func (c *consumerClaim) Partition() int32                         { return 0 }
+func (c *consumerClaim) InitialOffset() int64                     { return 0 }
+func (c *consumerClaim) HighWaterMarkOffset() int64               { return 0 }
+func (c *consumerClaim) Messages() <-chan *sarama.ConsumerMessage { return c.messages }
+
+func newTestValidator() *validator.Validate {
+	validator := validator.New()
+	validator.RegisterTagNameFunc(customvalidator.SetTagName)
+	validator.RegisterValidation("default-name", customvalidator.SetDefaultName)
+	validator.RegisterValidation("idn-mobile-number", customvalidator.SetIDNMobileNumber)
+	validator.RegisterValidation("ISO8601date", customvalidator.SetISO8601dateFormat)
+	validator.RegisterValidation("email", customvalidator.SetEmail)
+	validator.RegisterValidation("nik", customvalidator.SetNIK)
+	return validator
+}
+
+// deadLetterReason checks that the message is dead lettered for reason.
+func deadLetterReason(reason string) mocks.MessageChecker {
+	return func(message *sarama.ProducerMessage) error {
+		for _, header := range message.Headers {
+			if string(header.Key) == "dead-letter-reason" && string(header.Value) == reason {
+				return nil
+			}
+		}
+		return errors.New("the message is not dead lettered for " + reason)
+	}
+}
+
+func TestUserConsumer(t *testing.T) {
+	ctx := context.Background()
+	logger := newTestLogger()
+
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "user.db")+"?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	migrate(t, db, database.SQLite{})
+	// a replica which never catches up, the users created are only found in the read write database
+	replica, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "replica.db")+"?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer replica.Close()
+	migrate(t, replica, database.SQLite{})
+
+	keyring := crypto.NewKeyring("v1", crypto.NewAESGCM("12345678901234567890123456789012", "1234567890123456"))
+	userRepository := user.NewUserRepository(logger, database.SQLite{}, replica, db, "user_encrypt")
+	outboxRepository := outbox.NewOutboxRepository(logger, database.SQLite{}, db, "outbox_event")
+	userUsecase := user.NewUserUsecase(logger, time.UTC, keyring, newTestValidator(), audit.NewAuditor(logger, time.UTC, nil), userRepository, outboxRepository)
+
+	producer := mocks.NewSyncProducer(t, nil)
+	consumer := user.NewUserConsumer(logger, newTestValidator(), userUsecase, producer, "user-create-dlq")
+
+	created := func(eventID string, offset int64, value string) *sarama.ConsumerMessage {
+		return &sarama.ConsumerMessage{
+			Topic:     "user-create",
+			Offset:    offset,
+			Value:     []byte(value),
+			Headers:   []*sarama.RecordHeader{{Key: []byte("event-id"), Value: []byte(eventID)}},
+			Timestamp: time.Now(),
+		}
+	}
+	messages := []*sarama.ConsumerMessage{
+		created("event-1", 0, `{"name":"John Doe","email":"john@example.com"}`),
+		created("event-2", 1, `{"name":`),
+		created("event-3", 2, `{"name":"Jane Doe","email":"not an email"}`),
+		// delivered again after a crash which lost the offset of the first delivery
+		created("event-1", 3, `{"name":"John Doe","email":"john@example.com"}`),
+		// another message of the same email conflicts with the user of the first one
+		created("event-4", 4, `{"name":"Johnny Doe","email":"john@example.com"}`),
+	}
+
+	producer.ExpectSendMessageWithMessageCheckerFunctionAndSucceed(deadLetterReason(response.StatusInvalidPayload))
+	producer.ExpectSendMessageWithMessageCheckerFunctionAndSucceed(deadLetterReason(response.StatusInvalidPayload))
+	producer.ExpectSendMessageWithMessageCheckerFunctionAndSucceed(deadLetterReason(response.StatDuplicateEmail))
+
+	claim := &consumerClaim{messages: make(chan *sarama.ConsumerMessage, len(messages))}
+	for _, message := range messages {
+		claim.messages <- message
+	}
+	close(claim.messages)
+
+	// a message failing for good is retried until the session ends
+	sessionCtx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+	session := &consumerSession{ctx: sessionCtx}
+	if err := consumer.ConsumeClaim(session, claim); err != nil {
+		t.Fatal(err)
+	}
+	if err := producer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(session.marked) != len(messages) {
+		t.Fatalf("ConsumeClaim() marked the offsets %v, want every message", session.marked)
+	}
+	users, err := user.NewUserRepository(logger, database.SQLite{}, db, db, "user_encrypt").FindManyUser(ctx, user.UserFilter{})
+	if err != nil || len(users) != 1 {
+		t.Fatalf("FindManyUser() = %d users, %v, want the user of the message delivered twice only", len(users), err)
+	}
+}
@@ -0,0 +1,61 @@
+package user
+
+import (
+	"encoding/json"
+	"net/http"
+	"pii-encrypt-example/pkg/exception"
+	"pii-encrypt-example/pkg/middleware"
+	"pii-encrypt-example/pkg/response"
+	customvalidator "pii-encrypt-example/pkg/validator"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+)
+
+type UserExportHTTPHandler struct {
+	logger            *logrus.Logger
+	validator         *validator.Validate
+	userExportUsecase UserExportUsecase
+}
+
+func NewUserExportHTTPHandler(logger *logrus.Logger, router *mux.Router, basicAuth middleware.RouteMiddleware, validator *validator.Validate, userExportUsecase UserExportUsecase) {
+	handler := &UserExportHTTPHandler{
+		logger:            logger,
+		validator:         validator,
+		userExportUsecase: userExportUsecase,
+	}
+	router.HandleFunc("/api/v1/user/export", basicAuth.Verify(handler.CreateExport)).Methods(http.MethodPost)
+	router.HandleFunc("/api/v1/user/export/{id}", basicAuth.Verify(handler.GetExport)).Methods(http.MethodGet)
+}
+
+func (h UserExportHTTPHandler) CreateExport(w http.ResponseWriter, r *http.Request) {
+	var resp response.Response
+	var payload ExportRequest
+
+	ctx := r.Context()
+
+	err := json.NewDecoder(r.Body).Decode(&payload)
+	if err != nil {
+		resp = response.NewErrorResponse(err, http.StatusUnprocessableEntity, nil, response.StatusInvalidPayload, malformedPayloadMessage)
+		response.JSON(w, resp)
+		return
+	}
+
+	if err := h.validator.Struct(payload); err != nil {
+		resp = response.NewErrorResponse(exception.ErrBadRequest, http.StatusBadRequest, customvalidator.FieldErrors(err), response.StatusInvalidPayload, invalidPayloadMessage)
+		response.JSON(w, resp)
+		return
+	}
+
+	resp = h.userExportUsecase.CreateExport(ctx, payload)
+	response.JSON(w, resp)
+}
+
+func (h UserExportHTTPHandler) GetExport(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id := mux.Vars(r)["id"]
+
+	resp := h.userExportUsecase.GetExport(ctx, id)
+	response.JSON(w, resp)
+}
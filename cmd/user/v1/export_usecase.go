@@ -0,0 +1,359 @@
+package user
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"pii-encrypt-example/entity"
+	"pii-encrypt-example/pkg/audit"
+	"pii-encrypt-example/pkg/crypto"
+	"pii-encrypt-example/pkg/exception"
+	"pii-encrypt-example/pkg/pii"
+	"pii-encrypt-example/pkg/response"
+	"pii-encrypt-example/pkg/storage"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	exportTimeout = time.Minute * 30
+	// exportStaleAfter is the age after which a job still pending or running has been interrupted, e.g. by a crash.
+	exportStaleAfter     = exportTimeout + time.Minute
+	exportRetention      = time.Hour * 24
+	exportURLExpiresIn   = time.Minute * 5
+	exportObjectPrefix   = "exports/users/"
+	exportObjectEncoding = "piie-v1"
+	// exportAuditBatchSize is the number of users recorded in the audit log before they are written.
+	exportAuditBatchSize = 500
+	// exportSaveTimeout bounds the write of the state of a job, also when the export itself is cancelled.
+	exportSaveTimeout = time.Second * 10
+)
+
+var exportCSVHeader = []string{"uuid", "name", "email", "phoneNumber", "nationalityId", "dateOfBirth", "address", "createdAt"}
+
+type UserExportUsecase interface {
+	CreateExport(ctx context.Context, exportRequest ExportRequest) (resp response.Response)
+	GetExport(ctx context.Context, id string) (resp response.Response)
+	Shutdown(ctx context.Context) (err error)
+}
+
+type userExportUsecase struct {
+	logger         *logrus.Logger
+	location       *time.Location
+	crypto         crypto.Crypto
+	sealer         *pii.Sealer
+	storage        storage.Storage
+	bucketName     string
+	auditor        *audit.Auditor
+	userRepository UserRepository
+	ctx            context.Context
+	cancel         context.CancelFunc
+	running        sync.WaitGroup
+}
+
+// exportJob is the state of an export, kept as a json object in the storage next to the export file,
+// so every instance can report it. The passphrase is never part of it.
+type exportJob struct {
+	ExportResponse
+	Principal  string `json:"principal"`
+	ObjectPath string `json:"objectPath"`
+}
+
+func NewUserExportUsecase(logger *logrus.Logger, location *time.Location, crypto crypto.Crypto, storage storage.Storage, bucketName string, auditor *audit.Auditor, userRepository UserRepository) UserExportUsecase {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &userExportUsecase{
+		logger:         logger,
+		location:       location,
+		crypto:         crypto,
+		sealer:         pii.NewSealer(crypto),
+		storage:        storage,
+		bucketName:     bucketName,
+		auditor:        auditor,
+		userRepository: userRepository,
+		ctx:            ctx,
+		cancel:         cancel,
+	}
+}
+
+// CreateExport implements UserExportUsecase, the export itself runs in the background.
+func (u *userExportUsecase) CreateExport(ctx context.Context, exportRequest ExportRequest) (resp response.Response) {
+	var wrapper crypto.KeyWrapper
+	var passphrase crypto.Passphrase
+
+	if exportRequest.PublicKey != "" {
+		recipient, err := crypto.ParseRSARecipient(exportRequest.PublicKey)
+		if err != nil {
+			return response.NewErrorResponse(exception.ErrBadRequest, http.StatusBadRequest, nil, response.StatusInvalidPayload, err.Error())
+		}
+		wrapper = recipient
+	} else {
+		var err error
+		if passphrase, err = crypto.NewPassphrase(); err != nil {
+			u.logger.WithContext(ctx).Error(err)
+			return response.NewErrorResponse(exception.ErrInternalServer, http.StatusInternalServerError, nil, response.StatUnexpectedError, "")
+		}
+		wrapper = passphrase
+	}
+
+	var filter UserFilter
+	if exportRequest.Name != "" {
+		filter.Name = exportRequest.Name
+		filter.NameHashed = u.crypto.Hash(exportRequest.Name)
+	}
+
+	id := uuid.New().String()
+	principal, _ := ctx.Value(entity.PrincipalContextKey{}).(string)
+	job := exportJob{
+		ExportResponse: ExportResponse{
+			ID:        id,
+			Status:    ExportStatusPending,
+			Format:    exportRequest.Format,
+			CreatedAt: time.Now().In(u.location),
+		},
+		Principal:  principal,
+		ObjectPath: exportObjectPrefix + id + "." + exportRequest.Format + ".enc",
+	}
+	if err := u.saveJob(ctx, job); err != nil {
+		u.logger.WithContext(ctx).Error(err)
+		return response.NewErrorResponse(exception.ErrInternalServer, http.StatusInternalServerError, nil, response.StatUnexpectedError, "")
+	}
+
+	// the export outlives the request, it is only cancelled by Shutdown or its timeout.
+	runCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	stop := context.AfterFunc(u.ctx, cancel)
+	u.running.Add(1)
+	go func() {
+		defer u.running.Done()
+		defer stop()
+		defer cancel()
+		u.run(runCtx, job, filter, wrapper)
+	}()
+
+	exportResponse := job.ExportResponse
+	exportResponse.Passphrase = string(passphrase)
+	return response.NewSuccessResponse(exportResponse, response.StatCreated, "")
+}
+
+// GetExport implements UserExportUsecase, the jobs of another principal are not found.
+func (u *userExportUsecase) GetExport(ctx context.Context, id string) (resp response.Response) {
+	job, err := u.loadJob(ctx, id)
+	if err == exception.ErrNotFound {
+		return response.NewErrorResponse(exception.ErrNotFound, http.StatusNotFound, nil, response.StatNotFound, "")
+	}
+	if err != nil {
+		u.logger.WithContext(ctx).Error(err)
+		return response.NewErrorResponse(exception.ErrInternalServer, http.StatusInternalServerError, nil, response.StatUnexpectedError, "")
+	}
+
+	principal, _ := ctx.Value(entity.PrincipalContextKey{}).(string)
+	if job.Principal != principal || time.Since(job.CreatedAt) > exportRetention {
+		return response.NewErrorResponse(exception.ErrNotFound, http.StatusNotFound, nil, response.StatNotFound, "")
+	}
+
+	exportResponse := job.ExportResponse
+	switch exportResponse.Status {
+	case ExportStatusPending, ExportStatusRunning:
+		// the instance running the job stopped without recording its end
+		if time.Since(exportResponse.CreatedAt) > exportStaleAfter {
+			exportResponse.Status = ExportStatusFailed
+		}
+	case ExportStatusDone:
+		url, err := u.storage.SignURL(ctx, http.MethodGet, u.bucketName, job.ObjectPath, exportURLExpiresIn)
+		if err != nil {
+			u.logger.WithContext(ctx).Error(err)
+			return response.NewErrorResponse(exception.ErrInternalServer, http.StatusInternalServerError, nil, response.StatUnexpectedError, "")
+		}
+		expiresAt := time.Now().In(u.location).Add(exportURLExpiresIn)
+		exportResponse.URL = url
+		exportResponse.URLExpiresAt = &expiresAt
+	}
+
+	return response.NewSuccessResponse(exportResponse, response.StatOK, "")
+}
+
+// Shutdown implements UserExportUsecase, it cancels the running exports and waits until they are recorded as failed.
+func (u *userExportUsecase) Shutdown(ctx context.Context) (err error) {
+	u.cancel()
+
+	done := make(chan struct{})
+	go func() {
+		u.running.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// run streams the users through the encryption straight into the storage, the plaintext never touches the disk.
+func (u *userExportUsecase) run(ctx context.Context, job exportJob, filter UserFilter, wrapper crypto.KeyWrapper) {
+	ctx, cancel := context.WithTimeout(ctx, exportTimeout)
+	defer cancel()
+
+	job.Status = ExportStatusRunning
+	err := u.saveJob(ctx, job)
+
+	type result struct {
+		total int
+		err   error
+	}
+	var res result
+	if err == nil {
+		written := make(chan result, 1)
+
+		pr, pw := io.Pipe()
+		go func() {
+			total, err := u.write(ctx, pw, job.Format, filter, wrapper)
+			pw.CloseWithError(err)
+			written <- result{total, err}
+		}()
+
+		meta := map[string]string{
+			"export-id": job.ID,
+			"format":    job.Format,
+			"encoding":  exportObjectEncoding,
+		}
+		err = u.storage.PutObject(ctx, u.bucketName, job.ObjectPath, pr, "application/octet-stream", meta)
+		// unblocks the writer when the upload stops before reading everything.
+		pr.CloseWithError(err)
+
+		res = <-written
+		if err == nil {
+			err = res.err
+		}
+	}
+
+	finishedAt := time.Now().In(u.location)
+	job.FinishedAt = &finishedAt
+	job.Total = res.total
+	job.Status = ExportStatusDone
+	if err != nil {
+		u.logger.WithContext(ctx).Error(err)
+		job.Status = ExportStatusFailed
+	}
+
+	// the end is recorded even when the export has been cancelled
+	saveCtx, cancelSave := context.WithTimeout(context.WithoutCancel(ctx), exportSaveTimeout)
+	defer cancelSave()
+	if err := u.saveJob(saveCtx, job); err != nil {
+		u.logger.WithContext(ctx).Error(err)
+	}
+}
+
+func (u *userExportUsecase) write(ctx context.Context, w io.Writer, format string, filter UserFilter, wrapper crypto.KeyWrapper) (total int, err error) {
+	envelope, err := crypto.NewEnvelopeWriter(w, wrapper)
+	if err != nil {
+		return
+	}
+
+	var writeRow func(userResponse UserResponse) error
+	var flush func() error
+	switch format {
+	case FileFormatCSV:
+		csvWriter := csv.NewWriter(envelope)
+		if err = csvWriter.Write(exportCSVHeader); err != nil {
+			return
+		}
+		writeRow = func(r UserResponse) error {
+			return csvWriter.Write([]string{r.UUID, r.Name, r.Email, r.PhoneNumber, r.NationalityID, r.DateOfBirth, r.Address, r.CreatedAt.Format(time.RFC3339)})
+		}
+		flush = func() error {
+			csvWriter.Flush()
+			return csvWriter.Error()
+		}
+	default:
+		encoder := json.NewEncoder(envelope)
+		writeRow = func(r UserResponse) error {
+			return encoder.Encode(r)
+		}
+		flush = func() error { return nil }
+	}
+
+	// the users are recorded in the audit log by batch, before their batch is written.
+	batch := make([]UserResponse, 0, exportAuditBatchSize)
+	writeBatch := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		uuids := make([]string, len(batch))
+		for i, userResponse := range batch {
+			uuids[i] = userResponse.UUID
+		}
+		if err := u.auditor.Record(ctx, "export users", revealedFields, uuids); err != nil {
+			return err
+		}
+		for _, userResponse := range batch {
+			if err := writeRow(userResponse); err != nil {
+				return err
+			}
+		}
+		batch = batch[:0]
+		return nil
+	}
+
+	err = u.userRepository.EachUser(ctx, filter, func(user entity.User) error {
+		var userResponse UserResponse
+		if err := u.sealer.Open(ctx, user, &userResponse); err != nil {
+			u.logger.WithContext(ctx).Error(err)
+		}
+		total++
+		batch = append(batch, userResponse)
+		if len(batch) == exportAuditBatchSize {
+			return writeBatch()
+		}
+		return nil
+	})
+	if err != nil {
+		return
+	}
+	if err = writeBatch(); err != nil {
+		return
+	}
+
+	if err = flush(); err != nil {
+		return
+	}
+	err = envelope.Close()
+	return
+}
+
+func exportJobPath(id string) string {
+	return exportObjectPrefix + id + ".json"
+}
+
+func (u *userExportUsecase) saveJob(ctx context.Context, job exportJob) (err error) {
+	content, err := json.Marshal(job)
+	if err != nil {
+		return
+	}
+	return u.storage.PutObject(ctx, u.bucketName, exportJobPath(job.ID), bytes.NewReader(content), "application/json", map[string]string{"export-id": job.ID})
+}
+
+// loadJob returns the job of id, exception.ErrNotFound when there is none.
+func (u *userExportUsecase) loadJob(ctx context.Context, id string) (job exportJob, err error) {
+	if _, err = uuid.Parse(id); err != nil {
+		return job, exception.ErrNotFound
+	}
+
+	file, _, err := u.storage.GetObject(ctx, u.bucketName, exportJobPath(id))
+	if errors.Is(err, storage.ErrObjectNotFound) {
+		return job, exception.ErrNotFound
+	}
+	if err != nil {
+		return
+	}
+	defer file.Close()
+
+	err = json.NewDecoder(file).Decode(&job)
+	return
+}
@@ -0,0 +1,140 @@
+package user_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"pii-encrypt-example/cmd/user/v1"
+	"pii-encrypt-example/entity"
+	"pii-encrypt-example/pkg/audit"
+	"pii-encrypt-example/pkg/crypto"
+	"pii-encrypt-example/pkg/response"
+	"pii-encrypt-example/pkg/storage"
+)
+
+// discardingAuditRepository saves every access without keeping it.
+type discardingAuditRepository struct {
+	audit.AuditRepository
+}
+
+func (discardingAuditRepository) SaveAccess(ctx context.Context, access entity.PIIAccess) error {
+	return nil
+}
+
+// stallingStorage never completes the upload of an export file, until its context is cancelled.
+type stallingStorage struct {
+	storage.Storage
+}
+
+func (s *stallingStorage) PutObject(ctx context.Context, bucket, path string, reader io.Reader, contentType string, meta map[string]string) error {
+	if strings.HasSuffix(path, ".enc") {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	return s.Storage.PutObject(ctx, bucket, path, reader, contentType, meta)
+}
+
+func newTestExportUsecase(t *testing.T, objectStorage storage.Storage, userRepository user.UserRepository) user.UserExportUsecase {
+	logger := newTestLogger()
+	keyring := crypto.NewKeyring("v1", crypto.NewAESGCM("12345678901234567890123456789012", "1234567890123456"))
+	exportUsecase := user.NewUserExportUsecase(logger, time.UTC, keyring, objectStorage, "bucket", audit.NewAuditor(logger, time.UTC, discardingAuditRepository{}), userRepository)
+	t.Cleanup(func() { exportUsecase.Shutdown(context.Background()) })
+	return exportUsecase
+}
+
+// waitExport polls the export until its status is not one of pending.
+func waitExport(t *testing.T, ctx context.Context, exportUsecase user.UserExportUsecase, id string, pending ...string) user.ExportResponse {
+	deadline := time.Now().Add(time.Second * 10)
+	for {
+		resp := exportUsecase.GetExport(ctx, id)
+		if resp.Error() != nil {
+			t.Fatalf("GetExport() = %d %v", resp.HTTPStatusCode(), resp.Error())
+		}
+		exportResponse := resp.Data().(user.ExportResponse)
+		waiting := false
+		for _, status := range pending {
+			waiting = waiting || exportResponse.Status == status
+		}
+		if !waiting {
+			return exportResponse
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("export %s still %s", id, exportResponse.Status)
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+}
+
+func TestExportIsSharedBetweenInstances(t *testing.T) {
+	signer, err := storage.NewURLSigner("http://localhost/storage", []byte("12345678901234567890123456789012"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	objectStorage := storage.NewMemoryAdapter(signer)
+	userUsecase, userRepository := newTestUserUsecase(t, nil)
+	ctx := context.WithValue(context.Background(), entity.PrincipalContextKey{}, "support")
+	if resp := userUsecase.CreateUser(ctx, user.UserRequest{Name: "John Doe", Email: "john@example.com"}); resp.Error() != nil {
+		t.Fatal(resp.Error())
+	}
+
+	creating := newTestExportUsecase(t, objectStorage, userRepository)
+	reading := newTestExportUsecase(t, objectStorage, userRepository)
+
+	resp := creating.CreateExport(ctx, user.ExportRequest{Format: user.FileFormatCSV})
+	if resp.Error() != nil {
+		t.Fatalf("CreateExport() = %d %v", resp.HTTPStatusCode(), resp.Error())
+	}
+	created := resp.Data().(user.ExportResponse)
+	if created.Passphrase == "" {
+		t.Fatal("CreateExport() gave no passphrase")
+	}
+
+	exportResponse := waitExport(t, ctx, reading, created.ID, user.ExportStatusPending, user.ExportStatusRunning)
+	if exportResponse.Status != user.ExportStatusDone || exportResponse.Total != 1 || exportResponse.URL == "" {
+		t.Fatalf("export read by another instance = %+v, want it done with 1 user and its url", exportResponse)
+	}
+	if exportResponse.Passphrase != "" {
+		t.Fatal("the passphrase of the export has been kept")
+	}
+
+	other := context.WithValue(context.Background(), entity.PrincipalContextKey{}, "other")
+	if resp := reading.GetExport(other, created.ID); resp.HTTPStatusCode() != http.StatusNotFound || resp.Status() != response.StatNotFound {
+		t.Errorf("GetExport() by another principal = %d %s, want %d", resp.HTTPStatusCode(), resp.Status(), http.StatusNotFound)
+	}
+	if resp := reading.GetExport(ctx, "../"+created.ID); resp.HTTPStatusCode() != http.StatusNotFound {
+		t.Errorf("GetExport() of an invalid id = %d, want %d", resp.HTTPStatusCode(), http.StatusNotFound)
+	}
+}
+
+func TestExportShutdownFailsRunningJobs(t *testing.T) {
+	signer, err := storage.NewURLSigner("http://localhost/storage", []byte("12345678901234567890123456789012"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	objectStorage := storage.NewMemoryAdapter(signer)
+	_, userRepository := newTestUserUsecase(t, nil)
+	ctx := context.WithValue(context.Background(), entity.PrincipalContextKey{}, "support")
+
+	stopping := newTestExportUsecase(t, &stallingStorage{Storage: objectStorage}, userRepository)
+	reading := newTestExportUsecase(t, objectStorage, userRepository)
+
+	resp := stopping.CreateExport(ctx, user.ExportRequest{Format: user.FileFormatJSONL})
+	if resp.Error() != nil {
+		t.Fatalf("CreateExport() = %d %v", resp.HTTPStatusCode(), resp.Error())
+	}
+	id := resp.Data().(user.ExportResponse).ID
+	if exportResponse := waitExport(t, ctx, reading, id, user.ExportStatusPending); exportResponse.Status != user.ExportStatusRunning {
+		t.Fatalf("export status = %s, want %s", exportResponse.Status, user.ExportStatusRunning)
+	}
+
+	if err := stopping.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown() = %v", err)
+	}
+	if exportResponse := waitExport(t, ctx, reading, id); exportResponse.Status != user.ExportStatusFailed || exportResponse.FinishedAt == nil {
+		t.Fatalf("export interrupted by the shutdown = %+v, want it failed", exportResponse)
+	}
+}
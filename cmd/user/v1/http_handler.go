@@ -2,32 +2,50 @@ package user
 
 import (
 	"encoding/json"
-	"fmt"
+	"mime"
 	"net/http"
+	"pii-encrypt-example/pkg/exception"
 	"pii-encrypt-example/pkg/middleware"
 	"pii-encrypt-example/pkg/response"
+	customvalidator "pii-encrypt-example/pkg/validator"
+	"strconv"
 
 	"github.com/go-playground/validator/v10"
+	"github.com/google/uuid"
 	"github.com/gorilla/mux"
 	"github.com/sirupsen/logrus"
 )
 
+const (
+	malformedPayloadMessage = "malformed json payload"
+	invalidPayloadMessage   = "invalid payload"
+	// maxImportSize is the largest file of users accepted for an import, in bytes.
+	maxImportSize = 32 << 20
+)
+
 type UserHTTPHandler struct {
 	logger      *logrus.Logger
 	validator   *validator.Validate
 	userUsecase UserUsecase
 }
 
-func NewUserHTTPHandler(logger *logrus.Logger, router *mux.Router, basicAuth middleware.RouteMiddleware, validator *validator.Validate, userUsecase UserUsecase) {
+// NewUserHTTPHandler registers the routes of the users, searchAuth guards the lookups of the users, by name or by uuid,
+// which may be given a stricter budget than the other routes.
+func NewUserHTTPHandler(logger *logrus.Logger, router *mux.Router, basicAuth middleware.RouteMiddleware, searchAuth middleware.RouteMiddleware, validator *validator.Validate, userUsecase UserUsecase) {
 	handler := &UserHTTPHandler{
 		logger:      logger,
 		validator:   validator,
 		userUsecase: userUsecase,
 	}
-	router.HandleFunc("/api/v1/user", basicAuth.Verify(handler.GetManyUsers)).Methods(http.MethodGet)
+	router.HandleFunc("/api/v1/user", searchAuth.Verify(handler.GetManyUsers)).Methods(http.MethodGet)
 	router.HandleFunc("/api/v1/user", basicAuth.Verify(handler.CreateUser)).Methods(http.MethodPost)
+	router.HandleFunc("/api/v1/user/import", basicAuth.Verify(handler.ImportUsers)).Methods(http.MethodPost)
+	router.HandleFunc("/api/v1/user/{uuid}", searchAuth.Verify(handler.GetUser)).Methods(http.MethodGet)
+	router.HandleFunc("/api/v1/user/{uuid}", basicAuth.Verify(handler.UpdateUser)).Methods(http.MethodPut)
+	router.HandleFunc("/api/v1/user/{uuid}", basicAuth.Verify(handler.DeleteUser)).Methods(http.MethodDelete)
 }
 
+// GetManyUsers lists the users, filtered by name, a page of limit users at most follows the cursor.
 func (h UserHTTPHandler) GetManyUsers(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	var filter UserFilter
@@ -37,10 +55,31 @@ func (h UserHTTPHandler) GetManyUsers(w http.ResponseWriter, r *http.Request) {
 	if nameQS != "" {
 		filter.Name = nameQS
 	}
+	var err error
+	if limit := queryString.Get("limit"); limit != "" {
+		if filter.Limit, err = strconv.Atoi(limit); err != nil || filter.Limit < 1 {
+			response.JSON(w, response.NewErrorResponse(exception.ErrBadRequest, http.StatusBadRequest, nil, response.StatusInvalidPayload, "limit must be a positive number"))
+			return
+		}
+	}
+	if cursor := queryString.Get("cursor"); cursor != "" {
+		if err = uuid.Validate(cursor); err != nil {
+			response.JSON(w, response.NewErrorResponse(exception.ErrBadRequest, http.StatusBadRequest, nil, response.StatusInvalidPayload, "cursor must be the nextCursor of a page"))
+			return
+		}
+		filter.AfterUUID = cursor
+	}
 	resp := h.userUsecase.GetManyUsers(ctx, filter)
 	response.JSON(w, resp)
 }
 
+func (h UserHTTPHandler) GetUser(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	resp := h.userUsecase.GetUser(ctx, mux.Vars(r)["uuid"])
+	response.JSON(w, resp)
+}
+
 func (h UserHTTPHandler) CreateUser(w http.ResponseWriter, r *http.Request) {
 	var resp response.Response
 	var payload UserRequest
@@ -49,13 +88,13 @@ func (h UserHTTPHandler) CreateUser(w http.ResponseWriter, r *http.Request) {
 
 	err := json.NewDecoder(r.Body).Decode(&payload)
 	if err != nil {
-		resp = response.NewErrorResponse(err, http.StatusUnprocessableEntity, nil, response.StatusInvalidPayload, err.Error())
+		resp = response.NewErrorResponse(err, http.StatusUnprocessableEntity, nil, response.StatusInvalidPayload, malformedPayloadMessage)
 		response.JSON(w, resp)
 		return
 	}
 
-	if err := h.validateRequestBody(payload); err != nil {
-		resp = response.NewErrorResponse(err, http.StatusBadRequest, nil, response.StatusInvalidPayload, err.Error())
+	if fieldErrors, err := h.validateRequestBody(payload); err != nil {
+		resp = response.NewErrorResponse(err, http.StatusBadRequest, fieldErrors, response.StatusInvalidPayload, invalidPayloadMessage)
 		response.JSON(w, resp)
 		return
 	}
@@ -64,15 +103,70 @@ func (h UserHTTPHandler) CreateUser(w http.ResponseWriter, r *http.Request) {
 	response.JSON(w, resp)
 }
 
-func (h UserHTTPHandler) validateRequestBody(body interface{}) (err error) {
+func (h UserHTTPHandler) UpdateUser(w http.ResponseWriter, r *http.Request) {
+	var resp response.Response
+	var payload UserRequest
+
+	ctx := r.Context()
+
+	err := json.NewDecoder(r.Body).Decode(&payload)
+	if err != nil {
+		resp = response.NewErrorResponse(err, http.StatusUnprocessableEntity, nil, response.StatusInvalidPayload, malformedPayloadMessage)
+		response.JSON(w, resp)
+		return
+	}
+
+	if fieldErrors, err := h.validateRequestBody(payload); err != nil {
+		resp = response.NewErrorResponse(err, http.StatusBadRequest, fieldErrors, response.StatusInvalidPayload, invalidPayloadMessage)
+		response.JSON(w, resp)
+		return
+	}
+
+	resp = h.userUsecase.UpdateUser(ctx, mux.Vars(r)["uuid"], payload)
+	response.JSON(w, resp)
+}
+
+func (h UserHTTPHandler) DeleteUser(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	resp := h.userUsecase.DeleteUser(ctx, mux.Vars(r)["uuid"])
+	response.JSON(w, resp)
+}
+
+// ImportUsers imports the csv or jsonl file sent as the request body.
+// The format is taken from the "format" query string, or else from the content type.
+func (h UserHTTPHandler) ImportUsers(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = importFormatOf(r.Header.Get("Content-Type"))
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportSize)
+	resp := h.userUsecase.ImportUsers(ctx, r.Body, format)
+	response.JSON(w, resp)
+}
+
+func importFormatOf(contentType string) string {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch mediaType {
+	case "text/csv":
+		return FileFormatCSV
+	case "application/jsonl", "application/x-ndjson", "application/x-jsonlines":
+		return FileFormatJSONL
+	}
+	return ""
+}
+
+// validateRequestBody returns every failing field of the body, without the submitted values since they may be PII.
+func (h UserHTTPHandler) validateRequestBody(body interface{}) (fieldErrors []response.FieldError, err error) {
 	err = h.validator.Struct(body)
 	if err == nil {
 		return
 	}
 
-	errorFields := err.(validator.ValidationErrors)
-	errorField := errorFields[0]
-	err = fmt.Errorf("invalid '%s' with value '%v'", errorField.Field(), errorField.Value())
-
+	fieldErrors = customvalidator.FieldErrors(err)
+	err = exception.ErrBadRequest
 	return
 }
@@ -0,0 +1,144 @@
+package user
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+)
+
+// importRow is a row read from an import file, err is set when the row itself is malformed.
+// The rows are numbered from 1 in both formats, neither the csv header nor the blank lines are counted.
+type importRow struct {
+	row     int
+	request UserRequest
+	err     error
+}
+
+// importReader reads an import file row by row, Next returns io.EOF once the file is fully read.
+// Any other error means the file cannot be read any further.
+type importReader interface {
+	Next() (row importRow, err error)
+}
+
+func newImportReader(file io.Reader, format string) (importReader, error) {
+	switch format {
+	case FileFormatCSV:
+		return newCSVImportReader(file)
+	case FileFormatJSONL:
+		return newJSONLImportReader(file), nil
+	}
+	return nil, fmt.Errorf("unsupported import format %q", format)
+}
+
+type csvImportReader struct {
+	reader  *csv.Reader
+	columns []int
+	row     int
+}
+
+// newCSVImportReader reads the header of the file, whose columns are the json names of UserRequest in any order.
+func newCSVImportReader(file io.Reader) (*csvImportReader, error) {
+	reader := csv.NewReader(file)
+	reader.TrimLeadingSpace = true
+	reader.ReuseRecord = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("cannot read csv header: %w", err)
+	}
+
+	fields := requestFieldsByJSONName()
+	columns := make([]int, len(header))
+	for i, name := range header {
+		index, ok := fields[strings.TrimSpace(name)]
+		if !ok {
+			return nil, fmt.Errorf("unknown csv column %d", i+1)
+		}
+		columns[i] = index
+	}
+
+	return &csvImportReader{reader: reader, columns: columns}, nil
+}
+
+func (r *csvImportReader) Next() (row importRow, err error) {
+	record, err := r.reader.Read()
+	if err == io.EOF {
+		return
+	}
+
+	r.row++
+	row.row = r.row
+	if err != nil {
+		var parseErr *csv.ParseError
+		if !errors.As(err, &parseErr) {
+			return
+		}
+		// the values of the row are left out of the error, they may be PII.
+		row.err = fmt.Errorf("malformed csv row: %w", parseErr.Err)
+		return row, nil
+	}
+
+	request := reflect.ValueOf(&row.request).Elem()
+	for i, value := range record {
+		request.Field(r.columns[i]).SetString(strings.TrimSpace(value))
+	}
+	return row, nil
+}
+
+type jsonlImportReader struct {
+	scanner *bufio.Scanner
+	row     int
+}
+
+func newJSONLImportReader(file io.Reader) *jsonlImportReader {
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	return &jsonlImportReader{scanner: scanner}
+}
+
+func (r *jsonlImportReader) Next() (row importRow, err error) {
+	for r.scanner.Scan() {
+		line := strings.TrimSpace(r.scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		r.row++
+		row.row = r.row
+		if err := json.Unmarshal([]byte(line), &row.request); err != nil {
+			row.err = errors.New("malformed json row")
+		}
+		return row, nil
+	}
+
+	if err = r.scanner.Err(); err == nil {
+		err = io.EOF
+	}
+	return
+}
+
+// requestFieldsByJSONName maps the json name of every UserRequest field to its index.
+func requestFieldsByJSONName() map[string]int {
+	t := reflect.TypeOf(UserRequest{})
+	fields := make(map[string]int, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.SplitN(t.Field(i).Tag.Get("json"), ",", 2)[0]
+		fields[name] = i
+	}
+	return fields
+}
+
+// uniqueKeys returns the keys used to spot duplicated rows, one per unique blind index.
+func uniqueKeys(emailHash []byte, nationalityIDHash []byte) (emailKey string, nationalityIDKey string) {
+	emailKey = "email:" + hex.EncodeToString(emailHash)
+	if len(nationalityIDHash) > 0 {
+		nationalityIDKey = "nik:" + hex.EncodeToString(nationalityIDHash)
+	}
+	return
+}
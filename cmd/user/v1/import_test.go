@@ -0,0 +1,212 @@
+package user_test
+
+import (
+	"context"
+	"database/sql"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"pii-encrypt-example/cmd/user/v1"
+	"pii-encrypt-example/entity"
+	"pii-encrypt-example/pkg/audit"
+	"pii-encrypt-example/pkg/crypto"
+	"pii-encrypt-example/pkg/database"
+	"pii-encrypt-example/pkg/outbox"
+	"pii-encrypt-example/pkg/response"
+)
+
+// hidingUserRepository finds no registered user by its unique hashes, as if they were created after the lookup.
+type hidingUserRepository struct {
+	user.UserRepository
+}
+
+func (r *hidingUserRepository) FindManyUserByUniqueHashes(ctx context.Context, emailHashes [][]byte, nationalityIDHashes [][]byte) ([]entity.User, error) {
+	return nil, nil
+}
+
+// passThrough is a route middleware letting every request through.
+type passThrough struct{}
+
+func (passThrough) Verify(next http.HandlerFunc) http.HandlerFunc { return next }
+
+// newTestUserUsecase returns a usecase over a fresh sqlite database, wrap decorates its user repository.
+func newTestUserUsecase(t *testing.T, wrap func(user.UserRepository) user.UserRepository) (user.UserUsecase, user.UserRepository) {
+	logger := newTestLogger()
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "user.db")+"?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	migrate(t, db, database.SQLite{})
+
+	keyring := crypto.NewKeyring("v1", crypto.NewAESGCM("12345678901234567890123456789012", "1234567890123456"))
+	var userRepository user.UserRepository = user.NewUserRepository(logger, database.SQLite{}, db, db, "user_encrypt")
+	if wrap != nil {
+		userRepository = wrap(userRepository)
+	}
+	outboxRepository := outbox.NewOutboxRepository(logger, database.SQLite{}, db, "outbox_event")
+	return user.NewUserUsecase(logger, time.UTC, keyring, newTestValidator(), audit.NewAuditor(logger, time.UTC, discardingAuditRepository{}), userRepository, outboxRepository), userRepository
+}
+
+// importResult is the row and the status expected in the report of an import.
+type importResult struct {
+	row    int
+	status string
+}
+
+func TestImportUsers(t *testing.T) {
+	cases := []struct {
+		name   string
+		format string
+		file   string
+		wrap   func(user.UserRepository) user.UserRepository
+		want   []importResult
+	}{
+		{
+			name:   "csv",
+			format: user.FileFormatCSV,
+			file: "email, name\n" +
+				"john@example.com,John Doe\n" +
+				"jane@example.com\n" +
+				"not an email,Jane Doe\n" +
+				"\n" +
+				"John@Example.com ,Johnny Doe\n" +
+				"registered@example.com,Reg Istered\n" +
+				"jack@example.com,Jack Doe\n",
+			want: []importResult{
+				{1, response.StatCreated},
+				{2, response.StatusInvalidPayload},
+				{3, response.StatusInvalidPayload},
+				{4, response.StatDuplicateEmail},
+				{5, response.StatDuplicateEmail},
+				{6, response.StatCreated},
+			},
+		},
+		{
+			name:   "jsonl",
+			format: user.FileFormatJSONL,
+			file: `{"name":"John Doe","email":"john@example.com"}` + "\n" +
+				"\n" +
+				`{"name":` + "\n" +
+				`{"name":"Jane Doe","email":"not an email"}` + "\n" +
+				"   \n" +
+				`{"name":"Johnny Doe","email":"John@Example.com"}` + "\n" +
+				`{"name":"Reg Istered","email":"registered@example.com"}` + "\n" +
+				`{"name":"Jack Doe","email":"jack@example.com"}`,
+			want: []importResult{
+				{1, response.StatCreated},
+				{2, response.StatusInvalidPayload},
+				{3, response.StatusInvalidPayload},
+				{4, response.StatDuplicateEmail},
+				{5, response.StatDuplicateEmail},
+				{6, response.StatCreated},
+			},
+		},
+		{
+			// the registered user is only caught by the transaction, the rows are then inserted one by one
+			name:   "conflict of the batch transaction",
+			format: user.FileFormatJSONL,
+			file: `{"name":"John Doe","email":"john@example.com"}` + "\n" +
+				`{"name":"Reg Istered","email":"registered@example.com"}` + "\n" +
+				`{"name":"Jack Doe","email":"jack@example.com"}`,
+			wrap: func(repository user.UserRepository) user.UserRepository {
+				return &hidingUserRepository{UserRepository: repository}
+			},
+			want: []importResult{
+				{1, response.StatCreated},
+				{2, response.StatDuplicateEmail},
+				{3, response.StatCreated},
+			},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ctx := context.Background()
+			userUsecase, userRepository := newTestUserUsecase(t, c.wrap)
+			if resp := userUsecase.CreateUser(ctx, user.UserRequest{Name: "Registered", Email: "registered@example.com"}); resp.Error() != nil {
+				t.Fatal(resp.Error())
+			}
+
+			resp := userUsecase.ImportUsers(ctx, strings.NewReader(c.file), c.format)
+			if resp.Error() != nil {
+				t.Fatalf("ImportUsers() = %d %v", resp.HTTPStatusCode(), resp.Error())
+			}
+			report := resp.Data().(user.ImportReport)
+			if len(report.Rows) != len(c.want) {
+				t.Fatalf("ImportUsers() reported %+v, want %d rows", report.Rows, len(c.want))
+			}
+			created := 0
+			for i, want := range c.want {
+				if got := report.Rows[i]; got.Row != want.row || got.Status != want.status {
+					t.Errorf("row result #%d = %d %s, want %d %s", i, got.Row, got.Status, want.row, want.status)
+				}
+				if want.status == response.StatCreated {
+					created++
+				}
+			}
+			if report.Total != len(c.want) || report.Imported != created {
+				t.Errorf("ImportUsers() summary = %d rows, %d imported, want %d rows, %d imported", report.Total, report.Imported, len(c.want), created)
+			}
+
+			users, err := userRepository.FindManyUser(ctx, user.UserFilter{})
+			if err != nil || len(users) != created+1 {
+				t.Fatalf("FindManyUser() = %d users, %v, want the %d imported users and the registered one", len(users), err, created)
+			}
+		})
+	}
+}
+
+func TestImportUsersRejectsFile(t *testing.T) {
+	ctx := context.Background()
+	userUsecase, _ := newTestUserUsecase(t, nil)
+
+	cases := []struct {
+		name   string
+		format string
+		file   string
+	}{
+		{"unknown format", "xml", "<users/>"},
+		{"unknown csv column", user.FileFormatCSV, "name,password\nJohn Doe,secret\n"},
+		{"empty csv", user.FileFormatCSV, ""},
+	}
+	for _, c := range cases {
+		if resp := userUsecase.ImportUsers(ctx, strings.NewReader(c.file), c.format); resp.HTTPStatusCode() != http.StatusBadRequest {
+			t.Errorf("ImportUsers() of the %s = %d, want %d", c.name, resp.HTTPStatusCode(), http.StatusBadRequest)
+		}
+	}
+}
+
+func TestImportUsersHTTPHandlerSizeLimit(t *testing.T) {
+	userUsecase, _ := newTestUserUsecase(t, nil)
+	router := mux.NewRouter()
+	user.NewUserHTTPHandler(newTestLogger(), router, passThrough{}, passThrough{}, newTestValidator(), userUsecase)
+
+	// a single field spanning more than the 32 MiB allowed
+	body := io.MultiReader(strings.NewReader("name,email\n\""), io.LimitReader(repeatReader('a'), 33<<20))
+	r := httptest.NewRequest(http.MethodPost, "/api/v1/user/import", body)
+	r.Header.Set("Content-Type", "text/csv")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+
+	if w.Code != http.StatusRequestEntityTooLarge || !strings.Contains(w.Body.String(), response.StatPayloadTooLarge) {
+		t.Fatalf("import of a file too large = %d %s, want %d", w.Code, w.Body, http.StatusRequestEntityTooLarge)
+	}
+}
+
+// repeatReader reads the same byte forever.
+type repeatReader byte
+
+func (r repeatReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = byte(r)
+	}
+	return len(p), nil
+}
@@ -1,20 +1,107 @@
 package user
 
-import "time"
+import (
+	"pii-encrypt-example/pkg/response"
+	"time"
+)
 
 type UserResponse struct {
-	UUID      string    `json:"uuid"`
-	Name      string    `json:"name"`
-	Email     string    `json:"email"`
-	CreatedAt time.Time `json:"createdAt"`
+	UUID          string    `json:"uuid"`
+	Name          string    `json:"name"`
+	Email         string    `json:"email"`
+	PhoneNumber   string    `json:"phoneNumber"`
+	NationalityID string    `json:"nationalityId"`
+	DateOfBirth   string    `json:"dateOfBirth"`
+	Address       string    `json:"address"`
+	CreatedAt     time.Time `json:"createdAt"`
 }
 
 type UserRequest struct {
-	Name  string `json:"name" validate:"required"`
-	Email string `json:"email" validate:"required"`
+	Name          string `json:"name" validate:"required,default-name"`
+	Email         string `json:"email" validate:"required,email,max=254"`
+	PhoneNumber   string `json:"phoneNumber" validate:"omitempty,idn-mobile-number"`
+	NationalityID string `json:"nationalityId" validate:"omitempty,nik"`
+	DateOfBirth   string `json:"dateOfBirth" validate:"omitempty,ISO8601date"`
+	Address       string `json:"address" validate:"omitempty,max=255"`
+}
+
+// Types of the events published when a user changes.
+const (
+	EventUserCreated = "user.created"
+	EventUserUpdated = "user.updated"
+	EventUserDeleted = "user.deleted"
+)
+
+// UserEvent is the data of the user events, every PII field is masked. A deleted user only carries its uuid.
+type UserEvent struct {
+	UUID          string `json:"uuid"`
+	Name          string `json:"name,omitempty"`
+	Email         string `json:"email,omitempty"`
+	PhoneNumber   string `json:"phoneNumber,omitempty"`
+	NationalityID string `json:"nationalityId,omitempty"`
+	DateOfBirth   string `json:"dateOfBirth,omitempty"`
+	Address       string `json:"address,omitempty"`
 }
 
 type UserFilter struct {
 	Name       string
 	NameHashed []byte
+	// AfterUUID and Limit page the users in the order of their uuid, a zero Limit returns every user.
+	AfterUUID string
+	Limit     int
+}
+
+// Formats of the files imported and exported.
+const (
+	FileFormatCSV   = "csv"
+	FileFormatJSONL = "jsonl"
+)
+
+// ImportReport is the outcome of a bulk import, with one result per row of the file.
+type ImportReport struct {
+	Total      int               `json:"total"`
+	Imported   int               `json:"imported"`
+	Duplicated int               `json:"duplicated"`
+	Invalid    int               `json:"invalid"`
+	Failed     int               `json:"failed"`
+	Rows       []ImportRowResult `json:"rows"`
+}
+
+// ImportRowResult is the outcome of a single row, its status is one of the response statuses.
+type ImportRowResult struct {
+	Row     int                   `json:"row"`
+	Status  string                `json:"status"`
+	UUID    string                `json:"uuid,omitempty"`
+	Message string                `json:"message,omitempty"`
+	Errors  []response.FieldError `json:"errors,omitempty"`
+}
+
+// Statuses of an export job.
+const (
+	ExportStatusPending = "PENDING"
+	ExportStatusRunning = "RUNNING"
+	ExportStatusDone    = "DONE"
+	ExportStatusFailed  = "FAILED"
+)
+
+// ExportRequest asks for an encrypted export of the users matching Name, or of every user when it is empty.
+// The file is encrypted for the holder of PublicKey, a PEM encoded RSA key, or else with a one-time passphrase.
+type ExportRequest struct {
+	Format    string `json:"format" validate:"required,oneof=csv jsonl"`
+	Name      string `json:"name"`
+	PublicKey string `json:"publicKey"`
+}
+
+// ExportResponse describes an export job. The passphrase is only given once, when the job is created,
+// and the url is signed for a short time every time a done job is fetched.
+type ExportResponse struct {
+	ID           string     `json:"id"`
+	Status       string     `json:"status"`
+	Format       string     `json:"format"`
+	Total        int        `json:"total"`
+	Passphrase   string     `json:"passphrase,omitempty"`
+	URL          string     `json:"url,omitempty"`
+	URLExpiresAt *time.Time `json:"urlExpiresAt,omitempty"`
+	CreatedAt    time.Time  `json:"createdAt"`
+	FinishedAt   *time.Time `json:"finishedAt,omitempty"`
 }
@@ -5,20 +5,51 @@ import (
 	"database/sql"
 	"fmt"
 	"pii-encrypt-example/entity"
+	"pii-encrypt-example/pkg/database"
 	"pii-encrypt-example/pkg/exception"
+	"strings"
 
-	"github.com/go-sql-driver/mysql"
 	"github.com/sirupsen/logrus"
 )
 
+// Unique keys of the user table, used to tell which blind index a duplicate entry belongs to.
+// Sqlite reports the columns instead of the key, so both are matched.
+const (
+	emailHashUniqueKey         = "uq_user_encrypt_email_hash"
+	emailHashColumn            = "__encrypted__data_email_hash"
+	nationalityIDHashUniqueKey = "uq_user_encrypt_nik_hash"
+	nationalityIDHashColumn    = "__encrypted__data_nik_hash"
+)
+
+// Conflicts returned by the repository, all of them wrap exception.ErrConflict.
+var (
+	ErrDuplicateEmail         = fmt.Errorf("%w: duplicate email", exception.ErrConflict)
+	ErrDuplicateNationalityID = fmt.Errorf("%w: duplicate nationality id", exception.ErrConflict)
+	ErrUserReferenced         = fmt.Errorf("%w: user is still referenced", exception.ErrConflict)
+)
+
+const (
+	userInsertColumns = `uuid, __encrypted__data_nama_crypt, __encrypted__data_nama_hash, __encrypted__data_email_crypt, __encrypted__data_email_hash, __encrypted__data_phone_number_crypt, __encrypted__data_phone_number_hash, __encrypted__data_nik_crypt, __encrypted__data_nik_hash, __encrypted__data_date_of_birth_crypt, __encrypted__data_address_crypt, created_at, key_id`
+	userInsertValues  = `(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	userSelectColumns = `u.uuid, u.__encrypted__data_nama_crypt, u.__encrypted__data_nama_hash, u.__encrypted__data_email_crypt, u.__encrypted__data_email_hash, u.__encrypted__data_phone_number_crypt, u.__encrypted__data_phone_number_hash, u.__encrypted__data_nik_crypt, u.__encrypted__data_nik_hash, u.__encrypted__data_date_of_birth_crypt, u.__encrypted__data_address_crypt, u.created_at, u.key_id`
+)
+
 type UserRepository interface {
-	BeginTx(ctx context.Context) (tx *sql.Tx, err error)
-	RollbackTx(ctx context.Context, tx *sql.Tx) (err error)
-	CommitTx(ctx context.Context, tx *sql.Tx) (err error)
-	SaveUser(ctx context.Context, user entity.User, tx *sql.Tx) (id int64, err error)
-	// UpdateById(ctx context.Context, id int64, user UserRequest, tx *sql.Tx) (err error)
+	BeginTx(ctx context.Context) (tx database.Tx, err error)
+	RollbackTx(ctx context.Context, tx database.Tx) (err error)
+	CommitTx(ctx context.Context, tx database.Tx) (err error)
+	SaveUser(ctx context.Context, user entity.User, tx database.Tx) (id int64, err error)
+	SaveManyUsers(ctx context.Context, users []entity.User, tx database.Tx) (err error)
+	UpdateUser(ctx context.Context, user entity.User, tx database.Tx) (err error)
+	RotateUser(ctx context.Context, user entity.User, previousKeyID string, tx database.Tx) (err error)
+	DeleteUser(ctx context.Context, uuid string, tx database.Tx) (err error)
 	FindManyUser(ctx context.Context, filter UserFilter) (bunchOfUsers []entity.User, err error)
-	// FindOneUserByUUID(ctx context.Context, uuid string) (user entity.User, err error)
+	EachUser(ctx context.Context, filter UserFilter, fn func(user entity.User) error) (err error)
+	FindManyUserByUniqueHashes(ctx context.Context, emailHashes [][]byte, nationalityIDHashes [][]byte) (bunchOfUsers []entity.User, err error)
+	FindManyUserNotSealedWith(ctx context.Context, keyID string, afterUUID string, limit int) (bunchOfUsers []entity.User, err error)
+	FindOneUserByUUID(ctx context.Context, uuid string) (user entity.User, err error)
+	// UserExists reads the read write database, so a user created a moment ago is found.
+	UserExists(ctx context.Context, uuid string) (exists bool, err error)
 }
 
 type sqlCommand interface {
@@ -30,15 +61,17 @@ type sqlCommand interface {
 
 type userRepository struct {
 	logger      *logrus.Logger
+	dialect     database.Dialect
 	dbReadOnly  *sql.DB
 	dbReadWrite *sql.DB
 	tableName   string
 }
 
-// NewUserRepository is a constructor
-func NewUserRepository(logger *logrus.Logger, dbReadOnly *sql.DB, dbReadWrite *sql.DB, tableName string) UserRepository {
+// NewUserRepository is a constructor, the queries are written for the dialect of the databases.
+func NewUserRepository(logger *logrus.Logger, dialect database.Dialect, dbReadOnly *sql.DB, dbReadWrite *sql.DB, tableName string) UserRepository {
 	return &userRepository{
 		logger:      logger,
+		dialect:     dialect,
 		dbReadOnly:  dbReadOnly,
 		dbReadWrite: dbReadWrite,
 		tableName:   tableName,
@@ -46,57 +79,292 @@ func NewUserRepository(logger *logrus.Logger, dbReadOnly *sql.DB, dbReadWrite *s
 }
 
 // BeginTx returns sql trx for global scope.
-func (r *userRepository) BeginTx(ctx context.Context) (tx *sql.Tx, err error) {
+func (r *userRepository) BeginTx(ctx context.Context) (tx database.Tx, err error) {
 	return r.dbReadWrite.BeginTx(ctx, nil)
 }
 
+// command returns the transaction to run the commands in, or the read write database when there is none.
+func (r *userRepository) command(tx database.Tx) (cmd sqlCommand, err error) {
+	if tx == nil {
+		return r.dbReadWrite, nil
+	}
+	sqlTx, ok := tx.(*sql.Tx)
+	if !ok {
+		return nil, database.ErrForeignTx
+	}
+	return sqlTx, nil
+}
+
 // CommitTx will commit the transaction that has began.
-func (r *userRepository) CommitTx(ctx context.Context, tx *sql.Tx) (err error) {
+func (r *userRepository) CommitTx(ctx context.Context, tx database.Tx) (err error) {
 	return tx.Commit()
 }
 
 // RollbackTx will rollback the transaction to achieve the consistency.
-func (r *userRepository) RollbackTx(ctx context.Context, tx *sql.Tx) (err error) {
+func (r *userRepository) RollbackTx(ctx context.Context, tx database.Tx) (err error) {
 	return tx.Rollback()
 }
 
 // Save will collect the order
-func (r *userRepository) SaveUser(ctx context.Context, user entity.User, tx *sql.Tx) (id int64, err error) {
-	var cmd sqlCommand = r.dbReadWrite
-	if tx != nil {
-		cmd = tx
+func (r *userRepository) SaveUser(ctx context.Context, user entity.User, tx database.Tx) (id int64, err error) {
+	cmd, err := r.command(tx)
+	if err != nil {
+		return
 	}
 
-	command := fmt.Sprintf(`INSERT INTO %s SET uuid = ?, __encrypted__data_nama_crypt = ?, __encrypted__data_nama_hash = ?, __encrypted__data_email_crypt = ?, created_at = ?`, r.tableName)
-	_, err = r.exec(ctx, cmd, command, user.UUID, user.NameCrypt, user.NameHash, user.EmailCrypt, user.CreatedAt)
+	command := fmt.Sprintf(`INSERT INTO %s (%s) VALUES %s`, r.tableName, userInsertColumns, userInsertValues)
+	_, err = r.exec(ctx, cmd, command, user.UUID, user.NameCrypt, user.NameHash, user.EmailCrypt, user.EmailHash, user.PhoneNumberCrypt, user.PhoneNumberHash, user.NationalityIDCrypt, user.NationalityIDHash, user.DateOfBirthCrypt, user.AddressCrypt, user.CreatedAt, nullString(user.KeyID))
 	if err != nil {
-		err = wrapError(err)
+		err = r.wrapError(err)
 		return
 	}
 
 	// id, err = res.LastInsertId()
 	// if err != nil {
-	// 	err = wrapError(err)
+	// 	err = r.wrapError(err)
 	// 	return
 	// }
 
 	return
 }
 
+// SaveManyUsers inserts the users with a single multi-row statement, so either every user is saved or none is.
+func (r *userRepository) SaveManyUsers(ctx context.Context, users []entity.User, tx database.Tx) (err error) {
+	cmd, err := r.command(tx)
+	if err != nil {
+		return
+	}
+	if len(users) == 0 {
+		return
+	}
+
+	values := make([]string, len(users))
+	params := make([]interface{}, 0, len(users)*13)
+	for i, user := range users {
+		values[i] = userInsertValues
+		params = append(params, user.UUID, user.NameCrypt, user.NameHash, user.EmailCrypt, user.EmailHash, user.PhoneNumberCrypt, user.PhoneNumberHash, user.NationalityIDCrypt, user.NationalityIDHash, user.DateOfBirthCrypt, user.AddressCrypt, user.CreatedAt, nullString(user.KeyID))
+	}
+
+	command := fmt.Sprintf(`INSERT INTO %s (%s) VALUES %s`, r.tableName, userInsertColumns, strings.Join(values, ", "))
+	_, err = r.exec(ctx, cmd, command, params...)
+	if err != nil {
+		err = r.wrapError(err)
+		return
+	}
+
+	return
+}
+
+// UpdateUser replaces every encrypted field and the key id of the user, the uuid and the creation time are kept.
+func (r *userRepository) UpdateUser(ctx context.Context, user entity.User, tx database.Tx) (err error) {
+	cmd, err := r.command(tx)
+	if err != nil {
+		return
+	}
+
+	command := fmt.Sprintf(`UPDATE %s SET __encrypted__data_nama_crypt = ?, __encrypted__data_nama_hash = ?, __encrypted__data_email_crypt = ?, __encrypted__data_email_hash = ?, __encrypted__data_phone_number_crypt = ?, __encrypted__data_phone_number_hash = ?, __encrypted__data_nik_crypt = ?, __encrypted__data_nik_hash = ?, __encrypted__data_date_of_birth_crypt = ?, __encrypted__data_address_crypt = ?, key_id = ? WHERE uuid = ?`, r.tableName)
+	_, err = r.exec(ctx, cmd, command, user.NameCrypt, user.NameHash, user.EmailCrypt, user.EmailHash, user.PhoneNumberCrypt, user.PhoneNumberHash, user.NationalityIDCrypt, user.NationalityIDHash, user.DateOfBirthCrypt, user.AddressCrypt, nullString(user.KeyID), user.UUID)
+	if err != nil {
+		err = r.wrapError(err)
+		return
+	}
+
+	return
+}
+
+// RotateUser updates the user only while it is still sealed with the previous key, and returns exception.ErrConflict
+// when it has been written or deleted since it was read.
+func (r *userRepository) RotateUser(ctx context.Context, user entity.User, previousKeyID string, tx database.Tx) (err error) {
+	cmd, err := r.command(tx)
+	if err != nil {
+		return
+	}
+
+	command := fmt.Sprintf(`UPDATE %s SET __encrypted__data_nama_crypt = ?, __encrypted__data_nama_hash = ?, __encrypted__data_email_crypt = ?, __encrypted__data_email_hash = ?, __encrypted__data_phone_number_crypt = ?, __encrypted__data_phone_number_hash = ?, __encrypted__data_nik_crypt = ?, __encrypted__data_nik_hash = ?, __encrypted__data_date_of_birth_crypt = ?, __encrypted__data_address_crypt = ?, key_id = ? WHERE uuid = ? AND COALESCE(key_id, '') = ?`, r.tableName)
+	result, err := r.exec(ctx, cmd, command, user.NameCrypt, user.NameHash, user.EmailCrypt, user.EmailHash, user.PhoneNumberCrypt, user.PhoneNumberHash, user.NationalityIDCrypt, user.NationalityIDHash, user.DateOfBirthCrypt, user.AddressCrypt, nullString(user.KeyID), user.UUID, previousKeyID)
+	if err != nil {
+		err = r.wrapError(err)
+		return
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		err = r.wrapError(err)
+		return
+	}
+	if affected == 0 {
+		err = exception.ErrConflict
+	}
+	return
+}
+
+func (r *userRepository) DeleteUser(ctx context.Context, uuid string, tx database.Tx) (err error) {
+	cmd, err := r.command(tx)
+	if err != nil {
+		return
+	}
+
+	command := fmt.Sprintf(`DELETE FROM %s WHERE uuid = ?`, r.tableName)
+	result, err := r.exec(ctx, cmd, command, uuid)
+	if err != nil {
+		err = r.wrapError(err)
+		return
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		err = r.wrapError(err)
+		return
+	}
+	if affected == 0 {
+		err = exception.ErrNotFound
+	}
+
+	return
+}
+
+func (r *userRepository) FindOneUserByUUID(ctx context.Context, uuid string) (user entity.User, err error) {
+	var cmd sqlCommand = r.dbReadOnly
+
+	q := fmt.Sprintf(`SELECT %s FROM %s u WHERE u.uuid = ?`, userSelectColumns, r.tableName)
+	bunchOfUsers, err := r.query(ctx, cmd, q, uuid)
+	if err != nil {
+		err = r.wrapError(err)
+		return
+	}
+	if len(bunchOfUsers) == 0 {
+		err = exception.ErrNotFound
+		return
+	}
+
+	return bunchOfUsers[0], nil
+}
+
 func (r *userRepository) FindManyUser(ctx context.Context, filter UserFilter) (bunchOfUsers []entity.User, err error) {
 	var cmd sqlCommand = r.dbReadOnly
-	var params []interface{}
 
-	q := fmt.Sprintf(`SELECT u.uuid, u.__encrypted__data_nama_crypt, u.__encrypted__data_nama_hash, u.__encrypted__data_email_crypt, u.created_at FROM %s u`, r.tableName)
+	q, params := r.filterQuery(filter)
+	bunchOfUsers, err = r.query(ctx, cmd, q, params...)
+	if err != nil {
+		err = r.wrapError(err)
+		return
+	}
+	return
+}
+
+// filterQuery selects the users matching the filter, a page is ordered by uuid.
+func (r *userRepository) filterQuery(filter UserFilter) (q string, params []interface{}) {
+	var conditions []string
+
+	q = fmt.Sprintf(`SELECT %s FROM %s u`, userSelectColumns, r.tableName)
 
 	if filter.Name != "" {
-		q += fmt.Sprintf(` WHERE %s = ?`, "u.__encrypted__data_nama_hash")
+		conditions = append(conditions, "u.__encrypted__data_nama_hash = ?")
 		params = append(params, filter.NameHashed)
 	}
+	if filter.AfterUUID != "" {
+		conditions = append(conditions, "u.uuid > ?")
+		params = append(params, filter.AfterUUID)
+	}
+	if len(conditions) > 0 {
+		q += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	if filter.Limit > 0 {
+		q += fmt.Sprintf(` ORDER BY u.uuid LIMIT %d`, filter.Limit)
+	}
+	return
+}
+
+// EachUser streams the users matching the filter to fn, one row at a time, and stops at the first error returned by fn.
+func (r *userRepository) EachUser(ctx context.Context, filter UserFilter, fn func(user entity.User) error) (err error) {
+	var cmd sqlCommand = r.dbReadOnly
+
+	q, params := r.filterQuery(filter)
+	rows, err := cmd.QueryContext(ctx, r.dialect.Rebind(q), params...)
+	if err != nil {
+		r.logger.WithContext(ctx).Error(q, err)
+		return r.wrapError(err)
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			r.logger.WithContext(ctx).Error(q, err)
+		}
+	}()
 
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			r.logger.WithContext(ctx).Error(q, err)
+			return r.wrapError(err)
+		}
+		if err = fn(user); err != nil {
+			return err
+		}
+	}
+
+	if err = rows.Err(); err != nil {
+		r.logger.WithContext(ctx).Error(q, err)
+		return r.wrapError(err)
+	}
+	return
+}
+
+func (r *userRepository) UserExists(ctx context.Context, uuid string) (exists bool, err error) {
+	var cmd sqlCommand = r.dbReadWrite
+	var one int
+
+	q := fmt.Sprintf(`SELECT 1 FROM %s WHERE uuid = ?`, r.tableName)
+	err = cmd.QueryRowContext(ctx, r.dialect.Rebind(q), uuid).Scan(&one)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		r.logger.WithContext(ctx).Error(q, err)
+		return false, r.wrapError(err)
+	}
+	return true, nil
+}
+
+// FindManyUserNotSealedWith returns up to limit users sealed with another key than keyID, ordered by uuid from afterUUID excluded.
+// They are read from the read write database, a row of a lagging replica would be rotated from its stale content.
+func (r *userRepository) FindManyUserNotSealedWith(ctx context.Context, keyID string, afterUUID string, limit int) (bunchOfUsers []entity.User, err error) {
+	var cmd sqlCommand = r.dbReadWrite
+
+	q := fmt.Sprintf(`SELECT %s FROM %s u WHERE COALESCE(u.key_id, '') <> ? AND u.uuid > ? ORDER BY u.uuid LIMIT %d`, userSelectColumns, r.tableName, limit)
+	bunchOfUsers, err = r.query(ctx, cmd, q, keyID, afterUUID)
+	if err != nil {
+		err = r.wrapError(err)
+		return
+	}
+	return
+}
+
+// FindManyUserByUniqueHashes returns the users owning any of the given email or nationality id blind indexes.
+func (r *userRepository) FindManyUserByUniqueHashes(ctx context.Context, emailHashes [][]byte, nationalityIDHashes [][]byte) (bunchOfUsers []entity.User, err error) {
+	var cmd sqlCommand = r.dbReadWrite
+	var conditions []string
+	var params []interface{}
+
+	if len(emailHashes) > 0 {
+		conditions = append(conditions, fmt.Sprintf(`u.__encrypted__data_email_hash IN (%s)`, placeholders(len(emailHashes))))
+		for _, h := range emailHashes {
+			params = append(params, h)
+		}
+	}
+	if len(nationalityIDHashes) > 0 {
+		conditions = append(conditions, fmt.Sprintf(`u.__encrypted__data_nik_hash IN (%s)`, placeholders(len(nationalityIDHashes))))
+		for _, h := range nationalityIDHashes {
+			params = append(params, h)
+		}
+	}
+	if len(conditions) == 0 {
+		return
+	}
+
+	q := fmt.Sprintf(`SELECT %s FROM %s u WHERE %s`, userSelectColumns, r.tableName, strings.Join(conditions, " OR "))
 	bunchOfUsers, err = r.query(ctx, cmd, q, params...)
 	if err != nil {
-		err = wrapError(err)
+		err = r.wrapError(err)
 		return
 	}
 	return
@@ -104,7 +372,7 @@ func (r *userRepository) FindManyUser(ctx context.Context, filter UserFilter) (b
 
 func (r *userRepository) query(ctx context.Context, cmd sqlCommand, query string, args ...interface{}) (bunchOfUsers []entity.User, err error) {
 	var rows *sql.Rows
-	if rows, err = cmd.QueryContext(ctx, query, args...); err != nil {
+	if rows, err = cmd.QueryContext(ctx, r.dialect.Rebind(query), args...); err != nil {
 		r.logger.WithContext(ctx).Error(query, err)
 		return
 	}
@@ -118,7 +386,7 @@ func (r *userRepository) query(ctx context.Context, cmd sqlCommand, query string
 	for rows.Next() {
 		var user entity.User
 
-		err = rows.Scan(&user.UUID, &user.NameCrypt, &user.NameHash, &user.EmailCrypt, &user.CreatedAt)
+		user, err = scanUser(rows)
 
 		if err != nil {
 			r.logger.WithContext(ctx).Error(query, err)
@@ -131,9 +399,21 @@ func (r *userRepository) query(ctx context.Context, cmd sqlCommand, query string
 	return
 }
 
+func scanUser(rows *sql.Rows) (user entity.User, err error) {
+	var keyID sql.NullString
+	err = rows.Scan(&user.UUID, &user.NameCrypt, &user.NameHash, &user.EmailCrypt, &user.EmailHash, &user.PhoneNumberCrypt, &user.PhoneNumberHash, &user.NationalityIDCrypt, &user.NationalityIDHash, &user.DateOfBirthCrypt, &user.AddressCrypt, &user.CreatedAt, &keyID)
+	user.KeyID = keyID.String
+	return
+}
+
+// nullString stores an empty string as NULL.
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
 func (r *userRepository) exec(ctx context.Context, cmd sqlCommand, command string, args ...interface{}) (result sql.Result, err error) {
 	var stmt *sql.Stmt
-	if stmt, err = cmd.PrepareContext(ctx, command); err != nil {
+	if stmt, err = cmd.PrepareContext(ctx, r.dialect.Rebind(command)); err != nil {
 		r.logger.WithContext(ctx).Error(command, err)
 		return
 	}
@@ -150,14 +430,25 @@ func (r *userRepository) exec(ctx context.Context, cmd sqlCommand, command strin
 	return
 }
 
-func wrapError(e error) (err error) {
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
+
+func (r *userRepository) wrapError(e error) (err error) {
 	if e == sql.ErrNoRows {
 		return exception.ErrNotFound
 	}
-	if driverErr, ok := e.(*mysql.MySQLError); ok {
-		if driverErr.Number == 1062 {
-			return exception.ErrConflict
+	if detail, ok := r.dialect.DuplicateKey(e); ok {
+		switch {
+		case strings.Contains(detail, emailHashUniqueKey), strings.Contains(detail, emailHashColumn):
+			return ErrDuplicateEmail
+		case strings.Contains(detail, nationalityIDHashUniqueKey), strings.Contains(detail, nationalityIDHashColumn):
+			return ErrDuplicateNationalityID
 		}
+		return exception.ErrConflict
+	}
+	if r.dialect.ForeignKeyViolation(e) {
+		return ErrUserReferenced
 	}
 	return exception.ErrInternalServer
 }
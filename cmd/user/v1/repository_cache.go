@@ -0,0 +1,335 @@
+package user
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"expvar"
+	"pii-encrypt-example/entity"
+	"pii-encrypt-example/pkg/database"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/sirupsen/logrus"
+)
+
+// cacheMetrics is published on /debug/vars, hit_rate is the share of the lookups answered by the cache.
+var cacheMetrics = expvar.NewMap("user_repository_cache")
+
+func init() {
+	cacheMetrics.Set("hit_rate", expvar.Func(func() interface{} {
+		hits, misses := cacheCounter("hits"), cacheCounter("misses")
+		if hits+misses == 0 {
+			return 0.0
+		}
+		return float64(hits) / float64(hits+misses)
+	}))
+}
+
+func cacheCounter(name string) int64 {
+	if counter, ok := cacheMetrics.Get(name).(*expvar.Int); ok {
+		return counter.Value()
+	}
+	return 0
+}
+
+// cacheTombstone replaces a key invalidated by a write, the key is not cached again while it is there.
+const cacheTombstone = "-"
+
+type cachedUserRepository struct {
+	UserRepository
+	logger     *logrus.Logger
+	client     *redis.Client
+	ttl        time.Duration
+	replicaLag time.Duration
+	prefix     string
+
+	mu sync.Mutex
+	// pending holds the keys invalidated inside a transaction, they are invalidated again once it is committed
+	// so a read made before the commit cannot keep the previous rows in the cache.
+	pending map[database.Tx][]string
+}
+
+// NewCachedUserRepository decorates the repository with a read-through redis cache of the users by uuid and by name blind index.
+// Only the rows as stored are cached, so the cache holds ciphertext and blind indexes, never plaintext.
+// The keys invalidated by a write are not cached again for replicaLag, so the rows read from a read only replica
+// which has not caught up with the write yet never make it into the cache. A failing redis is logged and bypassed.
+func NewCachedUserRepository(logger *logrus.Logger, client *redis.Client, ttl time.Duration, replicaLag time.Duration, userRepository UserRepository) UserRepository {
+	return &cachedUserRepository{
+		UserRepository: userRepository,
+		logger:         logger,
+		client:         client,
+		ttl:            ttl,
+		replicaLag:     replicaLag,
+		prefix:         "user:",
+		pending:        make(map[database.Tx][]string),
+	}
+}
+
+func (r *cachedUserRepository) uuidKey(uuid string) string {
+	return r.prefix + "uuid:" + uuid
+}
+
+func (r *cachedUserRepository) nameKey(nameHash []byte) string {
+	return r.prefix + "name:" + hex.EncodeToString(nameHash)
+}
+
+func (r *cachedUserRepository) FindOneUserByUUID(ctx context.Context, uuid string) (user entity.User, err error) {
+	users, ok := r.getUsers(ctx, []string{r.uuidKey(uuid)})
+	if ok {
+		return users[0], nil
+	}
+
+	user, err = r.UserRepository.FindOneUserByUUID(ctx, uuid)
+	if err != nil {
+		return
+	}
+
+	r.setUsers(ctx, []entity.User{user}, "", nil)
+	return
+}
+
+// FindManyUser caches the uuids of the users owning a name blind index, the list of every user is not cached.
+// A page of the users of a name is cut from the cached list.
+func (r *cachedUserRepository) FindManyUser(ctx context.Context, filter UserFilter) (bunchOfUsers []entity.User, err error) {
+	if filter.Name == "" {
+		return r.UserRepository.FindManyUser(ctx, filter)
+	}
+
+	indexKey := r.nameKey(filter.NameHashed)
+	if bunchOfUsers, ok := r.getIndex(ctx, indexKey); ok {
+		return pageUsers(bunchOfUsers, filter), nil
+	}
+
+	bunchOfUsers, err = r.UserRepository.FindManyUser(ctx, UserFilter{Name: filter.Name, NameHashed: filter.NameHashed})
+	if err != nil {
+		return
+	}
+
+	uuids := make([]string, len(bunchOfUsers))
+	for i, user := range bunchOfUsers {
+		uuids[i] = user.UUID
+	}
+	r.setUsers(ctx, bunchOfUsers, indexKey, uuids)
+	return pageUsers(bunchOfUsers, filter), nil
+}
+
+// pageUsers returns the page of the filter out of every user matching it, as the repository would.
+func pageUsers(bunchOfUsers []entity.User, filter UserFilter) []entity.User {
+	if filter.Limit == 0 && filter.AfterUUID == "" {
+		return bunchOfUsers
+	}
+
+	page := make([]entity.User, 0, len(bunchOfUsers))
+	for _, user := range bunchOfUsers {
+		if user.UUID > filter.AfterUUID {
+			page = append(page, user)
+		}
+	}
+	sort.Slice(page, func(i, j int) bool { return page[i].UUID < page[j].UUID })
+	if filter.Limit > 0 && len(page) > filter.Limit {
+		page = page[:filter.Limit]
+	}
+	return page
+}
+
+func (r *cachedUserRepository) SaveUser(ctx context.Context, user entity.User, tx database.Tx) (id int64, err error) {
+	if id, err = r.UserRepository.SaveUser(ctx, user, tx); err != nil {
+		return
+	}
+
+	r.invalidate(ctx, tx, r.nameKey(user.NameHash))
+	return
+}
+
+func (r *cachedUserRepository) SaveManyUsers(ctx context.Context, users []entity.User, tx database.Tx) (err error) {
+	if err = r.UserRepository.SaveManyUsers(ctx, users, tx); err != nil {
+		return
+	}
+
+	keys := make([]string, len(users))
+	for i, user := range users {
+		keys[i] = r.nameKey(user.NameHash)
+	}
+	r.invalidate(ctx, tx, keys...)
+	return
+}
+
+func (r *cachedUserRepository) UpdateUser(ctx context.Context, user entity.User, tx database.Tx) (err error) {
+	keys := r.keysOf(ctx, user.UUID)
+	if err = r.UserRepository.UpdateUser(ctx, user, tx); err != nil {
+		return
+	}
+
+	r.invalidate(ctx, tx, append(keys, r.nameKey(user.NameHash))...)
+	return
+}
+
+func (r *cachedUserRepository) RotateUser(ctx context.Context, user entity.User, previousKeyID string, tx database.Tx) (err error) {
+	keys := r.keysOf(ctx, user.UUID)
+	if err = r.UserRepository.RotateUser(ctx, user, previousKeyID, tx); err != nil {
+		return
+	}
+
+	r.invalidate(ctx, tx, append(keys, r.nameKey(user.NameHash))...)
+	return
+}
+
+func (r *cachedUserRepository) DeleteUser(ctx context.Context, uuid string, tx database.Tx) (err error) {
+	keys := r.keysOf(ctx, uuid)
+	if err = r.UserRepository.DeleteUser(ctx, uuid, tx); err != nil {
+		return
+	}
+
+	r.invalidate(ctx, tx, keys...)
+	return
+}
+
+func (r *cachedUserRepository) CommitTx(ctx context.Context, tx database.Tx) (err error) {
+	err = r.UserRepository.CommitTx(ctx, tx)
+
+	r.mu.Lock()
+	keys := r.pending[tx]
+	delete(r.pending, tx)
+	r.mu.Unlock()
+
+	if err == nil {
+		r.invalidate(ctx, nil, keys...)
+	}
+	return
+}
+
+func (r *cachedUserRepository) RollbackTx(ctx context.Context, tx database.Tx) (err error) {
+	r.mu.Lock()
+	delete(r.pending, tx)
+	r.mu.Unlock()
+
+	return r.UserRepository.RollbackTx(ctx, tx)
+}
+
+// keysOf returns the keys caching the user as currently stored, they are read before it changes.
+func (r *cachedUserRepository) keysOf(ctx context.Context, uuid string) (keys []string) {
+	keys = []string{r.uuidKey(uuid)}
+	user, err := r.UserRepository.FindOneUserByUUID(ctx, uuid)
+	if err == nil {
+		keys = append(keys, r.nameKey(user.NameHash))
+	}
+	return
+}
+
+// invalidate replaces the keys with tombstones for replicaLag, they are invalidated again at the commit of tx.
+func (r *cachedUserRepository) invalidate(ctx context.Context, tx database.Tx, keys ...string) {
+	if len(keys) == 0 {
+		return
+	}
+
+	if tx != nil {
+		r.mu.Lock()
+		r.pending[tx] = append(r.pending[tx], keys...)
+		r.mu.Unlock()
+	}
+
+	_, err := r.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, key := range keys {
+			if r.replicaLag > 0 {
+				pipe.Set(ctx, key, cacheTombstone, r.replicaLag)
+			} else {
+				pipe.Del(ctx, key)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		cacheMetrics.Add("errors", 1)
+		r.logger.WithContext(ctx).Error(err)
+	}
+}
+
+// getIndex returns the users of an index key, it is a miss unless every user of the index is cached too.
+func (r *cachedUserRepository) getIndex(ctx context.Context, indexKey string) (bunchOfUsers []entity.User, ok bool) {
+	value, err := r.client.Get(ctx, indexKey).Bytes()
+	if err != nil {
+		r.miss(ctx, err)
+		return
+	}
+	if string(value) == cacheTombstone {
+		r.miss(ctx, redis.Nil)
+		return
+	}
+
+	var uuids []string
+	if err := json.Unmarshal(value, &uuids); err != nil {
+		r.miss(ctx, err)
+		return
+	}
+	if len(uuids) == 0 {
+		cacheMetrics.Add("hits", 1)
+		return []entity.User{}, true
+	}
+
+	keys := make([]string, len(uuids))
+	for i, uuid := range uuids {
+		keys[i] = r.uuidKey(uuid)
+	}
+	return r.getUsers(ctx, keys)
+}
+
+func (r *cachedUserRepository) getUsers(ctx context.Context, keys []string) (bunchOfUsers []entity.User, ok bool) {
+	values, err := r.client.MGet(ctx, keys...).Result()
+	if err != nil {
+		r.miss(ctx, err)
+		return
+	}
+
+	bunchOfUsers = make([]entity.User, len(values))
+	for i, value := range values {
+		s, isString := value.(string)
+		if !isString || s == cacheTombstone {
+			r.miss(ctx, redis.Nil)
+			return nil, false
+		}
+		if err := json.Unmarshal([]byte(s), &bunchOfUsers[i]); err != nil {
+			r.miss(ctx, err)
+			return nil, false
+		}
+	}
+
+	cacheMetrics.Add("hits", 1)
+	return bunchOfUsers, true
+}
+
+// setUsers caches the users, and the index key listing their uuids when given, the keys already set are left as is:
+// either they are up to date or they are tombstones.
+func (r *cachedUserRepository) setUsers(ctx context.Context, bunchOfUsers []entity.User, indexKey string, uuids []string) {
+	_, err := r.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, user := range bunchOfUsers {
+			value, err := json.Marshal(user)
+			if err != nil {
+				return err
+			}
+			pipe.SetNX(ctx, r.uuidKey(user.UUID), value, r.ttl)
+		}
+		if indexKey != "" {
+			value, err := json.Marshal(uuids)
+			if err != nil {
+				return err
+			}
+			pipe.SetNX(ctx, indexKey, value, r.ttl)
+		}
+		return nil
+	})
+	if err != nil {
+		cacheMetrics.Add("errors", 1)
+		r.logger.WithContext(ctx).Error(err)
+	}
+}
+
+func (r *cachedUserRepository) miss(ctx context.Context, err error) {
+	cacheMetrics.Add("misses", 1)
+	if err != redis.Nil {
+		cacheMetrics.Add("errors", 1)
+		r.logger.WithContext(ctx).Error(err)
+	}
+}
@@ -0,0 +1,146 @@
+package user_test
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+
+	"pii-encrypt-example/cmd/user/v1"
+	"pii-encrypt-example/entity"
+)
+
+// countingUserRepository counts the reads which reach the database.
+type countingUserRepository struct {
+	user.UserRepository
+	reads int
+}
+
+func (r *countingUserRepository) FindOneUserByUUID(ctx context.Context, uuid string) (entity.User, error) {
+	r.reads++
+	return r.UserRepository.FindOneUserByUUID(ctx, uuid)
+}
+
+func (r *countingUserRepository) FindManyUser(ctx context.Context, filter user.UserFilter) ([]entity.User, error) {
+	r.reads++
+	return r.UserRepository.FindManyUser(ctx, filter)
+}
+
+// TestCachedUserRepository runs against TEST_REDIS_ADDR, e.g. "localhost:6379", it is skipped when unset.
+func TestCachedUserRepository(t *testing.T) {
+	addr := os.Getenv("TEST_REDIS_ADDR")
+	if addr == "" {
+		t.Skip("TEST_REDIS_ADDR is not set")
+	}
+	client := redis.NewClient(&redis.Options{Addr: addr})
+	defer client.Close()
+
+	ctx := context.Background()
+	const replicaLag = time.Millisecond * 200
+	database := &countingUserRepository{UserRepository: newSQLiteUserRepository(t)}
+	repository := user.NewCachedUserRepository(newTestLogger(), client, time.Minute, replicaLag, database)
+
+	// findOne returns the user by uuid and whether the database was read
+	findOne := func(uuid string) (entity.User, bool) {
+		t.Helper()
+		reads := database.reads
+		u, err := repository.FindOneUserByUUID(ctx, uuid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return u, database.reads > reads
+	}
+	update := func(u entity.User, commit bool) {
+		t.Helper()
+		tx, _ := repository.BeginTx(ctx)
+		if err := repository.UpdateUser(ctx, u, tx); err != nil {
+			t.Fatal(err)
+		}
+		// read by another request before the transaction ends, from the rows as they were
+		if got, _ := findOne(u.UUID); bytes.Equal(got.NameCrypt, u.NameCrypt) {
+			t.Fatal("FindOneUserByUUID() returned a row not committed yet")
+		}
+		if commit {
+			repository.CommitTx(ctx, tx)
+		} else {
+			repository.RollbackTx(ctx, tx)
+		}
+	}
+
+	u := newTestUser()
+	tx, _ := repository.BeginTx(ctx)
+	if _, err := repository.SaveUser(ctx, u, tx); err != nil {
+		t.Fatal(err)
+	}
+	repository.CommitTx(ctx, tx)
+
+	t.Run("miss then hit", func(t *testing.T) {
+		if _, read := findOne(u.UUID); !read {
+			t.Fatal("FindOneUserByUUID() of a user not cached did not read the database")
+		}
+		if got, read := findOne(u.UUID); read || got.UUID != u.UUID {
+			t.Fatalf("FindOneUserByUUID() of a cached user = %s, read the database %t", got.UUID, read)
+		}
+	})
+
+	t.Run("name index not cached while the replica may lag", func(t *testing.T) {
+		filter := user.UserFilter{Name: "name", NameHashed: u.NameHash}
+		for i := 0; i < 2; i++ {
+			reads := database.reads
+			if users, err := repository.FindManyUser(ctx, filter); err != nil || len(users) != 1 || database.reads == reads {
+				t.Fatalf("FindManyUser() #%d after a write = %d users, %v, read the database %t", i, len(users), err, database.reads > reads)
+			}
+		}
+
+		time.Sleep(replicaLag * 2)
+		repository.FindManyUser(ctx, filter)
+		reads := database.reads
+		if users, err := repository.FindManyUser(ctx, filter); err != nil || len(users) != 1 || database.reads != reads {
+			t.Fatalf("FindManyUser() once the replica caught up = %d users, %v, read the database %t", len(users), err, database.reads > reads)
+		}
+	})
+
+	t.Run("page cut from the cached name index", func(t *testing.T) {
+		reads := database.reads
+		users, err := repository.FindManyUser(ctx, user.UserFilter{Name: "name", NameHashed: u.NameHash, Limit: 1})
+		if err != nil || len(users) != 1 || users[0].UUID != u.UUID || database.reads != reads {
+			t.Fatalf("FindManyUser() of the first page = %d users, %v, read the database %t", len(users), err, database.reads > reads)
+		}
+		users, err = repository.FindManyUser(ctx, user.UserFilter{Name: "name", NameHashed: u.NameHash, AfterUUID: u.UUID, Limit: 1})
+		if err != nil || len(users) != 0 || database.reads != reads {
+			t.Fatalf("FindManyUser() after the last user = %d users, %v, read the database %t", len(users), err, database.reads > reads)
+		}
+	})
+
+	t.Run("invalidated on rollback", func(t *testing.T) {
+		changed := u
+		changed.NameCrypt = []byte("rolled back")
+		update(changed, false)
+
+		time.Sleep(replicaLag * 2)
+		findOne(u.UUID)
+		if got, read := findOne(u.UUID); read || !bytes.Equal(got.NameCrypt, u.NameCrypt) {
+			t.Fatalf("FindOneUserByUUID() after a rollback = %q, read the database %t, want the cached row as it was", got.NameCrypt, read)
+		}
+	})
+
+	t.Run("invalidated on commit", func(t *testing.T) {
+		changed := u
+		changed.NameCrypt = []byte("committed")
+		update(changed, true)
+
+		// the read made before the commit is not cached
+		if got, read := findOne(u.UUID); !read || !bytes.Equal(got.NameCrypt, changed.NameCrypt) {
+			t.Fatalf("FindOneUserByUUID() after a commit = %q, read the database %t, want the committed row", got.NameCrypt, read)
+		}
+
+		time.Sleep(replicaLag * 2)
+		findOne(u.UUID)
+		if got, read := findOne(u.UUID); read || !bytes.Equal(got.NameCrypt, changed.NameCrypt) {
+			t.Fatalf("FindOneUserByUUID() once the replica caught up = %q, read the database %t, want the committed row cached", got.NameCrypt, read)
+		}
+	})
+}
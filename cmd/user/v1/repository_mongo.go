@@ -0,0 +1,381 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"pii-encrypt-example/entity"
+	"pii-encrypt-example/pkg/database"
+	"pii-encrypt-example/pkg/exception"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+// userDocument is the user as stored in mongodb, the ciphertexts and the blind indexes are stored as binary.
+// The optional fields are left out when empty, so they never collide on the unique indexes.
+type userDocument struct {
+	UUID               string    `bson:"_id"`
+	NameCrypt          []byte    `bson:"name_crypt"`
+	NameHash           []byte    `bson:"name_hash"`
+	EmailCrypt         []byte    `bson:"email_crypt"`
+	EmailHash          []byte    `bson:"email_hash"`
+	PhoneNumberCrypt   []byte    `bson:"phone_number_crypt,omitempty"`
+	PhoneNumberHash    []byte    `bson:"phone_number_hash,omitempty"`
+	NationalityIDCrypt []byte    `bson:"nik_crypt,omitempty"`
+	NationalityIDHash  []byte    `bson:"nik_hash,omitempty"`
+	DateOfBirthCrypt   []byte    `bson:"date_of_birth_crypt,omitempty"`
+	AddressCrypt       []byte    `bson:"address_crypt,omitempty"`
+	CreatedAt          time.Time `bson:"created_at"`
+	KeyID              string    `bson:"key_id,omitempty"`
+}
+
+func newUserDocument(user entity.User) userDocument {
+	return userDocument{
+		UUID:               user.UUID,
+		NameCrypt:          user.NameCrypt,
+		NameHash:           user.NameHash,
+		EmailCrypt:         user.EmailCrypt,
+		EmailHash:          user.EmailHash,
+		PhoneNumberCrypt:   user.PhoneNumberCrypt,
+		PhoneNumberHash:    user.PhoneNumberHash,
+		NationalityIDCrypt: user.NationalityIDCrypt,
+		NationalityIDHash:  user.NationalityIDHash,
+		DateOfBirthCrypt:   user.DateOfBirthCrypt,
+		AddressCrypt:       user.AddressCrypt,
+		CreatedAt:          user.CreatedAt,
+		KeyID:              user.KeyID,
+	}
+}
+
+func (d userDocument) user() entity.User {
+	return entity.User{
+		UUID:               d.UUID,
+		NameCrypt:          d.NameCrypt,
+		NameHash:           d.NameHash,
+		EmailCrypt:         d.EmailCrypt,
+		EmailHash:          d.EmailHash,
+		PhoneNumberCrypt:   d.PhoneNumberCrypt,
+		PhoneNumberHash:    d.PhoneNumberHash,
+		NationalityIDCrypt: d.NationalityIDCrypt,
+		NationalityIDHash:  d.NationalityIDHash,
+		DateOfBirthCrypt:   d.DateOfBirthCrypt,
+		AddressCrypt:       d.AddressCrypt,
+		CreatedAt:          d.CreatedAt,
+		KeyID:              d.KeyID,
+	}
+}
+
+type userMongoRepository struct {
+	logger *logrus.Logger
+	client *mongo.Client
+	// collectionReadOnly prefers the secondaries, like the read only database of the sql repository.
+	collectionReadOnly  *mongo.Collection
+	collectionReadWrite *mongo.Collection
+}
+
+// NewUserMongoRepository is a constructor, the indexes are created by CreateUserMongoIndexes.
+func NewUserMongoRepository(logger *logrus.Logger, client *mongo.Client, databaseName string, collectionName string) UserRepository {
+	db := client.Database(databaseName)
+	return &userMongoRepository{
+		logger:              logger,
+		client:              client,
+		collectionReadOnly:  db.Collection(collectionName, options.Collection().SetReadPreference(readpref.SecondaryPreferred())),
+		collectionReadWrite: db.Collection(collectionName),
+	}
+}
+
+// CreateUserMongoIndexes creates the indexes of the blind indexes, named like the keys of the sql table.
+func CreateUserMongoIndexes(ctx context.Context, client *mongo.Client, databaseName string, collectionName string) (err error) {
+	_, err = client.Database(databaseName).Collection(collectionName).Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "email_hash", Value: 1}},
+			Options: options.Index().SetName(emailHashUniqueKey).SetUnique(true),
+		},
+		{
+			Keys:    bson.D{{Key: "nik_hash", Value: 1}},
+			Options: options.Index().SetName(nationalityIDHashUniqueKey).SetUnique(true).SetPartialFilterExpression(bson.M{"nik_hash": bson.M{"$exists": true}}),
+		},
+		{
+			Keys:    bson.D{{Key: "phone_number_hash", Value: 1}},
+			Options: options.Index().SetName("idx_user_encrypt_phone_number_hash"),
+		},
+		{
+			Keys:    bson.D{{Key: "name_hash", Value: 1}},
+			Options: options.Index().SetName("idx_user_encrypt_nama_hash"),
+		},
+	})
+	return
+}
+
+// BeginTx starts a transaction, which needs mongodb to run as a replica set.
+func (r *userMongoRepository) BeginTx(ctx context.Context) (tx database.Tx, err error) {
+	return database.BeginMongoTx(ctx, r.client)
+}
+
+// CommitTx will commit the transaction that has began.
+func (r *userMongoRepository) CommitTx(ctx context.Context, tx database.Tx) (err error) {
+	return tx.Commit()
+}
+
+// RollbackTx will rollback the transaction to achieve the consistency.
+func (r *userMongoRepository) RollbackTx(ctx context.Context, tx database.Tx) (err error) {
+	return tx.Rollback()
+}
+
+// txContext binds ctx to the transaction, if any.
+func (r *userMongoRepository) txContext(ctx context.Context, tx database.Tx) (context.Context, error) {
+	if tx == nil {
+		return ctx, nil
+	}
+	mongoTx, ok := tx.(*database.MongoTx)
+	if !ok {
+		return nil, database.ErrForeignTx
+	}
+	return mongoTx.Context(ctx), nil
+}
+
+func (r *userMongoRepository) SaveUser(ctx context.Context, user entity.User, tx database.Tx) (id int64, err error) {
+	txCtx, err := r.txContext(ctx, tx)
+	if err != nil {
+		return
+	}
+
+	if _, err = r.collectionReadWrite.InsertOne(txCtx, newUserDocument(user)); err != nil {
+		r.logger.WithContext(ctx).Error("insert user ", err)
+		err = wrapMongoError(err)
+		return
+	}
+
+	return
+}
+
+// SaveManyUsers inserts the users with a single ordered insert, it is atomic only within a transaction.
+func (r *userMongoRepository) SaveManyUsers(ctx context.Context, users []entity.User, tx database.Tx) (err error) {
+	txCtx, err := r.txContext(ctx, tx)
+	if err != nil {
+		return
+	}
+	if len(users) == 0 {
+		return
+	}
+
+	documents := make([]interface{}, len(users))
+	for i, user := range users {
+		documents[i] = newUserDocument(user)
+	}
+
+	if _, err = r.collectionReadWrite.InsertMany(txCtx, documents); err != nil {
+		r.logger.WithContext(ctx).Error("insert many users ", err)
+		err = wrapMongoError(err)
+		return
+	}
+
+	return
+}
+
+// UpdateUser replaces every encrypted field and the key id of the user, the uuid and the creation time are kept.
+func (r *userMongoRepository) UpdateUser(ctx context.Context, user entity.User, tx database.Tx) (err error) {
+	txCtx, err := r.txContext(ctx, tx)
+	if err != nil {
+		return
+	}
+
+	current, err := r.findOne(txCtx, r.collectionReadWrite, user.UUID)
+	if err != nil {
+		return
+	}
+
+	document := newUserDocument(user)
+	document.CreatedAt = current.CreatedAt
+	result, err := r.collectionReadWrite.ReplaceOne(txCtx, bson.M{"_id": user.UUID}, document)
+	if err != nil {
+		r.logger.WithContext(ctx).Error("update user ", err)
+		err = wrapMongoError(err)
+		return
+	}
+	if result.MatchedCount == 0 {
+		err = exception.ErrNotFound
+	}
+
+	return
+}
+
+// RotateUser replaces the user only while it is still sealed with the previous key, and returns exception.ErrConflict
+// when it has been written or deleted since it was read.
+func (r *userMongoRepository) RotateUser(ctx context.Context, user entity.User, previousKeyID string, tx database.Tx) (err error) {
+	txCtx, err := r.txContext(ctx, tx)
+	if err != nil {
+		return
+	}
+
+	// the key id is left out of the documents sealed before it was recorded
+	query := bson.M{"_id": user.UUID, "key_id": previousKeyID}
+	if previousKeyID == "" {
+		query["key_id"] = bson.M{"$exists": false}
+	}
+
+	result, err := r.collectionReadWrite.ReplaceOne(txCtx, query, newUserDocument(user))
+	if err != nil {
+		r.logger.WithContext(ctx).Error("rotate user ", err)
+		err = wrapMongoError(err)
+		return
+	}
+	if result.MatchedCount == 0 {
+		err = exception.ErrConflict
+	}
+
+	return
+}
+
+func (r *userMongoRepository) DeleteUser(ctx context.Context, uuid string, tx database.Tx) (err error) {
+	txCtx, err := r.txContext(ctx, tx)
+	if err != nil {
+		return
+	}
+
+	result, err := r.collectionReadWrite.DeleteOne(txCtx, bson.M{"_id": uuid})
+	if err != nil {
+		r.logger.WithContext(ctx).Error("delete user ", err)
+		err = wrapMongoError(err)
+		return
+	}
+	if result.DeletedCount == 0 {
+		err = exception.ErrNotFound
+	}
+
+	return
+}
+
+func (r *userMongoRepository) FindOneUserByUUID(ctx context.Context, uuid string) (user entity.User, err error) {
+	document, err := r.findOne(ctx, r.collectionReadOnly, uuid)
+	if err != nil {
+		return
+	}
+
+	return document.user(), nil
+}
+
+func (r *userMongoRepository) UserExists(ctx context.Context, uuid string) (exists bool, err error) {
+	if _, err = r.findOne(ctx, r.collectionReadWrite, uuid); err == exception.ErrNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (r *userMongoRepository) findOne(ctx context.Context, collection *mongo.Collection, uuid string) (document userDocument, err error) {
+	if err = collection.FindOne(ctx, bson.M{"_id": uuid}).Decode(&document); err != nil {
+		if !errors.Is(err, mongo.ErrNoDocuments) {
+			r.logger.WithContext(ctx).Error("find user ", err)
+		}
+		err = wrapMongoError(err)
+	}
+	return
+}
+
+func (r *userMongoRepository) FindManyUser(ctx context.Context, filter UserFilter) (bunchOfUsers []entity.User, err error) {
+	err = r.EachUser(ctx, filter, func(user entity.User) error {
+		bunchOfUsers = append(bunchOfUsers, user)
+		return nil
+	})
+	return
+}
+
+// EachUser streams the users matching the filter to fn, one document at a time, and stops at the first error returned by fn.
+func (r *userMongoRepository) EachUser(ctx context.Context, filter UserFilter, fn func(user entity.User) error) (err error) {
+	query := bson.M{}
+	if filter.Name != "" {
+		query["name_hash"] = filter.NameHashed
+	}
+	if filter.AfterUUID != "" {
+		query["_id"] = bson.M{"$gt": filter.AfterUUID}
+	}
+
+	findOptions := options.Find()
+	if filter.Limit > 0 {
+		findOptions.SetSort(bson.M{"_id": 1}).SetLimit(int64(filter.Limit))
+	}
+	return r.each(ctx, r.collectionReadOnly, query, fn, findOptions)
+}
+
+// FindManyUserByUniqueHashes returns the users owning any of the given email or nationality id blind indexes.
+func (r *userMongoRepository) FindManyUserByUniqueHashes(ctx context.Context, emailHashes [][]byte, nationalityIDHashes [][]byte) (bunchOfUsers []entity.User, err error) {
+	var conditions bson.A
+	if len(emailHashes) > 0 {
+		conditions = append(conditions, bson.M{"email_hash": bson.M{"$in": emailHashes}})
+	}
+	if len(nationalityIDHashes) > 0 {
+		conditions = append(conditions, bson.M{"nik_hash": bson.M{"$in": nationalityIDHashes}})
+	}
+	if len(conditions) == 0 {
+		return
+	}
+
+	err = r.each(ctx, r.collectionReadWrite, bson.M{"$or": conditions}, func(user entity.User) error {
+		bunchOfUsers = append(bunchOfUsers, user)
+		return nil
+	})
+	return
+}
+
+// FindManyUserNotSealedWith returns up to limit users sealed with another key than keyID, ordered by uuid from afterUUID excluded.
+func (r *userMongoRepository) FindManyUserNotSealedWith(ctx context.Context, keyID string, afterUUID string, limit int) (bunchOfUsers []entity.User, err error) {
+	query := bson.M{"_id": bson.M{"$gt": afterUUID}, "key_id": bson.M{"$ne": keyID}}
+	findOptions := options.Find().SetSort(bson.M{"_id": 1}).SetLimit(int64(limit))
+
+	err = r.each(ctx, r.collectionReadWrite, query, func(user entity.User) error {
+		bunchOfUsers = append(bunchOfUsers, user)
+		return nil
+	}, findOptions)
+	return
+}
+
+func (r *userMongoRepository) each(ctx context.Context, collection *mongo.Collection, query bson.M, fn func(user entity.User) error, opts ...*options.FindOptions) (err error) {
+	cursor, err := collection.Find(ctx, query, opts...)
+	if err != nil {
+		r.logger.WithContext(ctx).Error("find users ", err)
+		return wrapMongoError(err)
+	}
+	defer func() {
+		if err := cursor.Close(ctx); err != nil {
+			r.logger.WithContext(ctx).Error("find users ", err)
+		}
+	}()
+
+	for cursor.Next(ctx) {
+		var document userDocument
+		if err := cursor.Decode(&document); err != nil {
+			r.logger.WithContext(ctx).Error("find users ", err)
+			return wrapMongoError(err)
+		}
+		if err = fn(document.user()); err != nil {
+			return err
+		}
+	}
+
+	if err = cursor.Err(); err != nil {
+		r.logger.WithContext(ctx).Error("find users ", err)
+		return wrapMongoError(err)
+	}
+	return
+}
+
+func wrapMongoError(e error) (err error) {
+	if errors.Is(e, mongo.ErrNoDocuments) {
+		return exception.ErrNotFound
+	}
+	if mongo.IsDuplicateKeyError(e) {
+		switch {
+		case strings.Contains(e.Error(), emailHashUniqueKey):
+			return ErrDuplicateEmail
+		case strings.Contains(e.Error(), nationalityIDHashUniqueKey):
+			return ErrDuplicateNationalityID
+		}
+		return exception.ErrConflict
+	}
+	return exception.ErrInternalServer
+}
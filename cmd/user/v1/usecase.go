@@ -2,44 +2,89 @@ package user
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
 	"pii-encrypt-example/entity"
+	"pii-encrypt-example/pkg/audit"
 	"pii-encrypt-example/pkg/crypto"
+	"pii-encrypt-example/pkg/database"
 	"pii-encrypt-example/pkg/exception"
+	"pii-encrypt-example/pkg/outbox"
+	"pii-encrypt-example/pkg/pii"
 	"pii-encrypt-example/pkg/response"
+	customvalidator "pii-encrypt-example/pkg/validator"
 	"time"
 
+	"github.com/go-playground/validator/v10"
 	"github.com/google/uuid"
 	"github.com/sirupsen/logrus"
 )
 
 type UserUsecase interface {
 	GetManyUsers(ctx context.Context, filter UserFilter) (resp response.Response)
+	GetUser(ctx context.Context, uuid string) (resp response.Response)
 	CreateUser(ctx context.Context, userRequest UserRequest) (resp response.Response)
+	CreateUserOnce(ctx context.Context, uuid string, userRequest UserRequest) (resp response.Response)
+	UpdateUser(ctx context.Context, uuid string, userRequest UserRequest) (resp response.Response)
+	DeleteUser(ctx context.Context, uuid string) (resp response.Response)
+	ImportUsers(ctx context.Context, file io.Reader, format string) (resp response.Response)
+	RotateKeys(ctx context.Context) (rotated int, err error)
 }
 
+// importBatchSize is the number of rows encrypted and inserted together by ImportUsers.
+const importBatchSize = 500
+
+// rotateBatchSize is the number of users read together by RotateKeys.
+const rotateBatchSize = 100
+
+// Sizes of a page of GetManyUsers, every page is a distinct lookup of the enumeration guard.
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+// revealedFields are the plaintext fields of a UserResponse, as recorded in the audit log.
+var revealedFields = []string{"name", "email", "phoneNumber", "nationalityId", "dateOfBirth", "address"}
+
 type userUsecase struct {
-	logger         *logrus.Logger
-	location       *time.Location
-	crypto         crypto.Crypto
-	userRepository UserRepository
+	logger           *logrus.Logger
+	location         *time.Location
+	crypto           crypto.Crypto
+	sealer           *pii.Sealer
+	validator        *validator.Validate
+	auditor          *audit.Auditor
+	userRepository   UserRepository
+	outboxRepository outbox.OutboxRepository
 }
 
-func NewUserUsecase(logger *logrus.Logger, location *time.Location, crypto crypto.Crypto, userRepository UserRepository) UserUsecase {
+// NewUserUsecase is a constructor, every user returned in plaintext is recorded by the auditor.
+func NewUserUsecase(logger *logrus.Logger, location *time.Location, crypto crypto.Crypto, validator *validator.Validate, auditor *audit.Auditor, userRepository UserRepository, outboxRepository outbox.OutboxRepository) UserUsecase {
 	return &userUsecase{
-		logger:         logger,
-		location:       location,
-		crypto:         crypto,
-		userRepository: userRepository,
+		logger:           logger,
+		location:         location,
+		crypto:           crypto,
+		sealer:           pii.NewSealer(crypto),
+		validator:        validator,
+		auditor:          auditor,
+		userRepository:   userRepository,
+		outboxRepository: outboxRepository,
 	}
 }
 
 // GetManyUsers implements Usecase
+// The users are paged by uuid, one more user than the page is read to tell whether a next page exists.
 func (u *userUsecase) GetManyUsers(ctx context.Context, filter UserFilter) (resp response.Response) {
 
 	if filter.Name != "" {
 		filter.NameHashed = u.crypto.Hash(filter.Name)
 	}
+	if filter.Limit <= 0 {
+		filter.Limit = defaultPageSize
+	}
+	pageSize := min(filter.Limit, maxPageSize)
+	filter.Limit = pageSize + 1
 
 	result, err := u.userRepository.FindManyUser(ctx, filter)
 	if err != nil {
@@ -50,69 +95,528 @@ func (u *userUsecase) GetManyUsers(ctx context.Context, filter UserFilter) (resp
 		return response.NewErrorResponse(err, http.StatusInternalServerError, nil, response.StatUnexpectedError, "")
 	}
 
+	var nextCursor interface{}
+	if len(result) > pageSize {
+		result = result[:pageSize]
+		nextCursor = result[pageSize-1].UUID
+	}
+
 	totalDataOnPage := len(result)
 	usersResponse := make([]UserResponse, totalDataOnPage)
+	uuids := make([]string, totalDataOnPage)
 	for i, v := range result {
-		var userResponse UserResponse
-		userResponse.UUID = v.UUID
-
-		name, err := u.crypto.Decrypt(v.NameCrypt)
-		if err != nil {
+		if err := u.sealer.Open(ctx, v, &usersResponse[i]); err != nil {
 			u.logger.WithContext(ctx).Error(err)
 			// return response.NewErrorResponse(exception.ErrInternalServer, http.StatusInternalServerError, nil, response.StatUnexpectedError, "")
 		}
-		userResponse.Name = string(name)
+		uuids[i] = v.UUID
+	}
 
-		email, err := u.crypto.Decrypt(v.EmailCrypt)
-		if err != nil {
-			u.logger.WithContext(ctx).Error(err)
-			// return response.NewErrorResponse(exception.ErrInternalServer, http.StatusInternalServerError, nil, response.StatUnexpectedError, "")
+	if err := u.auditor.Record(ctx, "list users", revealedFields, uuids); err != nil {
+		u.logger.WithContext(ctx).Error(err)
+		return response.NewErrorResponse(exception.ErrInternalServer, http.StatusInternalServerError, nil, response.StatUnexpectedError, "")
+	}
+
+	meta := response.PaginationCursorResponseMeta{TotalDataOnPage: int64(totalDataOnPage), NextCursor: nextCursor}
+	return response.NewSuccessResponseWithMeta(usersResponse, meta, response.StatOK, "")
+}
+
+// GetUser implements Usecase
+func (u *userUsecase) GetUser(ctx context.Context, uuid string) (resp response.Response) {
+	result, err := u.userRepository.FindOneUserByUUID(ctx, uuid)
+	if err != nil {
+		if err == exception.ErrNotFound {
+			return response.NewErrorResponse(err, http.StatusNotFound, nil, response.StatNotFound, "")
 		}
-		userResponse.Email = string(email)
+		u.logger.WithContext(ctx).Error(err)
+		return response.NewErrorResponse(err, http.StatusInternalServerError, nil, response.StatUnexpectedError, "")
+	}
 
-		userResponse.CreatedAt = v.CreatedAt
+	var userResponse UserResponse
+	if err := u.sealer.Open(ctx, result, &userResponse); err != nil {
+		u.logger.WithContext(ctx).Error(err)
+	}
 
-		usersResponse[i] = userResponse
+	if err := u.auditor.Record(ctx, "get user", revealedFields, []string{result.UUID}); err != nil {
+		u.logger.WithContext(ctx).Error(err)
+		return response.NewErrorResponse(exception.ErrInternalServer, http.StatusInternalServerError, nil, response.StatUnexpectedError, "")
 	}
 
-	return response.NewSuccessResponse(usersResponse, response.StatOK, "")
+	return response.NewSuccessResponse(userResponse, response.StatOK, "")
 }
 
 // CreateUser implements Usecase
 func (u *userUsecase) CreateUser(ctx context.Context, userRequest UserRequest) (resp response.Response) {
-	var user entity.User
+	return u.createUser(ctx, uuid.New().String(), userRequest)
+}
 
-	uuid := uuid.New().String()
-	user.UUID = uuid
-	nameCrypt, err := u.crypto.Encrypt(userRequest.Name)
+// CreateUserOnce implements Usecase, the user is created under uuid unless it exists already.
+// A request sent again, e.g. a message delivered twice, conflicts with the user it created and succeeds.
+func (u *userUsecase) CreateUserOnce(ctx context.Context, uuid string, userRequest UserRequest) (resp response.Response) {
+	resp = u.createUser(ctx, uuid, userRequest)
+	if resp.HTTPStatusCode() != http.StatusConflict {
+		return
+	}
+
+	// a replica may not have the user yet, the conflict would then be taken for another user's.
+	exists, err := u.userRepository.UserExists(ctx, uuid)
 	if err != nil {
 		u.logger.WithContext(ctx).Error(err)
 		return response.NewErrorResponse(exception.ErrInternalServer, http.StatusInternalServerError, nil, response.StatUnexpectedError, "")
 	}
-	user.NameCrypt = nameCrypt
-	user.NameHash = u.crypto.Hash(userRequest.Name)
+	if !exists {
+		return
+	}
+	return response.NewSuccessResponse(nil, response.StatAlreadyExist, "the user is created already")
+}
 
-	emailCrypt, err := u.crypto.Encrypt(userRequest.Email)
-	if err != nil {
+func (u *userUsecase) createUser(ctx context.Context, uuid string, userRequest UserRequest) (resp response.Response) {
+	var user entity.User
+
+	if err := u.seal(ctx, userRequest, &user); err != nil {
 		u.logger.WithContext(ctx).Error(err)
 		return response.NewErrorResponse(exception.ErrInternalServer, http.StatusInternalServerError, nil, response.StatUnexpectedError, "")
 	}
-	user.EmailCrypt = emailCrypt
+
+	user.UUID = uuid
 
 	createdAt := time.Now().In(u.location)
 	user.CreatedAt = createdAt
 
-	_, err = u.userRepository.SaveUser(ctx, user, nil)
+	err := u.saveUsersInTx(ctx, []entity.User{user})
 	if err != nil {
+		if status, message, ok := conflictStatus(err); ok {
+			return response.NewErrorResponse(err, http.StatusConflict, nil, status, message)
+		}
+		u.logger.WithContext(ctx).Error(err)
 		return response.NewErrorResponse(exception.ErrInternalServer, http.StatusInternalServerError, nil, response.StatUnexpectedError, "")
 	}
 
 	userResponse := UserResponse{
-		UUID:      uuid,
-		Name:      userRequest.Name,
-		Email:     userRequest.Email,
-		CreatedAt: createdAt,
+		UUID:          uuid,
+		Name:          userRequest.Name,
+		Email:         userRequest.Email,
+		PhoneNumber:   userRequest.PhoneNumber,
+		NationalityID: userRequest.NationalityID,
+		DateOfBirth:   userRequest.DateOfBirth,
+		Address:       userRequest.Address,
+		CreatedAt:     createdAt,
 	}
 
 	return response.NewSuccessResponse(userResponse, response.StatOK, "")
 }
+
+// UpdateUser implements Usecase
+func (u *userUsecase) UpdateUser(ctx context.Context, uuid string, userRequest UserRequest) (resp response.Response) {
+	current, err := u.userRepository.FindOneUserByUUID(ctx, uuid)
+	if err != nil {
+		if err == exception.ErrNotFound {
+			return response.NewErrorResponse(err, http.StatusNotFound, nil, response.StatNotFound, "")
+		}
+		u.logger.WithContext(ctx).Error(err)
+		return response.NewErrorResponse(exception.ErrInternalServer, http.StatusInternalServerError, nil, response.StatUnexpectedError, "")
+	}
+
+	var user entity.User
+	if err := u.seal(ctx, userRequest, &user); err != nil {
+		u.logger.WithContext(ctx).Error(err)
+		return response.NewErrorResponse(exception.ErrInternalServer, http.StatusInternalServerError, nil, response.StatUnexpectedError, "")
+	}
+	user.UUID = current.UUID
+	user.CreatedAt = current.CreatedAt
+
+	event, err := u.userEvent(ctx, EventUserUpdated, user)
+	if err != nil {
+		u.logger.WithContext(ctx).Error(err)
+		return response.NewErrorResponse(exception.ErrInternalServer, http.StatusInternalServerError, nil, response.StatUnexpectedError, "")
+	}
+
+	err = u.withTx(ctx, func(tx database.Tx) (err error) {
+		if err = u.userRepository.UpdateUser(ctx, user, tx); err != nil {
+			return
+		}
+		return u.outboxRepository.SaveEvent(ctx, event, tx)
+	})
+	if err != nil {
+		if status, message, ok := conflictStatus(err); ok {
+			return response.NewErrorResponse(err, http.StatusConflict, nil, status, message)
+		}
+		u.logger.WithContext(ctx).Error(err)
+		return response.NewErrorResponse(exception.ErrInternalServer, http.StatusInternalServerError, nil, response.StatUnexpectedError, "")
+	}
+
+	userResponse := UserResponse{
+		UUID:          user.UUID,
+		Name:          userRequest.Name,
+		Email:         userRequest.Email,
+		PhoneNumber:   userRequest.PhoneNumber,
+		NationalityID: userRequest.NationalityID,
+		DateOfBirth:   userRequest.DateOfBirth,
+		Address:       userRequest.Address,
+		CreatedAt:     user.CreatedAt,
+	}
+
+	return response.NewSuccessResponse(userResponse, response.StatUpdated, "")
+}
+
+// DeleteUser implements Usecase
+func (u *userUsecase) DeleteUser(ctx context.Context, uuid string) (resp response.Response) {
+	event, err := u.userEvent(ctx, EventUserDeleted, entity.User{UUID: uuid})
+	if err != nil {
+		u.logger.WithContext(ctx).Error(err)
+		return response.NewErrorResponse(exception.ErrInternalServer, http.StatusInternalServerError, nil, response.StatUnexpectedError, "")
+	}
+
+	err = u.withTx(ctx, func(tx database.Tx) (err error) {
+		if err = u.userRepository.DeleteUser(ctx, uuid, tx); err != nil {
+			return
+		}
+		return u.outboxRepository.SaveEvent(ctx, event, tx)
+	})
+	if err != nil {
+		if err == exception.ErrNotFound {
+			return response.NewErrorResponse(err, http.StatusNotFound, nil, response.StatNotFound, "")
+		}
+		if status, message, ok := conflictStatus(err); ok {
+			return response.NewErrorResponse(err, http.StatusConflict, nil, status, message)
+		}
+		u.logger.WithContext(ctx).Error(err)
+		return response.NewErrorResponse(exception.ErrInternalServer, http.StatusInternalServerError, nil, response.StatUnexpectedError, "")
+	}
+
+	return response.NewSuccessResponse(nil, response.StatOK, "")
+}
+
+// ImportUsers implements Usecase
+func (u *userUsecase) ImportUsers(ctx context.Context, file io.Reader, format string) (resp response.Response) {
+	rows, err := newImportReader(file, format)
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		return response.NewErrorResponse(exception.ErrPayloadTooLarge, http.StatusRequestEntityTooLarge, nil, response.StatPayloadTooLarge, fmt.Sprintf("the file is larger than %d bytes", maxBytesErr.Limit))
+	}
+	if err != nil {
+		return response.NewErrorResponse(exception.ErrBadRequest, http.StatusBadRequest, nil, response.StatBadRequest, err.Error())
+	}
+
+	report := ImportReport{Rows: make([]ImportRowResult, 0)}
+	seen := make(map[string]struct{})
+	batch := make([]importBatchItem, 0, importBatchSize)
+	createdAt := time.Now().In(u.location)
+
+	for {
+		row, err := rows.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			u.saveImportBatch(ctx, &report, batch)
+			report.summarize()
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				return response.NewErrorResponse(exception.ErrPayloadTooLarge, http.StatusRequestEntityTooLarge, report, response.StatPayloadTooLarge, fmt.Sprintf("the file is larger than %d bytes", maxBytesErr.Limit))
+			}
+			u.logger.WithContext(ctx).Error(err)
+			return response.NewErrorResponse(exception.ErrUnprocessableEntity, http.StatusUnprocessableEntity, report, response.StatusInvalidPayload, "the file cannot be read any further")
+		}
+
+		result := ImportRowResult{Row: row.row}
+		item, ok := u.prepareImportRow(ctx, row, &result, seen)
+		if ok {
+			item.index = len(report.Rows)
+			item.user.CreatedAt = createdAt
+			batch = append(batch, item)
+		}
+		report.Rows = append(report.Rows, result)
+
+		if len(batch) == importBatchSize {
+			u.saveImportBatch(ctx, &report, batch)
+			batch = batch[:0]
+		}
+	}
+
+	u.saveImportBatch(ctx, &report, batch)
+	report.summarize()
+
+	return response.NewSuccessResponse(report, response.StatOK, "")
+}
+
+// importBatchItem is a sealed row waiting to be inserted, index points to its result in the report.
+type importBatchItem struct {
+	index            int
+	user             entity.User
+	emailKey         string
+	nationalityIDKey string
+}
+
+// prepareImportRow validates and seals a row, rows duplicating a previous row of the same file are rejected here.
+func (u *userUsecase) prepareImportRow(ctx context.Context, row importRow, result *ImportRowResult, seen map[string]struct{}) (item importBatchItem, ok bool) {
+	if row.err != nil {
+		result.Status = response.StatusInvalidPayload
+		result.Message = row.err.Error()
+		return
+	}
+
+	if err := u.validator.Struct(row.request); err != nil {
+		result.Status = response.StatusInvalidPayload
+		result.Errors = customvalidator.FieldErrors(err)
+		return
+	}
+
+	if err := u.seal(ctx, row.request, &item.user); err != nil {
+		u.logger.WithContext(ctx).Error(err)
+		result.Status = response.StatUnexpectedError
+		return
+	}
+
+	item.emailKey, item.nationalityIDKey = uniqueKeys(item.user.EmailHash, item.user.NationalityIDHash)
+	if _, found := seen[item.emailKey]; found {
+		result.Status, result.Message, _ = conflictStatus(ErrDuplicateEmail)
+		return
+	}
+	if _, found := seen[item.nationalityIDKey]; found && item.nationalityIDKey != "" {
+		result.Status, result.Message, _ = conflictStatus(ErrDuplicateNationalityID)
+		return
+	}
+	seen[item.emailKey] = struct{}{}
+	if item.nationalityIDKey != "" {
+		seen[item.nationalityIDKey] = struct{}{}
+	}
+
+	item.user.UUID = uuid.New().String()
+	return item, true
+}
+
+// saveImportBatch skips the rows already registered and inserts the others in a single transaction.
+// When the transaction still conflicts, e.g. with a user created in the meantime, the rows are inserted one by one.
+func (u *userUsecase) saveImportBatch(ctx context.Context, report *ImportReport, batch []importBatchItem) {
+	if len(batch) == 0 {
+		return
+	}
+
+	var emailHashes, nationalityIDHashes [][]byte
+	for _, item := range batch {
+		emailHashes = append(emailHashes, item.user.EmailHash)
+		if item.user.NationalityIDHash != nil {
+			nationalityIDHashes = append(nationalityIDHashes, item.user.NationalityIDHash)
+		}
+	}
+
+	existingUsers, err := u.userRepository.FindManyUserByUniqueHashes(ctx, emailHashes, nationalityIDHashes)
+	if err != nil {
+		u.logger.WithContext(ctx).Error(err)
+		for _, item := range batch {
+			report.Rows[item.index].Status = response.StatUnexpectedError
+		}
+		return
+	}
+
+	existing := make(map[string]struct{}, len(existingUsers)*2)
+	for _, user := range existingUsers {
+		emailKey, nationalityIDKey := uniqueKeys(user.EmailHash, user.NationalityIDHash)
+		existing[emailKey] = struct{}{}
+		existing[nationalityIDKey] = struct{}{}
+	}
+
+	pending := make([]importBatchItem, 0, len(batch))
+	users := make([]entity.User, 0, len(batch))
+	for _, item := range batch {
+		result := &report.Rows[item.index]
+		if _, found := existing[item.emailKey]; found {
+			result.Status, result.Message, _ = conflictStatus(ErrDuplicateEmail)
+			continue
+		}
+		if _, found := existing[item.nationalityIDKey]; found && item.nationalityIDKey != "" {
+			result.Status, result.Message, _ = conflictStatus(ErrDuplicateNationalityID)
+			continue
+		}
+		pending = append(pending, item)
+		users = append(users, item.user)
+	}
+	if len(pending) == 0 {
+		return
+	}
+
+	err = u.saveUsersInTx(ctx, users)
+	if err == nil {
+		for _, item := range pending {
+			report.Rows[item.index].Status = response.StatCreated
+			report.Rows[item.index].UUID = item.user.UUID
+		}
+		return
+	}
+
+	if !errors.Is(err, exception.ErrConflict) {
+		u.logger.WithContext(ctx).Error(err)
+	}
+	for _, item := range pending {
+		result := &report.Rows[item.index]
+		if err := u.saveUsersInTx(ctx, []entity.User{item.user}); err != nil {
+			if status, message, ok := conflictStatus(err); ok {
+				result.Status, result.Message = status, message
+				continue
+			}
+			u.logger.WithContext(ctx).Error(err)
+			result.Status = response.StatUnexpectedError
+			continue
+		}
+		result.Status = response.StatCreated
+		result.UUID = item.user.UUID
+	}
+}
+
+// RotateKeys implements Usecase, it seals again with the primary key every user sealed with another key of the keyring,
+// reading them by batches of rotateBatchSize and writing one user at a time. A user which cannot be rotated is logged
+// and left for the next run, a user written since it was read is left as written, sealed with the primary key already.
+func (u *userUsecase) RotateKeys(ctx context.Context) (rotated int, err error) {
+	primaryKeyID := crypto.PrimaryKeyID(u.crypto)
+
+	stale, failed := 0, 0
+	afterUUID := ""
+	for {
+		bunchOfUsers, err := u.userRepository.FindManyUserNotSealedWith(ctx, primaryKeyID, afterUUID, rotateBatchSize)
+		if err != nil {
+			return rotated, err
+		}
+
+		for _, current := range bunchOfUsers {
+			stale++
+			if err := u.rotateUser(ctx, current); err != nil {
+				if err == exception.ErrConflict {
+					u.logger.WithContext(ctx).WithField("uuid", current.UUID).Info("user written during the rotation, left as written")
+					continue
+				}
+				u.logger.WithContext(ctx).WithField("uuid", current.UUID).Error(err)
+				failed++
+				continue
+			}
+			rotated++
+		}
+
+		if len(bunchOfUsers) < rotateBatchSize {
+			break
+		}
+		afterUUID = bunchOfUsers[len(bunchOfUsers)-1].UUID
+	}
+
+	if failed > 0 {
+		err = fmt.Errorf("user: %d of %d users could not be rotated", failed, stale)
+	}
+	return
+}
+
+// rotateUser opens the user with any key of the keyring and seals it with the primary key, the blind indexes included.
+// The user is written only while it is still sealed with the key it was read with, exception.ErrConflict is returned otherwise.
+func (u *userUsecase) rotateUser(ctx context.Context, current entity.User) (err error) {
+	var userRequest UserRequest
+	if err = u.sealer.Open(ctx, current, &userRequest); err != nil {
+		return
+	}
+
+	var user entity.User
+	if err = u.seal(ctx, userRequest, &user); err != nil {
+		return
+	}
+	user.UUID = current.UUID
+	user.CreatedAt = current.CreatedAt
+
+	return u.userRepository.RotateUser(ctx, user, current.KeyID, nil)
+}
+
+// seal seals the request into the user with the primary key, which is recorded on the user.
+func (u *userUsecase) seal(ctx context.Context, userRequest UserRequest, user *entity.User) (err error) {
+	if err = u.sealer.Seal(ctx, userRequest, user); err != nil {
+		return
+	}
+	user.KeyID = crypto.PrimaryKeyID(u.crypto)
+	return
+}
+
+// saveUsersInTx inserts the users together with their created events, so an event is recorded if and only if its user is.
+func (u *userUsecase) saveUsersInTx(ctx context.Context, users []entity.User) (err error) {
+	events := make([]entity.OutboxEvent, len(users))
+	for i, user := range users {
+		if events[i], err = u.userEvent(ctx, EventUserCreated, user); err != nil {
+			return
+		}
+	}
+
+	return u.withTx(ctx, func(tx database.Tx) (err error) {
+		if len(users) == 1 {
+			_, err = u.userRepository.SaveUser(ctx, users[0], tx)
+		} else {
+			err = u.userRepository.SaveManyUsers(ctx, users, tx)
+		}
+		if err != nil {
+			return
+		}
+
+		for _, event := range events {
+			if err = u.outboxRepository.SaveEvent(ctx, event, tx); err != nil {
+				return
+			}
+		}
+		return
+	})
+}
+
+// withTx runs fn in a transaction, which is committed only when fn succeeds.
+func (u *userUsecase) withTx(ctx context.Context, fn func(tx database.Tx) error) (err error) {
+	tx, err := u.userRepository.BeginTx(ctx)
+	if err != nil {
+		return
+	}
+
+	if err = fn(tx); err != nil {
+		if errRollback := u.userRepository.RollbackTx(ctx, tx); errRollback != nil {
+			u.logger.WithContext(ctx).Error(errRollback)
+		}
+		return
+	}
+
+	return u.userRepository.CommitTx(ctx, tx)
+}
+
+// userEvent builds the outbox event of a change to the user, its PII is masked so the event never carries plaintext.
+func (u *userUsecase) userEvent(ctx context.Context, eventType string, user entity.User) (event entity.OutboxEvent, err error) {
+	data := UserEvent{UUID: user.UUID}
+	if eventType != EventUserDeleted {
+		if err = u.sealer.Mask(ctx, user, &data); err != nil {
+			return
+		}
+	}
+
+	return outbox.NewEvent("user", user.UUID, eventType, data, time.Now().In(u.location))
+}
+
+// summarize counts the rows of the report by their outcome.
+func (report *ImportReport) summarize() {
+	report.Total = len(report.Rows)
+	report.Imported, report.Duplicated, report.Invalid, report.Failed = 0, 0, 0, 0
+	for _, row := range report.Rows {
+		switch row.Status {
+		case response.StatCreated:
+			report.Imported++
+		case response.StatDuplicateEmail, response.StatDuplicateNationalityID, response.StatAlreadyExist:
+			report.Duplicated++
+		case response.StatusInvalidPayload:
+			report.Invalid++
+		default:
+			report.Failed++
+		}
+	}
+}
+
+// conflictStatus maps a conflict returned by the repository to its response status and message.
+func conflictStatus(err error) (status string, message string, ok bool) {
+	switch {
+	case errors.Is(err, ErrDuplicateEmail):
+		return response.StatDuplicateEmail, "email has already been registered", true
+	case errors.Is(err, ErrDuplicateNationalityID):
+		return response.StatDuplicateNationalityID, "nationality id has already been registered", true
+	case errors.Is(err, ErrUserReferenced):
+		return response.StatNotPermitted, "user still owns documents", true
+	case errors.Is(err, exception.ErrConflict):
+		return response.StatAlreadyExist, "user already exists", true
+	}
+	return
+}
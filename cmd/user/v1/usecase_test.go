@@ -0,0 +1,74 @@
+package user_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+
+	"pii-encrypt-example/cmd/user/v1"
+	"pii-encrypt-example/entity"
+)
+
+// usersPage is the body of a page of GetManyUsers.
+type usersPage struct {
+	Data []user.UserResponse `json:"data"`
+	Meta struct {
+		TotalDataOnPage int64   `json:"totalDataOnPage"`
+		NextCursor      *string `json:"nextCursor"`
+	} `json:"meta"`
+}
+
+func TestGetManyUsersPages(t *testing.T) {
+	userUsecase, _ := newTestUserUsecase(t, nil)
+	router := mux.NewRouter()
+	user.NewUserHTTPHandler(newTestLogger(), router, passThrough{}, passThrough{}, newTestValidator(), userUsecase)
+
+	ctx := context.WithValue(context.Background(), entity.PrincipalContextKey{}, "support")
+	for i := 0; i < 3; i++ {
+		if resp := userUsecase.CreateUser(ctx, user.UserRequest{Name: "John Doe", Email: fmt.Sprintf("john%d@example.com", i)}); resp.Error() != nil {
+			t.Fatal(resp.Error())
+		}
+	}
+
+	get := func(query string) (int, usersPage) {
+		t.Helper()
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/user?"+query, nil))
+		var page usersPage
+		if w.Code == http.StatusOK {
+			if err := json.Unmarshal(w.Body.Bytes(), &page); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return w.Code, page
+	}
+
+	code, first := get("limit=2")
+	if code != http.StatusOK || len(first.Data) != 2 || first.Meta.TotalDataOnPage != 2 || first.Meta.NextCursor == nil {
+		t.Fatalf("first page = %d, %d users, want 2 users and a next cursor", code, len(first.Data))
+	}
+	code, last := get("limit=2&cursor=" + *first.Meta.NextCursor)
+	if code != http.StatusOK || len(last.Data) != 1 || last.Meta.NextCursor != nil {
+		t.Fatalf("last page = %d, %d users, want 1 user and no next cursor", code, len(last.Data))
+	}
+	for _, u := range first.Data {
+		if u.UUID == last.Data[0].UUID {
+			t.Fatalf("user %s returned on both pages", u.UUID)
+		}
+	}
+
+	if code, named := get("name=John%20Doe&limit=1&cursor=" + *first.Meta.NextCursor); code != http.StatusOK || len(named.Data) != 1 || named.Data[0].UUID != last.Data[0].UUID {
+		t.Fatalf("page of a name = %d, %d users, want the last user", code, len(named.Data))
+	}
+
+	for _, query := range []string{"limit=0", "limit=ten", "cursor=../user"} {
+		if code, _ := get(query); code != http.StatusBadRequest {
+			t.Errorf("GetManyUsers(%s) = %d, want %d", query, code, http.StatusBadRequest)
+		}
+	}
+}
@@ -19,6 +19,26 @@ import (
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+// CryptoKey is a key of the keyring.
+type CryptoKey struct {
+	ID     string
+	Secret string
+}
+
+// RateLimitRule is the budget of a token bucket.
+type RateLimitRule struct {
+	PerMinute int
+	Burst     int
+}
+
+// EnumerationWindow is a sliding window of the enumeration detection with its thresholds, a zero threshold is disabled.
+type EnumerationWindow struct {
+	Duration time.Duration
+	Alert    int
+	StepUp   int
+	Lockout  int
+}
+
 // Config is an app configuration.
 type Config struct {
 	Application struct {
@@ -26,21 +46,63 @@ type Config struct {
 		Port           string
 		Environment    string
 		AllowedOrigins []string
+		// TrustedProxies are the CIDRs of the proxies whose forwarded headers are believed
+		TrustedProxies []string
 		Timezone       *time.Location
 	}
 	BasicAuth struct {
 		Username string
 		Password string
 	}
+	// AdminBasicAuth guards the audit log, the admin endpoints are disabled while it is unset
+	AdminBasicAuth struct {
+		Username string
+		Password string
+	}
+	// Audit holds the keys of the audit log: ChainKey links the records, SigningKey is the base64 ed25519 seed
+	// signing the checkpoints and VerifyKey the base64 public key, enough to verify them
+	Audit struct {
+		ChainKey           string
+		SigningKey         string
+		VerifyKey          string
+		CheckpointInterval time.Duration
+	}
+	// RateLimit holds the budgets of the api, the search queries have their own. Driver is memory (default),
+	// each instance having its own buckets, or redis to share them between the instances
+	RateLimit struct {
+		Enable          bool
+		Driver          string
+		Principal       RateLimitRule
+		ClientIP        RateLimitRule
+		SearchPrincipal RateLimitRule
+		SearchClientIP  RateLimitRule
+	}
+	// Enumeration tracks the distinct lookups of every principal, the queries are kept as HMAC of Key.
+	// Driver is memory (default) or redis to track the principals across the instances
+	Enumeration struct {
+		Enable          bool
+		Driver          string
+		Key             string
+		LockoutDuration time.Duration
+		Windows         []EnumerationWindow
+	}
 	Crypto struct {
+		KeyID  string
 		Secret string
+		// Pepper of the blind indexes, shared by every key, it is never rotated or every stored hash would have to be computed again
 		Pepper string
+		// PreviousKeys only decrypt, they are kept until rotate-keys has sealed everything with the primary key
+		PreviousKeys []CryptoKey
 	}
 	Logger struct {
 		Formatter logrus.Formatter
 	}
 	Redis struct {
-		Options *redis.Options
+		Options     *redis.Options
+		CacheEnable bool
+		CacheTTL    time.Duration
+		// CacheReplicaLag is how long the users written are not cached again, it must outlast the replication lag
+		CacheReplicaLag time.Duration
 	}
 	MariadbReadWrite struct {
 		Driver             string
@@ -64,13 +126,41 @@ type Config struct {
 		MaxOpenConnections int
 		MaxIdleConnections int
 	}
+	PostgresReadWrite struct {
+		Driver             string
+		DSN                string
+		MaxOpenConnections int
+		MaxIdleConnections int
+	}
+	PostgresReadOnly struct {
+		Driver             string
+		DSN                string
+		MaxOpenConnections int
+		MaxIdleConnections int
+	}
+	SQLite struct {
+		Driver string
+		Path   string
+		DSN    string
+	}
+	Database struct {
+		// Driver selects the database of the users: mariadb (default), postgres, sqlite or mongodb
+		Driver string
+		// MigrateOnStart applies the pending migrations of the sql databases before serving
+		MigrateOnStart bool
+	}
 	Mongodb struct {
 		ClientOptions *options.ClientOptions
 		Database      string
 	}
 	SaramaKafka struct {
-		Addresses []string
-		Config    *sarama.Config
+		Addresses      []string
+		Config         *sarama.Config
+		UserEventTopic string
+		// the users submitted to UserCreateTopic are created by the consumer group, poison messages go to UserCreateDeadLetterTopic
+		ConsumerGroup             string
+		UserCreateTopic           string
+		UserCreateDeadLetterTopic string
 	}
 	Captcha struct {
 		Host           string
@@ -79,8 +169,17 @@ type Config struct {
 		AllowedOrigins []string
 	}
 	GCPStorage struct {
-		AccessID   string
-		PrivateKey string
+		AccessID         string
+		PrivateKey       string
+		Checksum         string
+		RetryMaxAttempts int
+	}
+	Storage struct {
+		Driver        string
+		Bucket        string
+		LocalRoot     string
+		BaseURL       string
+		SigningSecret string
 	}
 	GCPDataStore struct {
 		ProjectID   string
@@ -99,14 +198,22 @@ func Load() *Config {
 	cfg.app()
 	cfg.basicAuth()
 	cfg.crypto()
+	cfg.audit()
+	cfg.rateLimit()
+	cfg.enumeration()
 	cfg.logFormatter()
 	cfg.redis()
 	cfg.mariadbReadOnly()
 	cfg.mariadbReadWrite()
+	cfg.postgresReadOnly()
+	cfg.postgresReadWrite()
+	cfg.sqlite()
+	cfg.database()
 	cfg.mongodb()
 	cfg.sarama()
 	cfg.captcha()
 	cfg.gcpStorage()
+	cfg.storage()
 	cfg.gcpDatastore()
 	cfg.otpDuration()
 	return cfg
@@ -132,10 +239,18 @@ func (cfg *Config) app() {
 		allowedOrigins = strings.Split(rawAllowedOrigins, ",")
 	}
 
+	trustedProxies := make([]string, 0)
+	for _, cidr := range strings.Split(os.Getenv("APP_TRUSTED_PROXIES"), ",") {
+		if cidr = strings.TrimSpace(cidr); cidr != "" {
+			trustedProxies = append(trustedProxies, cidr)
+		}
+	}
+
 	cfg.Application.Name = appName
 	cfg.Application.Port = appPort
 	cfg.Application.Environment = appEnvironment
 	cfg.Application.AllowedOrigins = allowedOrigins
+	cfg.Application.TrustedProxies = trustedProxies
 	cfg.Application.Timezone = defaultTimezone
 }
 
@@ -145,14 +260,109 @@ func (cfg *Config) basicAuth() {
 
 	cfg.BasicAuth.Username = username
 	cfg.BasicAuth.Password = password
+
+	cfg.AdminBasicAuth.Username = os.Getenv("ADMIN_BASIC_AUTH_USERNAME")
+	cfg.AdminBasicAuth.Password = os.Getenv("ADMIN_BASIC_AUTH_PASSWORD")
 }
 
 func (cfg *Config) crypto() {
+	keyID := os.Getenv("AES_KEY_ID")
 	secret := os.Getenv("AES_SECRET")
 	pepper := os.Getenv("AES_PEPPER")
 
+	if keyID == "" {
+		keyID = "v1"
+	}
+
+	// AES_PREVIOUS_KEYS is a comma separated list of id:secret, the previous keys share AES_PEPPER
+	var previousKeys []CryptoKey
+	for _, key := range strings.Split(os.Getenv("AES_PREVIOUS_KEYS"), ",") {
+		parts := strings.SplitN(strings.TrimSpace(key), ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		previousKeys = append(previousKeys, CryptoKey{ID: parts[0], Secret: parts[1]})
+	}
+
+	cfg.Crypto.KeyID = keyID
 	cfg.Crypto.Pepper = pepper
 	cfg.Crypto.Secret = secret
+	cfg.Crypto.PreviousKeys = previousKeys
+}
+
+func (cfg *Config) audit() {
+	checkpointInterval, err := time.ParseDuration(os.Getenv("AUDIT_CHECKPOINT_INTERVAL"))
+	if err != nil {
+		checkpointInterval = time.Hour
+	}
+
+	cfg.Audit.ChainKey = os.Getenv("AUDIT_CHAIN_KEY")
+	cfg.Audit.SigningKey = os.Getenv("AUDIT_SIGNING_KEY")
+	cfg.Audit.VerifyKey = os.Getenv("AUDIT_VERIFY_KEY")
+	cfg.Audit.CheckpointInterval = checkpointInterval
+}
+
+func rateLimitRule(prefix string, perMinute, burst int) RateLimitRule {
+	if value, err := strconv.Atoi(os.Getenv(prefix + "_PER_MINUTE")); err == nil {
+		perMinute = value
+	}
+	if value, err := strconv.Atoi(os.Getenv(prefix + "_BURST")); err == nil {
+		burst = value
+	}
+	return RateLimitRule{PerMinute: perMinute, Burst: burst}
+}
+
+func (cfg *Config) rateLimit() {
+	enable, _ := strconv.ParseBool(os.Getenv("RATE_LIMIT_ENABLE"))
+	driver := os.Getenv("RATE_LIMIT_DRIVER")
+	if driver == "" {
+		driver = "memory"
+	}
+
+	cfg.RateLimit.Enable = enable
+	cfg.RateLimit.Driver = driver
+	// the budget of a principal is shared by every client ip calling with its credential
+	cfg.RateLimit.Principal = rateLimitRule("RATE_LIMIT_PRINCIPAL", 600, 100)
+	cfg.RateLimit.ClientIP = rateLimitRule("RATE_LIMIT_CLIENT_IP", 300, 50)
+	cfg.RateLimit.SearchPrincipal = rateLimitRule("RATE_LIMIT_SEARCH_PRINCIPAL", 60, 20)
+	cfg.RateLimit.SearchClientIP = rateLimitRule("RATE_LIMIT_SEARCH_CLIENT_IP", 30, 10)
+}
+
+func enumerationWindow(prefix string, duration time.Duration, alert, stepUp, lockout int) EnumerationWindow {
+	if value, err := time.ParseDuration(os.Getenv(prefix)); err == nil {
+		duration = value
+	}
+	if value, err := strconv.Atoi(os.Getenv(prefix + "_ALERT")); err == nil {
+		alert = value
+	}
+	if value, err := strconv.Atoi(os.Getenv(prefix + "_STEP_UP")); err == nil {
+		stepUp = value
+	}
+	if value, err := strconv.Atoi(os.Getenv(prefix + "_LOCKOUT")); err == nil {
+		lockout = value
+	}
+	return EnumerationWindow{Duration: duration, Alert: alert, StepUp: stepUp, Lockout: lockout}
+}
+
+func (cfg *Config) enumeration() {
+	enable, _ := strconv.ParseBool(os.Getenv("ENUMERATION_ENABLE"))
+	driver := os.Getenv("ENUMERATION_DRIVER")
+	if driver == "" {
+		driver = "memory"
+	}
+	lockoutDuration, err := time.ParseDuration(os.Getenv("ENUMERATION_LOCKOUT_DURATION"))
+	if err != nil || lockoutDuration <= 0 {
+		lockoutDuration = time.Minute * 15
+	}
+
+	cfg.Enumeration.Enable = enable
+	cfg.Enumeration.Driver = driver
+	cfg.Enumeration.Key = os.Getenv("ENUMERATION_KEY")
+	cfg.Enumeration.LockoutDuration = lockoutDuration
+	cfg.Enumeration.Windows = []EnumerationWindow{
+		enumerationWindow("ENUMERATION_SHORT_WINDOW", time.Minute, 10, 20, 0),
+		enumerationWindow("ENUMERATION_LONG_WINDOW", time.Hour, 50, 100, 200),
+	}
 }
 
 func (cfg *Config) logFormatter() {
@@ -192,7 +402,20 @@ func (cfg *Config) redis() {
 		TLSConfig: tlscfg,
 	}
 
+	cacheEnable, _ := strconv.ParseBool(os.Getenv("REDIS_CACHE_ENABLE"))
+	cacheTTL, err := time.ParseDuration(os.Getenv("REDIS_CACHE_TTL"))
+	if err != nil {
+		cacheTTL = time.Minute * 5
+	}
+	cacheReplicaLag, err := time.ParseDuration(os.Getenv("REDIS_CACHE_REPLICA_LAG"))
+	if err != nil {
+		cacheReplicaLag = time.Second * 5
+	}
+
 	cfg.Redis.Options = options
+	cfg.Redis.CacheEnable = cacheEnable
+	cfg.Redis.CacheTTL = cacheTTL
+	cfg.Redis.CacheReplicaLag = cacheReplicaLag
 }
 
 func (cfg *Config) mariadbReadOnly() {
@@ -299,6 +522,76 @@ func (cfg *Config) mariadbReadWrite() {
 	cfg.MariadbReadWrite.MaxIdleConnections = int(maxIdleConnections)
 }
 
+// postgresDSN sets the timezone of the sessions to the one of the application, the server default is kept for "Local".
+func postgresDSN(prefix string, location *time.Location) string {
+	connVal := url.Values{}
+	connVal.Add("sslmode", os.Getenv(prefix+"SSL_MODE"))
+	if location.String() != "Local" {
+		connVal.Add("timezone", location.String())
+	}
+
+	u := url.URL{
+		Scheme:   "postgres",
+		User:     url.UserPassword(os.Getenv(prefix+"USERNAME"), os.Getenv(prefix+"PASSWORD")),
+		Host:     fmt.Sprintf("%s:%s", os.Getenv(prefix+"HOST"), os.Getenv(prefix+"PORT")),
+		Path:     os.Getenv(prefix + "DATABASE"),
+		RawQuery: connVal.Encode(),
+	}
+	return u.String()
+}
+
+func (cfg *Config) postgresReadOnly() {
+	maxOpenConnections, _ := strconv.ParseInt(os.Getenv("POSTGRES_RO_MAX_OPEN_CONNECTIONS"), 10, 64)
+	maxIdleConnections, _ := strconv.ParseInt(os.Getenv("POSTGRES_RO_MAX_IDLE_CONNECTIONS"), 10, 64)
+
+	cfg.PostgresReadOnly.Driver = "pgx"
+	cfg.PostgresReadOnly.DSN = postgresDSN("POSTGRES_RO_", cfg.Application.Timezone)
+	cfg.PostgresReadOnly.MaxOpenConnections = int(maxOpenConnections)
+	cfg.PostgresReadOnly.MaxIdleConnections = int(maxIdleConnections)
+}
+
+func (cfg *Config) postgresReadWrite() {
+	maxOpenConnections, _ := strconv.ParseInt(os.Getenv("POSTGRES_RW_MAX_OPEN_CONNECTIONS"), 10, 64)
+	maxIdleConnections, _ := strconv.ParseInt(os.Getenv("POSTGRES_RW_MAX_IDLE_CONNECTIONS"), 10, 64)
+
+	cfg.PostgresReadWrite.Driver = "pgx"
+	cfg.PostgresReadWrite.DSN = postgresDSN("POSTGRES_RW_", cfg.Application.Timezone)
+	cfg.PostgresReadWrite.MaxOpenConnections = int(maxOpenConnections)
+	cfg.PostgresReadWrite.MaxIdleConnections = int(maxIdleConnections)
+}
+
+// sqlite enforces the foreign keys and waits for the lock of another connection instead of failing right away.
+// Its transactions take the write lock when they begin, so a transaction reading before it writes, e.g. the head of the
+// audit chain, is never overtaken by another process.
+func (cfg *Config) sqlite() {
+	path := os.Getenv("SQLITE_PATH")
+	if path == "" {
+		path = "pii-encrypt-example.db"
+	}
+
+	connVal := url.Values{}
+	connVal.Add("_pragma", "foreign_keys(1)")
+	connVal.Add("_pragma", "busy_timeout(5000)")
+	connVal.Add("_pragma", "journal_mode(WAL)")
+	connVal.Add("_txlock", "immediate")
+
+	cfg.SQLite.Driver = "sqlite"
+	cfg.SQLite.Path = path
+	cfg.SQLite.DSN = fmt.Sprintf("file:%s?%s", path, connVal.Encode())
+}
+
+func (cfg *Config) database() {
+	driver := os.Getenv("DATABASE_DRIVER")
+	if driver == "" {
+		driver = "mariadb"
+	}
+
+	migrateOnStart, _ := strconv.ParseBool(os.Getenv("DATABASE_MIGRATE_ON_START"))
+
+	cfg.Database.Driver = driver
+	cfg.Database.MigrateOnStart = migrateOnStart
+}
+
 func (cfg *Config) mongodb() {
 	appName := os.Getenv("APP_NAME")
 	uri := os.Getenv("MONGODB_URL")
@@ -354,11 +647,34 @@ func (cfg *Config) sarama() {
 	// consumer config
 	sc.Consumer.Group.Rebalance.Strategy = sarama.BalanceStrategyRoundRobin
 	sc.Consumer.Offsets.Initial = sarama.OffsetOldest
+	sc.Consumer.Return.Errors = true
 	// producer config
 	sc.Producer.Retry.Backoff = time.Millisecond * 500
+	sc.Producer.RequiredAcks = sarama.WaitForAll
+	sc.Producer.Return.Successes = true
+
+	userEventTopic := os.Getenv("KAFKA_USER_EVENT_TOPIC")
+	if userEventTopic == "" {
+		userEventTopic = "user-events"
+	}
 
 	cfg.SaramaKafka.Addresses = strings.Split(brokers, ",")
 	cfg.SaramaKafka.Config = sc
+	cfg.SaramaKafka.UserEventTopic = userEventTopic
+
+	consumerGroup := os.Getenv("KAFKA_CONSUMER_GROUP")
+	if consumerGroup == "" {
+		consumerGroup = "pii-encrypt-example"
+	}
+	userCreateTopic := os.Getenv("KAFKA_USER_CREATE_TOPIC")
+	userCreateDeadLetterTopic := os.Getenv("KAFKA_USER_CREATE_DEAD_LETTER_TOPIC")
+	if userCreateDeadLetterTopic == "" && userCreateTopic != "" {
+		userCreateDeadLetterTopic = userCreateTopic + "-dlq"
+	}
+
+	cfg.SaramaKafka.ConsumerGroup = consumerGroup
+	cfg.SaramaKafka.UserCreateTopic = userCreateTopic
+	cfg.SaramaKafka.UserCreateDeadLetterTopic = userCreateDeadLetterTopic
 }
 
 func (cfg *Config) captcha() {
@@ -382,10 +698,36 @@ func (cfg *Config) captcha() {
 
 func (cfg *Config) gcpStorage() {
 	accessID := os.Getenv("GCP_ACCESS_ID")
-	privateKey := os.Getenv("GCP_PRIVATE_KEY")
+	privateKey := strings.Replace(os.Getenv("GCP_PRIVATE_KEY"), `\n`, "\n", -1)
+
+	checksum := os.Getenv("GCP_STORAGE_CHECKSUM")
+	retryMaxAttempts, err := strconv.Atoi(os.Getenv("GCP_STORAGE_RETRY_MAX_ATTEMPTS"))
+	if err != nil {
+		retryMaxAttempts = 3
+	}
 
 	cfg.GCPStorage.AccessID = accessID
 	cfg.GCPStorage.PrivateKey = string(privateKey)
+	cfg.GCPStorage.Checksum = checksum
+	cfg.GCPStorage.RetryMaxAttempts = retryMaxAttempts
+}
+
+func (cfg *Config) storage() {
+	driver := os.Getenv("STORAGE_DRIVER")
+	bucket := os.Getenv("STORAGE_BUCKET")
+	localRoot := os.Getenv("STORAGE_LOCAL_ROOT")
+	baseURL := os.Getenv("STORAGE_BASE_URL")
+	signingSecret := os.Getenv("STORAGE_SIGNING_SECRET")
+
+	if localRoot == "" {
+		localRoot = "./data/storage"
+	}
+
+	cfg.Storage.Driver = driver
+	cfg.Storage.Bucket = bucket
+	cfg.Storage.LocalRoot = localRoot
+	cfg.Storage.BaseURL = baseURL
+	cfg.Storage.SigningSecret = signingSecret
 }
 
 func (cfg *Config) gcpDatastore() {
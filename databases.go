@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"pii-encrypt-example/cmd/user/v1"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"pii-encrypt-example/pkg/audit"
+	"pii-encrypt-example/pkg/database"
+	"pii-encrypt-example/pkg/outbox"
+)
+
+// userDatabases are the databases of the users and their repositories, either the sql databases or mongodb.
+type userDatabases struct {
+	dbReadOnly       *sql.DB
+	dbReadWrite      *sql.DB
+	dialect          database.Dialect
+	mongoClient      *mongo.Client
+	userRepository   user.UserRepository
+	outboxRepository outbox.OutboxRepository
+	auditRepository  audit.AuditRepository
+}
+
+// openUserDatabases opens the database of the users, mariadb unless configured otherwise, the sql databases share the repositories.
+func openUserDatabases(logger *logrus.Logger) (databases *userDatabases) {
+	databases = new(userDatabases)
+	auditChain := newAuditChain(logger)
+	switch cfg.Database.Driver {
+	case "mongodb":
+		mongoClient, err := mongo.Connect(context.Background(), cfg.Mongodb.ClientOptions)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		if err := mongoClient.Ping(context.Background(), nil); err != nil {
+			logger.Fatal(err)
+		}
+		if err := user.CreateUserMongoIndexes(context.Background(), mongoClient, cfg.Mongodb.Database, "user_encrypt"); err != nil {
+			logger.Fatal(err)
+		}
+		if err := outbox.CreateOutboxMongoIndexes(context.Background(), mongoClient, cfg.Mongodb.Database, "outbox_event"); err != nil {
+			logger.Fatal(err)
+		}
+		if err := audit.CreateAuditMongoIndexes(context.Background(), mongoClient, cfg.Mongodb.Database, "pii_access_audit"); err != nil {
+			logger.Fatal(err)
+		}
+		databases.mongoClient = mongoClient
+		databases.userRepository = user.NewUserMongoRepository(logger, mongoClient, cfg.Mongodb.Database, "user_encrypt")
+		databases.outboxRepository = outbox.NewOutboxMongoRepository(logger, mongoClient, cfg.Mongodb.Database, "outbox_event")
+		databases.auditRepository = audit.NewAuditMongoRepository(logger, mongoClient, cfg.Mongodb.Database, "pii_access_audit", auditChain)
+	default:
+		databases.dbReadOnly, databases.dbReadWrite, databases.dialect = openSQLDatabases(logger)
+		if cfg.Database.MigrateOnStart {
+			if _, err := newMigrator(logger, databases.dialect, databases.dbReadWrite).Up(context.Background()); err != nil {
+				logger.Fatal(err)
+			}
+		}
+		databases.userRepository = user.NewUserRepository(logger, databases.dialect, databases.dbReadOnly, databases.dbReadWrite, "user_encrypt")
+		databases.outboxRepository = outbox.NewOutboxRepository(logger, databases.dialect, databases.dbReadWrite, "outbox_event")
+		databases.auditRepository = audit.NewAuditRepository(logger, databases.dialect, databases.dbReadOnly, databases.dbReadWrite, "pii_access_audit", auditChain)
+	}
+	return
+}
+
+// Close closes whichever database has been opened.
+func (d *userDatabases) Close() {
+	if d.mongoClient != nil {
+		d.mongoClient.Disconnect(context.Background())
+	}
+	if d.dbReadWrite != nil {
+		d.dbReadOnly.Close()
+		d.dbReadWrite.Close()
+	}
+}
+
+// openSQLDatabases opens the read only and the read write sql databases of the configured driver, mariadb unless configured otherwise.
+func openSQLDatabases(logger *logrus.Logger) (dbReadOnly *sql.DB, dbReadWrite *sql.DB, dialect database.Dialect) {
+	switch cfg.Database.Driver {
+	case "postgres":
+		dbReadOnly = openDatabase(logger, cfg.PostgresReadOnly.Driver, cfg.PostgresReadOnly.DSN, cfg.PostgresReadOnly.MaxOpenConnections, cfg.PostgresReadOnly.MaxIdleConnections)
+		dbReadWrite = openDatabase(logger, cfg.PostgresReadWrite.Driver, cfg.PostgresReadWrite.DSN, cfg.PostgresReadWrite.MaxOpenConnections, cfg.PostgresReadWrite.MaxIdleConnections)
+		dialect = database.Postgres{}
+	case "sqlite":
+		// sqlite is a single file, the same database serves the reads and the writes
+		dbReadWrite = openDatabase(logger, cfg.SQLite.Driver, cfg.SQLite.DSN, 0, 0)
+		dbReadOnly = dbReadWrite
+		dialect = database.SQLite{}
+	default:
+		dbReadOnly = openDatabase(logger, cfg.MariadbReadOnly.Driver, cfg.MariadbReadOnly.DSN, cfg.MariadbReadOnly.MaxOpenConnections, cfg.MariadbReadOnly.MaxIdleConnections)
+		dbReadWrite = openDatabase(logger, cfg.MariadbReadWrite.Driver, cfg.MariadbReadWrite.DSN, cfg.MariadbReadWrite.MaxOpenConnections, cfg.MariadbReadWrite.MaxIdleConnections)
+		dialect = database.MySQL{}
+	}
+	return
+}
+
+// openDatabase opens and pings a sql database, the limits of the connections are left unset when zero.
+func openDatabase(logger *logrus.Logger, driverName string, dsn string, maxOpenConnections int, maxIdleConnections int) *sql.DB {
+	db, err := sql.Open(driverName, dsn)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	if err := db.Ping(); err != nil {
+		logger.Fatal(err)
+	}
+	db.SetConnMaxLifetime(time.Minute * 3)
+	if maxOpenConnections > 0 {
+		db.SetMaxOpenConns(maxOpenConnections)
+	}
+	if maxIdleConnections > 0 {
+		db.SetMaxIdleConns(maxIdleConnections)
+	}
+	return db
+}
@@ -0,0 +1,34 @@
+package entity
+
+import "time"
+
+// PIIAccess is a record of the audit log of the accesses to plaintext PII: who revealed which fields of which users, and why.
+// The log is append only, a record is never updated nor deleted. Each record carries the HMAC of its content
+// and of the HMAC of the previous record, so an edited or a removed record breaks the chain.
+type PIIAccess struct {
+	ID            int64     `json:"id"`
+	Principal     string    `json:"principal"`
+	RemoteAddress string    `json:"remote_address"`
+	XForwardedFor string    `json:"x_forwarded_for"`
+	XRealIP       string    `json:"x_real_ip"`
+	UserAgent     string    `json:"user_agent"`
+	ClientIP      string    `json:"client_ip"`
+	UserUUIDs     []string  `json:"user_uuids"`
+	Fields        []string  `json:"fields"`
+	Purpose       string    `json:"purpose"`
+	CreatedAt     time.Time `json:"created_at"`
+	PreviousHMAC  string    `json:"previous_hmac"`
+	HMAC          string    `json:"hmac"`
+	// ChainVersion is the format of the link of the record, 0 for the records linked before it was recorded
+	ChainVersion int `json:"chain_version"`
+}
+
+// AuditCheckpoint is a signed statement of the head of the audit log chain at a point in time,
+// it proves the records up to AccessID have not been rewritten nor truncated since.
+type AuditCheckpoint struct {
+	ID        int64     `json:"id"`
+	AccessID  int64     `json:"access_id"`
+	HMAC      string    `json:"hmac"`
+	Signature string    `json:"signature"`
+	CreatedAt time.Time `json:"created_at"`
+}
@@ -0,0 +1,18 @@
+package entity
+
+import "time"
+
+// Document is a file attached to a user, e.g. an ID card photo. The file is encrypted with its own data key,
+// which is stored wrapped by the keyring key identified by KeyID.
+type Document struct {
+	UUID        string    `json:"uuid"`
+	UserUUID    string    `json:"user_uuid"`
+	Type        string    `json:"type"`
+	ContentType string    `json:"content_type"`
+	Size        int64     `json:"size"`
+	SHA256      []byte    `json:"sha256"`
+	KeyID       string    `json:"key_id"`
+	WrappedKey  []byte    `json:"wrapped_key"`
+	ObjectPath  string    `json:"object_path"`
+	CreatedAt   time.Time `json:"created_at"`
+}
@@ -0,0 +1,16 @@
+package entity
+
+import "time"
+
+// OutboxEvent is an event recorded in the same transaction as the change it describes,
+// it is published to kafka afterwards and PublishedAt is set once the broker acknowledged it.
+type OutboxEvent struct {
+	ID            int64      `json:"id"`
+	EventID       string     `json:"event_id"`
+	AggregateType string     `json:"aggregate_type"`
+	AggregateID   string     `json:"aggregate_id"`
+	Type          string     `json:"type"`
+	Payload       []byte     `json:"payload"`
+	CreatedAt     time.Time  `json:"created_at"`
+	PublishedAt   *time.Time `json:"published_at"`
+}
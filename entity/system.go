@@ -2,9 +2,18 @@ package entity
 
 type ClientContextKey struct{}
 
+// RequestIDContextKey holds the id of the request, as a string, to correlate its logs and its response.
+type RequestIDContextKey struct{}
+
+// PrincipalContextKey holds the name of the authenticated caller, as a string.
+type PrincipalContextKey struct{}
+
+// ClientDevice describes the client of a request. The forwarded headers are kept as received, ClientIP is the address
+// of the client resolved through the trusted proxies only, the one to rely on.
 type ClientDevice struct {
 	RemoteAddress string
 	XForwardedFor string
 	XRealIP       string
 	UserAgent     string
+	ClientIP      string
 }
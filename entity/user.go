@@ -2,10 +2,21 @@ package entity
 
 import "time"
 
+// User is the stored form of a user, every PII is kept as ciphertext and, when it has to be searchable, as a blind index.
+// The pii tag is read by pii.Sealer to seal a dto into a User and to open it back.
 type User struct {
-	UUID       string    `json:"uuid"`
-	NameCrypt  []byte    `json:"__encrypted__data_name_crypt"`
-	NameHash   []byte    `json:"__encrypted__data_name_hash"`
-	EmailCrypt []byte    `json:"__encrypted__data_email_crypt"`
-	CreatedAt  time.Time `json:"created_at"`
+	UUID               string    `json:"uuid"`
+	NameCrypt          []byte    `json:"__encrypted__data_name_crypt" pii:"encrypt,mask=name"`
+	NameHash           []byte    `json:"__encrypted__data_name_hash" pii:"index"`
+	EmailCrypt         []byte    `json:"__encrypted__data_email_crypt" pii:"encrypt,mask=email"`
+	EmailHash          []byte    `json:"__encrypted__data_email_hash" pii:"index,normalize=email"`
+	PhoneNumberCrypt   []byte    `json:"__encrypted__data_phone_number_crypt" pii:"encrypt,mask=phone"`
+	PhoneNumberHash    []byte    `json:"__encrypted__data_phone_number_hash" pii:"index,normalize=phone"`
+	NationalityIDCrypt []byte    `json:"__encrypted__data_nik_crypt" pii:"encrypt,mask=nik"`
+	NationalityIDHash  []byte    `json:"__encrypted__data_nik_hash" pii:"index"`
+	DateOfBirthCrypt   []byte    `json:"__encrypted__data_date_of_birth_crypt" pii:"encrypt,mask=date"`
+	AddressCrypt       []byte    `json:"__encrypted__data_address_crypt" pii:"encrypt,mask=full"`
+	CreatedAt          time.Time `json:"created_at"`
+	// KeyID is the id of the keyring key the user was sealed with, empty for the users sealed before it was recorded.
+	KeyID string `json:"key_id"`
 }
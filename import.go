@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+
+	"pii-encrypt-example/cmd/user/v1"
+	"pii-encrypt-example/pkg/audit"
+	"pii-encrypt-example/pkg/crypto"
+)
+
+// runImportUsers runs the import subcommand on the database of the users.
+func runImportUsers(logger *logrus.Logger, keyring *crypto.Keyring, args []string) (err error) {
+	databases := openUserDatabases(logger)
+	defer databases.Close()
+
+	userUsecase := user.NewUserUsecase(logger, cfg.Application.Timezone, keyring, newValidator(), audit.NewAuditor(logger, cfg.Application.Timezone, databases.auditRepository), databases.userRepository, databases.outboxRepository)
+	return runImport(logger, userUsecase, args)
+}
+
+// runImport imports a csv or jsonl file of users and writes the report to stdout.
+func runImport(logger *logrus.Logger, userUsecase user.UserUsecase, args []string) (err error) {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	path := flags.String("file", "", "path of the csv or jsonl file to import")
+	format := flags.String("format", "", "format of the file, csv or jsonl, guessed from the file extension when empty")
+	if err = flags.Parse(args); err != nil {
+		return
+	}
+	if *path == "" {
+		return fmt.Errorf("import: -file is required")
+	}
+	if *format == "" {
+		*format = strings.TrimPrefix(filepath.Ext(*path), ".")
+	}
+
+	file, err := os.Open(*path)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+
+	resp := userUsecase.ImportUsers(context.Background(), file, *format)
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err = encoder.Encode(resp.Data()); err != nil {
+		return
+	}
+	if resp.Error() != nil {
+		return fmt.Errorf("import: %s", resp.Message())
+	}
+
+	logger.Info(fmt.Sprintf("import of %s is done", *path))
+	return
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	osuser "os/user"
+	"pii-encrypt-example/cmd/document/v1"
+	"pii-encrypt-example/cmd/user/v1"
+	"strings"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+
+	"pii-encrypt-example/entity"
+	"pii-encrypt-example/pkg/audit"
+	"pii-encrypt-example/pkg/crypto"
+	"pii-encrypt-example/pkg/pii"
+)
+
+// runEncrypt encrypts a single value with the primary key, e.g. to fix a row by hand.
+func runEncrypt(logger *logrus.Logger, keyring *crypto.Keyring, args []string) (err error) {
+	flags := flag.NewFlagSet("encrypt", flag.ContinueOnError)
+	encoding := flags.String("encoding", "base64", "encoding of the ciphertext, base64 or hex")
+	purpose := flags.String("purpose", "", "reason of the encryption, recorded in the audit log")
+	if err = flags.Parse(args); err != nil {
+		return
+	}
+	if *purpose == "" {
+		return fmt.Errorf("encrypt: -purpose is required")
+	}
+
+	plainText, err := readValue(flags.Args())
+	if err != nil {
+		return
+	}
+
+	cipherText, err := keyring.Encrypt(plainText)
+	if err != nil {
+		return
+	}
+	encoded, err := encode(*encoding, cipherText)
+	if err != nil {
+		return
+	}
+
+	auditCommand(logger, "encrypt", *purpose).WithField("key_id", keyring.PrimaryKeyID()).Info("value encrypted")
+	fmt.Println(encoded)
+	return
+}
+
+// runDecrypt decrypts a single value with any key of the keyring, every decryption is recorded in the audit log
+// with the operating system user as principal, before the value is printed.
+func runDecrypt(logger *logrus.Logger, keyring *crypto.Keyring, args []string) (err error) {
+	flags := flag.NewFlagSet("decrypt", flag.ContinueOnError)
+	encoding := flags.String("encoding", "base64", "encoding of the ciphertext, base64 or hex")
+	purpose := flags.String("purpose", "", "reason of the decryption, e.g. a support ticket, recorded in the audit log")
+	userUUID := flags.String("user", "", "uuid of the user the value belongs to, recorded in the audit log")
+	field := flags.String("field", "value", "field of the user the value belongs to, e.g. email, recorded in the audit log")
+	if err = flags.Parse(args); err != nil {
+		return
+	}
+	if *purpose == "" {
+		return fmt.Errorf("decrypt: -purpose is required")
+	}
+	if _, err = uuid.Parse(*userUUID); err != nil {
+		return fmt.Errorf("decrypt: -user must be the uuid of a user")
+	}
+
+	value, err := readValue(flags.Args())
+	if err != nil {
+		return
+	}
+	cipherText, err := decode(*encoding, value)
+	if err != nil {
+		return
+	}
+
+	plainText, err := keyring.Decrypt(cipherText)
+	if err != nil {
+		auditCommand(logger, "decrypt", *purpose).Warn("value cannot be decrypted")
+		return
+	}
+
+	databases := openUserDatabases(logger)
+	defer databases.Close()
+	auditor := audit.NewAuditor(logger, cfg.Application.Timezone, databases.auditRepository)
+	ctx := context.WithValue(context.Background(), entity.PrincipalContextKey{}, operator())
+	if err = auditor.Record(ctx, "decrypt: "+*purpose, []string{*field}, []string{*userUUID}); err != nil {
+		return
+	}
+
+	auditCommand(logger, "decrypt", *purpose).Info("value decrypted")
+	fmt.Println(string(plainText))
+	return
+}
+
+// runHash computes the blind index of a value with AES_PEPPER, to look a user up in the database.
+func runHash(logger *logrus.Logger, keyring *crypto.Keyring, args []string) (err error) {
+	flags := flag.NewFlagSet("hash", flag.ContinueOnError)
+	encoding := flags.String("encoding", "hex", "encoding of the blind index, base64 or hex")
+	normalize := flags.String("normalize", "", "normalizer of the field, email or phone, none for the name and the nationality id")
+	if err = flags.Parse(args); err != nil {
+		return
+	}
+
+	value, err := readValue(flags.Args())
+	if err != nil {
+		return
+	}
+
+	encoded, err := encode(*encoding, keyring.Hash(pii.Normalize(*normalize, value)))
+	if err != nil {
+		return
+	}
+	fmt.Println(encoded)
+	return
+}
+
+// runRotateKeys seals again with the primary key every user and every document key sealed with a previous key,
+// a previous key can be removed from AES_PREVIOUS_KEYS once it succeeds.
+func runRotateKeys(logger *logrus.Logger, keyring *crypto.Keyring, args []string) (err error) {
+	flags := flag.NewFlagSet("rotate-keys", flag.ContinueOnError)
+	if err = flags.Parse(args); err != nil {
+		return
+	}
+
+	ctx := context.Background()
+	databases := openUserDatabases(logger)
+	defer databases.Close()
+	userRepository := databases.userRepository
+
+	// the rotated users are invalidated in the cache of the running servers
+	if cfg.Redis.CacheEnable {
+		redisClient := redis.NewClient(cfg.Redis.Options)
+		defer redisClient.Close()
+		if err = redisClient.Ping(ctx).Err(); err != nil {
+			return
+		}
+		userRepository = user.NewCachedUserRepository(logger, redisClient, cfg.Redis.CacheTTL, cfg.Redis.CacheReplicaLag, userRepository)
+	}
+
+	auditor := audit.NewAuditor(logger, cfg.Application.Timezone, databases.auditRepository)
+	userUsecase := user.NewUserUsecase(logger, cfg.Application.Timezone, keyring, newValidator(), auditor, userRepository, databases.outboxRepository)
+	rotatedUsers, err := userUsecase.RotateKeys(ctx)
+	logger.Info(fmt.Sprintf("rotate-keys: %d users sealed with %s", rotatedUsers, keyring.PrimaryKeyID()))
+	if err != nil {
+		return
+	}
+
+	// the documents are only kept in the sql databases, their objects are left untouched
+	if databases.dbReadWrite != nil {
+		documentRepository := document.NewDocumentRepository(logger, databases.dialect, databases.dbReadOnly, databases.dbReadWrite, "user_document", "user_encrypt")
+		documentUsecase := document.NewDocumentUsecase(logger, cfg.Application.Timezone, keyring, nil, cfg.Storage.Bucket, auditor, documentRepository)
+		rotatedDocuments, err := documentUsecase.RotateKeys(ctx)
+		logger.Info(fmt.Sprintf("rotate-keys: %d document keys wrapped with %s", rotatedDocuments, keyring.PrimaryKeyID()))
+		if err != nil {
+			return err
+		}
+	}
+	return
+}
+
+// auditCommand returns the audit entry of a subcommand handling a plaintext, the value itself is never logged.
+func auditCommand(logger *logrus.Logger, command string, purpose string) *logrus.Entry {
+	return logger.WithFields(logrus.Fields{
+		"audit":    true,
+		"command":  command,
+		"operator": operator(),
+		"purpose":  purpose,
+	})
+}
+
+// operator returns the name of the operating system user running the command.
+func operator() string {
+	if current, err := osuser.Current(); err == nil {
+		return current.Username
+	}
+	return "unknown"
+}
+
+// readValue returns the value given as argument, or the first line of stdin when there is none,
+// which keeps the value out of the shell history.
+func readValue(args []string) (value string, err error) {
+	if len(args) > 0 && args[0] != "-" {
+		return args[0], nil
+	}
+
+	value, err = bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return
+	}
+	return strings.TrimRight(value, "\r\n"), nil
+}
+
+func encode(encoding string, b []byte) (string, error) {
+	switch encoding {
+	case "base64":
+		return base64.StdEncoding.EncodeToString(b), nil
+	case "hex":
+		return hex.EncodeToString(b), nil
+	}
+	return "", fmt.Errorf("unknown encoding %q, expected base64 or hex", encoding)
+}
+
+func decode(encoding string, s string) ([]byte, error) {
+	switch encoding {
+	case "base64":
+		return base64.StdEncoding.DecodeString(s)
+	case "hex":
+		return hex.DecodeString(s)
+	}
+	return nil, fmt.Errorf("unknown encoding %q, expected base64 or hex", encoding)
+}
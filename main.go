@@ -1,29 +1,19 @@
 package main
 
 import (
-	"database/sql"
-	"net/http"
+	"fmt"
 	"os"
-	"os/signal"
-
-	"pii-encrypt-example/cmd/user/v1"
 	"pii-encrypt-example/configs"
-	"pii-encrypt-example/server"
-	"syscall"
-	"time"
 
 	"github.com/go-playground/validator/v10"
 	_ "github.com/go-sql-driver/mysql"
-	"github.com/gorilla/mux"
 	_ "github.com/joho/godotenv/autoload" // for development
-	"github.com/rs/cors"
 	"github.com/sirupsen/logrus"
 	ddlogrus "gopkg.in/DataDog/dd-trace-go.v1/contrib/sirupsen/logrus"
 
 	"pii-encrypt-example/pkg/crypto"
 	"pii-encrypt-example/pkg/hook"
-	"pii-encrypt-example/pkg/middleware"
-	"pii-encrypt-example/pkg/response"
+	customvalidator "pii-encrypt-example/pkg/validator"
 )
 
 var (
@@ -38,80 +28,87 @@ func init() {
 }
 
 func main() {
+	// the server runs unless another subcommand is given, e.g. "app migrate up"
+	command, args := "serve", []string{}
+	if len(os.Args) > 1 {
+		command, args = os.Args[1], os.Args[2:]
+	}
+
 	logger := logrus.New()
 	logger.SetFormatter(cfg.Logger.Formatter)
 	logger.SetReportCaller(true)
+	// the redaction runs first, the other hooks and the formatter only see the masked entries
+	logger.AddHook(hook.NewRedactionHook(hook.DefaultSensitiveKeys...))
+	logger.AddHook(hook.NewRequestIDHook())
 	logger.AddHook(&ddlogrus.DDContextLogHook{})
-	logger.AddHook(hook.NewStdoutLoggerHook(logrus.New(), cfg.Logger.Formatter))
+	// the other subcommands log to stderr only, their output is written to stdout
+	if command == "serve" {
+		logger.AddHook(hook.NewStdoutLoggerHook(logrus.New(), cfg.Logger.Formatter))
+	}
 
 	// set crypto
-	crypto := crypto.NewAESGCM(cfg.Crypto.Secret, cfg.Crypto.Pepper)
-
-	// set mariadb read only object
-	dbReadOnly, err := sql.Open(cfg.MariadbReadOnly.Driver, cfg.MariadbReadOnly.DSN)
-	if err != nil {
-		logger.Fatal(err)
-	}
-	if err := dbReadOnly.Ping(); err != nil {
-		logger.Fatal(err)
+	keyring := newKeyring()
+	crypto.RegisterKeyring(keyring)
+
+	var err error
+	switch command {
+	case "serve":
+		serve(logger, keyring)
+	case "migrate":
+		err = runMigrate(logger, args)
+	case "import":
+		err = runImportUsers(logger, keyring, args)
+	case "encrypt":
+		err = runEncrypt(logger, keyring, args)
+	case "decrypt":
+		err = runDecrypt(logger, keyring, args)
+	case "hash":
+		err = runHash(logger, keyring, args)
+	case "rotate-keys":
+		err = runRotateKeys(logger, keyring, args)
+	case "verify-audit":
+		err = runVerifyAudit(logger, args)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", command, usage)
+		os.Exit(2)
 	}
-	dbReadOnly.SetConnMaxLifetime(time.Minute * 3)
-	dbReadOnly.SetMaxOpenConns(cfg.MariadbReadOnly.MaxOpenConnections)
-	dbReadOnly.SetMaxIdleConns(cfg.MariadbReadOnly.MaxIdleConnections)
-
-	// set mariadb read write object
-	dbReadWrite, err := sql.Open(cfg.MariadbReadWrite.Driver, cfg.MariadbReadWrite.DSN)
 	if err != nil {
 		logger.Fatal(err)
 	}
-	if err := dbReadWrite.Ping(); err != nil {
-		logger.Fatal(err)
-	}
-	dbReadWrite.SetConnMaxLifetime(time.Minute * 3)
-	dbReadWrite.SetMaxOpenConns(cfg.MariadbReadWrite.MaxOpenConnections)
-	dbReadWrite.SetMaxIdleConns(cfg.MariadbReadWrite.MaxIdleConnections)
-
-	router := mux.NewRouter()
-	router.HandleFunc("/todo", index)
-
-	basicAuthMiddleware := middleware.NewBasicAuth(cfg.BasicAuth.Username, cfg.BasicAuth.Password)
-
-	// set validator
-	validator := validator.New()
-	// validator.RegisterTagNameFunc(customvalidator.SetTagName)
-	// validator.RegisterValidation("default-name", customvalidator.SetDefaultName)
-	// validator.RegisterValidation("idn-mobile-number", customvalidator.SetIDNMobileNumber)
-	// validator.RegisterValidation("ISO8601date", customvalidator.SetISO8601dateFormat)
-
-	userRepository := user.NewUserRepository(logger, dbReadOnly, dbReadWrite, "user_encrypt")
-	userUsecase := user.NewUserUsecase(logger, cfg.Application.Timezone, crypto, userRepository)
-	user.NewUserHTTPHandler(logger, router, basicAuthMiddleware, validator, userUsecase)
-
-	handler := middleware.ClientDeviceMiddleware(router)
-	// set cors
-	handler = cors.New(cors.Options{
-		AllowedOrigins:   cfg.Application.AllowedOrigins,
-		AllowedMethods:   []string{http.MethodPost, http.MethodGet, http.MethodPut, http.MethodDelete},
-		AllowedHeaders:   []string{"Origin", "Accept", "Content-Type", "X-Requested-With", "Authorization"},
-		AllowCredentials: true,
-	}).Handler(handler)
-	handler = middleware.NewRecovery(logger, true).Handler(handler)
-
-	// initiate server
-	srv := server.NewServer(logger, handler, cfg.Application.Port)
-	srv.Start()
-
-	sigterm := make(chan os.Signal, 1)
-	signal.Notify(sigterm, syscall.SIGINT, syscall.SIGTERM)
-	<-sigterm
-
-	srv.Close()
-	dbReadOnly.Close()
-	dbReadWrite.Close()
+}
 
+const usage = `usage: app <command> [flags]
+
+commands:
+  serve         run the http server and the kafka workers, the default
+  migrate       apply, revert or list the schema migrations
+  import        import a csv or jsonl file of users
+  encrypt       encrypt a value with the primary key of the keyring
+  decrypt       decrypt a value with the keyring
+  hash          compute the blind index of a value
+  rotate-keys   seal again with the primary key every user and document sealed with a previous key
+  verify-audit  walk the chain of the pii access audit log and report its first broken link
+`
+
+// newKeyring returns the keyring of the configured keys, the previous keys only decrypt.
+// Every key shares the pepper, so the blind indexes stored before a rotation still match.
+func newKeyring() *crypto.Keyring {
+	keyring := crypto.NewKeyring(cfg.Crypto.KeyID, crypto.NewAESGCM(cfg.Crypto.Secret, cfg.Crypto.Pepper))
+	for _, key := range cfg.Crypto.PreviousKeys {
+		keyring.Add(key.ID, crypto.NewAESGCM(key.Secret, cfg.Crypto.Pepper))
+	}
+	return keyring
 }
 
-func index(w http.ResponseWriter, r *http.Request) {
-	resp := response.NewSuccessResponse(nil, response.StatOK, indexMessage)
-	response.JSON(w, resp)
+// newValidator returns the validator of the requests, with the custom validations.
+func newValidator() *validator.Validate {
+	validator := validator.New()
+	validator.RegisterTagNameFunc(customvalidator.SetTagName)
+	validator.RegisterValidation("default-name", customvalidator.SetDefaultName)
+	validator.RegisterValidation("idn-mobile-number", customvalidator.SetIDNMobileNumber)
+	validator.RegisterValidation("ISO8601date", customvalidator.SetISO8601dateFormat)
+	validator.RegisterValidation("email", customvalidator.SetEmail)
+	validator.RegisterValidation("nik", customvalidator.SetNIK)
+	validator.RegisterValidation("npwp", customvalidator.SetNPWP)
+	return validator
 }
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/sirupsen/logrus"
+
+	"pii-encrypt-example/migrations"
+	"pii-encrypt-example/pkg/database"
+)
+
+// newMigrator returns the migrator of the embedded migrations of dialect.
+func newMigrator(logger *logrus.Logger, dialect database.Dialect, db *sql.DB) *database.Migrator {
+	migrator, err := database.NewMigrator(logger, dialect, db, migrations.FS, "schema_migrations")
+	if err != nil {
+		logger.Fatal(err)
+	}
+	return migrator
+}
+
+// runMigrate runs the migrate subcommand: migrate [up | down -steps n | status | baseline <version>].
+// baseline adopts a database created before the migrations, by the sql script, its schema being the one of version.
+func runMigrate(logger *logrus.Logger, args []string) (err error) {
+	command := "up"
+	if len(args) > 0 {
+		command, args = args[0], args[1:]
+	}
+
+	flags := flag.NewFlagSet("migrate "+command, flag.ContinueOnError)
+	steps := flags.Int("steps", 1, "number of migrations reverted by down")
+	if err = flags.Parse(args); err != nil {
+		return
+	}
+	var version int64
+	if command == "baseline" {
+		if version, err = strconv.ParseInt(flags.Arg(0), 10, 64); err != nil {
+			return fmt.Errorf("migrate: baseline expects the version of the schema, e.g. migrate baseline 1")
+		}
+	}
+
+	if cfg.Database.Driver == "mongodb" {
+		return fmt.Errorf("migrate: mongodb has no migrations, its indexes are created at startup")
+	}
+	dbReadOnly, dbReadWrite, dialect := openSQLDatabases(logger)
+	defer func() {
+		dbReadOnly.Close()
+		dbReadWrite.Close()
+	}()
+	migrator := newMigrator(logger, dialect, dbReadWrite)
+
+	ctx := context.Background()
+	switch command {
+	case "up":
+		applied, err := migrator.Up(ctx)
+		if err != nil {
+			return err
+		}
+		logger.Info(fmt.Sprintf("migrate: %d migrations applied", len(applied)))
+	case "down":
+		reverted, err := migrator.Down(ctx, *steps)
+		if err != nil {
+			return err
+		}
+		logger.Info(fmt.Sprintf("migrate: %d migrations reverted", len(reverted)))
+	case "baseline":
+		recorded, err := migrator.Baseline(ctx, version)
+		if err != nil {
+			return err
+		}
+		logger.Info(fmt.Sprintf("migrate: %d migrations recorded as applied", len(recorded)))
+	case "status":
+		statuses, err := migrator.Status(ctx)
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED AT")
+		for _, status := range statuses {
+			appliedAt := "pending"
+			if status.AppliedAt != nil {
+				appliedAt = status.AppliedAt.In(cfg.Application.Timezone).Format("2006-01-02 15:04:05")
+			}
+			fmt.Fprintf(w, "%04d\t%s\t%s\n", status.Version, status.Name, appliedAt)
+		}
+		w.Flush()
+		return err
+	default:
+		return fmt.Errorf("migrate: unknown command %q, expected up, down, status or baseline", command)
+	}
+	return
+}
@@ -0,0 +1,11 @@
+// Package migrations embeds the versioned schema of every sql dialect, one directory per dialect.
+// A migration is a pair of files named <version>_<name>.up.sql and <version>_<name>.down.sql,
+// an applied migration must never be edited, its changes go into a new version.
+package migrations
+
+import "embed"
+
+// FS holds the migrations, read by database.NewMigrator.
+//
+//go:embed mysql/*.sql postgres/*.sql sqlite/*.sql
+var FS embed.FS
@@ -0,0 +1,19 @@
+CREATE TABLE `user_encrypt` (
+  `uuid` char(36),
+  `__encrypted__data_nama_crypt` varbinary(255) NOT NULL,
+  `__encrypted__data_nama_hash` varbinary(32) NOT NULL,
+  `__encrypted__data_email_crypt` varbinary(255) NOT NULL,
+  `__encrypted__data_email_hash` varbinary(32) NOT NULL,
+  `__encrypted__data_phone_number_crypt` varbinary(255) NULL,
+  `__encrypted__data_phone_number_hash` varbinary(32) NULL,
+  `__encrypted__data_nik_crypt` varbinary(255) NULL,
+  `__encrypted__data_nik_hash` varbinary(32) NULL,
+  `__encrypted__data_date_of_birth_crypt` varbinary(255) NULL,
+  `__encrypted__data_address_crypt` varbinary(512) NULL,
+  `created_at` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (`uuid`),
+  UNIQUE KEY `uq_user_encrypt_email_hash` (`__encrypted__data_email_hash`),
+  UNIQUE KEY `uq_user_encrypt_nik_hash` (`__encrypted__data_nik_hash`),
+  KEY `idx_user_encrypt_nama_hash` (`__encrypted__data_nama_hash`),
+  KEY `idx_user_encrypt_phone_number_hash` (`__encrypted__data_phone_number_hash`)
+);
@@ -0,0 +1,15 @@
+CREATE TABLE `user_document` (
+  `uuid` char(36),
+  `user_uuid` char(36) NOT NULL,
+  `type` varchar(16) NOT NULL,
+  `content_type` varchar(64) NOT NULL,
+  `size` bigint NOT NULL,
+  `sha256` binary(32) NOT NULL,
+  `key_id` varchar(32) NOT NULL,
+  `wrapped_key` varbinary(255) NOT NULL,
+  `object_path` varchar(255) NOT NULL,
+  `created_at` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (`uuid`),
+  KEY `idx_user_document_user_uuid` (`user_uuid`),
+  CONSTRAINT `fk_user_document_user_uuid` FOREIGN KEY (`user_uuid`) REFERENCES `user_encrypt` (`uuid`)
+);
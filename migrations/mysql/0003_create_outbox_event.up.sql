@@ -0,0 +1,13 @@
+CREATE TABLE `outbox_event` (
+  `id` bigint NOT NULL AUTO_INCREMENT,
+  `event_id` char(36) NOT NULL,
+  `aggregate_type` varchar(32) NOT NULL,
+  `aggregate_id` char(36) NOT NULL,
+  `type` varchar(64) NOT NULL,
+  `payload` blob NOT NULL,
+  `created_at` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  `published_at` timestamp NULL,
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `uq_outbox_event_event_id` (`event_id`),
+  KEY `idx_outbox_event_published_at` (`published_at`, `id`)
+);
@@ -0,0 +1,3 @@
+ALTER TABLE `user_encrypt`
+  DROP KEY `idx_user_encrypt_key_id`,
+  DROP COLUMN `key_id`;
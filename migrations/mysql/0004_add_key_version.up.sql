@@ -0,0 +1,3 @@
+ALTER TABLE `user_encrypt`
+  ADD COLUMN `key_id` varchar(32) NULL,
+  ADD KEY `idx_user_encrypt_key_id` (`key_id`);
@@ -0,0 +1,15 @@
+CREATE TABLE `pii_access_audit` (
+  `id` bigint NOT NULL AUTO_INCREMENT,
+  `principal` varchar(64) NOT NULL,
+  `remote_address` varchar(64) NOT NULL,
+  `x_forwarded_for` varchar(255) NOT NULL,
+  `x_real_ip` varchar(64) NOT NULL,
+  `user_agent` varchar(255) NOT NULL,
+  `user_uuids` text NOT NULL,
+  `fields` varchar(255) NOT NULL,
+  `purpose` varchar(64) NOT NULL,
+  `created_at` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (`id`),
+  KEY `idx_pii_access_audit_principal` (`principal`, `created_at`),
+  KEY `idx_pii_access_audit_created_at` (`created_at`)
+);
@@ -0,0 +1,5 @@
+DROP TABLE `pii_access_audit_checkpoint`;
+
+ALTER TABLE `pii_access_audit`
+  DROP COLUMN `hmac`,
+  DROP COLUMN `previous_hmac`;
@@ -0,0 +1,12 @@
+ALTER TABLE `pii_access_audit`
+  ADD COLUMN `previous_hmac` char(64) NULL,
+  ADD COLUMN `hmac` char(64) NULL;
+
+CREATE TABLE `pii_access_audit_checkpoint` (
+  `id` bigint NOT NULL AUTO_INCREMENT,
+  `access_id` bigint NOT NULL,
+  `hmac` char(64) NOT NULL,
+  `signature` varchar(128) NOT NULL,
+  `created_at` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (`id`)
+);
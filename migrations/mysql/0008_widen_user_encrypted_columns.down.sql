@@ -0,0 +1,4 @@
+ALTER TABLE `user_encrypt`
+  MODIFY `__encrypted__data_nama_crypt` varbinary(255) NOT NULL,
+  MODIFY `__encrypted__data_email_crypt` varbinary(255) NOT NULL,
+  MODIFY `__encrypted__data_address_crypt` varbinary(512) NULL;
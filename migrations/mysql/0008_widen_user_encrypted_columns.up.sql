@@ -0,0 +1,6 @@
+-- a ciphertext is the plaintext, up to 4 bytes a character in utf-8, with a 12 bytes nonce and a 16 bytes tag:
+-- 100 characters of name, 254 of email and 255 of address. The other dialects have no length limit.
+ALTER TABLE `user_encrypt`
+  MODIFY `__encrypted__data_nama_crypt` varbinary(428) NOT NULL,
+  MODIFY `__encrypted__data_email_crypt` varbinary(1044) NOT NULL,
+  MODIFY `__encrypted__data_address_crypt` varbinary(1048) NULL;
@@ -0,0 +1 @@
+ALTER TABLE `pii_access_audit` ADD COLUMN `chain_version` int NOT NULL DEFAULT 0;
@@ -0,0 +1,19 @@
+CREATE TABLE user_encrypt (
+  uuid char(36),
+  __encrypted__data_nama_crypt bytea NOT NULL,
+  __encrypted__data_nama_hash bytea NOT NULL,
+  __encrypted__data_email_crypt bytea NOT NULL,
+  __encrypted__data_email_hash bytea NOT NULL,
+  __encrypted__data_phone_number_crypt bytea NULL,
+  __encrypted__data_phone_number_hash bytea NULL,
+  __encrypted__data_nik_crypt bytea NULL,
+  __encrypted__data_nik_hash bytea NULL,
+  __encrypted__data_date_of_birth_crypt bytea NULL,
+  __encrypted__data_address_crypt bytea NULL,
+  created_at timestamptz NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (uuid),
+  CONSTRAINT uq_user_encrypt_email_hash UNIQUE (__encrypted__data_email_hash),
+  CONSTRAINT uq_user_encrypt_nik_hash UNIQUE (__encrypted__data_nik_hash)
+);
+CREATE INDEX idx_user_encrypt_nama_hash ON user_encrypt (__encrypted__data_nama_hash);
+CREATE INDEX idx_user_encrypt_phone_number_hash ON user_encrypt (__encrypted__data_phone_number_hash);
@@ -0,0 +1,15 @@
+CREATE TABLE user_document (
+  uuid char(36),
+  user_uuid char(36) NOT NULL,
+  type varchar(16) NOT NULL,
+  content_type varchar(64) NOT NULL,
+  size bigint NOT NULL,
+  sha256 bytea NOT NULL,
+  key_id varchar(32) NOT NULL,
+  wrapped_key bytea NOT NULL,
+  object_path varchar(255) NOT NULL,
+  created_at timestamptz NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (uuid),
+  CONSTRAINT fk_user_document_user_uuid FOREIGN KEY (user_uuid) REFERENCES user_encrypt (uuid)
+);
+CREATE INDEX idx_user_document_user_uuid ON user_document (user_uuid);
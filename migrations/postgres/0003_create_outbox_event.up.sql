@@ -0,0 +1,13 @@
+CREATE TABLE outbox_event (
+  id bigserial,
+  event_id char(36) NOT NULL,
+  aggregate_type varchar(32) NOT NULL,
+  aggregate_id char(36) NOT NULL,
+  type varchar(64) NOT NULL,
+  payload bytea NOT NULL,
+  created_at timestamptz NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  published_at timestamptz NULL,
+  PRIMARY KEY (id),
+  CONSTRAINT uq_outbox_event_event_id UNIQUE (event_id)
+);
+CREATE INDEX idx_outbox_event_published_at ON outbox_event (published_at, id);
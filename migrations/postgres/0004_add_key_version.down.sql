@@ -0,0 +1,2 @@
+DROP INDEX idx_user_encrypt_key_id;
+ALTER TABLE user_encrypt DROP COLUMN key_id;
@@ -0,0 +1,2 @@
+ALTER TABLE user_encrypt ADD COLUMN key_id varchar(32) NULL;
+CREATE INDEX idx_user_encrypt_key_id ON user_encrypt (key_id);
@@ -0,0 +1 @@
+DROP TABLE pii_access_audit;
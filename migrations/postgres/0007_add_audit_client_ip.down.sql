@@ -0,0 +1 @@
+ALTER TABLE pii_access_audit DROP COLUMN client_ip;
@@ -0,0 +1,2 @@
+-- the relays claim the oldest unpublished event of each aggregate.
+CREATE INDEX idx_outbox_event_aggregate_id ON outbox_event (aggregate_id, published_at, id);
@@ -0,0 +1,19 @@
+CREATE TABLE user_encrypt (
+  uuid char(36),
+  __encrypted__data_nama_crypt blob NOT NULL,
+  __encrypted__data_nama_hash blob NOT NULL,
+  __encrypted__data_email_crypt blob NOT NULL,
+  __encrypted__data_email_hash blob NOT NULL,
+  __encrypted__data_phone_number_crypt blob NULL,
+  __encrypted__data_phone_number_hash blob NULL,
+  __encrypted__data_nik_crypt blob NULL,
+  __encrypted__data_nik_hash blob NULL,
+  __encrypted__data_date_of_birth_crypt blob NULL,
+  __encrypted__data_address_crypt blob NULL,
+  created_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (uuid)
+);
+CREATE UNIQUE INDEX uq_user_encrypt_email_hash ON user_encrypt (__encrypted__data_email_hash);
+CREATE UNIQUE INDEX uq_user_encrypt_nik_hash ON user_encrypt (__encrypted__data_nik_hash);
+CREATE INDEX idx_user_encrypt_nama_hash ON user_encrypt (__encrypted__data_nama_hash);
+CREATE INDEX idx_user_encrypt_phone_number_hash ON user_encrypt (__encrypted__data_phone_number_hash);
@@ -0,0 +1,12 @@
+CREATE TABLE outbox_event (
+  id integer PRIMARY KEY AUTOINCREMENT,
+  event_id char(36) NOT NULL,
+  aggregate_type varchar(32) NOT NULL,
+  aggregate_id char(36) NOT NULL,
+  type varchar(64) NOT NULL,
+  payload blob NOT NULL,
+  created_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  published_at datetime NULL
+);
+CREATE UNIQUE INDEX uq_outbox_event_event_id ON outbox_event (event_id);
+CREATE INDEX idx_outbox_event_published_at ON outbox_event (published_at, id);
@@ -0,0 +1,14 @@
+CREATE TABLE pii_access_audit (
+  id integer PRIMARY KEY AUTOINCREMENT,
+  principal varchar(64) NOT NULL,
+  remote_address varchar(64) NOT NULL,
+  x_forwarded_for varchar(255) NOT NULL,
+  x_real_ip varchar(64) NOT NULL,
+  user_agent varchar(255) NOT NULL,
+  user_uuids text NOT NULL,
+  fields varchar(255) NOT NULL,
+  purpose varchar(64) NOT NULL,
+  created_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX idx_pii_access_audit_principal ON pii_access_audit (principal, created_at);
+CREATE INDEX idx_pii_access_audit_created_at ON pii_access_audit (created_at);
@@ -0,0 +1,4 @@
+DROP TABLE pii_access_audit_checkpoint;
+
+ALTER TABLE pii_access_audit DROP COLUMN hmac;
+ALTER TABLE pii_access_audit DROP COLUMN previous_hmac;
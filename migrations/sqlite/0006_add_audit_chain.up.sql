@@ -0,0 +1,10 @@
+ALTER TABLE pii_access_audit ADD COLUMN previous_hmac char(64) NULL;
+ALTER TABLE pii_access_audit ADD COLUMN hmac char(64) NULL;
+
+CREATE TABLE pii_access_audit_checkpoint (
+  id integer PRIMARY KEY AUTOINCREMENT,
+  access_id bigint NOT NULL,
+  hmac char(64) NOT NULL,
+  signature varchar(128) NOT NULL,
+  created_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1 @@
+ALTER TABLE pii_access_audit ADD COLUMN client_ip varchar(64) NOT NULL DEFAULT '';
@@ -0,0 +1 @@
+ALTER TABLE outbox_event DROP COLUMN claimed_until;
@@ -0,0 +1,2 @@
+-- the relays claim the events for a while, so they are sent outside of the transaction locking them.
+ALTER TABLE outbox_event ADD COLUMN claimed_until datetime NULL;
@@ -0,0 +1 @@
+DROP INDEX idx_outbox_event_aggregate_id;
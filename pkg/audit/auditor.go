@@ -0,0 +1,67 @@
+package audit
+
+import (
+	"context"
+	"pii-encrypt-example/entity"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// maxUUIDsPerRecord is the number of users of a single record, a larger access is split in several records.
+const maxUUIDsPerRecord = 500
+
+// Auditor records the accesses to plaintext PII, the principal and the client device are taken from the context.
+type Auditor struct {
+	logger     *logrus.Logger
+	location   *time.Location
+	repository AuditRepository
+}
+
+// NewAuditor is a constructor.
+func NewAuditor(logger *logrus.Logger, location *time.Location, repository AuditRepository) *Auditor {
+	return &Auditor{
+		logger:     logger,
+		location:   location,
+		repository: repository,
+	}
+}
+
+// Record records that the fields of the users have been revealed for the purpose.
+// The caller must not return the plaintext when it fails, an access which cannot be recorded must not happen.
+func (a *Auditor) Record(ctx context.Context, purpose string, fields []string, userUUIDs []string) (err error) {
+	if len(userUUIDs) == 0 {
+		return
+	}
+
+	access := entity.PIIAccess{
+		Principal: Principal(ctx),
+		Fields:    fields,
+		Purpose:   purpose,
+		CreatedAt: time.Now().In(a.location),
+	}
+	if clientDevice, ok := ctx.Value(entity.ClientContextKey{}).(entity.ClientDevice); ok {
+		access.RemoteAddress = clientDevice.RemoteAddress
+		access.XForwardedFor = clientDevice.XForwardedFor
+		access.XRealIP = clientDevice.XRealIP
+		access.UserAgent = clientDevice.UserAgent
+		access.ClientIP = clientDevice.ClientIP
+	}
+
+	for start := 0; start < len(userUUIDs); start += maxUUIDsPerRecord {
+		end := min(start+maxUUIDsPerRecord, len(userUUIDs))
+		access.UserUUIDs = userUUIDs[start:end]
+		if err = a.repository.SaveAccess(ctx, access); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// Principal returns the authenticated caller of the context, or "anonymous" when there is none.
+func Principal(ctx context.Context) string {
+	if principal, ok := ctx.Value(entity.PrincipalContextKey{}).(string); ok && principal != "" {
+		return principal
+	}
+	return "anonymous"
+}
@@ -0,0 +1,81 @@
+package audit_test
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"pii-encrypt-example/entity"
+	"pii-encrypt-example/migrations"
+	"pii-encrypt-example/pkg/audit"
+	"pii-encrypt-example/pkg/database"
+)
+
+var testChain = audit.NewChain([]byte("12345678901234567890123456789012"))
+
+func newTestRepository(t *testing.T) (*logrus.Logger, *sql.DB, audit.AuditRepository) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "audit.db")+"?_pragma=foreign_keys(1)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	migrator, err := database.NewMigrator(logger, database.SQLite{}, db, migrations.FS, "schema_migrations")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := migrator.Up(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return logger, db, audit.NewAuditRepository(logger, database.SQLite{}, db, db, "pii_access_audit", testChain)
+}
+
+func TestAuditorRecord(t *testing.T) {
+	logger, _, repository := newTestRepository(t)
+	auditor := audit.NewAuditor(logger, time.UTC, repository)
+
+	ctx := context.WithValue(context.Background(), entity.PrincipalContextKey{}, "support")
+	ctx = context.WithValue(ctx, entity.ClientContextKey{}, entity.ClientDevice{RemoteAddress: "10.0.0.1:5000", UserAgent: "curl/8.0", ClientIP: "203.0.113.7"})
+
+	uuids := make([]string, 1200)
+	for i := range uuids {
+		uuids[i] = fmt.Sprintf("00000000-0000-0000-0000-%012d", i)
+	}
+	if err := auditor.Record(ctx, "export users", []string{"name", "email"}, uuids); err != nil {
+		t.Fatal(err)
+	}
+	if err := auditor.Record(context.Background(), "get user", []string{"name"}, uuids[:1]); err != nil {
+		t.Fatal(err)
+	}
+	if err := auditor.Record(ctx, "list users", []string{"name"}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	accesses, err := repository.FindManyAccess(ctx, audit.AccessFilter{Principal: "support", Limit: 10})
+	if err != nil || len(accesses) != 3 {
+		t.Fatalf("FindManyAccess() of the principal = %d records, %v, want 3 records", len(accesses), err)
+	}
+	latest := accesses[0]
+	if len(latest.UserUUIDs) != 200 || latest.UserUUIDs[199] != uuids[1199] || latest.RemoteAddress != "10.0.0.1:5000" || latest.UserAgent != "curl/8.0" || latest.ClientIP != "203.0.113.7" || latest.Purpose != "export users" || len(latest.Fields) != 2 {
+		t.Fatalf("FindManyAccess() latest record = %+v", latest)
+	}
+
+	accesses, err = repository.FindManyAccess(ctx, audit.AccessFilter{UserUUID: uuids[0], Limit: 10})
+	if err != nil || len(accesses) != 2 || accesses[0].Principal != "anonymous" {
+		t.Fatalf("FindManyAccess() of the user = %+v, %v, want the anonymous and the support records", accesses, err)
+	}
+
+	accesses, err = repository.FindManyAccess(ctx, audit.AccessFilter{From: time.Now().Add(time.Hour), Limit: 10})
+	if err != nil || len(accesses) != 0 {
+		t.Fatalf("FindManyAccess() of the future = %+v, %v, want nothing", accesses, err)
+	}
+}
@@ -0,0 +1,92 @@
+package audit
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"hash"
+	"pii-encrypt-example/entity"
+	"strings"
+	"time"
+)
+
+// ChainVersion is the format of the links written by Link, recorded on every record and linked with it.
+// Version 1 links every field, the records of version 0 link the client ip only when it is set.
+const ChainVersion = 1
+
+// Chain computes the links of the audit log: the HMAC of a record covers its content and the HMAC of the previous record.
+// Without the key, a record cannot be edited, inserted or removed without breaking every following link.
+type Chain struct {
+	key []byte
+}
+
+// NewChain is a constructor, the key must be kept apart from the database holding the audit log.
+func NewChain(key []byte) *Chain {
+	return &Chain{key: key}
+}
+
+// Link returns the HMAC of the access in the format of access.ChainVersion, linked to access.PreviousHMAC.
+// The time is taken to the second, the precision every database keeps.
+func (c *Chain) Link(access entity.PIIAccess) string {
+	mac := hmac.New(sha256.New, c.key)
+	if access.ChainVersion > 0 {
+		binary.Write(mac, binary.BigEndian, uint32(access.ChainVersion))
+	}
+	writeField(mac, access.PreviousHMAC)
+	writeField(mac, access.Principal)
+	writeField(mac, access.RemoteAddress)
+	writeField(mac, access.XForwardedFor)
+	writeField(mac, access.XRealIP)
+	writeField(mac, access.UserAgent)
+	writeField(mac, strings.Join(access.UserUUIDs, ","))
+	writeField(mac, strings.Join(access.Fields, ","))
+	writeField(mac, access.Purpose)
+	binary.Write(mac, binary.BigEndian, access.CreatedAt.Unix())
+	// the client ip came after the first records, which are linked without it
+	if access.ChainVersion > 0 || access.ClientIP != "" {
+		writeField(mac, access.ClientIP)
+	}
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify reports whether the HMAC of the access matches its content and its previous HMAC.
+func (c *Chain) Verify(access entity.PIIAccess) bool {
+	return hmac.Equal([]byte(c.Link(access)), []byte(access.HMAC))
+}
+
+// SignCheckpoint returns the signature of the checkpoint, in base64.
+func SignCheckpoint(privateKey ed25519.PrivateKey, checkpoint entity.AuditCheckpoint) string {
+	return base64.StdEncoding.EncodeToString(ed25519.Sign(privateKey, checkpointMessage(checkpoint)))
+}
+
+// VerifyCheckpoint reports whether the checkpoint has been signed by the private key of publicKey.
+func VerifyCheckpoint(publicKey ed25519.PublicKey, checkpoint entity.AuditCheckpoint) bool {
+	signature, err := base64.StdEncoding.DecodeString(checkpoint.Signature)
+	if err != nil {
+		return false
+	}
+	return ed25519.Verify(publicKey, checkpointMessage(checkpoint), signature)
+}
+
+func checkpointMessage(checkpoint entity.AuditCheckpoint) []byte {
+	h := sha256.New()
+	writeField(h, "pii-access-audit-checkpoint")
+	binary.Write(h, binary.BigEndian, checkpoint.AccessID)
+	writeField(h, checkpoint.HMAC)
+	binary.Write(h, binary.BigEndian, checkpoint.CreatedAt.Unix())
+	return h.Sum(nil)
+}
+
+// writeField writes the length of s before s, so the boundaries of the fields are part of the hash.
+func writeField(h hash.Hash, s string) {
+	binary.Write(h, binary.BigEndian, uint32(len(s)))
+	h.Write([]byte(s))
+}
+
+// truncate returns t to the second, as linked by the chain.
+func truncate(t time.Time) time.Time {
+	return t.Truncate(time.Second)
+}
@@ -0,0 +1,84 @@
+package audit
+
+import (
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"pii-encrypt-example/entity"
+	"pii-encrypt-example/pkg/exception"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Checkpointer signs the head of the audit log chain at regular intervals. Once signed, the records up to the head
+// cannot be rewritten, even with the key of the chain, nor truncated without the verification noticing.
+type Checkpointer struct {
+	logger     *logrus.Logger
+	location   *time.Location
+	repository AuditRepository
+	privateKey ed25519.PrivateKey
+	interval   time.Duration
+}
+
+// NewCheckpointer is a constructor, the private key must be kept apart from the key of the chain.
+func NewCheckpointer(logger *logrus.Logger, location *time.Location, repository AuditRepository, privateKey ed25519.PrivateKey, interval time.Duration) *Checkpointer {
+	return &Checkpointer{
+		logger:     logger,
+		location:   location,
+		repository: repository,
+		privateKey: privateKey,
+		interval:   interval,
+	}
+}
+
+// Run signs a checkpoint every interval until ctx is done.
+func (c *Checkpointer) Run(ctx context.Context) {
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if _, err := c.Checkpoint(ctx); err != nil && ctx.Err() == nil {
+			c.logger.WithContext(ctx).Error(err)
+		}
+	}
+}
+
+// Checkpoint signs the head of the chain, created is false when the log is empty or the head is already signed.
+// Several instances may sign the same head, which is harmless.
+func (c *Checkpointer) Checkpoint(ctx context.Context) (created bool, err error) {
+	head, err := c.repository.FindLastAccess(ctx)
+	if errors.Is(err, exception.ErrNotFound) || (err == nil && head.HMAC == "") {
+		return false, nil
+	}
+	if err != nil {
+		return
+	}
+
+	checkpoints, err := c.repository.FindManyCheckpoint(ctx)
+	if err != nil {
+		return
+	}
+	if n := len(checkpoints); n > 0 && checkpoints[n-1].AccessID == head.ID && checkpoints[n-1].HMAC == head.HMAC {
+		return false, nil
+	}
+
+	checkpoint := entity.AuditCheckpoint{
+		AccessID:  head.ID,
+		HMAC:      head.HMAC,
+		CreatedAt: truncate(time.Now().In(c.location)),
+	}
+	checkpoint.Signature = SignCheckpoint(c.privateKey, checkpoint)
+	if err = c.repository.SaveCheckpoint(ctx, checkpoint); err != nil {
+		return
+	}
+
+	c.logger.WithContext(ctx).WithField("access_id", head.ID).Info("pii access audit checkpoint signed")
+	return true, nil
+}
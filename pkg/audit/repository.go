@@ -0,0 +1,277 @@
+package audit
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"pii-encrypt-example/entity"
+	"pii-encrypt-example/pkg/database"
+	"pii-encrypt-example/pkg/exception"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	accessSelectColumns     = `a.id, a.principal, a.remote_address, a.x_forwarded_for, a.x_real_ip, a.user_agent, a.client_ip, a.user_uuids, a.fields, a.purpose, a.created_at, a.previous_hmac, a.hmac, a.chain_version`
+	checkpointSelectColumns = `c.id, c.access_id, c.hmac, c.signature, c.created_at`
+)
+
+// AccessFilter selects the records of the audit log, the empty criteria are ignored.
+type AccessFilter struct {
+	Principal string
+	UserUUID  string
+	From      time.Time
+	To        time.Time
+	Limit     int
+}
+
+// AuditRepository stores the audit log, it has no way to update or delete a record.
+type AuditRepository interface {
+	// SaveAccess appends the access to the chain, its HMAC is linked to the HMAC of the last record.
+	SaveAccess(ctx context.Context, access entity.PIIAccess) (err error)
+	// FindManyAccess returns the records matching the filter, the latest first.
+	FindManyAccess(ctx context.Context, filter AccessFilter) (accesses []entity.PIIAccess, err error)
+	// EachAccess calls fn with every record, in the order of the chain, until fn returns an error.
+	EachAccess(ctx context.Context, fn func(access entity.PIIAccess) error) (err error)
+	// FindLastAccess returns the head of the chain, exception.ErrNotFound when the log is empty.
+	FindLastAccess(ctx context.Context) (access entity.PIIAccess, err error)
+	SaveCheckpoint(ctx context.Context, checkpoint entity.AuditCheckpoint) (err error)
+	// FindManyCheckpoint returns every checkpoint, the oldest first.
+	FindManyCheckpoint(ctx context.Context) (checkpoints []entity.AuditCheckpoint, err error)
+}
+
+type auditRepository struct {
+	logger              *logrus.Logger
+	dialect             database.Dialect
+	dbReadOnly          *sql.DB
+	dbReadWrite         *sql.DB
+	tableName           string
+	checkpointTableName string
+	chain               *Chain
+	mu                  sync.Mutex
+}
+
+// NewAuditRepository is a constructor, the queries are written for the dialect of the databases.
+// The user uuids and the fields are stored comma separated, the checkpoints in the table tableName_checkpoint.
+func NewAuditRepository(logger *logrus.Logger, dialect database.Dialect, dbReadOnly *sql.DB, dbReadWrite *sql.DB, tableName string, chain *Chain) AuditRepository {
+	return &auditRepository{
+		logger:              logger,
+		dialect:             dialect,
+		dbReadOnly:          dbReadOnly,
+		dbReadWrite:         dbReadWrite,
+		tableName:           tableName,
+		checkpointTableName: tableName + "_checkpoint",
+		chain:               chain,
+	}
+}
+
+// SaveAccess takes the lock of the dialect while it reads the head of the chain and appends the access,
+// the instances sharing the database append one at a time. Sqlite has no lock of its own, the processes sharing
+// its file append one at a time only when the transactions begin immediate, the "_txlock=immediate" dsn parameter.
+func (r *auditRepository) SaveAccess(ctx context.Context, access entity.PIIAccess) (err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	conn, err := r.dbReadWrite.Conn(ctx)
+	if err != nil {
+		r.logger.WithContext(ctx).Error(err)
+		return exception.ErrInternalServer
+	}
+	defer conn.Close()
+
+	unlock, err := r.dialect.Lock(ctx, conn, r.tableName)
+	if err != nil {
+		r.logger.WithContext(ctx).Error(err)
+		return exception.ErrInternalServer
+	}
+	defer func() {
+		if err := unlock(); err != nil {
+			r.logger.WithContext(ctx).Error(err)
+		}
+	}()
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		r.logger.WithContext(ctx).Error(err)
+		return exception.ErrInternalServer
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	q := fmt.Sprintf(`SELECT a.hmac FROM %s a ORDER BY a.id DESC LIMIT 1`, r.tableName)
+	var previousHMAC sql.NullString
+	if err = tx.QueryRowContext(ctx, q).Scan(&previousHMAC); err != nil && err != sql.ErrNoRows {
+		r.logger.WithContext(ctx).Error(q, err)
+		err = exception.ErrInternalServer
+		return
+	}
+
+	access.CreatedAt = truncate(access.CreatedAt)
+	access.PreviousHMAC = previousHMAC.String
+	access.ChainVersion = ChainVersion
+	access.HMAC = r.chain.Link(access)
+
+	command := fmt.Sprintf(`INSERT INTO %s (principal, remote_address, x_forwarded_for, x_real_ip, user_agent, client_ip, user_uuids, fields, purpose, created_at, previous_hmac, hmac, chain_version) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`, r.tableName)
+	_, err = tx.ExecContext(ctx, r.dialect.Rebind(command), access.Principal, access.RemoteAddress, access.XForwardedFor, access.XRealIP, access.UserAgent, access.ClientIP,
+		strings.Join(access.UserUUIDs, ","), strings.Join(access.Fields, ","), access.Purpose, access.CreatedAt, sql.NullString{String: access.PreviousHMAC, Valid: access.PreviousHMAC != ""}, access.HMAC, access.ChainVersion)
+	if err != nil {
+		r.logger.WithContext(ctx).Error(command, err)
+		err = exception.ErrInternalServer
+		return
+	}
+
+	if err = tx.Commit(); err != nil {
+		r.logger.WithContext(ctx).Error(command, err)
+		err = exception.ErrInternalServer
+	}
+	return
+}
+
+func (r *auditRepository) FindManyAccess(ctx context.Context, filter AccessFilter) (accesses []entity.PIIAccess, err error) {
+	var conditions []string
+	var params []interface{}
+
+	if filter.Principal != "" {
+		conditions = append(conditions, `a.principal = ?`)
+		params = append(params, filter.Principal)
+	}
+	if filter.UserUUID != "" {
+		conditions = append(conditions, `a.user_uuids LIKE ?`)
+		params = append(params, "%"+filter.UserUUID+"%")
+	}
+	if !filter.From.IsZero() {
+		conditions = append(conditions, `a.created_at >= ?`)
+		params = append(params, filter.From)
+	}
+	if !filter.To.IsZero() {
+		conditions = append(conditions, `a.created_at < ?`)
+		params = append(params, filter.To)
+	}
+
+	q := fmt.Sprintf(`SELECT %s FROM %s a`, accessSelectColumns, r.tableName)
+	if len(conditions) > 0 {
+		q += ` WHERE ` + strings.Join(conditions, " AND ")
+	}
+	q += ` ORDER BY a.id DESC LIMIT ?`
+	params = append(params, filter.Limit)
+
+	err = r.query(ctx, q, params, func(rows *sql.Rows) error {
+		access, err := scanAccess(rows)
+		if err != nil {
+			return err
+		}
+		accesses = append(accesses, access)
+		return nil
+	})
+	return
+}
+
+func (r *auditRepository) EachAccess(ctx context.Context, fn func(access entity.PIIAccess) error) (err error) {
+	q := fmt.Sprintf(`SELECT %s FROM %s a ORDER BY a.id`, accessSelectColumns, r.tableName)
+	return r.query(ctx, q, nil, func(rows *sql.Rows) error {
+		access, err := scanAccess(rows)
+		if err != nil {
+			return err
+		}
+		return fn(access)
+	})
+}
+
+func (r *auditRepository) FindLastAccess(ctx context.Context) (access entity.PIIAccess, err error) {
+	q := fmt.Sprintf(`SELECT %s FROM %s a ORDER BY a.id DESC LIMIT 1`, accessSelectColumns, r.tableName)
+	found := false
+	err = r.query(ctx, q, nil, func(rows *sql.Rows) (err error) {
+		access, err = scanAccess(rows)
+		found = err == nil
+		return
+	})
+	if err == nil && !found {
+		err = exception.ErrNotFound
+	}
+	return
+}
+
+func (r *auditRepository) SaveCheckpoint(ctx context.Context, checkpoint entity.AuditCheckpoint) (err error) {
+	command := fmt.Sprintf(`INSERT INTO %s (access_id, hmac, signature, created_at) VALUES (?, ?, ?, ?)`, r.checkpointTableName)
+	_, err = r.dbReadWrite.ExecContext(ctx, r.dialect.Rebind(command), checkpoint.AccessID, checkpoint.HMAC, checkpoint.Signature, checkpoint.CreatedAt)
+	if err != nil {
+		r.logger.WithContext(ctx).Error(command, err)
+		err = exception.ErrInternalServer
+		return
+	}
+
+	return
+}
+
+func (r *auditRepository) FindManyCheckpoint(ctx context.Context) (checkpoints []entity.AuditCheckpoint, err error) {
+	q := fmt.Sprintf(`SELECT %s FROM %s c ORDER BY c.id`, checkpointSelectColumns, r.checkpointTableName)
+	err = r.query(ctx, q, nil, func(rows *sql.Rows) error {
+		var checkpoint entity.AuditCheckpoint
+		if err := rows.Scan(&checkpoint.ID, &checkpoint.AccessID, &checkpoint.HMAC, &checkpoint.Signature, &checkpoint.CreatedAt); err != nil {
+			return scanError{err}
+		}
+		checkpoints = append(checkpoints, checkpoint)
+		return nil
+	})
+	return
+}
+
+// query runs q on the read only database and calls fn with each row, the errors of the database are logged
+// and returned as exception.ErrInternalServer while the errors of fn are returned as is.
+func (r *auditRepository) query(ctx context.Context, q string, params []interface{}, fn func(rows *sql.Rows) error) (err error) {
+	rows, err := r.dbReadOnly.QueryContext(ctx, r.dialect.Rebind(q), params...)
+	if err != nil {
+		r.logger.WithContext(ctx).Error(q, err)
+		return exception.ErrInternalServer
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			r.logger.WithContext(ctx).Error(q, err)
+		}
+	}()
+
+	for rows.Next() {
+		if err = fn(rows); err != nil {
+			if _, scan := err.(scanError); scan {
+				r.logger.WithContext(ctx).Error(q, err)
+				err = exception.ErrInternalServer
+			}
+			return
+		}
+	}
+
+	if err = rows.Err(); err != nil {
+		r.logger.WithContext(ctx).Error(q, err)
+		err = exception.ErrInternalServer
+	}
+	return
+}
+
+// scanError is an error of rows.Scan, as opposed to an error of the callback of EachAccess.
+type scanError struct{ error }
+
+func scanAccess(rows *sql.Rows) (access entity.PIIAccess, err error) {
+	var userUUIDs, fields string
+	var previousHMAC, hmac sql.NullString
+	if err = rows.Scan(&access.ID, &access.Principal, &access.RemoteAddress, &access.XForwardedFor, &access.XRealIP, &access.UserAgent, &access.ClientIP, &userUUIDs, &fields, &access.Purpose, &access.CreatedAt, &previousHMAC, &hmac, &access.ChainVersion); err != nil {
+		return access, scanError{err}
+	}
+	access.UserUUIDs = splitList(userUUIDs)
+	access.Fields = splitList(fields)
+	access.PreviousHMAC = previousHMAC.String
+	access.HMAC = hmac.String
+	return
+}
+
+func splitList(s string) []string {
+	if s == "" {
+		return []string{}
+	}
+	return strings.Split(s, ",")
+}
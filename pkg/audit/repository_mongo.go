@@ -0,0 +1,277 @@
+package audit
+
+import (
+	"context"
+	"pii-encrypt-example/entity"
+	"pii-encrypt-example/pkg/exception"
+	"regexp"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// maxAppendAttempts is the number of times an access is appended while other instances keep taking its place in the chain.
+const maxAppendAttempts = 10
+
+// accessDocument is the audit record as stored in mongodb, the user uuids are kept as an array to be indexed.
+// Seq is the position of the record in the chain, unique, it is the id of the record.
+type accessDocument struct {
+	ID            primitive.ObjectID `bson:"_id,omitempty"`
+	Seq           int64              `bson:"seq,omitempty"`
+	Principal     string             `bson:"principal"`
+	RemoteAddress string             `bson:"remote_address"`
+	XForwardedFor string             `bson:"x_forwarded_for"`
+	XRealIP       string             `bson:"x_real_ip"`
+	UserAgent     string             `bson:"user_agent"`
+	ClientIP      string             `bson:"client_ip"`
+	UserUUIDs     []string           `bson:"user_uuids"`
+	Fields        []string           `bson:"fields"`
+	Purpose       string             `bson:"purpose"`
+	CreatedAt     time.Time          `bson:"created_at"`
+	PreviousHMAC  string             `bson:"previous_hmac,omitempty"`
+	HMAC          string             `bson:"hmac,omitempty"`
+	ChainVersion  int                `bson:"chain_version"`
+}
+
+// checkpointDocument is the audit checkpoint as stored in mongodb.
+type checkpointDocument struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty"`
+	AccessID  int64              `bson:"access_id"`
+	HMAC      string             `bson:"hmac"`
+	Signature string             `bson:"signature"`
+	CreatedAt time.Time          `bson:"created_at"`
+}
+
+type auditMongoRepository struct {
+	logger               *logrus.Logger
+	collection           *mongo.Collection
+	checkpointCollection *mongo.Collection
+	chain                *Chain
+}
+
+// NewAuditMongoRepository is a constructor, the checkpoints are kept in the collection collectionName_checkpoint.
+func NewAuditMongoRepository(logger *logrus.Logger, client *mongo.Client, databaseName string, collectionName string, chain *Chain) AuditRepository {
+	return &auditMongoRepository{
+		logger:               logger,
+		collection:           client.Database(databaseName).Collection(collectionName),
+		checkpointCollection: client.Database(databaseName).Collection(collectionName + "_checkpoint"),
+		chain:                chain,
+	}
+}
+
+// CreateAuditMongoIndexes creates the indexes used to find the records of a principal or of a user,
+// and the unique index ordering the chain.
+func CreateAuditMongoIndexes(ctx context.Context, client *mongo.Client, databaseName string, collectionName string) (err error) {
+	_, err = client.Database(databaseName).Collection(collectionName).Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "principal", Value: 1}, {Key: "created_at", Value: -1}},
+			Options: options.Index().SetName("idx_pii_access_audit_principal"),
+		},
+		{
+			Keys:    bson.D{{Key: "user_uuids", Value: 1}},
+			Options: options.Index().SetName("idx_pii_access_audit_user_uuids"),
+		},
+		{
+			Keys:    bson.D{{Key: "created_at", Value: -1}},
+			Options: options.Index().SetName("idx_pii_access_audit_created_at"),
+		},
+		{
+			// the records written before the chain have no seq
+			Keys:    bson.D{{Key: "seq", Value: 1}},
+			Options: options.Index().SetName("idx_pii_access_audit_seq").SetUnique(true).SetPartialFilterExpression(bson.M{"seq": bson.M{"$gt": 0}}),
+		},
+	})
+	return
+}
+
+// SaveAccess appends the access after the head of the chain, the unique seq makes the concurrent appends retry
+// instead of forking the chain.
+func (r *auditMongoRepository) SaveAccess(ctx context.Context, access entity.PIIAccess) (err error) {
+	access.CreatedAt = truncate(access.CreatedAt)
+
+	for attempt := 0; attempt < maxAppendAttempts; attempt++ {
+		var head accessDocument
+		err = r.collection.FindOne(ctx, bson.M{}, options.FindOne().SetSort(bson.D{{Key: "seq", Value: -1}})).Decode(&head)
+		if err != nil && err != mongo.ErrNoDocuments {
+			r.logger.WithContext(ctx).Error("find pii access ", err)
+			return exception.ErrInternalServer
+		}
+
+		access.PreviousHMAC = head.HMAC
+		access.ChainVersion = ChainVersion
+		access.HMAC = r.chain.Link(access)
+		_, err = r.collection.InsertOne(ctx, accessDocument{
+			Seq:           head.Seq + 1,
+			Principal:     access.Principal,
+			RemoteAddress: access.RemoteAddress,
+			XForwardedFor: access.XForwardedFor,
+			XRealIP:       access.XRealIP,
+			UserAgent:     access.UserAgent,
+			ClientIP:      access.ClientIP,
+			UserUUIDs:     access.UserUUIDs,
+			Fields:        access.Fields,
+			Purpose:       access.Purpose,
+			CreatedAt:     access.CreatedAt,
+			PreviousHMAC:  access.PreviousHMAC,
+			HMAC:          access.HMAC,
+			ChainVersion:  access.ChainVersion,
+		})
+		if mongo.IsDuplicateKeyError(err) {
+			continue
+		}
+		if err != nil {
+			r.logger.WithContext(ctx).Error("insert pii access ", err)
+			return exception.ErrInternalServer
+		}
+		return
+	}
+
+	r.logger.WithContext(ctx).Error("insert pii access ", err)
+	return exception.ErrInternalServer
+}
+
+func (r *auditMongoRepository) FindManyAccess(ctx context.Context, filter AccessFilter) (accesses []entity.PIIAccess, err error) {
+	query := bson.M{}
+	if filter.Principal != "" {
+		query["principal"] = filter.Principal
+	}
+	if filter.UserUUID != "" {
+		query["user_uuids"] = bson.M{"$regex": regexp.QuoteMeta(filter.UserUUID)}
+	}
+	createdAt := bson.M{}
+	if !filter.From.IsZero() {
+		createdAt["$gte"] = filter.From
+	}
+	if !filter.To.IsZero() {
+		createdAt["$lt"] = filter.To
+	}
+	if len(createdAt) > 0 {
+		query["created_at"] = createdAt
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}).SetLimit(int64(filter.Limit))
+	cursor, err := r.collection.Find(ctx, query, opts)
+	if err != nil {
+		r.logger.WithContext(ctx).Error("find pii accesses ", err)
+		err = exception.ErrInternalServer
+		return
+	}
+
+	var documents []accessDocument
+	if err = cursor.All(ctx, &documents); err != nil {
+		r.logger.WithContext(ctx).Error("find pii accesses ", err)
+		err = exception.ErrInternalServer
+		return
+	}
+
+	for _, document := range documents {
+		accesses = append(accesses, document.access())
+	}
+	return
+}
+
+func (r *auditMongoRepository) EachAccess(ctx context.Context, fn func(access entity.PIIAccess) error) (err error) {
+	cursor, err := r.collection.Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{Key: "seq", Value: 1}, {Key: "_id", Value: 1}}))
+	if err != nil {
+		r.logger.WithContext(ctx).Error("find pii accesses ", err)
+		return exception.ErrInternalServer
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var document accessDocument
+		if err = cursor.Decode(&document); err != nil {
+			r.logger.WithContext(ctx).Error("decode pii access ", err)
+			return exception.ErrInternalServer
+		}
+		if err = fn(document.access()); err != nil {
+			return
+		}
+	}
+
+	if err = cursor.Err(); err != nil {
+		r.logger.WithContext(ctx).Error("find pii accesses ", err)
+		err = exception.ErrInternalServer
+	}
+	return
+}
+
+func (r *auditMongoRepository) FindLastAccess(ctx context.Context) (access entity.PIIAccess, err error) {
+	var document accessDocument
+	err = r.collection.FindOne(ctx, bson.M{}, options.FindOne().SetSort(bson.D{{Key: "seq", Value: -1}, {Key: "_id", Value: -1}})).Decode(&document)
+	if err == mongo.ErrNoDocuments {
+		err = exception.ErrNotFound
+		return
+	}
+	if err != nil {
+		r.logger.WithContext(ctx).Error("find pii access ", err)
+		err = exception.ErrInternalServer
+		return
+	}
+	return document.access(), nil
+}
+
+func (r *auditMongoRepository) SaveCheckpoint(ctx context.Context, checkpoint entity.AuditCheckpoint) (err error) {
+	_, err = r.checkpointCollection.InsertOne(ctx, checkpointDocument{
+		AccessID:  checkpoint.AccessID,
+		HMAC:      checkpoint.HMAC,
+		Signature: checkpoint.Signature,
+		CreatedAt: checkpoint.CreatedAt,
+	})
+	if err != nil {
+		r.logger.WithContext(ctx).Error("insert pii access checkpoint ", err)
+		err = exception.ErrInternalServer
+		return
+	}
+
+	return
+}
+
+func (r *auditMongoRepository) FindManyCheckpoint(ctx context.Context) (checkpoints []entity.AuditCheckpoint, err error) {
+	cursor, err := r.checkpointCollection.Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+	if err != nil {
+		r.logger.WithContext(ctx).Error("find pii access checkpoints ", err)
+		err = exception.ErrInternalServer
+		return
+	}
+
+	var documents []checkpointDocument
+	if err = cursor.All(ctx, &documents); err != nil {
+		r.logger.WithContext(ctx).Error("find pii access checkpoints ", err)
+		err = exception.ErrInternalServer
+		return
+	}
+
+	for _, document := range documents {
+		checkpoints = append(checkpoints, entity.AuditCheckpoint{
+			AccessID:  document.AccessID,
+			HMAC:      document.HMAC,
+			Signature: document.Signature,
+			CreatedAt: document.CreatedAt,
+		})
+	}
+	return
+}
+
+func (document accessDocument) access() entity.PIIAccess {
+	return entity.PIIAccess{
+		ID:            document.Seq,
+		Principal:     document.Principal,
+		RemoteAddress: document.RemoteAddress,
+		XForwardedFor: document.XForwardedFor,
+		XRealIP:       document.XRealIP,
+		UserAgent:     document.UserAgent,
+		ClientIP:      document.ClientIP,
+		UserUUIDs:     document.UserUUIDs,
+		Fields:        document.Fields,
+		Purpose:       document.Purpose,
+		CreatedAt:     document.CreatedAt,
+		PreviousHMAC:  document.PreviousHMAC,
+		HMAC:          document.HMAC,
+		ChainVersion:  document.ChainVersion,
+	}
+}
@@ -0,0 +1,104 @@
+package audit
+
+import (
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"pii-encrypt-example/entity"
+)
+
+// errBroken stops the walk of the chain at the first broken link.
+var errBroken = errors.New("audit: broken link")
+
+// VerifyReport is the outcome of the verification of the audit log, Broken is nil when the log is intact.
+type VerifyReport struct {
+	Accesses    int
+	Unchained   int
+	Checkpoints int
+	Broken      *BrokenLink
+}
+
+// BrokenLink is the first record, or checkpoint, which does not match the chain.
+type BrokenLink struct {
+	AccessID     int64
+	CheckpointID int64
+	Reason       string
+}
+
+func (b BrokenLink) String() string {
+	if b.CheckpointID != 0 {
+		return fmt.Sprintf("checkpoint %d of record %d: %s", b.CheckpointID, b.AccessID, b.Reason)
+	}
+	return fmt.Sprintf("record %d: %s", b.AccessID, b.Reason)
+}
+
+// Verify walks the chain of the audit log from its first record and reports the first broken link.
+// The records written before the chain, which have no HMAC, are only allowed at its start.
+// The signatures of the checkpoints are verified when publicKey is given.
+func Verify(ctx context.Context, repository AuditRepository, chain *Chain, publicKey ed25519.PublicKey) (report VerifyReport, err error) {
+	checkpoints, err := repository.FindManyCheckpoint(ctx)
+	if err != nil {
+		return
+	}
+	report.Checkpoints = len(checkpoints)
+
+	byAccessID := make(map[int64][]entity.AuditCheckpoint)
+	for _, checkpoint := range checkpoints {
+		if publicKey != nil && !VerifyCheckpoint(publicKey, checkpoint) {
+			report.Broken = &BrokenLink{AccessID: checkpoint.AccessID, CheckpointID: checkpoint.ID, Reason: "the signature is invalid"}
+			return
+		}
+		byAccessID[checkpoint.AccessID] = append(byAccessID[checkpoint.AccessID], checkpoint)
+	}
+
+	previousHMAC := ""
+	chained := false
+	err = repository.EachAccess(ctx, func(access entity.PIIAccess) error {
+		report.Accesses++
+		broken := func(reason string) error {
+			report.Broken = &BrokenLink{AccessID: access.ID, Reason: reason}
+			return errBroken
+		}
+
+		if access.HMAC == "" {
+			if chained {
+				return broken("the record has no hmac")
+			}
+			report.Unchained++
+			return nil
+		}
+		if access.PreviousHMAC != previousHMAC {
+			return broken("the previous hmac does not match the record before")
+		}
+		if !chain.Verify(access) {
+			return broken("the hmac does not match the content of the record")
+		}
+		previousHMAC = access.HMAC
+		chained = true
+
+		for _, checkpoint := range byAccessID[access.ID] {
+			if checkpoint.HMAC != access.HMAC {
+				report.Broken = &BrokenLink{AccessID: access.ID, CheckpointID: checkpoint.ID, Reason: "the record does not match the signed hmac"}
+				return errBroken
+			}
+		}
+		delete(byAccessID, access.ID)
+		return nil
+	})
+	if err == errBroken {
+		return report, nil
+	}
+	if err != nil {
+		return
+	}
+
+	// a checkpoint of a record which has not been walked means the log has been truncated.
+	for _, checkpoint := range checkpoints {
+		if _, missing := byAccessID[checkpoint.AccessID]; missing {
+			report.Broken = &BrokenLink{AccessID: checkpoint.AccessID, CheckpointID: checkpoint.ID, Reason: "the signed record is missing, the log has been truncated"}
+			return
+		}
+	}
+	return
+}
@@ -0,0 +1,96 @@
+package audit_test
+
+import (
+	"context"
+	"crypto/ed25519"
+	"testing"
+	"time"
+
+	"pii-encrypt-example/pkg/audit"
+)
+
+func TestVerify(t *testing.T) {
+	ctx := context.Background()
+	logger, db, repository := newTestRepository(t)
+	auditor := audit.NewAuditor(logger, time.UTC, repository)
+	publicKey, privateKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkpointer := audit.NewCheckpointer(logger, time.UTC, repository, privateKey, time.Hour)
+
+	verify := func() audit.VerifyReport {
+		t.Helper()
+		report, err := audit.Verify(ctx, repository, testChain, publicKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return report
+	}
+
+	// a record written before the chain starts it.
+	if _, err := db.Exec(`INSERT INTO pii_access_audit (principal, remote_address, x_forwarded_for, x_real_ip, user_agent, user_uuids, fields, purpose) VALUES ('legacy', '', '', '', '', 'a', 'name', 'get user')`); err != nil {
+		t.Fatal(err)
+	}
+	for _, uuid := range []string{"b", "c", "d"} {
+		if err := auditor.Record(ctx, "get user", []string{"name"}, []string{uuid}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if created, err := checkpointer.Checkpoint(ctx); err != nil || !created {
+		t.Fatalf("Checkpoint() = %v, %v, want a checkpoint", created, err)
+	}
+	if created, err := checkpointer.Checkpoint(ctx); err != nil || created {
+		t.Fatalf("Checkpoint() of a signed head = %v, %v, want nothing", created, err)
+	}
+
+	if report := verify(); report.Broken != nil || report.Accesses != 4 || report.Unchained != 1 || report.Checkpoints != 1 {
+		t.Fatalf("Verify() of an intact log = %+v", report)
+	}
+
+	// an edited record breaks its own link.
+	if _, err := db.Exec(`UPDATE pii_access_audit SET user_uuids = 'x' WHERE id = 3`); err != nil {
+		t.Fatal(err)
+	}
+	if report := verify(); report.Broken == nil || report.Broken.AccessID != 3 {
+		t.Fatalf("Verify() of an edited log = %+v, want record 3 broken", report)
+	}
+	if _, err := db.Exec(`UPDATE pii_access_audit SET user_uuids = 'c' WHERE id = 3`); err != nil {
+		t.Fatal(err)
+	}
+
+	// a record cannot be passed off as linked in the format of another version.
+	if _, err := db.Exec(`UPDATE pii_access_audit SET chain_version = 0 WHERE id = 3`); err != nil {
+		t.Fatal(err)
+	}
+	if report := verify(); report.Broken == nil || report.Broken.AccessID != 3 {
+		t.Fatalf("Verify() of a log with a record of another version = %+v, want record 3 broken", report)
+	}
+	if _, err := db.Exec(`UPDATE pii_access_audit SET chain_version = 1 WHERE id = 3`); err != nil {
+		t.Fatal(err)
+	}
+
+	// a removed record breaks the link of the next one.
+	if _, err := db.Exec(`DELETE FROM pii_access_audit WHERE id = 2`); err != nil {
+		t.Fatal(err)
+	}
+	if report := verify(); report.Broken == nil || report.Broken.AccessID != 3 {
+		t.Fatalf("Verify() of a log missing a record = %+v, want record 3 broken", report)
+	}
+
+	// a truncated log is caught by the checkpoint of its head.
+	if _, err := db.Exec(`DELETE FROM pii_access_audit WHERE id >= 2`); err != nil {
+		t.Fatal(err)
+	}
+	if report := verify(); report.Broken == nil || report.Broken.AccessID != 4 || report.Broken.CheckpointID == 0 {
+		t.Fatalf("Verify() of a truncated log = %+v, want the checkpoint of record 4 broken", report)
+	}
+
+	// a forged checkpoint is rejected.
+	if _, err := db.Exec(`UPDATE pii_access_audit_checkpoint SET access_id = 1`); err != nil {
+		t.Fatal(err)
+	}
+	if report := verify(); report.Broken == nil || report.Broken.CheckpointID == 0 {
+		t.Fatalf("Verify() of a forged checkpoint = %+v, want the checkpoint broken", report)
+	}
+}
@@ -62,6 +62,9 @@ func (a AESGCM) Encrypt(plainText string) (cipherText []byte, err error) {
 func (a AESGCM) Decrypt(encryptedTextBytes []byte) (plainText []byte, err error) {
 	// The key argument should be the AES key, either 16 or 32 bytes to select AES-128 or AES-256.
 	key := []byte(a.secret)
+	if len(encryptedTextBytes) < 12 {
+		return nil, fmt.Errorf("cipherText too short")
+	}
 	nonce := encryptedTextBytes[:12]
 	encryptedTextBytes = encryptedTextBytes[12:]
 
@@ -0,0 +1,215 @@
+package crypto
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// An envelope is a stream encrypted with a random data key, preceded by the data key wrapped for its recipient:
+//
+//	"PIIE" | mode (1 byte) | wrapped key length (2 bytes) | wrapped key | stream
+//
+// It lets a file be shared outside of the service without sharing any key of the keyring.
+const (
+	envelopeMagic = "PIIE"
+
+	envelopeModePassphrase byte = 1
+	envelopeModeRSA        byte = 2
+
+	passphraseSaltSize = 16
+	passphraseSize     = 24
+)
+
+// KeyWrapper wraps the data key of an envelope for its recipient.
+type KeyWrapper interface {
+	Mode() byte
+	WrapKey(dataKey []byte) (wrappedKey []byte, err error)
+}
+
+// KeyUnwrapper unwraps the data key of an envelope.
+type KeyUnwrapper interface {
+	UnwrapKey(mode byte, wrappedKey []byte) (dataKey []byte, err error)
+}
+
+// NewEnvelopeWriter returns a writer encrypting everything written to it into w, for the recipient of the wrapper.
+// Close must be called to complete the envelope, it does not close w.
+func NewEnvelopeWriter(w io.Writer, wrapper KeyWrapper) (io.WriteCloser, error) {
+	dataKey, err := NewDataKey()
+	if err != nil {
+		return nil, err
+	}
+
+	wrappedKey, err := wrapper.WrapKey(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(wrappedKey) > 0xffff {
+		return nil, errors.New("crypto: wrapped key is too large")
+	}
+
+	header := append([]byte(envelopeMagic), wrapper.Mode(), 0, 0)
+	binary.BigEndian.PutUint16(header[len(envelopeMagic)+1:], uint16(len(wrappedKey)))
+	header = append(header, wrappedKey...)
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+
+	return NewStreamWriter(w, dataKey)
+}
+
+// NewEnvelopeReader returns a reader decrypting the envelope written by NewEnvelopeWriter.
+func NewEnvelopeReader(r io.Reader, unwrapper KeyUnwrapper) (io.Reader, error) {
+	header := make([]byte, len(envelopeMagic)+3)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, fmt.Errorf("crypto: cannot read envelope header: %w", err)
+	}
+	if string(header[:len(envelopeMagic)]) != envelopeMagic {
+		return nil, errors.New("crypto: not an envelope")
+	}
+
+	wrappedKey := make([]byte, binary.BigEndian.Uint16(header[len(envelopeMagic)+1:]))
+	if _, err := io.ReadFull(r, wrappedKey); err != nil {
+		return nil, fmt.Errorf("crypto: cannot read envelope key: %w", err)
+	}
+
+	dataKey, err := unwrapper.UnwrapKey(header[len(envelopeMagic)], wrappedKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewStreamReader(r, dataKey)
+}
+
+// Passphrase wraps the data key with a key derived from a passphrase by scrypt.
+type Passphrase string
+
+// NewPassphrase returns a random passphrase, meant to be used once.
+func NewPassphrase() (Passphrase, error) {
+	b := make([]byte, passphraseSize)
+	if _, err := io.ReadFull(rand.Reader, b); err != nil {
+		return "", err
+	}
+	return Passphrase(base64.RawURLEncoding.EncodeToString(b)), nil
+}
+
+// Mode implements KeyWrapper.
+func (p Passphrase) Mode() byte {
+	return envelopeModePassphrase
+}
+
+// WrapKey implements KeyWrapper.
+func (p Passphrase) WrapKey(dataKey []byte) (wrappedKey []byte, err error) {
+	salt := make([]byte, passphraseSaltSize)
+	if _, err = io.ReadFull(rand.Reader, salt); err != nil {
+		return
+	}
+
+	aead, err := p.aead(salt)
+	if err != nil {
+		return
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+		return
+	}
+
+	wrappedKey = append(salt, nonce...)
+	wrappedKey = aead.Seal(wrappedKey, nonce, dataKey, nil)
+	return
+}
+
+// UnwrapKey implements KeyUnwrapper.
+func (p Passphrase) UnwrapKey(mode byte, wrappedKey []byte) (dataKey []byte, err error) {
+	if mode != envelopeModePassphrase {
+		return nil, errors.New("crypto: envelope is not protected by a passphrase")
+	}
+	if len(wrappedKey) < passphraseSaltSize+12 {
+		return nil, errors.New("crypto: wrapped key too short")
+	}
+
+	salt := wrappedKey[:passphraseSaltSize]
+	aead, err := p.aead(salt)
+	if err != nil {
+		return
+	}
+
+	nonce := wrappedKey[passphraseSaltSize : passphraseSaltSize+aead.NonceSize()]
+	return aead.Open(nil, nonce, wrappedKey[passphraseSaltSize+aead.NonceSize():], nil)
+}
+
+func (p Passphrase) aead(salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key([]byte(p), salt, 1<<15, 8, 1, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// RSARecipient wraps the data key with RSA-OAEP for the holder of the private key.
+type RSARecipient struct {
+	PublicKey *rsa.PublicKey
+}
+
+// ParseRSARecipient reads a PEM encoded RSA public key, either PKIX or PKCS #1.
+func ParseRSARecipient(publicKeyPEM string) (recipient RSARecipient, err error) {
+	block, _ := pem.Decode([]byte(publicKeyPEM))
+	if block == nil {
+		return recipient, errors.New("crypto: public key is not PEM encoded")
+	}
+
+	rsaKey, err := x509.ParsePKCS1PublicKey(block.Bytes)
+	if err != nil {
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return recipient, fmt.Errorf("crypto: cannot parse public key: %w", err)
+		}
+		var ok bool
+		if rsaKey, ok = key.(*rsa.PublicKey); !ok {
+			return recipient, errors.New("crypto: public key is not an RSA key")
+		}
+	}
+	if rsaKey.Size() < 256 {
+		return recipient, errors.New("crypto: RSA public key must be at least 2048 bits")
+	}
+	return RSARecipient{PublicKey: rsaKey}, nil
+}
+
+// Mode implements KeyWrapper.
+func (r RSARecipient) Mode() byte {
+	return envelopeModeRSA
+}
+
+// WrapKey implements KeyWrapper.
+func (r RSARecipient) WrapKey(dataKey []byte) (wrappedKey []byte, err error) {
+	return rsa.EncryptOAEP(sha256.New(), rand.Reader, r.PublicKey, dataKey, []byte(envelopeMagic))
+}
+
+// RSAPrivateKey unwraps the data key of an envelope written for its RSARecipient.
+type RSAPrivateKey struct {
+	PrivateKey *rsa.PrivateKey
+}
+
+// UnwrapKey implements KeyUnwrapper.
+func (r RSAPrivateKey) UnwrapKey(mode byte, wrappedKey []byte) (dataKey []byte, err error) {
+	if mode != envelopeModeRSA {
+		return nil, errors.New("crypto: envelope is not protected by an RSA key")
+	}
+	return rsa.DecryptOAEP(sha256.New(), rand.Reader, r.PrivateKey, wrappedKey, []byte(envelopeMagic))
+}
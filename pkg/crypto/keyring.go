@@ -0,0 +1,97 @@
+package crypto
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// ErrNoKeyring is returned by the encrypted column types when no keyring has been registered.
+var ErrNoKeyring = errors.New("crypto: no keyring registered")
+
+// Keyring is a Crypto holding every key able to decrypt the stored data.
+// New data is always encrypted with the primary key, while older keys are kept to decrypt data that has not been rotated yet.
+type Keyring struct {
+	primaryID string
+	ids       []string
+	keys      map[string]Crypto
+}
+
+// NewKeyring is constructor.
+func NewKeyring(primaryID string, primary Crypto) *Keyring {
+	return &Keyring{
+		primaryID: primaryID,
+		ids:       []string{primaryID},
+		keys:      map[string]Crypto{primaryID: primary},
+	}
+}
+
+// Add registers a secondary key, used only for decryption.
+func (k *Keyring) Add(id string, c Crypto) *Keyring {
+	if _, ok := k.keys[id]; !ok {
+		k.ids = append(k.ids, id)
+	}
+	k.keys[id] = c
+	return k
+}
+
+// PrimaryKeyID returns the id of the key used for encryption.
+func (k *Keyring) PrimaryKeyID() string {
+	return k.primaryID
+}
+
+// Key returns the key registered with the given id.
+func (k *Keyring) Key(id string) (c Crypto, ok bool) {
+	c, ok = k.keys[id]
+	return
+}
+
+// Encrypt returns encrypted string using the primary key.
+func (k *Keyring) Encrypt(plainText string) (cipherText []byte, err error) {
+	return k.keys[k.primaryID].Encrypt(plainText)
+}
+
+// Decrypt tries the primary key first and then every secondary key in the order they were added.
+func (k *Keyring) Decrypt(encryptedTextBytes []byte) (plainText []byte, err error) {
+	for _, id := range k.ids {
+		if plainText, err = k.keys[id].Decrypt(encryptedTextBytes); err == nil {
+			return
+		}
+	}
+	return nil, fmt.Errorf("crypto: no key of the keyring can decrypt the cipherText: %w", err)
+}
+
+// Hash returns the blind index computed by the primary key. The keys of a keyring are expected to share their pepper,
+// the blind indexes are looked up with the hash of the primary key only and would miss the rows hashed by another pepper.
+func (k *Keyring) Hash(s string) []byte {
+	return k.keys[k.primaryID].Hash(s)
+}
+
+var (
+	registeredMu      sync.RWMutex
+	registeredKeyring Crypto
+)
+
+// RegisterKeyring sets the keyring used by EncryptedString, EncryptedTime and EncryptedInt.
+func RegisterKeyring(c Crypto) {
+	registeredMu.Lock()
+	defer registeredMu.Unlock()
+	registeredKeyring = c
+}
+
+func keyring() (Crypto, error) {
+	registeredMu.RLock()
+	defer registeredMu.RUnlock()
+	if registeredKeyring == nil {
+		return nil, ErrNoKeyring
+	}
+	return registeredKeyring, nil
+}
+
+// PrimaryKeyID returns the id of the key c encrypts with, or an empty string when c is not a Keyring.
+func PrimaryKeyID(c Crypto) string {
+	if k, ok := c.(*Keyring); ok {
+		return k.PrimaryKeyID()
+	}
+	return ""
+}
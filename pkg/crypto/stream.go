@@ -0,0 +1,216 @@
+package crypto
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// The stream format splits the plaintext in chunks sealed independently with AES-256-GCM, so a stream of any size
+// is encrypted and decrypted with a bounded amount of memory. Each chunk nonce is made of a random prefix written in the
+// stream header, the chunk counter and a flag set only on the last chunk, which prevents chunks from being reordered,
+// dropped or the stream from being truncated.
+const (
+	streamMagic       = "PIIS"
+	streamVersion     = 1
+	streamChunkSize   = 64 * 1024
+	streamPrefixSize  = 7
+	streamHeaderSize  = len(streamMagic) + 1 + streamPrefixSize
+	streamDataKeySize = 32
+)
+
+// ErrStreamTruncated is returned when an encrypted stream ends before its last chunk, or when its last chunk cannot be authenticated.
+var ErrStreamTruncated = errors.New("crypto: encrypted stream is truncated")
+
+// NewDataKey returns a random key for the stream functions.
+func NewDataKey() (key []byte, err error) {
+	key = make([]byte, streamDataKeySize)
+	_, err = io.ReadFull(rand.Reader, key)
+	return
+}
+
+type streamWriter struct {
+	w       io.Writer
+	aead    cipher.AEAD
+	prefix  []byte
+	counter uint32
+	buf     []byte
+	closed  bool
+}
+
+// NewStreamWriter returns a writer encrypting everything written to it into w with the 32 bytes key.
+// Close must be called to write the last chunk, it does not close w.
+func NewStreamWriter(w io.Writer, key []byte) (io.WriteCloser, error) {
+	aead, err := newStreamAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := make([]byte, streamPrefixSize)
+	if _, err := io.ReadFull(rand.Reader, prefix); err != nil {
+		return nil, err
+	}
+
+	header := append([]byte(streamMagic), streamVersion)
+	header = append(header, prefix...)
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+
+	return &streamWriter{
+		w:      w,
+		aead:   aead,
+		prefix: prefix,
+		buf:    make([]byte, 0, streamChunkSize),
+	}, nil
+}
+
+func (s *streamWriter) Write(p []byte) (n int, err error) {
+	if s.closed {
+		return 0, errors.New("crypto: write to closed stream")
+	}
+
+	for len(p) > 0 {
+		// a full chunk is only flushed once more data comes, so the last chunk is always written by Close.
+		if len(s.buf) == streamChunkSize {
+			if err = s.flush(false); err != nil {
+				return
+			}
+		}
+
+		m := copy(s.buf[len(s.buf):streamChunkSize], p)
+		s.buf = s.buf[:len(s.buf)+m]
+		p = p[m:]
+		n += m
+	}
+	return
+}
+
+func (s *streamWriter) Close() error {
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	return s.flush(true)
+}
+
+func (s *streamWriter) flush(last bool) error {
+	if s.counter == ^uint32(0) {
+		return errors.New("crypto: stream is too large")
+	}
+
+	chunk := s.aead.Seal(nil, streamNonce(s.prefix, s.counter, last), s.buf, nil)
+	s.counter++
+	s.buf = s.buf[:0]
+
+	_, err := s.w.Write(chunk)
+	return err
+}
+
+type streamReader struct {
+	r       *bufio.Reader
+	aead    cipher.AEAD
+	prefix  []byte
+	counter uint32
+	chunk   []byte
+	plain   []byte
+	done    bool
+}
+
+// NewStreamReader returns a reader decrypting the stream written by NewStreamWriter.
+// Read fails as soon as a chunk has been tampered with, or with ErrStreamTruncated when the stream is cut.
+func NewStreamReader(r io.Reader, key []byte) (io.Reader, error) {
+	aead, err := newStreamAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, streamHeaderSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, fmt.Errorf("crypto: cannot read stream header: %w", err)
+	}
+	if string(header[:len(streamMagic)]) != streamMagic || header[len(streamMagic)] != streamVersion {
+		return nil, errors.New("crypto: not an encrypted stream")
+	}
+
+	return &streamReader{
+		r:      bufio.NewReaderSize(r, streamChunkSize+aead.Overhead()+1),
+		aead:   aead,
+		prefix: header[len(streamMagic)+1:],
+		chunk:  make([]byte, streamChunkSize+aead.Overhead()),
+	}, nil
+}
+
+func (s *streamReader) Read(p []byte) (n int, err error) {
+	for len(s.plain) == 0 {
+		if s.done {
+			return 0, io.EOF
+		}
+		if err = s.next(); err != nil {
+			return
+		}
+	}
+
+	n = copy(p, s.plain)
+	s.plain = s.plain[n:]
+	return
+}
+
+func (s *streamReader) next() error {
+	n, err := io.ReadFull(s.r, s.chunk)
+	last := false
+	switch {
+	case err == io.ErrUnexpectedEOF:
+		last = true
+	case err == io.EOF:
+		return ErrStreamTruncated
+	case err != nil:
+		return err
+	default:
+		// a full chunk is the last one only when nothing follows it.
+		if _, err := s.r.Peek(1); err == io.EOF {
+			last = true
+		} else if err != nil {
+			return err
+		}
+	}
+
+	plain, err := s.aead.Open(s.chunk[:0:0], streamNonce(s.prefix, s.counter, last), s.chunk[:n], nil)
+	if err != nil {
+		if !last {
+			return err
+		}
+		return ErrStreamTruncated
+	}
+
+	s.counter++
+	s.plain = plain
+	s.done = last
+	return nil
+}
+
+func newStreamAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != streamDataKeySize {
+		return nil, fmt.Errorf("crypto: stream key must be %d bytes", streamDataKeySize)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func streamNonce(prefix []byte, counter uint32, last bool) []byte {
+	nonce := make([]byte, 12)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[streamPrefixSize:], counter)
+	if last {
+		nonce[11] = 1
+	}
+	return nonce
+}
@@ -0,0 +1,118 @@
+package crypto_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"io"
+	"testing"
+
+	"pii-encrypt-example/pkg/crypto"
+)
+
+func TestStream(t *testing.T) {
+	key, _ := crypto.NewDataKey()
+
+	for _, size := range []int{0, 1, 64 * 1024, 64*1024 + 1, 3*64*1024 - 7} {
+		plainText := make([]byte, size)
+		rand.Read(plainText)
+
+		var buf bytes.Buffer
+		w, err := crypto.NewStreamWriter(&buf, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(plainText)
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		encrypted := buf.Bytes()
+
+		r, err := crypto.NewStreamReader(bytes.NewReader(encrypted), key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decrypted, err := io.ReadAll(r)
+		if err != nil || !bytes.Equal(decrypted, plainText) {
+			t.Fatalf("size %d: round trip failed (%v)", size, err)
+		}
+
+		// cutting the stream anywhere after its header must be detected.
+		for _, cut := range []int{12, len(encrypted) - 1, 12 + 64*1024 + 16} {
+			if cut >= len(encrypted) {
+				continue
+			}
+			r, _ := crypto.NewStreamReader(bytes.NewReader(encrypted[:cut]), key)
+			if _, err := io.ReadAll(r); err == nil {
+				t.Errorf("size %d: expected truncation at %d to be detected", size, cut)
+			}
+		}
+	}
+}
+
+func TestEnvelope(t *testing.T) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	passphrase, _ := crypto.NewPassphrase()
+
+	cases := []struct {
+		wrapper   crypto.KeyWrapper
+		unwrapper crypto.KeyUnwrapper
+	}{
+		{passphrase, passphrase},
+		{crypto.RSARecipient{PublicKey: &privateKey.PublicKey}, crypto.RSAPrivateKey{PrivateKey: privateKey}},
+	}
+
+	for _, c := range cases {
+		var buf bytes.Buffer
+		w, err := crypto.NewEnvelopeWriter(&buf, c.wrapper)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(w, "uuid,name\n1,John\n")
+		w.Close()
+
+		r, err := crypto.NewEnvelopeReader(bytes.NewReader(buf.Bytes()), c.unwrapper)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decrypted, err := io.ReadAll(r)
+		if err != nil || string(decrypted) != "uuid,name\n1,John\n" {
+			t.Errorf("unexpected envelope content %q (%v)", decrypted, err)
+		}
+	}
+
+	var buf bytes.Buffer
+	w, _ := crypto.NewEnvelopeWriter(&buf, passphrase)
+	w.Close()
+	if _, err := crypto.NewEnvelopeReader(bytes.NewReader(buf.Bytes()), crypto.Passphrase("wrong")); err == nil {
+		t.Error("expected a wrong passphrase to be rejected")
+	}
+	if _, err := crypto.NewEnvelopeReader(bytes.NewReader(buf.Bytes()), crypto.RSAPrivateKey{PrivateKey: privateKey}); err == nil {
+		t.Error("expected a mode mismatch to be rejected")
+	}
+}
+
+func TestParseRSARecipient(t *testing.T) {
+	for _, bits := range []int{1024, 2048} {
+		privateKey, err := rsa.GenerateKey(rand.Reader, bits)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pkix, _ := x509.MarshalPKIXPublicKey(&privateKey.PublicKey)
+		encodings := map[string]*pem.Block{
+			"PKCS#1": {Type: "RSA PUBLIC KEY", Bytes: x509.MarshalPKCS1PublicKey(&privateKey.PublicKey)},
+			"PKIX":   {Type: "PUBLIC KEY", Bytes: pkix},
+		}
+		for name, block := range encodings {
+			_, err := crypto.ParseRSARecipient(string(pem.EncodeToMemory(block)))
+			if (err == nil) != (bits >= 2048) {
+				t.Errorf("ParseRSARecipient() of a %d bits %s key = %v", bits, name, err)
+			}
+		}
+	}
+}
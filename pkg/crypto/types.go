@@ -0,0 +1,123 @@
+package crypto
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// EncryptedString is a string column encrypted at rest with the registered keyring.
+// It is encrypted in Value and decrypted in Scan, an empty string is stored as NULL.
+type EncryptedString string
+
+// Value implements driver.Valuer.
+func (s EncryptedString) Value() (driver.Value, error) {
+	if s == "" {
+		return nil, nil
+	}
+	return encryptValue(string(s))
+}
+
+// Scan implements sql.Scanner.
+func (s *EncryptedString) Scan(src interface{}) error {
+	plainText, err := decryptValue(src)
+	if err != nil {
+		return err
+	}
+	*s = EncryptedString(plainText)
+	return nil
+}
+
+// EncryptedTime is a time column encrypted at rest with the registered keyring.
+// The time is kept in RFC 3339 format with nanoseconds, a zero time is stored as NULL.
+type EncryptedTime struct {
+	time.Time
+}
+
+// Value implements driver.Valuer.
+func (t EncryptedTime) Value() (driver.Value, error) {
+	if t.IsZero() {
+		return nil, nil
+	}
+	return encryptValue(t.Format(time.RFC3339Nano))
+}
+
+// Scan implements sql.Scanner.
+func (t *EncryptedTime) Scan(src interface{}) error {
+	plainText, err := decryptValue(src)
+	if err != nil {
+		return err
+	}
+	if plainText == "" {
+		t.Time = time.Time{}
+		return nil
+	}
+
+	parsed, err := time.Parse(time.RFC3339Nano, plainText)
+	if err != nil {
+		return fmt.Errorf("crypto: scan EncryptedTime: %w", err)
+	}
+	t.Time = parsed
+	return nil
+}
+
+// EncryptedInt is an integer column encrypted at rest with the registered keyring.
+// Unlike the other types a zero is a meaningful value, so it is encrypted as well.
+type EncryptedInt int64
+
+// Value implements driver.Valuer.
+func (i EncryptedInt) Value() (driver.Value, error) {
+	return encryptValue(strconv.FormatInt(int64(i), 10))
+}
+
+// Scan implements sql.Scanner.
+func (i *EncryptedInt) Scan(src interface{}) error {
+	plainText, err := decryptValue(src)
+	if err != nil {
+		return err
+	}
+	if plainText == "" {
+		*i = 0
+		return nil
+	}
+
+	parsed, err := strconv.ParseInt(plainText, 10, 64)
+	if err != nil {
+		return fmt.Errorf("crypto: scan EncryptedInt: %w", err)
+	}
+	*i = EncryptedInt(parsed)
+	return nil
+}
+
+func encryptValue(plainText string) (driver.Value, error) {
+	k, err := keyring()
+	if err != nil {
+		return nil, err
+	}
+	return k.Encrypt(plainText)
+}
+
+func decryptValue(src interface{}) (string, error) {
+	var cipherText []byte
+	switch v := src.(type) {
+	case nil:
+		return "", nil
+	case []byte:
+		cipherText = v
+	case string:
+		cipherText = []byte(v)
+	default:
+		return "", fmt.Errorf("crypto: cannot scan %T into an encrypted column", src)
+	}
+
+	k, err := keyring()
+	if err != nil {
+		return "", err
+	}
+	plainText, err := k.Decrypt(cipherText)
+	if err != nil {
+		return "", err
+	}
+	return string(plainText), nil
+}
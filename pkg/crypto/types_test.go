@@ -0,0 +1,55 @@
+package crypto_test
+
+import (
+	"testing"
+	"time"
+
+	"pii-encrypt-example/pkg/crypto"
+)
+
+func TestEncryptedTypes(t *testing.T) {
+	old := crypto.NewAESGCM("abcdefghijabcdefghijabcdefghij12", "1234567890123456")
+	keyring := crypto.NewKeyring("v2", crypto.NewAESGCM("12345678901234567890123456789012", "1234567890123456")).Add("v1", old)
+	crypto.RegisterKeyring(keyring)
+
+	s := crypto.EncryptedString("john@mail.com")
+	value, err := s.Value()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var scannedString crypto.EncryptedString
+	if err := scannedString.Scan(value); err != nil || scannedString != s {
+		t.Errorf("expected %q, got %q (%v)", s, scannedString, err)
+	}
+
+	tm := crypto.EncryptedTime{Time: time.Date(1990, 1, 2, 0, 0, 0, 0, time.UTC)}
+	value, err = tm.Value()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var scannedTime crypto.EncryptedTime
+	if err := scannedTime.Scan(value); err != nil || !scannedTime.Equal(tm.Time) {
+		t.Errorf("expected %v, got %v (%v)", tm, scannedTime, err)
+	}
+
+	i := crypto.EncryptedInt(0)
+	value, err = i.Value()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var scannedInt crypto.EncryptedInt = 7
+	if err := scannedInt.Scan(value); err != nil || scannedInt != i {
+		t.Errorf("expected %d, got %d (%v)", i, scannedInt, err)
+	}
+
+	// data encrypted with a secondary key is still readable.
+	cipherText, _ := old.Encrypt("legacy")
+	if err := scannedString.Scan(cipherText); err != nil || scannedString != "legacy" {
+		t.Errorf("expected legacy, got %q (%v)", scannedString, err)
+	}
+
+	var null crypto.EncryptedString = "stale"
+	if err := null.Scan(nil); err != nil || null != "" {
+		t.Errorf("expected NULL to scan as empty string, got %q (%v)", null, err)
+	}
+}
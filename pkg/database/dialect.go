@@ -0,0 +1,168 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"hash/fnv"
+	"strconv"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jackc/pgx/v5/pgconn"
+	_ "github.com/jackc/pgx/v5/stdlib" // registers the "pgx" driver
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// Dialect hides the differences between the sql databases from the repositories.
+// The repositories write their queries with "?" placeholders and standard sql, which Rebind adapts.
+type Dialect interface {
+	// Name is the name of the dialect, which is also the name of its migrations directory.
+	Name() string
+	// Rebind rewrites the "?" placeholders of query in the syntax of the dialect.
+	Rebind(query string) string
+	// SkipLocked is the clause locking the selected rows while skipping the rows locked by other transactions,
+	// it is empty when the database has no row locks.
+	SkipLocked() string
+	// DuplicateKey tells whether err is a unique constraint violation, detail holds the name of the constraint
+	// or of the columns, as reported by the database.
+	DuplicateKey(err error) (detail string, ok bool)
+	// ForeignKeyViolation tells whether err is a foreign key constraint violation.
+	ForeignKeyViolation(err error) bool
+	// Lock takes the lock called name on the session of conn, waiting for another session to release it,
+	// the lock is released by unlock or when the session ends.
+	Lock(ctx context.Context, conn *sql.Conn, name string) (unlock func() error, err error)
+}
+
+// Names of the dialects, the directories of their migrations.
+const (
+	DialectMySQL    = "mysql"
+	DialectPostgres = "postgres"
+	DialectSQLite   = "sqlite"
+)
+
+// MySQL is the dialect of mysql and mariadb.
+type MySQL struct{}
+
+func (MySQL) Name() string { return DialectMySQL }
+
+func (MySQL) Rebind(query string) string { return query }
+
+func (MySQL) SkipLocked() string { return " FOR UPDATE SKIP LOCKED" }
+
+func (MySQL) DuplicateKey(err error) (detail string, ok bool) {
+	var driverErr *mysql.MySQLError
+	if errors.As(err, &driverErr) && driverErr.Number == 1062 {
+		return driverErr.Message, true
+	}
+	return
+}
+
+func (MySQL) ForeignKeyViolation(err error) bool {
+	var driverErr *mysql.MySQLError
+	return errors.As(err, &driverErr) && (driverErr.Number == 1451 || driverErr.Number == 1452)
+}
+
+// Lock takes a named lock, waiting up to a minute at a time until ctx is done.
+func (MySQL) Lock(ctx context.Context, conn *sql.Conn, name string) (unlock func() error, err error) {
+	for {
+		var acquired sql.NullInt64
+		if err = conn.QueryRowContext(ctx, "SELECT GET_LOCK(?, 60)", name).Scan(&acquired); err != nil {
+			return
+		}
+		if acquired.Int64 == 1 {
+			break
+		}
+		if err = ctx.Err(); err != nil {
+			return
+		}
+	}
+
+	return func() error {
+		_, err := conn.ExecContext(context.Background(), "SELECT RELEASE_LOCK(?)", name)
+		return err
+	}, nil
+}
+
+// Postgres is the dialect of postgresql through the pgx driver.
+type Postgres struct{}
+
+func (Postgres) Name() string { return DialectPostgres }
+
+// Rebind numbers the placeholders, "?" becomes "$1", "$2" and so on.
+func (Postgres) Rebind(query string) string {
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+func (Postgres) SkipLocked() string { return " FOR UPDATE SKIP LOCKED" }
+
+func (Postgres) DuplicateKey(err error) (detail string, ok bool) {
+	var driverErr *pgconn.PgError
+	if errors.As(err, &driverErr) && driverErr.Code == "23505" {
+		return driverErr.ConstraintName, true
+	}
+	return
+}
+
+func (Postgres) ForeignKeyViolation(err error) bool {
+	var driverErr *pgconn.PgError
+	return errors.As(err, &driverErr) && driverErr.Code == "23503"
+}
+
+// Lock takes a session level advisory lock, keyed by the hash of name.
+func (Postgres) Lock(ctx context.Context, conn *sql.Conn, name string) (unlock func() error, err error) {
+	h := fnv.New64a()
+	h.Write([]byte(name))
+	key := int64(h.Sum64())
+
+	if _, err = conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", key); err != nil {
+		return
+	}
+
+	return func() error {
+		_, err := conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", key)
+		return err
+	}, nil
+}
+
+// SQLite is the dialect of sqlite through the modernc.org/sqlite driver, the foreign keys are only enforced
+// when the connection enables them, e.g. with the "_pragma=foreign_keys(1)" dsn parameter.
+type SQLite struct{}
+
+func (SQLite) Name() string { return DialectSQLite }
+
+func (SQLite) Rebind(query string) string { return query }
+
+// SkipLocked is empty, sqlite locks the whole database for the writing transaction.
+func (SQLite) SkipLocked() string { return "" }
+
+// DuplicateKey returns the columns of the violated constraint, sqlite does not report its name.
+func (SQLite) DuplicateKey(err error) (detail string, ok bool) {
+	var driverErr *sqlite.Error
+	if errors.As(err, &driverErr) && (driverErr.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE || driverErr.Code() == sqlite3.SQLITE_CONSTRAINT_PRIMARYKEY) {
+		return driverErr.Error(), true
+	}
+	return
+}
+
+func (SQLite) ForeignKeyViolation(err error) bool {
+	var driverErr *sqlite.Error
+	return errors.As(err, &driverErr) && driverErr.Code() == sqlite3.SQLITE_CONSTRAINT_FOREIGNKEY
+}
+
+// Lock does nothing, sqlite has no named locks. The writers of a sqlite database are serialized by the database itself,
+// and its ddl is transactional, so a concurrent migration fails on the version it applies twice and rolls back.
+func (SQLite) Lock(ctx context.Context, conn *sql.Conn, name string) (unlock func() error, err error) {
+	return func() error { return nil }, nil
+}
@@ -0,0 +1,390 @@
+package database
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	// ErrMigrationChecksum is returned when an applied migration has been edited since it was applied.
+	ErrMigrationChecksum = errors.New("database: applied migration has been modified")
+	// ErrMigrationUnknown is returned when the database has a migration the binary does not know, i.e. it is older than the schema.
+	ErrMigrationUnknown = errors.New("database: applied migration is unknown")
+	// ErrMigrationIrreversible is returned when reverting a migration without a down script.
+	ErrMigrationIrreversible = errors.New("database: migration has no down script")
+	// ErrMigrationVersion is returned when a baseline is not the version of a known migration.
+	ErrMigrationVersion = errors.New("database: no migration of this version")
+)
+
+// migrationFile matches the name of a migration file, e.g. 0001_create_user_encrypt.up.sql.
+var migrationFile = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// migrationTables creates the table of the applied migrations, in the types of each dialect.
+var migrationTables = map[string]string{
+	DialectMySQL:    "CREATE TABLE IF NOT EXISTS %s (version bigint NOT NULL, name varchar(255) NOT NULL, checksum char(64) NOT NULL, applied_at datetime NOT NULL, PRIMARY KEY (version))",
+	DialectPostgres: "CREATE TABLE IF NOT EXISTS %s (version bigint NOT NULL, name varchar(255) NOT NULL, checksum char(64) NOT NULL, applied_at timestamptz NOT NULL, PRIMARY KEY (version))",
+	DialectSQLite:   "CREATE TABLE IF NOT EXISTS %s (version bigint NOT NULL, name varchar(255) NOT NULL, checksum char(64) NOT NULL, applied_at datetime NOT NULL, PRIMARY KEY (version))",
+}
+
+// Migration is a version of the schema, Checksum is the sha256 of its up script.
+type Migration struct {
+	Version  int64
+	Name     string
+	Up       string
+	Down     string
+	Checksum string
+}
+
+// MigrationStatus is a migration and the time it was applied, AppliedAt is nil while the migration is pending.
+type MigrationStatus struct {
+	Migration
+	AppliedAt *time.Time
+}
+
+// appliedMigration is a row of the table of the applied migrations.
+type appliedMigration struct {
+	version   int64
+	name      string
+	checksum  string
+	appliedAt time.Time
+}
+
+// Migrator applies the versioned migrations of its dialect and records them in a table,
+// the runners of several instances take turns through the lock of the dialect.
+type Migrator struct {
+	logger     *logrus.Logger
+	dialect    Dialect
+	db         *sql.DB
+	tableName  string
+	migrations []Migration
+}
+
+// NewMigrator is a constructor, it reads the migrations from the directory of the dialect in migrations.
+func NewMigrator(logger *logrus.Logger, dialect Dialect, db *sql.DB, migrations fs.FS, tableName string) (*Migrator, error) {
+	loaded, err := loadMigrations(migrations, dialect.Name())
+	if err != nil {
+		return nil, err
+	}
+
+	return &Migrator{
+		logger:     logger,
+		dialect:    dialect,
+		db:         db,
+		tableName:  tableName,
+		migrations: loaded,
+	}, nil
+}
+
+func loadMigrations(migrations fs.FS, dir string) (loaded []Migration, err error) {
+	entries, err := fs.ReadDir(migrations, dir)
+	if err != nil {
+		return nil, fmt.Errorf("database: read migrations: %w", err)
+	}
+
+	byVersion := make(map[int64]*Migration)
+	for _, entry := range entries {
+		match := migrationFile.FindStringSubmatch(entry.Name())
+		if entry.IsDir() || match == nil {
+			continue
+		}
+
+		version, _ := strconv.ParseInt(match[1], 10, 64)
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = migration
+		}
+		if migration.Name != match[2] {
+			return nil, fmt.Errorf("database: migration %d is named both %s and %s", version, migration.Name, match[2])
+		}
+
+		content, err := fs.ReadFile(migrations, dir+"/"+entry.Name())
+		if err != nil {
+			return nil, fmt.Errorf("database: read migration %s: %w", entry.Name(), err)
+		}
+		if match[3] == "up" {
+			sum := sha256.Sum256(content)
+			migration.Up = string(content)
+			migration.Checksum = hex.EncodeToString(sum[:])
+		} else {
+			migration.Down = string(content)
+		}
+	}
+
+	for _, migration := range byVersion {
+		if migration.Up == "" {
+			return nil, fmt.Errorf("database: migration %d %s has no up script", migration.Version, migration.Name)
+		}
+		loaded = append(loaded, *migration)
+	}
+	sort.Slice(loaded, func(i, j int) bool { return loaded[i].Version < loaded[j].Version })
+	return
+}
+
+// Migrations returns every known migration, by version.
+func (m *Migrator) Migrations() []Migration {
+	return m.migrations
+}
+
+// Up applies the pending migrations by version, each in its own transaction, and returns them.
+// It refuses to run when an applied migration is unknown or has been modified.
+func (m *Migrator) Up(ctx context.Context) (applied []Migration, err error) {
+	err = m.locked(ctx, func(conn *sql.Conn, done map[int64]appliedMigration) error {
+		for _, migration := range m.migrations {
+			if _, ok := done[migration.Version]; ok {
+				continue
+			}
+			if err := m.apply(ctx, conn, migration, true); err != nil {
+				return err
+			}
+			applied = append(applied, migration)
+		}
+		return nil
+	})
+	return
+}
+
+// Down reverts the last steps applied migrations, latest first, and returns them.
+func (m *Migrator) Down(ctx context.Context, steps int) (reverted []Migration, err error) {
+	err = m.locked(ctx, func(conn *sql.Conn, done map[int64]appliedMigration) error {
+		for i := len(m.migrations) - 1; i >= 0 && len(reverted) < steps; i-- {
+			migration := m.migrations[i]
+			if _, ok := done[migration.Version]; !ok {
+				continue
+			}
+			if strings.TrimSpace(migration.Down) == "" {
+				return fmt.Errorf("%w: %d %s", ErrMigrationIrreversible, migration.Version, migration.Name)
+			}
+			if err := m.apply(ctx, conn, migration, false); err != nil {
+				return err
+			}
+			reverted = append(reverted, migration)
+		}
+		return nil
+	})
+	return
+}
+
+// Baseline records the migrations up to version as applied without running them, and returns them.
+// It adopts a database whose schema was created before the migrations, e.g. by hand from the sql script,
+// the migrations after version are applied by Up as usual.
+func (m *Migrator) Baseline(ctx context.Context, version int64) (recorded []Migration, err error) {
+	known := false
+	for _, migration := range m.migrations {
+		known = known || migration.Version == version
+	}
+	if !known {
+		return nil, fmt.Errorf("%w: %d", ErrMigrationVersion, version)
+	}
+
+	err = m.locked(ctx, func(conn *sql.Conn, done map[int64]appliedMigration) error {
+		tx, err := conn.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+
+		for _, migration := range m.migrations {
+			if _, ok := done[migration.Version]; ok || migration.Version > version {
+				continue
+			}
+			if _, err := tx.ExecContext(ctx, m.dialect.Rebind(fmt.Sprintf("INSERT INTO %s (version, name, checksum, applied_at) VALUES (?, ?, ?, ?)", m.tableName)),
+				migration.Version, migration.Name, migration.Checksum, time.Now().UTC()); err != nil {
+				return fmt.Errorf("database: baseline %d %s: %w", migration.Version, migration.Name, err)
+			}
+			recorded = append(recorded, migration)
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+
+		for _, migration := range recorded {
+			m.logger.WithContext(ctx).Infof("migration baseline %d %s", migration.Version, migration.Name)
+		}
+		return nil
+	})
+	if err != nil {
+		recorded = nil
+	}
+	return
+}
+
+// Status returns every known migration and when it was applied, along with the error of the verification of the applied ones.
+func (m *Migrator) Status(ctx context.Context) (statuses []MigrationStatus, err error) {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	done, err := m.applied(ctx, conn)
+	if err != nil {
+		return
+	}
+
+	for _, migration := range m.migrations {
+		status := MigrationStatus{Migration: migration}
+		if row, ok := done[migration.Version]; ok {
+			appliedAt := row.appliedAt
+			status.AppliedAt = &appliedAt
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, m.verify(done)
+}
+
+// locked runs fn on a single connection holding the lock of the migrations, given the verified applied migrations.
+func (m *Migrator) locked(ctx context.Context, fn func(conn *sql.Conn, done map[int64]appliedMigration) error) (err error) {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	unlock, err := m.dialect.Lock(ctx, conn, m.tableName)
+	if err != nil {
+		return fmt.Errorf("database: lock migrations: %w", err)
+	}
+	defer func() {
+		if err := unlock(); err != nil {
+			m.logger.WithContext(ctx).Error("unlock migrations ", err)
+		}
+	}()
+
+	done, err := m.applied(ctx, conn)
+	if err != nil {
+		return
+	}
+	if err = m.verify(done); err != nil {
+		return
+	}
+
+	return fn(conn, done)
+}
+
+// applied creates the table of the applied migrations when missing, and reads it.
+func (m *Migrator) applied(ctx context.Context, conn *sql.Conn) (done map[int64]appliedMigration, err error) {
+	if _, err = conn.ExecContext(ctx, fmt.Sprintf(migrationTables[m.dialect.Name()], m.tableName)); err != nil {
+		return nil, fmt.Errorf("database: create %s: %w", m.tableName, err)
+	}
+
+	rows, err := conn.QueryContext(ctx, fmt.Sprintf("SELECT version, name, checksum, applied_at FROM %s", m.tableName))
+	if err != nil {
+		return nil, fmt.Errorf("database: read %s: %w", m.tableName, err)
+	}
+	defer rows.Close()
+
+	done = make(map[int64]appliedMigration)
+	for rows.Next() {
+		var row appliedMigration
+		if err = rows.Scan(&row.version, &row.name, &row.checksum, &row.appliedAt); err != nil {
+			return nil, fmt.Errorf("database: read %s: %w", m.tableName, err)
+		}
+		done[row.version] = row
+	}
+	return done, rows.Err()
+}
+
+// verify checks every applied migration is known and unmodified.
+func (m *Migrator) verify(done map[int64]appliedMigration) error {
+	known := make(map[int64]Migration, len(m.migrations))
+	for _, migration := range m.migrations {
+		known[migration.Version] = migration
+	}
+
+	versions := make([]int64, 0, len(done))
+	for version := range done {
+		versions = append(versions, version)
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i] < versions[j] })
+
+	for _, version := range versions {
+		row := done[version]
+		migration, ok := known[version]
+		if !ok {
+			return fmt.Errorf("%w: %d %s", ErrMigrationUnknown, row.version, row.name)
+		}
+		if migration.Checksum != row.checksum {
+			return fmt.Errorf("%w: %d %s", ErrMigrationChecksum, row.version, row.name)
+		}
+	}
+	return nil
+}
+
+// apply runs the up or down script of migration and records it, in a single transaction.
+// Mysql commits its ddl implicitly, a failed migration may then be partly applied and has to be fixed by hand.
+func (m *Migrator) apply(ctx context.Context, conn *sql.Conn, migration Migration, up bool) (err error) {
+	script, direction := migration.Up, "up"
+	if !up {
+		script, direction = migration.Down, "down"
+	}
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			err = fmt.Errorf("database: migrate %s %d %s: %w", direction, migration.Version, migration.Name, err)
+		}
+	}()
+
+	for _, statement := range splitStatements(script) {
+		if _, err = tx.ExecContext(ctx, statement); err != nil {
+			return
+		}
+	}
+
+	if up {
+		_, err = tx.ExecContext(ctx, m.dialect.Rebind(fmt.Sprintf("INSERT INTO %s (version, name, checksum, applied_at) VALUES (?, ?, ?, ?)", m.tableName)),
+			migration.Version, migration.Name, migration.Checksum, time.Now().UTC())
+	} else {
+		_, err = tx.ExecContext(ctx, m.dialect.Rebind(fmt.Sprintf("DELETE FROM %s WHERE version = ?", m.tableName)), migration.Version)
+	}
+	if err != nil {
+		return
+	}
+
+	if err = tx.Commit(); err != nil {
+		return
+	}
+
+	m.logger.WithContext(ctx).Infof("migration %s %d %s", direction, migration.Version, migration.Name)
+	return
+}
+
+// splitStatements splits a script on the semicolons ending a line, the drivers run a single statement at a time.
+// The comment lines are left out.
+func splitStatements(script string) (statements []string) {
+	var statement strings.Builder
+	for _, line := range strings.Split(script, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "--") {
+			continue
+		}
+
+		statement.WriteString(line)
+		statement.WriteString("\n")
+		if strings.HasSuffix(trimmed, ";") {
+			statements = append(statements, strings.TrimSuffix(strings.TrimSpace(statement.String()), ";"))
+			statement.Reset()
+		}
+	}
+	if rest := strings.TrimSpace(statement.String()); rest != "" {
+		statements = append(statements, rest)
+	}
+	return
+}
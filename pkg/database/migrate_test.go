@@ -0,0 +1,140 @@
+package database_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"io"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"github.com/sirupsen/logrus"
+
+	"pii-encrypt-example/migrations"
+	"pii-encrypt-example/pkg/database"
+)
+
+func newTestLogger() *logrus.Logger {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	return logger
+}
+
+func openSQLite(t *testing.T) *sql.DB {
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "migrate.db")+"?_pragma=foreign_keys(1)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// TestMigrations applies and reverts the embedded sqlite migrations, twice to check the down scripts leave nothing behind.
+func TestMigrations(t *testing.T) {
+	ctx := context.Background()
+	migrator, err := database.NewMigrator(newTestLogger(), database.SQLite{}, openSQLite(t), migrations.FS, "schema_migrations")
+	if err != nil {
+		t.Fatal(err)
+	}
+	total := len(migrator.Migrations())
+
+	for i := 0; i < 2; i++ {
+		applied, err := migrator.Up(ctx)
+		if err != nil || len(applied) != total {
+			t.Fatalf("Up() = %d migrations, %v, want %d migrations", len(applied), err, total)
+		}
+		if applied, err := migrator.Up(ctx); err != nil || len(applied) != 0 {
+			t.Fatalf("Up() of a migrated database = %d migrations, %v, want nothing", len(applied), err)
+		}
+
+		reverted, err := migrator.Down(ctx, total)
+		if err != nil || len(reverted) != total {
+			t.Fatalf("Down() = %d migrations, %v, want %d migrations", len(reverted), err, total)
+		}
+	}
+}
+
+func TestMigratorStatus(t *testing.T) {
+	ctx := context.Background()
+	db := openSQLite(t)
+	files := fstest.MapFS{
+		"sqlite/0001_create_a.up.sql":   {Data: []byte("CREATE TABLE a (id integer);\nCREATE INDEX idx_a_id ON a (id);\n")},
+		"sqlite/0001_create_a.down.sql": {Data: []byte("DROP TABLE a;\n")},
+		"sqlite/0002_create_b.up.sql":   {Data: []byte("-- b has no down script\nCREATE TABLE b (id integer);\n")},
+	}
+	migrator, err := database.NewMigrator(newTestLogger(), database.SQLite{}, db, files, "schema_migrations")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := migrator.Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+	statuses, err := migrator.Status(ctx)
+	if err != nil || len(statuses) != 2 || statuses[0].AppliedAt == nil || statuses[1].AppliedAt == nil {
+		t.Fatalf("Status() = %+v, %v, want 2 applied migrations", statuses, err)
+	}
+
+	if _, err := migrator.Down(ctx, 1); !errors.Is(err, database.ErrMigrationIrreversible) {
+		t.Fatalf("Down() without a down script = %v, want %v", err, database.ErrMigrationIrreversible)
+	}
+
+	// an applied migration must not change.
+	files["sqlite/0001_create_a.up.sql"] = &fstest.MapFile{Data: []byte("CREATE TABLE a (id bigint);\n")}
+	edited, err := database.NewMigrator(newTestLogger(), database.SQLite{}, db, files, "schema_migrations")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := edited.Up(ctx); !errors.Is(err, database.ErrMigrationChecksum) {
+		t.Fatalf("Up() after editing a migration = %v, want %v", err, database.ErrMigrationChecksum)
+	}
+
+	// a database migrated by a newer binary is left alone.
+	delete(files, "sqlite/0002_create_b.up.sql")
+	files["sqlite/0001_create_a.up.sql"] = &fstest.MapFile{Data: []byte("CREATE TABLE a (id integer);\nCREATE INDEX idx_a_id ON a (id);\n")}
+	older, err := database.NewMigrator(newTestLogger(), database.SQLite{}, db, files, "schema_migrations")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := older.Up(ctx); !errors.Is(err, database.ErrMigrationUnknown) {
+		t.Fatalf("Up() of a newer database = %v, want %v", err, database.ErrMigrationUnknown)
+	}
+}
+
+// TestMigratorBaseline adopts a database whose schema was created by hand before the migrations.
+func TestMigratorBaseline(t *testing.T) {
+	ctx := context.Background()
+	db := openSQLite(t)
+	files := fstest.MapFS{
+		"sqlite/0001_create_a.up.sql": {Data: []byte("CREATE TABLE a (id integer);\n")},
+		"sqlite/0002_create_b.up.sql": {Data: []byte("CREATE TABLE b (id integer);\n")},
+	}
+	migrator, err := database.NewMigrator(newTestLogger(), database.SQLite{}, db, files, "schema_migrations")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := db.Exec("CREATE TABLE a (id integer)"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := migrator.Up(ctx); err == nil {
+		t.Fatal("Up() of a schema created by hand succeeded, want the table to exist already")
+	}
+
+	if _, err := migrator.Baseline(ctx, 3); !errors.Is(err, database.ErrMigrationVersion) {
+		t.Fatalf("Baseline() of an unknown version = %v, want %v", err, database.ErrMigrationVersion)
+	}
+	recorded, err := migrator.Baseline(ctx, 1)
+	if err != nil || len(recorded) != 1 || recorded[0].Version != 1 {
+		t.Fatalf("Baseline() = %+v, %v, want the migration 1 recorded", recorded, err)
+	}
+	if recorded, err := migrator.Baseline(ctx, 1); err != nil || len(recorded) != 0 {
+		t.Fatalf("Baseline() again = %+v, %v, want nothing recorded", recorded, err)
+	}
+
+	applied, err := migrator.Up(ctx)
+	if err != nil || len(applied) != 1 || applied[0].Version != 2 {
+		t.Fatalf("Up() after the baseline = %+v, %v, want the migration 2 applied", applied, err)
+	}
+}
@@ -0,0 +1,53 @@
+package database
+
+import (
+	"context"
+	"errors"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// ErrForeignTx is returned by a repository given a transaction begun by the repository of another database.
+var ErrForeignTx = errors.New("database: transaction belongs to another database")
+
+// Tx is a transaction of any of the databases, *sql.Tx and *MongoTx implement it.
+// A repository given the transaction of another database returns ErrForeignTx.
+type Tx interface {
+	Commit() error
+	Rollback() error
+}
+
+// MongoTx is a transaction in a mongodb session, mongodb only runs transactions on a replica set.
+type MongoTx struct {
+	session mongo.Session
+}
+
+// BeginMongoTx starts a session and its transaction, the session ends with the transaction.
+func BeginMongoTx(ctx context.Context, client *mongo.Client) (tx *MongoTx, err error) {
+	session, err := client.StartSession()
+	if err != nil {
+		return
+	}
+
+	if err = session.StartTransaction(); err != nil {
+		session.EndSession(ctx)
+		return
+	}
+
+	return &MongoTx{session: session}, nil
+}
+
+// Context returns ctx bound to the transaction, operations run with it are part of the transaction.
+func (tx *MongoTx) Context(ctx context.Context) context.Context {
+	return mongo.NewSessionContext(ctx, tx.session)
+}
+
+func (tx *MongoTx) Commit() error {
+	defer tx.session.EndSession(context.Background())
+	return tx.session.CommitTransaction(context.Background())
+}
+
+func (tx *MongoTx) Rollback() error {
+	defer tx.session.EndSession(context.Background())
+	return tx.session.AbortTransaction(context.Background())
+}
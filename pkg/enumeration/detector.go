@@ -0,0 +1,153 @@
+package enumeration
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Level is the response to the queries of a principal, from the least to the most severe.
+type Level int
+
+// Levels of a verdict.
+const (
+	LevelNone Level = iota
+	LevelAlert
+	LevelStepUp
+	LevelLockout
+)
+
+func (l Level) String() string {
+	switch l {
+	case LevelAlert:
+		return "alert"
+	case LevelStepUp:
+		return "step_up"
+	case LevelLockout:
+		return "lockout"
+	}
+	return "none"
+}
+
+// Window is a sliding window over the distinct queries of a principal, with the number of distinct queries from
+// which each level applies. A zero threshold is disabled.
+type Window struct {
+	Duration time.Duration
+	Alert    int
+	StepUp   int
+	Lockout  int
+}
+
+// threshold is the number of distinct queries from which level applies.
+func (w Window) threshold(level Level) int {
+	switch level {
+	case LevelAlert:
+		return w.Alert
+	case LevelStepUp:
+		return w.StepUp
+	case LevelLockout:
+		return w.Lockout
+	}
+	return 0
+}
+
+// level is the most severe level reached by distinct queries.
+func (w Window) level(distinct int) Level {
+	for level := LevelLockout; level > LevelNone; level-- {
+		if threshold := w.threshold(level); threshold > 0 && distinct >= threshold {
+			return level
+		}
+	}
+	return LevelNone
+}
+
+// Verdict is the level of a principal, Window and Distinct are the window which decided it and its distinct queries.
+// RetryAfter is the remaining lockout.
+type Verdict struct {
+	Level      Level
+	Window     time.Duration
+	Distinct   int
+	RetryAfter time.Duration
+}
+
+// Detector tracks the number of distinct queries of every principal over sliding windows, a principal issuing
+// many distinct lookups is enumerating the users whatever its rate.
+type Detector struct {
+	logger          *logrus.Logger
+	store           Store
+	key             []byte
+	lockoutDuration time.Duration
+	windows         []Window
+	durations       []time.Duration
+}
+
+// NewDetector is a constructor, the queries are kept as HMAC of key so the store never holds the names looked up.
+// A principal reaching a lockout threshold is locked out for lockoutDuration and starts afresh afterwards.
+func NewDetector(logger *logrus.Logger, store Store, key []byte, lockoutDuration time.Duration, windows ...Window) *Detector {
+	durations := make([]time.Duration, len(windows))
+	for i, window := range windows {
+		durations[i] = window.Duration
+	}
+
+	return &Detector{
+		logger:          logger,
+		store:           store,
+		key:             key,
+		lockoutDuration: lockoutDuration,
+		windows:         windows,
+		durations:       durations,
+	}
+}
+
+// Observe records the query of the principal and returns its verdict. The security events are logged once, by the
+// query crossing the threshold, with the security.event field for the alerting to pick them up.
+func (d *Detector) Observe(ctx context.Context, principal string, query string) (verdict Verdict, err error) {
+	remaining, err := d.store.Locked(ctx, principal)
+	if err != nil {
+		return
+	}
+	if remaining > 0 {
+		return Verdict{Level: LevelLockout, RetryAfter: remaining}, nil
+	}
+
+	now := time.Now()
+	previous, distinct, err := d.store.Observe(ctx, principal, d.digest(query), now, d.durations)
+	if err != nil {
+		return
+	}
+
+	for i, window := range d.windows {
+		level := window.level(distinct[i])
+		if level > verdict.Level {
+			verdict = Verdict{Level: level, Window: window.Duration, Distinct: distinct[i]}
+		}
+		added := previous.IsZero() || now.Sub(previous) > window.Duration
+		if added && level > LevelNone && distinct[i] == window.threshold(level) {
+			d.logger.WithContext(ctx).WithFields(logrus.Fields{
+				"security.event":            "enumeration",
+				"security.level":            level.String(),
+				"security.principal":        principal,
+				"security.window":           window.Duration.String(),
+				"security.distinct_queries": distinct[i],
+			}).Warn("enumeration of the users suspected")
+		}
+	}
+
+	if verdict.Level == LevelLockout {
+		if err = d.store.Lock(ctx, principal, d.lockoutDuration); err != nil {
+			return
+		}
+		verdict.RetryAfter = d.lockoutDuration
+	}
+	return
+}
+
+func (d *Detector) digest(query string) string {
+	mac := hmac.New(sha256.New, d.key)
+	mac.Write([]byte(query))
+	return hex.EncodeToString(mac.Sum(nil)[:16])
+}
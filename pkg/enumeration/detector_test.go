@@ -0,0 +1,84 @@
+package enumeration_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+
+	"pii-encrypt-example/pkg/enumeration"
+)
+
+// The redis store is tested against TEST_REDIS_ADDR, e.g. "localhost:6379", it is skipped when unset.
+func TestDetectorObserve(t *testing.T) {
+	stores := map[string]func(t *testing.T) enumeration.Store{
+		"memory": func(t *testing.T) enumeration.Store { return enumeration.NewMemoryStore() },
+		"redis": func(t *testing.T) enumeration.Store {
+			addr := os.Getenv("TEST_REDIS_ADDR")
+			if addr == "" {
+				t.Skip("TEST_REDIS_ADDR is not set")
+			}
+			client := redis.NewClient(&redis.Options{Addr: addr})
+			t.Cleanup(func() { client.Close() })
+			return enumeration.NewRedisStore(client, fmt.Sprintf("enumeration_test:%d:", time.Now().UnixNano()))
+		},
+	}
+
+	for name, newStore := range stores {
+		t.Run(name, func(t *testing.T) {
+			logger := logrus.New()
+			logger.SetOutput(io.Discard)
+			hook := test.NewLocal(logger)
+
+			detector := enumeration.NewDetector(logger, newStore(t), []byte("12345678901234567890123456789012"), time.Minute,
+				enumeration.Window{Duration: time.Minute, Alert: 2, StepUp: 3, Lockout: 5},
+				enumeration.Window{Duration: time.Hour},
+			)
+			ctx := context.Background()
+
+			steps := []struct {
+				query  string
+				want   enumeration.Level
+				events int
+			}{
+				{"name=a", enumeration.LevelNone, 0},
+				{"name=b", enumeration.LevelAlert, 1},
+				{"name=b", enumeration.LevelAlert, 1},
+				{"name=c", enumeration.LevelStepUp, 2},
+				{"name=d", enumeration.LevelStepUp, 2},
+				{"name=e", enumeration.LevelLockout, 3},
+				{"name=a", enumeration.LevelLockout, 3},
+			}
+			for i, step := range steps {
+				verdict, err := detector.Observe(ctx, "support", step.query)
+				if err != nil || verdict.Level != step.want {
+					t.Fatalf("Observe() #%d of %q = %+v, %v, want %s", i, step.query, verdict, err, step.want)
+				}
+				if len(hook.AllEntries()) != step.events {
+					t.Fatalf("Observe() #%d of %q logged %d events, want %d", i, step.query, len(hook.AllEntries()), step.events)
+				}
+			}
+
+			entry := hook.LastEntry()
+			if entry.Data["security.event"] != "enumeration" || entry.Data["security.level"] != "lockout" || entry.Data["security.principal"] != "support" {
+				t.Fatalf("lockout event = %+v", entry.Data)
+			}
+
+			verdict, err := detector.Observe(ctx, "support", "name=f")
+			if err != nil || verdict.RetryAfter <= 0 || verdict.RetryAfter > time.Minute {
+				t.Fatalf("Observe() of a principal locked out = %+v, %v, want the remaining lockout", verdict, err)
+			}
+
+			verdict, err = detector.Observe(ctx, "backoffice", "name=a")
+			if err != nil || verdict.Level != enumeration.LevelNone {
+				t.Fatalf("Observe() of another principal = %+v, %v, want none", verdict, err)
+			}
+		})
+	}
+}
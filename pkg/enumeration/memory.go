@@ -0,0 +1,101 @@
+package enumeration
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// sweepInterval is how often the queries older than their retention are dropped.
+const sweepInterval = time.Minute
+
+type memoryPrincipal struct {
+	// seen holds when each query was last seen
+	seen        map[string]time.Time
+	retention   time.Duration
+	lockedUntil time.Time
+}
+
+type memoryStore struct {
+	mu         sync.Mutex
+	principals map[string]*memoryPrincipal
+	sweptAt    time.Time
+}
+
+// NewMemoryStore keeps the queries in memory, every instance of the app tracks the principals on its own.
+func NewMemoryStore() Store {
+	return &memoryStore{
+		principals: make(map[string]*memoryPrincipal),
+		sweptAt:    time.Now(),
+	}
+}
+
+func (s *memoryStore) Observe(ctx context.Context, principal string, query string, now time.Time, windows []time.Duration) (previous time.Time, distinct []int, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if now.Sub(s.sweptAt) >= sweepInterval {
+		s.sweep(now)
+	}
+
+	p := s.principal(principal)
+	p.retention = retention(windows)
+	if seenAt, ok := p.seen[query]; ok && now.Sub(seenAt) <= p.retention {
+		previous = seenAt
+	}
+	p.seen[query] = now
+
+	distinct = make([]int, len(windows))
+	for _, seenAt := range p.seen {
+		for i, window := range windows {
+			if now.Sub(seenAt) <= window {
+				distinct[i]++
+			}
+		}
+	}
+	return
+}
+
+func (s *memoryStore) Lock(ctx context.Context, principal string, d time.Duration) (err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p := s.principal(principal)
+	p.seen = make(map[string]time.Time)
+	p.lockedUntil = time.Now().Add(d)
+	return
+}
+
+func (s *memoryStore) Locked(ctx context.Context, principal string) (remaining time.Duration, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if p, ok := s.principals[principal]; ok {
+		remaining = max(0, time.Until(p.lockedUntil))
+	}
+	return
+}
+
+func (s *memoryStore) principal(principal string) *memoryPrincipal {
+	p, ok := s.principals[principal]
+	if !ok {
+		p = &memoryPrincipal{seen: make(map[string]time.Time)}
+		s.principals[principal] = p
+	}
+	return p
+}
+
+// sweep drops the queries older than the retention, and the principals left with nothing.
+func (s *memoryStore) sweep(now time.Time) {
+	for principal, p := range s.principals {
+		for query, seenAt := range p.seen {
+			if now.Sub(seenAt) > p.retention {
+				delete(p.seen, query)
+			}
+		}
+		if len(p.seen) == 0 && !now.Before(p.lockedUntil) {
+			delete(s.principals, principal)
+		}
+	}
+	s.sweptAt = now
+}
@@ -0,0 +1,83 @@
+package enumeration
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+type redisStore struct {
+	client *redis.Client
+	prefix string
+}
+
+// NewRedisStore keeps the queries in redis, the instances of the app track the principals together.
+// The queries of a principal are a sorted set scored by the milliseconds they were last seen at.
+func NewRedisStore(client *redis.Client, prefix string) Store {
+	return &redisStore{
+		client: client,
+		prefix: prefix,
+	}
+}
+
+func (s *redisStore) Observe(ctx context.Context, principal string, query string, now time.Time, windows []time.Duration) (previous time.Time, distinct []int, err error) {
+	key := s.queriesKey(principal)
+	retention := retention(windows)
+
+	var score *redis.FloatCmd
+	counts := make([]*redis.IntCmd, len(windows))
+	_, err = s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.ZRemRangeByScore(ctx, key, "-inf", "("+strconv.FormatInt(now.Add(-retention).UnixMilli(), 10))
+		score = pipe.ZScore(ctx, key, query)
+		pipe.ZAdd(ctx, key, &redis.Z{Score: float64(now.UnixMilli()), Member: query})
+		for i, window := range windows {
+			counts[i] = pipe.ZCount(ctx, key, strconv.FormatInt(now.Add(-window).UnixMilli(), 10), "+inf")
+		}
+		pipe.PExpire(ctx, key, retention)
+		return nil
+	})
+	// the query was not seen before
+	if err == redis.Nil {
+		err = nil
+	}
+	if err != nil {
+		return
+	}
+
+	if seenAt, err := score.Result(); err == nil {
+		previous = time.UnixMilli(int64(seenAt))
+	}
+	distinct = make([]int, len(windows))
+	for i, count := range counts {
+		distinct[i] = int(count.Val())
+	}
+	return
+}
+
+func (s *redisStore) Lock(ctx context.Context, principal string, d time.Duration) (err error) {
+	_, err = s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, s.lockKey(principal), 1, d)
+		pipe.Del(ctx, s.queriesKey(principal))
+		return nil
+	})
+	return
+}
+
+func (s *redisStore) Locked(ctx context.Context, principal string) (remaining time.Duration, err error) {
+	remaining, err = s.client.PTTL(ctx, s.lockKey(principal)).Result()
+	if err != nil {
+		return 0, err
+	}
+	// a missing key has a negative ttl
+	return max(0, remaining), nil
+}
+
+func (s *redisStore) queriesKey(principal string) string {
+	return s.prefix + "queries:" + principal
+}
+
+func (s *redisStore) lockKey(principal string) string {
+	return s.prefix + "lock:" + principal
+}
@@ -0,0 +1,25 @@
+package enumeration
+
+import (
+	"context"
+	"time"
+)
+
+// Store keeps the queries of the principals for the longest window and their lockouts.
+type Store interface {
+	// Observe records the query seen at now, it returns when it was seen before, zero when it was not, and the number
+	// of distinct queries seen within each window.
+	Observe(ctx context.Context, principal string, query string, now time.Time, windows []time.Duration) (previous time.Time, distinct []int, err error)
+	// Lock locks the principal out for d and forgets its queries.
+	Lock(ctx context.Context, principal string, d time.Duration) (err error)
+	// Locked returns the remaining lockout of the principal, zero when it is not locked out.
+	Locked(ctx context.Context, principal string) (remaining time.Duration, err error)
+}
+
+// retention is the longest of the windows.
+func retention(windows []time.Duration) (longest time.Duration) {
+	for _, window := range windows {
+		longest = max(longest, window)
+	}
+	return
+}
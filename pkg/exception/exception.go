@@ -16,4 +16,6 @@ var (
 	ErrTimeout             error = fmt.Errorf("Request time out")
 	ErrLocked              error = fmt.Errorf("Locked")
 	ErrForbidden           error = fmt.Errorf("Forbidden")
+	ErrTooManyRequests     error = fmt.Errorf("Too many requests")
+	ErrPayloadTooLarge     error = fmt.Errorf("Payload too large")
 )
@@ -0,0 +1,85 @@
+package hook
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"pii-encrypt-example/pkg/pii"
+
+	"github.com/sirupsen/logrus"
+)
+
+// redacted replaces the value of a sensitive field.
+const redacted = "[REDACTED]"
+
+// DefaultSensitiveKeys are the fields whose value is never logged, compared without case, "_", "-" nor ".".
+var DefaultSensitiveKeys = []string{
+	"name", "email", "phoneNumber", "nationalityId", "nik", "npwp", "dateOfBirth", "address",
+	"password", "secret", "pepper", "passphrase", "token", "authorization", "cookie",
+}
+
+type redactionHook struct {
+	sensitiveKeys map[string]struct{}
+}
+
+// NewRedactionHook returns a hook masking the PII of every entry, it must be added before any other hook
+// so the others, and the formatter, only see the masked entry.
+func NewRedactionHook(sensitiveKeys ...string) logrus.Hook {
+	keys := make(map[string]struct{}, len(sensitiveKeys))
+	for _, key := range sensitiveKeys {
+		keys[normalizeKey(key)] = struct{}{}
+	}
+	return &redactionHook{sensitiveKeys: keys}
+}
+
+// Levels implements logrus.Hook interface, this hook applies to all defined levels
+func (h *redactionHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+// Fire implements logrus.Hook interface, it masks the PII found in the message and in the fields,
+// the value of a sensitive field is dropped entirely.
+func (h *redactionHook) Fire(e *logrus.Entry) error {
+	e.Message = pii.Redact(e.Message)
+
+	for key, value := range e.Data {
+		if h.sensitive(key) {
+			e.Data[key] = redacted
+			continue
+		}
+
+		switch v := value.(type) {
+		case nil, bool, int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64, time.Time, time.Duration:
+		case string:
+			e.Data[key] = pii.Redact(v)
+		case error:
+			if s := v.Error(); pii.Redact(s) != s {
+				e.Data[key] = pii.Redact(s)
+			}
+		default:
+			// e.g. a struct, which the formatter would print as is
+			if s := fmt.Sprintf("%+v", v); pii.Redact(s) != s {
+				e.Data[key] = pii.Redact(s)
+			}
+		}
+	}
+
+	return nil
+}
+
+// sensitive reports whether the key, or its last dotted segment, is a sensitive key.
+func (h *redactionHook) sensitive(key string) bool {
+	if _, ok := h.sensitiveKeys[normalizeKey(key)]; ok {
+		return true
+	}
+	if i := strings.LastIndex(key, "."); i >= 0 {
+		_, ok := h.sensitiveKeys[normalizeKey(key[i+1:])]
+		return ok
+	}
+	return false
+}
+
+func normalizeKey(key string) string {
+	return strings.ToLower(strings.NewReplacer("_", "", "-", "", ".", "").Replace(key))
+}
@@ -0,0 +1,42 @@
+package hook_test
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+
+	"pii-encrypt-example/pkg/hook"
+)
+
+func TestRedactionHook(t *testing.T) {
+	var out bytes.Buffer
+	logger := logrus.New()
+	logger.SetOutput(&out)
+	logger.SetFormatter(&logrus.JSONFormatter{})
+	logger.AddHook(hook.NewRedactionHook(hook.DefaultSensitiveKeys...))
+
+	type payload struct{ Email string }
+	logger.WithFields(logrus.Fields{
+		"phone_number":         "081234567890",
+		"mpv.client.x_real_ip": "10.0.0.1",
+		"query":                "SELECT * FROM user_encrypt WHERE uuid = ?",
+		"payload":              payload{Email: "budi@example.com"},
+		"count":                3,
+	}).WithError(errors.New("Duplicate entry '3171011501900001' for key 'nationality_id_hash'")).
+		Error("cannot register budi.santoso@example.co.id, +62 812-3456-7890: panic")
+
+	logged := out.String()
+	for _, plaintext := range []string{"081234567890", "budi@example.com", "3171011501900001", "budi.santoso@example.co.id", "812-3456-78"} {
+		if strings.Contains(logged, plaintext) {
+			t.Errorf("logged %q in %s", plaintext, logged)
+		}
+	}
+	for _, kept := range []string{`"phone_number":"[REDACTED]"`, `10.0.0.1`, `WHERE uuid = ?`, `"count":3`, `b***@example.com`, `3171**********01`, `b***********@example.co.id`} {
+		if !strings.Contains(logged, kept) {
+			t.Errorf("logged %s, want %s", logged, kept)
+		}
+	}
+}
@@ -0,0 +1,31 @@
+package hook
+
+import (
+	"pii-encrypt-example/entity"
+
+	"github.com/sirupsen/logrus"
+)
+
+type requestIDHook struct{}
+
+// NewRequestIDHook returns a hook adding the id of the request to the entries logged with its context.
+func NewRequestIDHook() logrus.Hook {
+	return &requestIDHook{}
+}
+
+// Levels implements logrus.Hook interface, this hook applies to all defined levels
+func (h *requestIDHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+// Fire implements logrus.Hook interface, attaches the request id found in entry context
+func (h *requestIDHook) Fire(e *logrus.Entry) error {
+	if e.Context == nil {
+		return nil
+	}
+
+	if requestID, ok := e.Context.Value(entity.RequestIDContextKey{}).(string); ok {
+		e.Data["mpv.request_id"] = requestID
+	}
+	return nil
+}
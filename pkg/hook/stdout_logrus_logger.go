@@ -43,6 +43,7 @@ func (d *stdoutLoggerHook) Fire(e *logrus.Entry) error {
 	e.Data["mpv.client.remote_address"] = clientDevice.RemoteAddress
 	e.Data["mpv.client.x_forwarded_for"] = clientDevice.XForwardedFor
 	e.Data["mpv.client.x_real_ip"] = clientDevice.XRealIP
+	e.Data["mpv.client.client_ip"] = clientDevice.ClientIP
 
 	return nil
 }
@@ -1,8 +1,10 @@
 package middleware
 
 import (
+	"context"
 	"net/http"
 
+	"pii-encrypt-example/entity"
 	"pii-encrypt-example/pkg/exception"
 	"pii-encrypt-example/pkg/response"
 )
@@ -26,7 +28,7 @@ func (ba *BasicAuth) respondUnauthorized(w http.ResponseWriter) {
 	response.JSON(w, resp)
 }
 
-// Verify will verify the request to ensure it comes with an authorized basic auth token.
+// Verify will verify the request to ensure it comes with an authorized basic auth token, the username becomes the principal of the request.
 func (ba *BasicAuth) Verify(next http.HandlerFunc) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		username, password, ok := r.BasicAuth()
@@ -39,6 +41,8 @@ func (ba *BasicAuth) Verify(next http.HandlerFunc) http.HandlerFunc {
 			ba.respondUnauthorized(w)
 			return
 		}
-		next(w, r)
+
+		ctx := context.WithValue(r.Context(), entity.PrincipalContextKey{}, username)
+		next(w, r.WithContext(ctx))
 	})
 }
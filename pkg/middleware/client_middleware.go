@@ -2,12 +2,48 @@ package middleware
 
 import (
 	"context"
+	"fmt"
+	"net"
 	"net/http"
+	"net/netip"
+	"strings"
 
 	"pii-encrypt-example/entity"
 )
 
-func ClientDeviceMiddleware(handler http.Handler) http.Handler {
+// ClientDevice records the client of every request in the context, see entity.ClientDevice.
+type ClientDevice struct {
+	trustedProxies []netip.Prefix
+}
+
+// NewClientDevice is a constructor, the forwarded headers are only believed when sent by one of the trusted proxies.
+func NewClientDevice(trustedProxies []netip.Prefix) *ClientDevice {
+	return &ClientDevice{trustedProxies: trustedProxies}
+}
+
+// ParseTrustedProxies parses the CIDRs of the trusted proxies, a single address is taken as its own network.
+func ParseTrustedProxies(cidrs []string) (prefixes []netip.Prefix, err error) {
+	for _, cidr := range cidrs {
+		if !strings.Contains(cidr, "/") {
+			addr, err := netip.ParseAddr(cidr)
+			if err != nil {
+				return nil, fmt.Errorf("trusted proxy %q: %w", cidr, err)
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("trusted proxy %q: %w", cidr, err)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return
+}
+
+// Handler records the client device of the request before calling handler.
+func (cd *ClientDevice) Handler(handler http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
 
@@ -17,6 +53,7 @@ func ClientDeviceMiddleware(handler http.Handler) http.Handler {
 			XRealIP:       r.Header.Get("X-Real-IP"),
 			UserAgent:     r.UserAgent(),
 		}
+		clientDevice.ClientIP = cd.clientIP(r)
 
 		ctx = context.WithValue(ctx, entity.ClientContextKey{}, clientDevice)
 		r = r.WithContext(ctx)
@@ -24,3 +61,63 @@ func ClientDeviceMiddleware(handler http.Handler) http.Handler {
 		handler.ServeHTTP(w, r)
 	})
 }
+
+// clientIP resolves the address of the client. The X-Forwarded-For header is read from the right, each proxy appending
+// the address it received the request from: the first address which is not a trusted proxy is the client, the
+// addresses on its left are whatever the client sent. X-Real-IP is only used when there is no X-Forwarded-For.
+func (cd *ClientDevice) clientIP(r *http.Request) string {
+	remote, ok := parseAddr(r.RemoteAddr)
+	if !ok {
+		return r.RemoteAddr
+	}
+	if !cd.trusted(remote) {
+		return remote.String()
+	}
+
+	// the values of a repeated header are read as a single list
+	var hops []string
+	for _, value := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(value, ",")...)
+	}
+
+	client := remote
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, ok := parseAddr(strings.TrimSpace(hops[i]))
+		if !ok {
+			// a malformed hop has not been appended by a trusted proxy, the last address is as far as it can go
+			return client.String()
+		}
+		client = hop
+		if !cd.trusted(hop) {
+			return client.String()
+		}
+	}
+
+	if len(hops) == 0 {
+		if realIP, ok := parseAddr(strings.TrimSpace(r.Header.Get("X-Real-IP"))); ok {
+			return realIP.String()
+		}
+	}
+	return client.String()
+}
+
+func (cd *ClientDevice) trusted(addr netip.Addr) bool {
+	for _, prefix := range cd.trustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseAddr parses an address with or without a port, e.g. "10.0.0.1", "10.0.0.1:5000" or "[::1]:5000".
+func parseAddr(s string) (addr netip.Addr, ok bool) {
+	if host, _, err := net.SplitHostPort(s); err == nil {
+		s = host
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return addr, false
+	}
+	return addr.Unmap(), true
+}
@@ -0,0 +1,60 @@
+package middleware_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"pii-encrypt-example/entity"
+	"pii-encrypt-example/pkg/middleware"
+)
+
+func TestClientDeviceClientIP(t *testing.T) {
+	trustedProxies, err := middleware.ParseTrustedProxies([]string{"10.0.0.0/8", "127.0.0.1", "fd00::/8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := middleware.ParseTrustedProxies([]string{"10.0.0.0/33"}); err == nil {
+		t.Fatal("ParseTrustedProxies() of an invalid cidr, want an error")
+	}
+
+	tests := []struct {
+		name          string
+		remoteAddress string
+		xForwardedFor []string
+		xRealIP       string
+		want          string
+	}{
+		{"direct", "203.0.113.7:5000", nil, "", "203.0.113.7"},
+		{"spoofed by a direct client", "203.0.113.7:5000", []string{"198.51.100.1"}, "198.51.100.2", "203.0.113.7"},
+		{"behind a proxy", "10.0.0.2:5000", []string{"203.0.113.7"}, "", "203.0.113.7"},
+		{"spoofed behind a proxy", "10.0.0.2:5000", []string{"198.51.100.1, 203.0.113.7"}, "", "203.0.113.7"},
+		{"behind two proxies", "127.0.0.1:5000", []string{"198.51.100.1, 203.0.113.7", "10.1.1.1"}, "", "203.0.113.7"},
+		{"only proxies", "10.0.0.2:5000", []string{"10.0.0.3"}, "", "10.0.0.3"},
+		{"malformed hop", "10.0.0.2:5000", []string{"203.0.113.7, unknown, 10.0.0.3"}, "", "10.0.0.3"},
+		{"real ip of a proxy", "10.0.0.2:5000", nil, "203.0.113.7", "203.0.113.7"},
+		{"ipv6", "[fd00::1]:5000", []string{"2001:db8::7"}, "", "2001:db8::7"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var clientDevice entity.ClientDevice
+			handler := middleware.NewClientDevice(trustedProxies).Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				clientDevice, _ = r.Context().Value(entity.ClientContextKey{}).(entity.ClientDevice)
+			}))
+
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tt.remoteAddress
+			for _, value := range tt.xForwardedFor {
+				r.Header.Add("X-Forwarded-For", value)
+			}
+			if tt.xRealIP != "" {
+				r.Header.Set("X-Real-IP", tt.xRealIP)
+			}
+			handler.ServeHTTP(httptest.NewRecorder(), r)
+
+			if clientDevice.ClientIP != tt.want {
+				t.Fatalf("ClientIP = %q, want %q", clientDevice.ClientIP, tt.want)
+			}
+		})
+	}
+}
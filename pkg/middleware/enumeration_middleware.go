@@ -0,0 +1,76 @@
+package middleware
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/sirupsen/logrus"
+
+	"pii-encrypt-example/entity"
+	"pii-encrypt-example/pkg/enumeration"
+	"pii-encrypt-example/pkg/exception"
+	"pii-encrypt-example/pkg/response"
+)
+
+const (
+	lockedMessage        = "Too many distinct queries, try again later"
+	stepUpRefusedMessage = "Too many distinct queries"
+	stepUpAction         = "search"
+)
+
+// EnumerationGuard is a route middleware feeding the lookups of the principals to the enumeration detector,
+// it requires a captcha from the principals to step up and refuses the principals locked out. Every caller of a basic
+// auth credential shares its principal, the lookups spread over several client ips add up.
+type EnumerationGuard struct {
+	logger   *logrus.Logger
+	detector *enumeration.Detector
+	captcha  RecaptchaRouteMiddleware
+}
+
+// NewEnumerationGuard is a constructor, without captcha the principals to step up are refused until their
+// distinct queries are back under the threshold. It must run after the authentication to know the principal.
+func NewEnumerationGuard(logger *logrus.Logger, detector *enumeration.Detector, captcha RecaptchaRouteMiddleware) *EnumerationGuard {
+	return &EnumerationGuard{
+		logger:   logger,
+		detector: detector,
+		captcha:  captcha,
+	}
+}
+
+// Verify observes the query of the request, the path and its sorted query string. A failing detector is logged and
+// lets the request through.
+func (eg *EnumerationGuard) Verify(next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		principal, ok := ctx.Value(entity.PrincipalContextKey{}).(string)
+		if !ok || principal == "" {
+			next(w, r)
+			return
+		}
+
+		verdict, err := eg.detector.Observe(ctx, principal, r.URL.Path+"?"+r.URL.Query().Encode())
+		if err != nil {
+			eg.logger.WithContext(ctx).Error("enumeration detector ", err)
+			next(w, r)
+			return
+		}
+
+		switch verdict.Level {
+		case enumeration.LevelLockout:
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Max(1, math.Ceil(verdict.RetryAfter.Seconds())))))
+			resp := response.NewErrorResponse(exception.ErrLocked, http.StatusLocked, nil, response.StatLocked, lockedMessage)
+			response.JSON(w, resp)
+		case enumeration.LevelStepUp:
+			if eg.captcha == nil {
+				resp := response.NewErrorResponse(exception.ErrForbidden, http.StatusForbidden, nil, response.StatForbidden, stepUpRefusedMessage)
+				response.JSON(w, resp)
+				return
+			}
+			eg.captcha.Verify(next, stepUpAction)(w, r)
+		default:
+			next(w, r)
+		}
+	})
+}
@@ -0,0 +1,82 @@
+package middleware_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"pii-encrypt-example/entity"
+	"pii-encrypt-example/pkg/enumeration"
+	"pii-encrypt-example/pkg/middleware"
+)
+
+func TestEnumerationGuardVerify(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	// accepts the token "human" only
+	recaptchaServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/recaptcha/api/siteverify" || r.PostFormValue("secret") != "secret" {
+			http.Error(w, "unexpected request", http.StatusBadRequest)
+			return
+		}
+		if r.PostFormValue("response") == "human" {
+			w.Write([]byte(`{"success":true,"score":0.9,"action":"search","hostname":"localhost"}`))
+			return
+		}
+		w.Write([]byte(`{"success":false,"error-codes":["invalid-input-response"]}`))
+	}))
+	defer recaptchaServer.Close()
+
+	detector := enumeration.NewDetector(logger, enumeration.NewMemoryStore(), []byte("key"), time.Minute,
+		enumeration.Window{Duration: time.Minute, StepUp: 2, Lockout: 4},
+	)
+	captcha := middleware.NewRecaptcha(logger, recaptchaServer.URL, "secret", "active", []string{"http://localhost"})
+	handler := middleware.NewEnumerationGuard(logger, detector, captcha).Verify(func(w http.ResponseWriter, r *http.Request) {})
+
+	clientIP := "203.0.113.7"
+	request := func(target, token string) *httptest.ResponseRecorder {
+		ctx := context.WithValue(context.Background(), entity.PrincipalContextKey{}, "support")
+		ctx = context.WithValue(ctx, entity.ClientContextKey{}, entity.ClientDevice{ClientIP: clientIP})
+		r := httptest.NewRequest(http.MethodGet, target, nil).WithContext(ctx)
+		if token != "" {
+			r.Header.Set(middleware.HeaderRecaptchaToken, token)
+		}
+		w := httptest.NewRecorder()
+		handler(w, r)
+		return w
+	}
+
+	steps := []struct {
+		target string
+		token  string
+		want   int
+	}{
+		{"/api/v1/user?name=a", "", http.StatusOK},
+		{"/api/v1/user?name=a&x=1", "", http.StatusForbidden},
+		// the same query with its parameters in another order
+		{"/api/v1/user?x=1&name=a", "human", http.StatusOK},
+		{"/api/v1/user?name=b", "robot", http.StatusForbidden},
+		{"/api/v1/user/00000000-0000-0000-0000-000000000001", "human", http.StatusLocked},
+	}
+	for i, step := range steps {
+		if w := request(step.target, step.token); w.Code != step.want {
+			t.Fatalf("request #%d of %s = %d %s, want %d", i, step.target, w.Code, w.Body, step.want)
+		}
+	}
+
+	if w := request("/api/v1/user?name=a", "human"); w.Header().Get("Retry-After") != "60" {
+		t.Fatalf("request of a principal locked out Retry-After = %q, want 60", w.Header().Get("Retry-After"))
+	}
+
+	// another caller of the same credential is locked out as well
+	clientIP = "198.51.100.1"
+	if w := request("/api/v1/user?name=a", ""); w.Code != http.StatusLocked {
+		t.Fatalf("request of the principal from another client ip = %d %s, want %d", w.Code, w.Body, http.StatusLocked)
+	}
+}
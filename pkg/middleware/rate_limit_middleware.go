@@ -0,0 +1,116 @@
+package middleware
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/sirupsen/logrus"
+
+	"pii-encrypt-example/entity"
+	"pii-encrypt-example/pkg/exception"
+	"pii-encrypt-example/pkg/ratelimit"
+	"pii-encrypt-example/pkg/response"
+)
+
+const tooManyRequestsMessage = "Too many requests"
+
+// RateLimit is a route middleware taking a token of a bucket of the request, either the bucket of its client ip
+// or the bucket of its principal, the request is answered with 429 as soon as the bucket is empty.
+type RateLimit struct {
+	logger  *logrus.Logger
+	limiter ratelimit.Limiter
+	name    string
+	rule    ratelimit.Rule
+	key     func(ctx context.Context) (key string, ok bool)
+}
+
+// NewClientIPRateLimit is a constructor of the limit per client ip, name separates the budgets, e.g. the search queries
+// from the other requests. It runs before the authentication, so the failed attempts are limited as well.
+func NewClientIPRateLimit(logger *logrus.Logger, limiter ratelimit.Limiter, name string, rule ratelimit.Rule) *RateLimit {
+	return &RateLimit{
+		logger:  logger,
+		limiter: limiter,
+		name:    name,
+		rule:    rule,
+		key:     clientIPKey,
+	}
+}
+
+// NewPrincipalRateLimit is a constructor of the limit per principal, it must run after the authentication to know the principal.
+// Every caller of a basic auth credential shares the bucket of its principal, whichever its client ip,
+// the limit per client ip running before the authentication keeps a single caller to its own budget.
+func NewPrincipalRateLimit(logger *logrus.Logger, limiter ratelimit.Limiter, name string, rule ratelimit.Rule) *RateLimit {
+	return &RateLimit{
+		logger:  logger,
+		limiter: limiter,
+		name:    name,
+		rule:    rule,
+		key:     principalKey,
+	}
+}
+
+// Chain returns the route middleware verifying the request with the middlewares in order.
+func Chain(middlewares ...RouteMiddleware) RouteMiddleware {
+	return chain(middlewares)
+}
+
+type chain []RouteMiddleware
+
+func (c chain) Verify(next http.HandlerFunc) http.HandlerFunc {
+	for i := len(c) - 1; i >= 0; i-- {
+		next = c[i].Verify(next)
+	}
+	return next
+}
+
+func clientIPKey(ctx context.Context) (key string, ok bool) {
+	clientDevice, ok := ctx.Value(entity.ClientContextKey{}).(entity.ClientDevice)
+	if !ok || clientDevice.ClientIP == "" {
+		return "", false
+	}
+	return "ip:" + clientDevice.ClientIP, true
+}
+
+func principalKey(ctx context.Context) (key string, ok bool) {
+	principal, ok := ctx.Value(entity.PrincipalContextKey{}).(string)
+	if !ok || principal == "" {
+		return "", false
+	}
+	return "principal:" + principal, true
+}
+
+// Verify limits the request, a request without a client ip or a principal to key the bucket is let through.
+// A failing limiter is logged and lets the request through.
+func (rl *RateLimit) Verify(next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if key, ok := rl.key(r.Context()); ok {
+			if !rl.allow(w, r, rl.name+":"+key, rl.rule) {
+				return
+			}
+		}
+
+		next(w, r)
+	})
+}
+
+// allow takes a token of the bucket of key, the request is answered with 429 when there is none.
+func (rl *RateLimit) allow(w http.ResponseWriter, r *http.Request, key string, rule ratelimit.Rule) bool {
+	ctx := r.Context()
+
+	decision, err := rl.limiter.Allow(ctx, key, rule)
+	if err != nil {
+		rl.logger.WithContext(ctx).Error("rate limit ", err)
+		return true
+	}
+	if decision.Allowed {
+		return true
+	}
+
+	rl.logger.WithContext(ctx).WithField("rate_limit.key", key).Warn("rate limit exceeded")
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Max(1, math.Ceil(decision.RetryAfter.Seconds())))))
+	resp := response.NewErrorResponse(exception.ErrTooManyRequests, http.StatusTooManyRequests, nil, response.StatTooManyRequests, tooManyRequestsMessage)
+	response.JSON(w, resp)
+	return false
+}
@@ -0,0 +1,57 @@
+package middleware_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+
+	"pii-encrypt-example/entity"
+	"pii-encrypt-example/pkg/middleware"
+	"pii-encrypt-example/pkg/ratelimit"
+)
+
+func TestRateLimitVerify(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	limiter := ratelimit.NewMemoryLimiter()
+	clientIPRateLimit := middleware.NewClientIPRateLimit(logger, limiter, "search", ratelimit.PerMinute(60, 3))
+	principalRateLimit := middleware.NewPrincipalRateLimit(logger, limiter, "search", ratelimit.PerMinute(60, 2))
+	basicAuth := middleware.NewBasicAuth("support", "secret")
+	handler := middleware.Chain(clientIPRateLimit, basicAuth, principalRateLimit).Verify(func(w http.ResponseWriter, r *http.Request) {})
+
+	request := func(password, clientIP string) *httptest.ResponseRecorder {
+		ctx := context.WithValue(context.Background(), entity.ClientContextKey{}, entity.ClientDevice{ClientIP: clientIP})
+		r := httptest.NewRequest(http.MethodGet, "/api/v1/user?name=x", nil).WithContext(ctx)
+		r.SetBasicAuth("support", password)
+		w := httptest.NewRecorder()
+		handler(w, r)
+		return w
+	}
+
+	// the client ip is limited before the authentication, the failed attempts included
+	for i := 0; i < 3; i++ {
+		if w := request("guess", "198.51.100.2"); w.Code != http.StatusUnauthorized {
+			t.Fatalf("request #%d with a wrong password = %d, want 401", i, w.Code)
+		}
+	}
+	if w := request("secret", "198.51.100.2"); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("request of the client ip = %d, want 429", w.Code)
+	}
+
+	for i := 0; i < 2; i++ {
+		if w := request("secret", "203.0.113.7"); w.Code != http.StatusOK {
+			t.Fatalf("request #%d = %d, want 200", i, w.Code)
+		}
+	}
+
+	// the bucket of the principal is shared by its client ips
+	w := request("secret", "198.51.100.1")
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "1" {
+		t.Fatalf("request of the principal from another client ip = %d, Retry-After %q, want 429 after 1s", w.Code, w.Header().Get("Retry-After"))
+	}
+}
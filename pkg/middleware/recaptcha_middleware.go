@@ -0,0 +1,167 @@
+package middleware
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"pii-encrypt-example/entity"
+	"pii-encrypt-example/pkg/exception"
+	"pii-encrypt-example/pkg/response"
+)
+
+const (
+	// HeaderRecaptchaToken is the header carrying the recaptcha token of the request.
+	HeaderRecaptchaToken = "X-Recaptcha-Token"
+
+	captchaRequiredMessage = "Captcha verification required"
+	// recaptchaMinScore is the lowest score of a recaptcha v3 token taken for a human
+	recaptchaMinScore = 0.5
+)
+
+// recaptchaResponse is the response of the siteverify api, the score and the action are only set by recaptcha v3.
+type recaptchaResponse struct {
+	Success    bool     `json:"success"`
+	Score      float64  `json:"score"`
+	Action     string   `json:"action"`
+	Hostname   string   `json:"hostname"`
+	ErrorCodes []string `json:"error-codes"`
+}
+
+// Recaptcha is a concrete struct of google recaptcha verifier.
+type Recaptcha struct {
+	logger         *logrus.Logger
+	client         *http.Client
+	host           string
+	secret         string
+	active         bool
+	allowedOrigins []string
+}
+
+// NewRecaptcha is a constructor, the requests are let through while status is not "active".
+// allowedOrigins are the hostnames the tokens may be issued for, "*" allows any.
+func NewRecaptcha(logger *logrus.Logger, host, secret, status string, allowedOrigins []string) RecaptchaRouteMiddleware {
+	if host == "" {
+		host = "https://www.google.com"
+	}
+	return &Recaptcha{
+		logger:         logger,
+		client:         &http.Client{Timeout: time.Second * 10},
+		host:           strings.TrimSuffix(host, "/"),
+		secret:         secret,
+		active:         status == "active",
+		allowedOrigins: allowedOrigins,
+	}
+}
+
+func (rc *Recaptcha) respondCaptchaRequired(w http.ResponseWriter) {
+	resp := response.NewErrorResponse(exception.ErrForbidden, http.StatusForbidden, nil, response.StatCaptchaRequired, captchaRequiredMessage)
+	response.JSON(w, resp)
+}
+
+// Verify will verify the recaptcha token of the request, the action of a v3 token must be one of actions when given.
+func (rc *Recaptcha) Verify(next http.HandlerFunc, actions ...string) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !rc.active {
+			next(w, r)
+			return
+		}
+
+		ctx := r.Context()
+		token := r.Header.Get(HeaderRecaptchaToken)
+		if token == "" {
+			rc.respondCaptchaRequired(w)
+			return
+		}
+
+		var clientIP string
+		if clientDevice, ok := ctx.Value(entity.ClientContextKey{}).(entity.ClientDevice); ok {
+			clientIP = clientDevice.ClientIP
+		}
+		result, err := rc.siteVerify(r, token, clientIP)
+		if err != nil {
+			rc.logger.WithContext(ctx).Error("recaptcha ", err)
+			resp := response.NewErrorResponse(exception.ErrInternalServer, http.StatusInternalServerError, nil, response.StatUnexpectedError, "")
+			response.JSON(w, resp)
+			return
+		}
+
+		if !rc.valid(result, actions) {
+			rc.logger.WithContext(ctx).WithFields(logrus.Fields{
+				"recaptcha.score":       result.Score,
+				"recaptcha.action":      result.Action,
+				"recaptcha.error_codes": strings.Join(result.ErrorCodes, ","),
+			}).Warn("recaptcha rejected")
+			rc.respondCaptchaRequired(w)
+			return
+		}
+
+		next(w, r)
+	})
+}
+
+func (rc *Recaptcha) siteVerify(r *http.Request, token, clientIP string) (result recaptchaResponse, err error) {
+	form := url.Values{"secret": {rc.secret}, "response": {token}}
+	if clientIP != "" {
+		form.Set("remoteip", clientIP)
+	}
+
+	req, err := http.NewRequestWithContext(r.Context(), http.MethodPost, rc.host+"/recaptcha/api/siteverify", strings.NewReader(form.Encode()))
+	if err != nil {
+		return
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	res, err := rc.client.Do(req)
+	if err != nil {
+		return
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		err = fmt.Errorf("siteverify responded %s", res.Status)
+		return
+	}
+	err = json.NewDecoder(res.Body).Decode(&result)
+	return
+}
+
+func (rc *Recaptcha) valid(result recaptchaResponse, actions []string) bool {
+	if !result.Success || !rc.allowedOrigin(result.Hostname) {
+		return false
+	}
+	// a v2 token has no action nor score
+	if result.Action == "" {
+		return true
+	}
+	if result.Score < recaptchaMinScore {
+		return false
+	}
+	if len(actions) == 0 {
+		return true
+	}
+	for _, action := range actions {
+		if result.Action == action {
+			return true
+		}
+	}
+	return false
+}
+
+func (rc *Recaptcha) allowedOrigin(hostname string) bool {
+	for _, origin := range rc.allowedOrigins {
+		origin = strings.TrimSpace(origin)
+		if origin == "*" || origin == hostname {
+			return true
+		}
+		if u, err := url.Parse(origin); err == nil && u.Hostname() == hostname {
+			return true
+		}
+	}
+	return false
+}
@@ -26,6 +26,10 @@ func (rm *Recovery) Handler(handler http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		defer func() {
 			if recovered := recover(); recovered != nil {
+				// an aborted response is cut short by the server, nothing can be written anymore
+				if recovered == http.ErrAbortHandler {
+					panic(recovered)
+				}
 
 				rm.logger.WithContext(r.Context()).WithFields(
 					logrus.Fields{
@@ -0,0 +1,32 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+
+	"github.com/google/uuid"
+
+	"pii-encrypt-example/entity"
+	"pii-encrypt-example/pkg/response"
+)
+
+// requestIDPattern restricts the request ids accepted from the callers, they end up in the logs and in the headers.
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._:\-]{1,128}$`)
+
+// RequestIDMiddleware keeps the X-Request-ID of the caller, or generates one, stores it in the context and echoes it
+// in the response. It must wrap every other middleware so their logs and responses carry it too.
+func RequestIDMiddleware(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := r.Header.Get(response.HeaderRequestID)
+		if !requestIDPattern.MatchString(requestID) {
+			requestID = uuid.New().String()
+		}
+
+		w.Header().Set(response.HeaderRequestID, requestID)
+		ctx := context.WithValue(r.Context(), entity.RequestIDContextKey{}, requestID)
+		r = r.WithContext(ctx)
+
+		handler.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,55 @@
+package middleware_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"pii-encrypt-example/entity"
+	"pii-encrypt-example/pkg/exception"
+	"pii-encrypt-example/pkg/middleware"
+	"pii-encrypt-example/pkg/response"
+)
+
+func TestRequestIDMiddleware(t *testing.T) {
+	var seen string
+	handler := middleware.RequestIDMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen, _ = r.Context().Value(entity.RequestIDContextKey{}).(string)
+		response.JSON(w, response.NewErrorResponse(exception.ErrNotFound, http.StatusNotFound, nil, response.StatNotFound, ""))
+	}))
+
+	tests := []struct {
+		name     string
+		header   string
+		accepted bool
+	}{
+		{"kept", "req-1234.abcd", true},
+		{"generated", "", false},
+		{"injected", "abc\"}\n{\"admin\":true", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.header != "" {
+				r.Header.Set(response.HeaderRequestID, tt.header)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			var body struct {
+				RequestID string `json:"requestId"`
+			}
+			if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+				t.Fatal(err)
+			}
+			echoed := w.Header().Get(response.HeaderRequestID)
+			if echoed == "" || echoed != seen || body.RequestID != seen {
+				t.Fatalf("request id in the context %q, the header %q and the body %q, want the same", seen, echoed, body.RequestID)
+			}
+			if (seen == tt.header) != tt.accepted {
+				t.Fatalf("request id %q from the header %q, accepted = %v", seen, tt.header, tt.accepted)
+			}
+		})
+	}
+}
@@ -0,0 +1,49 @@
+package outbox
+
+import (
+	"encoding/json"
+	"pii-encrypt-example/entity"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Message is the envelope of every event published by the relay, Data is the payload given to NewEvent.
+type Message struct {
+	ID            string          `json:"id"`
+	Type          string          `json:"type"`
+	AggregateType string          `json:"aggregateType"`
+	AggregateID   string          `json:"aggregateId"`
+	OccurredAt    time.Time       `json:"occurredAt"`
+	Data          json.RawMessage `json:"data"`
+}
+
+// NewEvent builds the outbox event of a change to the aggregate identified by aggregateID.
+// The data is published as is, so it must never hold plaintext PII.
+func NewEvent(aggregateType string, aggregateID string, eventType string, data interface{}, occurredAt time.Time) (event entity.OutboxEvent, err error) {
+	message := Message{
+		ID:            uuid.New().String(),
+		Type:          eventType,
+		AggregateType: aggregateType,
+		AggregateID:   aggregateID,
+		OccurredAt:    occurredAt,
+	}
+	if message.Data, err = json.Marshal(data); err != nil {
+		return
+	}
+
+	payload, err := json.Marshal(message)
+	if err != nil {
+		return
+	}
+
+	event = entity.OutboxEvent{
+		EventID:       message.ID,
+		AggregateType: aggregateType,
+		AggregateID:   aggregateID,
+		Type:          eventType,
+		Payload:       payload,
+		CreatedAt:     occurredAt,
+	}
+	return
+}
@@ -0,0 +1,151 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"pii-encrypt-example/entity"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/sirupsen/logrus"
+)
+
+// claimTimeout is how long the events claimed by a relay are left to it, after which another relay publishes them.
+// It must outlast the sending of a batch, retries included.
+const claimTimeout = time.Minute * 5
+
+// Relay publishes the outbox events to kafka, the events of an aggregate in the order they were recorded.
+// Only the oldest unpublished event of each aggregate is claimed, so an event is sent once the previous one of its
+// aggregate has been acknowledged, and an event which failed holds back the later ones until it is sent again.
+// An event is marked as published only after the broker acknowledged it, so it is delivered at least once:
+// consumers must be ready to see the same event id twice.
+type Relay struct {
+	logger     *logrus.Logger
+	repository OutboxRepository
+	producer   sarama.SyncProducer
+	topic      string
+	interval   time.Duration
+	batchSize  int
+}
+
+// NewRelay is a constructor, the producer must be configured with Producer.Return.Successes.
+func NewRelay(logger *logrus.Logger, repository OutboxRepository, producer sarama.SyncProducer, topic string, interval time.Duration, batchSize int) *Relay {
+	return &Relay{
+		logger:     logger,
+		repository: repository,
+		producer:   producer,
+		topic:      topic,
+		interval:   interval,
+		batchSize:  batchSize,
+	}
+}
+
+// Run publishes the pending events until ctx is done. A full batch is followed right away by the next one.
+func (r *Relay) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		published, err := r.Publish(ctx)
+		if err != nil && ctx.Err() == nil {
+			r.logger.WithContext(ctx).Error(err)
+		}
+		if err == nil && published == r.batchSize {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Publish sends a single batch of pending events and returns how many of them were published.
+// The events are claimed in a short transaction and sent after it, so several relays may run against the same table
+// without holding the locks of the rows while the broker is waited for.
+func (r *Relay) Publish(ctx context.Context) (published int, err error) {
+	events, err := r.claim(ctx)
+	if err != nil || len(events) == 0 {
+		return
+	}
+
+	messages := make([]*sarama.ProducerMessage, len(events))
+	for i, event := range events {
+		messages[i] = &sarama.ProducerMessage{
+			Topic: r.topic,
+			// the aggregate id keeps the events of a user in the same partition, a batch holds one event per aggregate.
+			Key:   sarama.StringEncoder(event.AggregateID),
+			Value: sarama.ByteEncoder(event.Payload),
+			Headers: []sarama.RecordHeader{
+				{Key: []byte("event-id"), Value: []byte(event.EventID)},
+				{Key: []byte("event-type"), Value: []byte(event.Type)},
+			},
+			Metadata: event.EventID,
+		}
+	}
+
+	errSend := r.producer.SendMessages(messages)
+
+	failed := make(map[string]struct{})
+	var producerErrors sarama.ProducerErrors
+	if errors.As(errSend, &producerErrors) {
+		for _, producerError := range producerErrors {
+			if eventID, ok := producerError.Msg.Metadata.(string); ok {
+				failed[eventID] = struct{}{}
+			}
+		}
+	} else if errSend != nil {
+		for _, event := range events {
+			failed[event.EventID] = struct{}{}
+		}
+	}
+
+	eventIDs := make([]string, 0, len(events))
+	failedIDs := make([]string, 0, len(failed))
+	for _, event := range events {
+		if _, found := failed[event.EventID]; found {
+			failedIDs = append(failedIDs, event.EventID)
+		} else {
+			eventIDs = append(eventIDs, event.EventID)
+		}
+	}
+
+	// the events left claimed are published again once their claim has expired
+	if err = r.repository.MarkPublished(ctx, eventIDs, time.Now(), nil); err != nil {
+		return
+	}
+	if err = r.repository.ReleaseEvents(ctx, failedIDs, nil); err != nil {
+		return
+	}
+
+	return len(eventIDs), errSend
+}
+
+// claim claims a batch of pending events for claimTimeout.
+func (r *Relay) claim(ctx context.Context) (events []entity.OutboxEvent, err error) {
+	tx, err := r.repository.BeginTx(ctx)
+	if err != nil {
+		return
+	}
+	committed := false
+	defer func() {
+		if !committed {
+			if errRollback := r.repository.RollbackTx(ctx, tx); errRollback != nil {
+				r.logger.WithContext(ctx).Error(errRollback)
+			}
+		}
+	}()
+
+	// the claims are compared as text by sqlite, they are all written in utc
+	now := time.Now().UTC()
+	if events, err = r.repository.ClaimEvents(ctx, r.batchSize, now, now.Add(claimTimeout), tx); err != nil {
+		return
+	}
+	if err = r.repository.CommitTx(ctx, tx); err != nil {
+		return
+	}
+	committed = true
+	return
+}
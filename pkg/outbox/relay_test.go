@@ -0,0 +1,201 @@
+package outbox_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/Shopify/sarama/mocks"
+	"github.com/sirupsen/logrus"
+
+	"pii-encrypt-example/entity"
+	"pii-encrypt-example/pkg/database"
+	"pii-encrypt-example/pkg/outbox"
+)
+
+// memoryRepository keeps the events in memory, the transaction is ignored.
+// Like the other repositories, it only claims the oldest unpublished event of each aggregate.
+type memoryRepository struct {
+	events    []entity.OutboxEvent
+	claims    map[string]time.Time
+	committed int
+}
+
+func (r *memoryRepository) BeginTx(ctx context.Context) (database.Tx, error) { return nil, nil }
+func (r *memoryRepository) RollbackTx(ctx context.Context, tx database.Tx) error {
+	return nil
+}
+func (r *memoryRepository) CommitTx(ctx context.Context, tx database.Tx) error {
+	r.committed++
+	return nil
+}
+
+func (r *memoryRepository) SaveEvent(ctx context.Context, event entity.OutboxEvent, tx database.Tx) error {
+	event.ID = int64(len(r.events) + 1)
+	r.events = append(r.events, event)
+	return nil
+}
+
+func (r *memoryRepository) ClaimEvents(ctx context.Context, limit int, now time.Time, claimedUntil time.Time, tx database.Tx) (events []entity.OutboxEvent, err error) {
+	if r.claims == nil {
+		r.claims = make(map[string]time.Time)
+	}
+	held := make(map[string]bool)
+	for _, event := range r.events {
+		if event.PublishedAt != nil {
+			continue
+		}
+		if !held[event.AggregateID] && !r.claims[event.EventID].After(now) && len(events) < limit {
+			r.claims[event.EventID] = claimedUntil
+			events = append(events, event)
+		}
+		held[event.AggregateID] = true
+	}
+	return
+}
+
+func (r *memoryRepository) ReleaseEvents(ctx context.Context, eventIDs []string, tx database.Tx) error {
+	for _, eventID := range eventIDs {
+		delete(r.claims, eventID)
+	}
+	return nil
+}
+
+func (r *memoryRepository) MarkPublished(ctx context.Context, eventIDs []string, publishedAt time.Time, tx database.Tx) error {
+	for _, eventID := range eventIDs {
+		for i := range r.events {
+			if r.events[i].EventID == eventID {
+				r.events[i].PublishedAt = &publishedAt
+			}
+		}
+	}
+	return nil
+}
+
+func newTestLogger() *logrus.Logger {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	return logger
+}
+
+func TestRelayPublish(t *testing.T) {
+	ctx := context.Background()
+	repository := &memoryRepository{}
+	for _, id := range []string{"user-1", "user-2", "user-3"} {
+		event, err := outbox.NewEvent("user", id, "user.created", map[string]string{"uuid": id}, time.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+		repository.SaveEvent(ctx, event, nil)
+	}
+
+	producer := mocks.NewSyncProducer(t, nil)
+	defer producer.Close()
+	relay := outbox.NewRelay(newTestLogger(), repository, producer, "user-events", time.Second, 2)
+
+	producer.ExpectSendMessageWithCheckerFunctionAndSucceed(func(value []byte) error {
+		var message outbox.Message
+		if err := json.Unmarshal(value, &message); err != nil {
+			return err
+		}
+		if message.AggregateID != "user-1" || message.Type != "user.created" {
+			return errors.New("unexpected message " + string(value))
+		}
+		return nil
+	})
+	producer.ExpectSendMessageAndSucceed()
+
+	published, err := relay.Publish(ctx)
+	if err != nil || published != 2 {
+		t.Fatalf("Publish() = %d, %v, want 2 events", published, err)
+	}
+	if repository.committed != 1 {
+		t.Fatalf("Publish() committed %d claims, want 1", repository.committed)
+	}
+
+	// a failed batch stays in the outbox and is sent again, hence at least once.
+	producer.ExpectSendMessageAndFail(sarama.ErrNotLeaderForPartition)
+	if _, err := relay.Publish(ctx); err == nil {
+		t.Fatal("Publish() succeeded while the broker failed")
+	}
+	if repository.events[2].PublishedAt != nil {
+		t.Fatal("the failed event is marked as published")
+	}
+
+	producer.ExpectSendMessageAndSucceed()
+	published, err = relay.Publish(ctx)
+	if err != nil || published != 1 {
+		t.Fatalf("Publish() = %d, %v, want 1 event", published, err)
+	}
+
+	published, err = relay.Publish(ctx)
+	if err != nil || published != 0 {
+		t.Fatalf("Publish() = %d, %v, want nothing to publish", published, err)
+	}
+
+	// an event claimed by another relay is left to it
+	event, _ := outbox.NewEvent("user", "user-4", "user.created", nil, time.Now())
+	repository.SaveEvent(ctx, event, nil)
+	repository.ClaimEvents(ctx, 1, time.Now(), time.Now().Add(time.Minute), nil)
+	published, err = relay.Publish(ctx)
+	if err != nil || published != 0 {
+		t.Fatalf("Publish() = %d, %v, want the claimed event skipped", published, err)
+	}
+}
+
+// expectMessage expects the event of the aggregate to be sent.
+func expectMessage(producer *mocks.SyncProducer, aggregateID string, eventType string, err error) {
+	producer.ExpectSendMessageWithCheckerFunctionAndFail(func(value []byte) error {
+		var message outbox.Message
+		if err := json.Unmarshal(value, &message); err != nil {
+			return err
+		}
+		if message.AggregateID != aggregateID || message.Type != eventType {
+			return fmt.Errorf("sent %s of %s, want %s of %s", message.Type, message.AggregateID, eventType, aggregateID)
+		}
+		return nil
+	}, err)
+}
+
+func TestRelayPublishInOrderOfAggregate(t *testing.T) {
+	ctx := context.Background()
+	repository := &memoryRepository{}
+	for _, event := range []struct{ aggregateID, eventType string }{
+		{"user-1", "user.created"},
+		{"user-1", "user.updated"},
+		{"user-2", "user.created"},
+	} {
+		event, err := outbox.NewEvent("user", event.aggregateID, event.eventType, nil, time.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+		repository.SaveEvent(ctx, event, nil)
+	}
+
+	producer := mocks.NewSyncProducer(t, nil)
+	defer producer.Close()
+	relay := outbox.NewRelay(newTestLogger(), repository, producer, "user-events", time.Second, 10)
+
+	// the update is not sent along with the creation, which fails and holds it back
+	expectMessage(producer, "user-1", "user.created", sarama.ErrNotLeaderForPartition)
+	expectMessage(producer, "user-2", "user.created", nil)
+	if published, err := relay.Publish(ctx); err == nil || published != 0 {
+		t.Fatalf("Publish() = %d, %v, want the failure of the batch", published, err)
+	}
+
+	expectMessage(producer, "user-1", "user.created", nil)
+	expectMessage(producer, "user-2", "user.created", nil)
+	if published, err := relay.Publish(ctx); err != nil || published != 2 {
+		t.Fatalf("Publish() = %d, %v, want the creations sent again", published, err)
+	}
+
+	expectMessage(producer, "user-1", "user.updated", nil)
+	if published, err := relay.Publish(ctx); err != nil || published != 1 {
+		t.Fatalf("Publish() = %d, %v, want the update after the creation", published, err)
+	}
+}
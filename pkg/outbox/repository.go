@@ -0,0 +1,235 @@
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"pii-encrypt-example/entity"
+	"pii-encrypt-example/pkg/database"
+	"pii-encrypt-example/pkg/exception"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const eventSelectColumns = `e.id, e.event_id, e.aggregate_type, e.aggregate_id, e.type, e.payload, e.created_at, e.published_at`
+
+type OutboxRepository interface {
+	BeginTx(ctx context.Context) (tx database.Tx, err error)
+	RollbackTx(ctx context.Context, tx database.Tx) (err error)
+	CommitTx(ctx context.Context, tx database.Tx) (err error)
+	// SaveEvent must be given the transaction of the change the event describes.
+	SaveEvent(ctx context.Context, event entity.OutboxEvent, tx database.Tx) (err error)
+	// ClaimEvents claims the oldest unpublished events, which are not claimed by another relay at now, until claimedUntil.
+	// Only the oldest unpublished event of each aggregate is claimed, the next one waits until it is published.
+	// The events are locked until tx ends, the events locked by another relay are skipped.
+	ClaimEvents(ctx context.Context, limit int, now time.Time, claimedUntil time.Time, tx database.Tx) (events []entity.OutboxEvent, err error)
+	MarkPublished(ctx context.Context, eventIDs []string, publishedAt time.Time, tx database.Tx) (err error)
+	// ReleaseEvents gives up the claim of the events, so the next relay publishes them right away.
+	ReleaseEvents(ctx context.Context, eventIDs []string, tx database.Tx) (err error)
+}
+
+type sqlCommand interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	PrepareContext(ctx context.Context, query string) (*sql.Stmt, error)
+}
+
+type outboxRepository struct {
+	logger      *logrus.Logger
+	dialect     database.Dialect
+	dbReadWrite *sql.DB
+	tableName   string
+}
+
+// NewOutboxRepository is a constructor, the queries are written for the dialect of the database.
+func NewOutboxRepository(logger *logrus.Logger, dialect database.Dialect, dbReadWrite *sql.DB, tableName string) OutboxRepository {
+	return &outboxRepository{
+		logger:      logger,
+		dialect:     dialect,
+		dbReadWrite: dbReadWrite,
+		tableName:   tableName,
+	}
+}
+
+// BeginTx returns sql trx for global scope.
+func (r *outboxRepository) BeginTx(ctx context.Context) (tx database.Tx, err error) {
+	return r.dbReadWrite.BeginTx(ctx, nil)
+}
+
+// command returns the transaction to run the commands in, or the read write database when there is none.
+func (r *outboxRepository) command(tx database.Tx) (cmd sqlCommand, err error) {
+	if tx == nil {
+		return r.dbReadWrite, nil
+	}
+	sqlTx, ok := tx.(*sql.Tx)
+	if !ok {
+		return nil, database.ErrForeignTx
+	}
+	return sqlTx, nil
+}
+
+// CommitTx will commit the transaction that has began.
+func (r *outboxRepository) CommitTx(ctx context.Context, tx database.Tx) (err error) {
+	return tx.Commit()
+}
+
+// RollbackTx will rollback the transaction to achieve the consistency.
+func (r *outboxRepository) RollbackTx(ctx context.Context, tx database.Tx) (err error) {
+	return tx.Rollback()
+}
+
+func (r *outboxRepository) SaveEvent(ctx context.Context, event entity.OutboxEvent, tx database.Tx) (err error) {
+	cmd, err := r.command(tx)
+	if err != nil {
+		return
+	}
+
+	command := fmt.Sprintf(`INSERT INTO %s (event_id, aggregate_type, aggregate_id, type, payload, created_at) VALUES (?, ?, ?, ?, ?, ?)`, r.tableName)
+	_, err = r.exec(ctx, cmd, command, event.EventID, event.AggregateType, event.AggregateID, event.Type, event.Payload, event.CreatedAt)
+	if err != nil {
+		err = r.wrapError(err)
+		return
+	}
+
+	return
+}
+
+func (r *outboxRepository) ClaimEvents(ctx context.Context, limit int, now time.Time, claimedUntil time.Time, tx database.Tx) (events []entity.OutboxEvent, err error) {
+	// an event skipped because another relay locks it still holds back the later events of its aggregate
+	q := fmt.Sprintf(`SELECT %s FROM %s e WHERE e.published_at IS NULL AND (e.claimed_until IS NULL OR e.claimed_until < ?) AND e.id = (SELECT MIN(o.id) FROM %s o WHERE o.aggregate_id = e.aggregate_id AND o.published_at IS NULL) ORDER BY e.id LIMIT ?%s`, eventSelectColumns, r.tableName, r.tableName, r.dialect.SkipLocked())
+
+	cmd, err := r.command(tx)
+	if err != nil {
+		return
+	}
+
+	rows, err := cmd.QueryContext(ctx, r.dialect.Rebind(q), now, limit)
+	if err != nil {
+		r.logger.WithContext(ctx).Error(q, err)
+		err = r.wrapError(err)
+		return
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			r.logger.WithContext(ctx).Error(q, err)
+		}
+	}()
+
+	for rows.Next() {
+		var event entity.OutboxEvent
+		var publishedAt sql.NullTime
+		if err = rows.Scan(&event.ID, &event.EventID, &event.AggregateType, &event.AggregateID, &event.Type, &event.Payload, &event.CreatedAt, &publishedAt); err != nil {
+			r.logger.WithContext(ctx).Error(q, err)
+			err = r.wrapError(err)
+			return
+		}
+		if publishedAt.Valid {
+			event.PublishedAt = &publishedAt.Time
+		}
+		events = append(events, event)
+	}
+
+	if err = rows.Err(); err != nil {
+		r.logger.WithContext(ctx).Error(q, err)
+		err = r.wrapError(err)
+		return
+	}
+	if len(events) == 0 {
+		return
+	}
+
+	params := []interface{}{claimedUntil}
+	for _, event := range events {
+		params = append(params, event.EventID)
+	}
+
+	command := fmt.Sprintf(`UPDATE %s SET claimed_until = ? WHERE event_id IN (%s)`, r.tableName, placeholders(len(events)))
+	if _, err = r.exec(ctx, cmd, command, params...); err != nil {
+		return nil, r.wrapError(err)
+	}
+	return
+}
+
+func (r *outboxRepository) MarkPublished(ctx context.Context, eventIDs []string, publishedAt time.Time, tx database.Tx) (err error) {
+	cmd, err := r.command(tx)
+	if err != nil {
+		return
+	}
+	if len(eventIDs) == 0 {
+		return
+	}
+
+	params := []interface{}{publishedAt}
+	for _, eventID := range eventIDs {
+		params = append(params, eventID)
+	}
+
+	command := fmt.Sprintf(`UPDATE %s SET published_at = ?, claimed_until = NULL WHERE event_id IN (%s)`, r.tableName, placeholders(len(eventIDs)))
+	_, err = r.exec(ctx, cmd, command, params...)
+	if err != nil {
+		err = r.wrapError(err)
+		return
+	}
+
+	return
+}
+
+func (r *outboxRepository) ReleaseEvents(ctx context.Context, eventIDs []string, tx database.Tx) (err error) {
+	cmd, err := r.command(tx)
+	if err != nil {
+		return
+	}
+	if len(eventIDs) == 0 {
+		return
+	}
+
+	params := make([]interface{}, 0, len(eventIDs))
+	for _, eventID := range eventIDs {
+		params = append(params, eventID)
+	}
+
+	command := fmt.Sprintf(`UPDATE %s SET claimed_until = NULL WHERE event_id IN (%s)`, r.tableName, placeholders(len(eventIDs)))
+	_, err = r.exec(ctx, cmd, command, params...)
+	if err != nil {
+		err = r.wrapError(err)
+		return
+	}
+
+	return
+}
+
+func (r *outboxRepository) exec(ctx context.Context, cmd sqlCommand, command string, args ...interface{}) (result sql.Result, err error) {
+	var stmt *sql.Stmt
+	if stmt, err = cmd.PrepareContext(ctx, r.dialect.Rebind(command)); err != nil {
+		r.logger.WithContext(ctx).Error(command, err)
+		return
+	}
+	defer func() {
+		if err := stmt.Close(); err != nil {
+			r.logger.WithContext(ctx).Error(command, err)
+		}
+	}()
+
+	if result, err = stmt.ExecContext(ctx, args...); err != nil {
+		r.logger.WithContext(ctx).Error(command, err)
+	}
+
+	return
+}
+
+func (r *outboxRepository) wrapError(e error) (err error) {
+	if e == sql.ErrNoRows {
+		return exception.ErrNotFound
+	}
+	if _, ok := r.dialect.DuplicateKey(e); ok {
+		return exception.ErrConflict
+	}
+	return exception.ErrInternalServer
+}
+
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
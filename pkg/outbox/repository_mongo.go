@@ -0,0 +1,210 @@
+package outbox
+
+import (
+	"context"
+	"pii-encrypt-example/entity"
+	"pii-encrypt-example/pkg/database"
+	"pii-encrypt-example/pkg/exception"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// eventDocument is the outbox event as stored in mongodb, the event id is the document id.
+type eventDocument struct {
+	EventID       string     `bson:"_id"`
+	AggregateType string     `bson:"aggregate_type"`
+	AggregateID   string     `bson:"aggregate_id"`
+	Type          string     `bson:"type"`
+	Payload       []byte     `bson:"payload"`
+	CreatedAt     time.Time  `bson:"created_at"`
+	PublishedAt   *time.Time `bson:"published_at"`
+	ClaimedUntil  *time.Time `bson:"claimed_until"`
+}
+
+type outboxMongoRepository struct {
+	logger     *logrus.Logger
+	client     *mongo.Client
+	collection *mongo.Collection
+}
+
+// NewOutboxMongoRepository is a constructor, the events must be saved in the transaction of a mongodb repository.
+// Mongodb cannot skip the events locked by another relay, the events are claimed one by one instead.
+func NewOutboxMongoRepository(logger *logrus.Logger, client *mongo.Client, databaseName string, collectionName string) OutboxRepository {
+	return &outboxMongoRepository{
+		logger:     logger,
+		client:     client,
+		collection: client.Database(databaseName).Collection(collectionName),
+	}
+}
+
+// CreateOutboxMongoIndexes creates the index used to find the unpublished events.
+func CreateOutboxMongoIndexes(ctx context.Context, client *mongo.Client, databaseName string, collectionName string) (err error) {
+	_, err = client.Database(databaseName).Collection(collectionName).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "published_at", Value: 1}, {Key: "created_at", Value: 1}},
+		Options: options.Index().SetName("idx_outbox_event_published_at"),
+	})
+	return
+}
+
+// BeginTx starts a transaction, which needs mongodb to run as a replica set.
+func (r *outboxMongoRepository) BeginTx(ctx context.Context) (tx database.Tx, err error) {
+	return database.BeginMongoTx(ctx, r.client)
+}
+
+// CommitTx will commit the transaction that has began.
+func (r *outboxMongoRepository) CommitTx(ctx context.Context, tx database.Tx) (err error) {
+	return tx.Commit()
+}
+
+// RollbackTx will rollback the transaction to achieve the consistency.
+func (r *outboxMongoRepository) RollbackTx(ctx context.Context, tx database.Tx) (err error) {
+	return tx.Rollback()
+}
+
+// txContext binds ctx to the transaction, if any.
+func (r *outboxMongoRepository) txContext(ctx context.Context, tx database.Tx) (context.Context, error) {
+	if tx == nil {
+		return ctx, nil
+	}
+	mongoTx, ok := tx.(*database.MongoTx)
+	if !ok {
+		return nil, database.ErrForeignTx
+	}
+	return mongoTx.Context(ctx), nil
+}
+
+func (r *outboxMongoRepository) SaveEvent(ctx context.Context, event entity.OutboxEvent, tx database.Tx) (err error) {
+	txCtx, err := r.txContext(ctx, tx)
+	if err != nil {
+		return
+	}
+
+	_, err = r.collection.InsertOne(txCtx, eventDocument{
+		EventID:       event.EventID,
+		AggregateType: event.AggregateType,
+		AggregateID:   event.AggregateID,
+		Type:          event.Type,
+		Payload:       event.Payload,
+		CreatedAt:     event.CreatedAt,
+	})
+	if err != nil {
+		r.logger.WithContext(ctx).Error("insert outbox event ", err)
+		err = r.wrapError(err)
+		return
+	}
+
+	return
+}
+
+func (r *outboxMongoRepository) ClaimEvents(ctx context.Context, limit int, now time.Time, claimedUntil time.Time, tx database.Tx) (events []entity.OutboxEvent, err error) {
+	txCtx, err := r.txContext(ctx, tx)
+	if err != nil {
+		return
+	}
+
+	// a missing claimed_until matches nil too
+	unclaimed := bson.M{
+		"published_at": nil,
+		"$or":          bson.A{bson.M{"claimed_until": nil}, bson.M{"claimed_until": bson.M{"$lt": now}}},
+	}
+	order := bson.D{{Key: "created_at", Value: 1}, {Key: "_id", Value: 1}}
+	// only the oldest unpublished event of each aggregate is claimable
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"published_at": nil}}},
+		{{Key: "$sort", Value: order}},
+		{{Key: "$group", Value: bson.M{"_id": "$aggregate_id", "event": bson.M{"$first": "$$ROOT"}}}},
+		{{Key: "$replaceRoot", Value: bson.M{"newRoot": "$event"}}},
+		{{Key: "$match", Value: unclaimed}},
+		{{Key: "$sort", Value: order}},
+		{{Key: "$limit", Value: int64(limit)}},
+	}
+	cursor, err := r.collection.Aggregate(txCtx, pipeline)
+	if err != nil {
+		r.logger.WithContext(ctx).Error("find outbox events ", err)
+		err = r.wrapError(err)
+		return
+	}
+
+	var documents []eventDocument
+	if err = cursor.All(txCtx, &documents); err != nil {
+		r.logger.WithContext(ctx).Error("find outbox events ", err)
+		err = r.wrapError(err)
+		return
+	}
+
+	for _, document := range documents {
+		// the event is skipped when another relay claimed it in the meantime
+		filter := bson.M{"_id": document.EventID}
+		for key, value := range unclaimed {
+			filter[key] = value
+		}
+		result, err := r.collection.UpdateOne(txCtx, filter, bson.M{"$set": bson.M{"claimed_until": claimedUntil}})
+		if err != nil {
+			r.logger.WithContext(ctx).Error("claim outbox event ", err)
+			return nil, r.wrapError(err)
+		}
+		if result.MatchedCount == 0 {
+			continue
+		}
+
+		events = append(events, entity.OutboxEvent{
+			EventID:       document.EventID,
+			AggregateType: document.AggregateType,
+			AggregateID:   document.AggregateID,
+			Type:          document.Type,
+			Payload:       document.Payload,
+			CreatedAt:     document.CreatedAt,
+			PublishedAt:   document.PublishedAt,
+		})
+	}
+	return
+}
+
+func (r *outboxMongoRepository) MarkPublished(ctx context.Context, eventIDs []string, publishedAt time.Time, tx database.Tx) (err error) {
+	txCtx, err := r.txContext(ctx, tx)
+	if err != nil {
+		return
+	}
+	if len(eventIDs) == 0 {
+		return
+	}
+
+	_, err = r.collection.UpdateMany(txCtx, bson.M{"_id": bson.M{"$in": eventIDs}}, bson.M{"$set": bson.M{"published_at": publishedAt, "claimed_until": nil}})
+	if err != nil {
+		r.logger.WithContext(ctx).Error("update outbox events ", err)
+		err = r.wrapError(err)
+		return
+	}
+
+	return
+}
+
+func (r *outboxMongoRepository) ReleaseEvents(ctx context.Context, eventIDs []string, tx database.Tx) (err error) {
+	txCtx, err := r.txContext(ctx, tx)
+	if err != nil {
+		return
+	}
+	if len(eventIDs) == 0 {
+		return
+	}
+
+	_, err = r.collection.UpdateMany(txCtx, bson.M{"_id": bson.M{"$in": eventIDs}}, bson.M{"$set": bson.M{"claimed_until": nil}})
+	if err != nil {
+		r.logger.WithContext(ctx).Error("release outbox events ", err)
+		err = r.wrapError(err)
+		return
+	}
+
+	return
+}
+
+func (r *outboxMongoRepository) wrapError(e error) (err error) {
+	if mongo.IsDuplicateKeyError(e) {
+		return exception.ErrConflict
+	}
+	return exception.ErrInternalServer
+}
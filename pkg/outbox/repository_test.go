@@ -0,0 +1,105 @@
+package outbox_test
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"pii-encrypt-example/migrations"
+	"pii-encrypt-example/pkg/database"
+	"pii-encrypt-example/pkg/exception"
+	"pii-encrypt-example/pkg/outbox"
+)
+
+func TestOutboxRepositoryClaimEvents(t *testing.T) {
+	ctx := context.Background()
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "outbox.db")+"?_pragma=busy_timeout(5000)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	migrator, err := database.NewMigrator(newTestLogger(), database.SQLite{}, db, migrations.FS, "schema_migrations")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := migrator.Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+	repository := outbox.NewOutboxRepository(newTestLogger(), database.SQLite{}, db, "outbox_event")
+
+	var eventIDs []string
+	for _, id := range []string{"user-1", "user-2", "user-3"} {
+		event, _ := outbox.NewEvent("user", id, "user.created", map[string]string{"uuid": id}, time.Now())
+		if err := repository.SaveEvent(ctx, event, nil); err != nil {
+			t.Fatal(err)
+		}
+		eventIDs = append(eventIDs, event.EventID)
+	}
+	event, _ := outbox.NewEvent("user", "user-1", "user.created", nil, time.Now())
+	event.EventID = eventIDs[0]
+	if err := repository.SaveEvent(ctx, event, nil); err != exception.ErrConflict {
+		t.Fatalf("SaveEvent() of a known event id = %v, want %v", err, exception.ErrConflict)
+	}
+
+	claim := func(now time.Time) (claimed []string) {
+		t.Helper()
+		events, err := repository.ClaimEvents(ctx, 2, now, now.Add(time.Minute), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, event := range events {
+			claimed = append(claimed, event.EventID)
+		}
+		return
+	}
+
+	now := time.Now().UTC()
+	if claimed := claim(now); len(claimed) != 2 || claimed[0] != eventIDs[0] || claimed[1] != eventIDs[1] {
+		t.Fatalf("ClaimEvents() = %v, want the 2 oldest events", claimed)
+	}
+	if claimed := claim(now); len(claimed) != 1 || claimed[0] != eventIDs[2] {
+		t.Fatalf("ClaimEvents() = %v, want the event left unclaimed", claimed)
+	}
+	if claimed := claim(now); len(claimed) != 0 {
+		t.Fatalf("ClaimEvents() = %v, want every event claimed already", claimed)
+	}
+
+	if err := repository.MarkPublished(ctx, eventIDs[:1], now, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := repository.ReleaseEvents(ctx, eventIDs[1:2], nil); err != nil {
+		t.Fatal(err)
+	}
+	if claimed := claim(now); len(claimed) != 1 || claimed[0] != eventIDs[1] {
+		t.Fatalf("ClaimEvents() = %v, want the released event", claimed)
+	}
+
+	// the claims have expired, the published event is never claimed again
+	if claimed := claim(now.Add(time.Hour)); len(claimed) != 2 || claimed[0] != eventIDs[1] || claimed[1] != eventIDs[2] {
+		t.Fatalf("ClaimEvents() after the claims expired = %v, want the unpublished events", claimed)
+	}
+
+	// the next event of an aggregate waits until the previous one is published, even when it is claimable
+	next, _ := outbox.NewEvent("user", "user-2", "user.updated", map[string]string{"uuid": "user-2"}, time.Now())
+	if err := repository.SaveEvent(ctx, next, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := repository.ReleaseEvents(ctx, eventIDs[1:], nil); err != nil {
+		t.Fatal(err)
+	}
+	if claimed := claim(now); len(claimed) != 2 || claimed[0] != eventIDs[1] || claimed[1] != eventIDs[2] {
+		t.Fatalf("ClaimEvents() = %v, want the oldest event of each aggregate", claimed)
+	}
+	if claimed := claim(now); len(claimed) != 0 {
+		t.Fatalf("ClaimEvents() = %v, want the next event held back by the claimed one", claimed)
+	}
+	if err := repository.MarkPublished(ctx, eventIDs[1:2], now, nil); err != nil {
+		t.Fatal(err)
+	}
+	if claimed := claim(now); len(claimed) != 1 || claimed[0] != next.EventID {
+		t.Fatalf("ClaimEvents() = %v, want the next event once the previous one is published", claimed)
+	}
+}
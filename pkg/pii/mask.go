@@ -0,0 +1,63 @@
+package pii
+
+import "strings"
+
+// Masks applied to a plaintext before it leaves the service in a masked form.
+const (
+	MaskEmailRule         = "email"
+	MaskPhoneNumberRule   = "phone"
+	MaskNationalityIDRule = "nik"
+	MaskNameRule          = "name"
+	MaskDateRule          = "date"
+	MaskFullRule          = "full"
+)
+
+const maskChar = "*"
+
+// Mask applies the named mask to s, an unknown name masks s entirely.
+func Mask(rule string, s string) string {
+	if s == "" {
+		return s
+	}
+
+	switch rule {
+	case MaskEmailRule:
+		return MaskEmail(s)
+	case MaskPhoneNumberRule:
+		return keepEnds(s, 4, 2)
+	case MaskNationalityIDRule:
+		return keepEnds(s, 4, 2)
+	case MaskNameRule:
+		return MaskName(s)
+	case MaskDateRule:
+		return "****-**-**"
+	}
+	return strings.Repeat(maskChar, len([]rune(s)))
+}
+
+// MaskEmail keeps the first character of the local part and the domain, e.g. "j***@mail.com".
+func MaskEmail(email string) string {
+	local, domain, ok := strings.Cut(email, "@")
+	if !ok {
+		return strings.Repeat(maskChar, len([]rune(email)))
+	}
+	return keepEnds(local, 1, 0) + "@" + domain
+}
+
+// MaskName keeps the initial of every word, e.g. "J*** D**".
+func MaskName(name string) string {
+	words := strings.Fields(name)
+	for i, w := range words {
+		words[i] = keepEnds(w, 1, 0)
+	}
+	return strings.Join(words, " ")
+}
+
+// keepEnds masks everything but the first head and the last tail characters of s.
+func keepEnds(s string, head int, tail int) string {
+	r := []rune(s)
+	if len(r) <= head+tail {
+		return strings.Repeat(maskChar, len(r))
+	}
+	return string(r[:head]) + strings.Repeat(maskChar, len(r)-head-tail) + string(r[len(r)-tail:])
+}
@@ -0,0 +1,42 @@
+package pii
+
+import "strings"
+
+// Normalizers applied to a plaintext before its blind index is computed.
+const (
+	NormalizeEmailRule       = "email"
+	NormalizePhoneNumberRule = "phone"
+)
+
+// Normalize applies the named normalizer to s, an unknown or empty name returns s unchanged.
+func Normalize(rule string, s string) string {
+	switch rule {
+	case NormalizeEmailRule:
+		return NormalizeEmail(s)
+	case NormalizePhoneNumberRule:
+		return NormalizePhoneNumber(s)
+	}
+	return s
+}
+
+// NormalizeEmail makes the blind index of an email case and whitespace insensitive,
+// so "John@Mail.com " and "john@mail.com" are treated as the same address.
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// NormalizePhoneNumber makes the blind index of a phone number independent of its notation,
+// so "+62 812-3456-789", "62812345678" and "0812345678" are treated as the same number.
+func NormalizePhoneNumber(phoneNumber string) string {
+	var b strings.Builder
+	for _, r := range phoneNumber {
+		if r >= '0' && r <= '9' {
+			b.WriteRune(r)
+		}
+	}
+	digits := b.String()
+	if strings.HasPrefix(digits, "0") {
+		digits = "62" + digits[1:]
+	}
+	return digits
+}
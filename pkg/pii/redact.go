@@ -0,0 +1,23 @@
+package pii
+
+import "regexp"
+
+// Patterns of the PII found in free text. A NIK is 16 digits, an indonesian mobile number starts with +62, 62 or 0
+// followed by 8, the separators commonly typed in between are tolerated.
+var (
+	emailPattern         = regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9\-]+(?:\.[A-Za-z0-9\-]+)*\.[A-Za-z]{2,}`)
+	nationalityIDPattern = regexp.MustCompile(`\b\d{16}\b`)
+	phoneNumberPattern   = regexp.MustCompile(`(?:\+62|\b62|\b0)[ \-]?8\d{1,3}[ \-]?\d{3,4}[ \-]?\d{3,5}\b`)
+)
+
+// Redact masks the emails, the NIKs and the phone numbers found in s, with the masks of the fields they belong to.
+func Redact(s string) string {
+	s = emailPattern.ReplaceAllStringFunc(s, MaskEmail)
+	s = nationalityIDPattern.ReplaceAllStringFunc(s, func(nik string) string {
+		return Mask(MaskNationalityIDRule, nik)
+	})
+	s = phoneNumberPattern.ReplaceAllStringFunc(s, func(phoneNumber string) string {
+		return Mask(MaskPhoneNumberRule, phoneNumber)
+	})
+	return s
+}
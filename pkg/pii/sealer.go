@@ -0,0 +1,249 @@
+package pii
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+
+	"pii-encrypt-example/pkg/crypto"
+)
+
+// tagName is the struct tag read by the sealer.
+//
+// The tag is put on the entity fields that hold ciphertext or a blind index:
+//
+//	NameCrypt  []byte `pii:"encrypt,mask=name"`
+//	NameHash   []byte `pii:"index"`
+//	EmailHash  []byte `pii:"index,normalize=email"`
+//	PhoneCrypt []byte `pii:"encrypt,field=PhoneNumber"`
+//
+// The plaintext field is looked up by name on the dto, by default it is the entity field name
+// without its "Crypt" or "Hash" suffix. Fields without the tag are copied as is when both sides share the same name and type.
+const tagName = "pii"
+
+const (
+	kindEncrypt = "encrypt"
+	kindIndex   = "index"
+)
+
+// Sealer encrypts and hashes dto fields into an entity and decrypts them back, driven by the pii struct tag of the entity.
+type Sealer struct {
+	crypto crypto.Crypto
+	fields sync.Map // reflect.Type -> []field
+}
+
+type field struct {
+	index     int
+	name      string
+	plainName string
+	kind      string
+	normalize string
+	mask      string
+}
+
+// NewSealer is a constructor.
+func NewSealer(crypto crypto.Crypto) *Sealer {
+	return &Sealer{crypto: crypto}
+}
+
+// Seal copies the dto into the entity pointed by dst, encrypting and hashing the annotated fields.
+// An empty plaintext is kept as nil so optional values are stored as NULL.
+func (s *Sealer) Seal(ctx context.Context, dto interface{}, dst interface{}) (err error) {
+	src, out, fields, err := s.prepare(dto, dst, dst)
+	if err != nil {
+		return
+	}
+
+	for _, f := range fields {
+		target := out.Field(f.index)
+		if f.kind == "" {
+			copyField(src, f.name, target)
+			continue
+		}
+
+		plainText, ok, err := plainValue(src, f.plainName)
+		if err != nil {
+			return err
+		}
+		if !ok || plainText == "" {
+			continue
+		}
+
+		switch f.kind {
+		case kindEncrypt:
+			cipherText, err := s.crypto.Encrypt(plainText)
+			if err != nil {
+				return err
+			}
+			target.SetBytes(cipherText)
+		case kindIndex:
+			target.SetBytes(s.crypto.Hash(Normalize(f.normalize, plainText)))
+		}
+	}
+
+	return
+}
+
+// Open copies the entity into the dto pointed by dst, decrypting the encrypted fields.
+// A field that cannot be decrypted is left empty and its error is returned once every other field is opened.
+func (s *Sealer) Open(ctx context.Context, entity interface{}, dst interface{}) (err error) {
+	return s.open(entity, dst, false)
+}
+
+// Mask works like Open but masks every decrypted field which declares a mask, so the result is safe to share.
+func (s *Sealer) Mask(ctx context.Context, entity interface{}, dst interface{}) (err error) {
+	return s.open(entity, dst, true)
+}
+
+func (s *Sealer) open(entity interface{}, dst interface{}, masked bool) (err error) {
+	src, out, fields, err := s.prepare(entity, dst, entity)
+	if err != nil {
+		return
+	}
+
+	var errs []error
+	for _, f := range fields {
+		switch f.kind {
+		case "":
+			if target := out.FieldByName(f.name); target.IsValid() {
+				copyField(src, f.name, target)
+			}
+		case kindEncrypt:
+			target := out.FieldByName(f.plainName)
+			if !target.IsValid() {
+				continue
+			}
+			cipherText := src.Field(f.index).Bytes()
+			if len(cipherText) == 0 {
+				continue
+			}
+			plainText, err := s.crypto.Decrypt(cipherText)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("pii: decrypt %s: %w", f.name, err))
+				continue
+			}
+			value := string(plainText)
+			if masked && f.mask != "" {
+				value = Mask(f.mask, value)
+			}
+			if err := setPlainValue(target, value); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// prepare dereferences both sides and reads the fields of the entity, which is either src or dst.
+func (s *Sealer) prepare(src interface{}, dst interface{}, entity interface{}) (in reflect.Value, out reflect.Value, fields []field, err error) {
+	out = reflect.ValueOf(dst)
+	if out.Kind() != reflect.Pointer || out.IsNil() || out.Elem().Kind() != reflect.Struct {
+		err = fmt.Errorf("pii: destination must be a non-nil pointer to struct, got %T", dst)
+		return
+	}
+	out = out.Elem()
+
+	in = reflect.Indirect(reflect.ValueOf(src))
+	if in.Kind() != reflect.Struct {
+		err = fmt.Errorf("pii: source must be a struct, got %T", src)
+		return
+	}
+
+	fields, err = s.fieldsOf(reflect.Indirect(reflect.ValueOf(entity)).Type())
+	return
+}
+
+func (s *Sealer) fieldsOf(t reflect.Type) ([]field, error) {
+	if cached, ok := s.fields.Load(t); ok {
+		return cached.([]field), nil
+	}
+
+	var fields []field
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if !sf.IsExported() {
+			continue
+		}
+
+		f := field{index: i, name: sf.Name}
+		tag, ok := sf.Tag.Lookup(tagName)
+		if !ok || tag == "-" {
+			fields = append(fields, f)
+			continue
+		}
+
+		for i, opt := range strings.Split(tag, ",") {
+			key, value, _ := strings.Cut(strings.TrimSpace(opt), "=")
+			if i == 0 {
+				f.kind = key
+				continue
+			}
+			switch key {
+			case "field":
+				f.plainName = value
+			case "normalize":
+				f.normalize = value
+			case "mask":
+				f.mask = value
+			default:
+				return nil, fmt.Errorf("pii: unknown option %q on %s.%s", key, t.Name(), sf.Name)
+			}
+		}
+
+		if f.kind != kindEncrypt && f.kind != kindIndex {
+			return nil, fmt.Errorf("pii: unknown kind %q on %s.%s", f.kind, t.Name(), sf.Name)
+		}
+		if sf.Type.Kind() != reflect.Slice || sf.Type.Elem().Kind() != reflect.Uint8 {
+			return nil, fmt.Errorf("pii: %s.%s must be []byte", t.Name(), sf.Name)
+		}
+		if f.plainName == "" {
+			f.plainName = strings.TrimSuffix(strings.TrimSuffix(sf.Name, "Crypt"), "Hash")
+		}
+
+		fields = append(fields, f)
+	}
+
+	s.fields.Store(t, fields)
+	return fields, nil
+}
+
+func copyField(src reflect.Value, name string, target reflect.Value) {
+	value := src.FieldByName(name)
+	if !value.IsValid() || !value.Type().AssignableTo(target.Type()) {
+		return
+	}
+	target.Set(value)
+}
+
+func plainValue(src reflect.Value, name string) (plainText string, ok bool, err error) {
+	value := src.FieldByName(name)
+	if !value.IsValid() {
+		return
+	}
+
+	switch {
+	case value.Kind() == reflect.String:
+		return value.String(), true, nil
+	case value.Kind() == reflect.Slice && value.Type().Elem().Kind() == reflect.Uint8:
+		return string(value.Bytes()), true, nil
+	}
+
+	err = fmt.Errorf("pii: unsupported plaintext field %s of type %s", name, value.Type())
+	return
+}
+
+func setPlainValue(target reflect.Value, plainText string) error {
+	switch {
+	case target.Kind() == reflect.String:
+		target.SetString(plainText)
+	case target.Kind() == reflect.Slice && target.Type().Elem().Kind() == reflect.Uint8:
+		target.SetBytes([]byte(plainText))
+	default:
+		return fmt.Errorf("pii: unsupported plaintext field of type %s", target.Type())
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package pii_test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"pii-encrypt-example/pkg/crypto"
+	"pii-encrypt-example/pkg/pii"
+)
+
+type person struct {
+	UUID       string
+	NameCrypt  []byte `pii:"encrypt,mask=name"`
+	NameHash   []byte `pii:"index"`
+	EmailCrypt []byte `pii:"encrypt,mask=email"`
+	EmailHash  []byte `pii:"index,normalize=email"`
+	PhoneCrypt []byte `pii:"encrypt,field=PhoneNumber"`
+}
+
+type personDTO struct {
+	UUID        string
+	Name        string
+	Email       string
+	PhoneNumber string
+}
+
+func TestSealer(t *testing.T) {
+	ctx := context.Background()
+	c := crypto.NewAESGCM("12345678901234567890123456789012", "1234567890123456")
+	sealer := pii.NewSealer(c)
+
+	dto := personDTO{UUID: "uuid", Name: "John Doe", Email: "John@Mail.com"}
+
+	var p person
+	if err := sealer.Seal(ctx, dto, &p); err != nil {
+		t.Fatal(err)
+	}
+	if p.UUID != dto.UUID {
+		t.Errorf("expected plain field to be copied, got %q", p.UUID)
+	}
+	if !bytes.Equal(p.NameHash, c.Hash("John Doe")) {
+		t.Error("expected name to be indexed")
+	}
+	if !bytes.Equal(p.EmailHash, c.Hash("john@mail.com")) {
+		t.Error("expected email to be normalized before indexing")
+	}
+	if p.PhoneCrypt != nil {
+		t.Error("expected empty plaintext to stay nil")
+	}
+
+	var opened personDTO
+	if err := sealer.Open(ctx, p, &opened); err != nil {
+		t.Fatal(err)
+	}
+	if opened != dto {
+		t.Errorf("expected %+v, got %+v", dto, opened)
+	}
+
+	var masked personDTO
+	if err := sealer.Mask(ctx, p, &masked); err != nil {
+		t.Fatal(err)
+	}
+	if masked.Name != "J*** D**" || masked.Email != "J***@Mail.com" {
+		t.Errorf("unexpected masked values %+v", masked)
+	}
+}
@@ -0,0 +1,83 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// sweepInterval is how often the buckets which are full again are dropped.
+const sweepInterval = time.Minute
+
+type bucket struct {
+	tokens    float64
+	updatedAt time.Time
+	rule      Rule
+}
+
+type memoryLimiter struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	sweptAt time.Time
+	now     func() time.Time
+}
+
+// NewMemoryLimiter keeps the buckets in memory, every instance of the app has its own budget.
+func NewMemoryLimiter() Limiter {
+	return &memoryLimiter{
+		buckets: make(map[string]*bucket),
+		sweptAt: time.Now(),
+		now:     time.Now,
+	}
+}
+
+func (l *memoryLimiter) Allow(ctx context.Context, key string, rule Rule) (decision Decision, err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	if now.Sub(l.sweptAt) >= sweepInterval {
+		l.sweep(now)
+	}
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(rule.Burst), updatedAt: now}
+		l.buckets[key] = b
+	}
+	b.rule = rule
+	b.refill(now)
+
+	if b.tokens < 1 {
+		return Decision{RetryAfter: retryAfter(1-b.tokens, rule)}, nil
+	}
+	b.tokens--
+	return Decision{Allowed: true, Remaining: int(b.tokens)}, nil
+}
+
+// sweep drops the buckets which are full again, they would be created the same.
+func (l *memoryLimiter) sweep(now time.Time) {
+	for key, b := range l.buckets {
+		b.refill(now)
+		if b.tokens >= float64(b.rule.Burst) {
+			delete(l.buckets, key)
+		}
+	}
+	l.sweptAt = now
+}
+
+func (b *bucket) refill(now time.Time) {
+	if elapsed := now.Sub(b.updatedAt).Seconds(); elapsed > 0 {
+		b.tokens = math.Min(float64(b.rule.Burst), b.tokens+elapsed*b.rule.Rate)
+	}
+	b.updatedAt = now
+}
+
+// retryAfter is the time to refill the missing tokens, a bucket which is never refilled is retried after an hour.
+func retryAfter(missing float64, rule Rule) time.Duration {
+	if rule.Rate <= 0 {
+		return time.Hour
+	}
+	return time.Duration(math.Ceil(missing / rule.Rate * float64(time.Second)))
+}
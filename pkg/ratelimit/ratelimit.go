@@ -0,0 +1,30 @@
+package ratelimit
+
+import (
+	"context"
+	"time"
+)
+
+// Rule is the budget of a token bucket: it holds up to Burst tokens and is refilled with Rate tokens per second,
+// every request takes a token.
+type Rule struct {
+	Rate  float64
+	Burst int
+}
+
+// PerMinute is the rule allowing n requests per minute with bursts of burst requests.
+func PerMinute(n int, burst int) Rule {
+	return Rule{Rate: float64(n) / 60, Burst: burst}
+}
+
+// Decision is the outcome of taking a token, RetryAfter is how long to wait for the next token once denied.
+type Decision struct {
+	Allowed    bool
+	Remaining  int
+	RetryAfter time.Duration
+}
+
+// Limiter takes a token of the bucket of key, the buckets are created full.
+type Limiter interface {
+	Allow(ctx context.Context, key string, rule Rule) (decision Decision, err error)
+}
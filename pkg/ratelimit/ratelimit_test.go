@@ -0,0 +1,58 @@
+package ratelimit_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+
+	"pii-encrypt-example/pkg/ratelimit"
+)
+
+// The redis limiter is tested against TEST_REDIS_ADDR, e.g. "localhost:6379", it is skipped when unset.
+func TestLimiterAllow(t *testing.T) {
+	limiters := map[string]func(t *testing.T) ratelimit.Limiter{
+		"memory": func(t *testing.T) ratelimit.Limiter { return ratelimit.NewMemoryLimiter() },
+		"redis": func(t *testing.T) ratelimit.Limiter {
+			addr := os.Getenv("TEST_REDIS_ADDR")
+			if addr == "" {
+				t.Skip("TEST_REDIS_ADDR is not set")
+			}
+			client := redis.NewClient(&redis.Options{Addr: addr})
+			t.Cleanup(func() { client.Close() })
+			return ratelimit.NewRedisLimiter(client, fmt.Sprintf("ratelimit_test:%d:", time.Now().UnixNano()))
+		},
+	}
+
+	for name, newLimiter := range limiters {
+		t.Run(name, func(t *testing.T) {
+			limiter := newLimiter(t)
+			ctx := context.Background()
+			rule := ratelimit.PerMinute(6, 3)
+
+			for i := 0; i < 3; i++ {
+				decision, err := limiter.Allow(ctx, "principal:support", rule)
+				if err != nil || !decision.Allowed || decision.Remaining != 2-i {
+					t.Fatalf("Allow() #%d = %+v, %v, want allowed with %d remaining", i, decision, err, 2-i)
+				}
+			}
+
+			decision, err := limiter.Allow(ctx, "principal:support", rule)
+			if err != nil || decision.Allowed {
+				t.Fatalf("Allow() of an empty bucket = %+v, %v, want denied", decision, err)
+			}
+			// a token every 10 seconds
+			if decision.RetryAfter <= 9*time.Second || decision.RetryAfter > 10*time.Second {
+				t.Fatalf("Allow() of an empty bucket RetryAfter = %s, want about 10s", decision.RetryAfter)
+			}
+
+			decision, err = limiter.Allow(ctx, "principal:other", rule)
+			if err != nil || !decision.Allowed {
+				t.Fatalf("Allow() of another key = %+v, %v, want allowed", decision, err)
+			}
+		})
+	}
+}
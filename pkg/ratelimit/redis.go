@@ -0,0 +1,83 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// tokenBucketScript takes a token of the bucket KEYS[1] atomically, ARGV holds the rate per second and the burst.
+// The clock of redis is used so the instances of the app share the same time. The numbers are written as strings,
+// redis would truncate them to integers. It returns whether the token is taken, the tokens left and the
+// milliseconds to wait for the next token.
+var tokenBucketScript = redis.NewScript(`
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local time = redis.call("TIME")
+local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+
+local bucket = redis.call("HMGET", KEYS[1], "tokens", "ts")
+local tokens = tonumber(bucket[1])
+local ts = tonumber(bucket[2])
+if tokens == nil or ts == nil then
+	tokens = burst
+	ts = now
+end
+if now > ts then
+	tokens = math.min(burst, tokens + (now - ts) / 1000 * rate)
+end
+
+local allowed = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+end
+
+local full = 3600000
+local retry = 0
+if rate > 0 then
+	full = math.ceil((burst - tokens) / rate * 1000)
+	if allowed == 0 then
+		retry = math.ceil((1 - tokens) / rate * 1000)
+	end
+elseif allowed == 0 then
+	retry = 3600000
+end
+
+redis.call("HSET", KEYS[1], "tokens", tostring(tokens), "ts", tostring(now))
+redis.call("PEXPIRE", KEYS[1], math.max(full, 1000))
+return {allowed, math.floor(tokens), retry}
+`)
+
+type redisLimiter struct {
+	client *redis.Client
+	prefix string
+}
+
+// NewRedisLimiter keeps the buckets in redis, the instances of the app share the same budget.
+// The buckets expire once they are full again.
+func NewRedisLimiter(client *redis.Client, prefix string) Limiter {
+	return &redisLimiter{
+		client: client,
+		prefix: prefix,
+	}
+}
+
+func (l *redisLimiter) Allow(ctx context.Context, key string, rule Rule) (decision Decision, err error) {
+	result, err := tokenBucketScript.Run(ctx, l.client, []string{l.prefix + key}, rule.Rate, rule.Burst).Int64Slice()
+	if err != nil {
+		return
+	}
+	if len(result) != 3 {
+		err = fmt.Errorf("ratelimit: unexpected reply %v", result)
+		return
+	}
+
+	return Decision{
+		Allowed:    result[0] == 1,
+		Remaining:  int(result[1]),
+		RetryAfter: time.Duration(result[2]) * time.Millisecond,
+	}, nil
+}
@@ -0,0 +1,9 @@
+package response
+
+// FieldError describes an invalid field of a request payload.
+// It never carries the submitted value, so it is safe to be returned and logged even when the field holds PII.
+type FieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
@@ -5,18 +5,22 @@ import (
 	"net/http"
 )
 
+// HeaderRequestID is the header carrying the id of the request, it is echoed in the error responses.
+const HeaderRequestID = "X-Request-ID"
+
 // REST is a collection of behavior of REST.
 type REST interface {
 	JSON(w http.ResponseWriter)
 }
 
 type restObject struct {
-	Success bool        `json:"success"`
-	Data    interface{} `json:"data"`
-	Message string      `json:"message"`
-	Status  string      `json:"status"`
-	Code    int         `json:"code"`
-	Meta    interface{} `json:"meta,omitempty"` // will not be appeared if not set.
+	Success   bool        `json:"success"`
+	Data      interface{} `json:"data"`
+	Message   string      `json:"message"`
+	Status    string      `json:"status"`
+	Code      int         `json:"code"`
+	Meta      interface{} `json:"meta,omitempty"`      // will not be appeared if not set.
+	RequestID string      `json:"requestId,omitempty"` // only set on the errors, to be quoted in the support tickets.
 	// can add more
 }
 
@@ -67,6 +71,9 @@ func JSON(w http.ResponseWriter, resp Response) {
 		Code:    resp.HTTPStatusCode(),
 		Meta:    resp.Meta(),
 	}
+	if !success {
+		ro.RequestID = w.Header().Get(HeaderRequestID)
+	}
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(ro.Code)
 	json.NewEncoder(w).Encode(ro)
@@ -43,4 +43,8 @@ const (
 	StatActionHasBeenTaken                string = "ACTION_HAS_BEEN_TAKEN"
 	StatResetPasswordRequestExceed        string = "RESET_PASSWORD_USER_EXCEED"
 	StatUnitNotVerified                   string = "UNIT_NOT_VERIFIED"
+	StatTooManyRequests                   string = "TOO_MANY_REQUESTS"
+	StatCaptchaRequired                   string = "CAPTCHA_REQUIRED"
+	StatLocked                            string = "LOCKED"
+	StatPayloadTooLarge                   string = "PAYLOAD_TOO_LARGE"
 )
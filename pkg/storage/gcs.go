@@ -1,37 +1,245 @@
 package storage
 
 import (
+	"bytes"
 	"context"
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"hash/crc32"
 	"io"
+	"os"
+	"strings"
 	"time"
 
 	gcstorage "cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
 )
 
+// ErrChecksumMismatch is returned when the uploaded object does not match the checksum of the data sent.
+var ErrChecksumMismatch = errors.New("storage: checksum of the uploaded object does not match")
+
+// Checksum is the integrity check done on every uploaded object.
+type Checksum int
+
+// Checksums supported by the gcs adapter.
+const (
+	ChecksumNone Checksum = iota
+	ChecksumCRC32C
+	ChecksumMD5
+)
+
+// ParseChecksum reads the name of a checksum: none, crc32c or md5.
+func ParseChecksum(name string) (checksum Checksum, err error) {
+	switch strings.ToLower(name) {
+	case "none":
+		return ChecksumNone, nil
+	case "", "crc32c":
+		return ChecksumCRC32C, nil
+	case "md5":
+		return ChecksumMD5, nil
+	}
+	return checksum, fmt.Errorf("storage: unknown checksum %q", name)
+}
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
 type gcsAdapter struct {
 	gcpAccessID                   string
 	gcpPrivateKey                 string
 	client                        *gcstorage.Client
 	defaultExpiresTimeOfSignedURL time.Duration
+	checksum                      Checksum
+	maxAttempts                   int
+	initialBackoff                time.Duration
+	maxBackoff                    time.Duration
+}
+
+// GCSOption configures the gcs adapter.
+type GCSOption func(gcs *gcsAdapter)
+
+// WithChecksum sets the integrity check of the uploads, CRC32C by default.
+func WithChecksum(checksum Checksum) GCSOption {
+	return func(gcs *gcsAdapter) {
+		gcs.checksum = checksum
+	}
+}
+
+// WithRetry sets how transient failures are retried, the backoff doubles after every attempt up to maxBackoff.
+// The whole upload is retried, also when its checksum does not match, from a temporary copy of its reader
+// unless the reader is an io.ReadSeeker. The client itself never retries an upload.
+func WithRetry(maxAttempts int, initialBackoff time.Duration, maxBackoff time.Duration) GCSOption {
+	return func(gcs *gcsAdapter) {
+		gcs.maxAttempts = maxAttempts
+		gcs.initialBackoff = initialBackoff
+		gcs.maxBackoff = maxBackoff
+	}
 }
 
-func NewGCSAdapter(client *gcstorage.Client, accessID, privateKey string) Storage {
-	return &gcsAdapter{
+func NewGCSAdapter(client *gcstorage.Client, accessID, privateKey string, opts ...GCSOption) Storage {
+	gcs := &gcsAdapter{
 		gcpAccessID:                   accessID,
 		gcpPrivateKey:                 privateKey,
 		client:                        client,
 		defaultExpiresTimeOfSignedURL: time.Second * 60,
+		checksum:                      ChecksumCRC32C,
+		maxAttempts:                   3,
+		initialBackoff:                time.Millisecond * 500,
+		maxBackoff:                    time.Second * 10,
 	}
+	for _, opt := range opts {
+		opt(gcs)
+	}
+	if gcs.maxAttempts < 1 {
+		gcs.maxAttempts = 1
+	}
+	return gcs
 }
 
+// PutObject uploads the file and verifies the checksum of the stored object against the data read from file.
+// An upload that fails, is cancelled through ctx or does not match its checksum never leaves an object behind.
 func (gcs *gcsAdapter) PutObject(ctx context.Context, bucketName string, filepath string, file io.Reader, contentType string, meta map[string]string) (err error) {
-	w := gcs.client.Bucket(bucketName).Object(filepath).NewWriter(ctx)
+	seeker, seekable := file.(io.ReadSeeker)
+	var start int64
+	if seekable {
+		if start, err = seeker.Seek(0, io.SeekCurrent); err != nil {
+			seekable = false
+		}
+	}
+	if !seekable && gcs.maxAttempts > 1 {
+		spool, err := spoolFile(file)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			spool.Close()
+			os.Remove(spool.Name())
+		}()
+		file, seeker, start = spool, spool, 0
+	}
+
+	backoff := gcs.initialBackoff
+	for attempt := 1; ; attempt++ {
+		err = gcs.putObject(ctx, bucketName, filepath, file, contentType, meta)
+		if err == nil || attempt >= gcs.maxAttempts || !(gcstorage.ShouldRetry(err) || errors.Is(err, ErrChecksumMismatch)) {
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > gcs.maxBackoff {
+			backoff = gcs.maxBackoff
+		}
+
+		if _, err = seeker.Seek(start, io.SeekStart); err != nil {
+			return
+		}
+	}
+}
+
+// spoolFile copies file to a temporary file, so an upload can be sent again.
+// The callers stream encrypted content, nothing is written to the disk in clear.
+func spoolFile(file io.Reader) (spool *os.File, err error) {
+	spool, err = os.CreateTemp("", "gcs-upload-*")
+	if err != nil {
+		return
+	}
+	if _, err = io.Copy(spool, file); err == nil {
+		_, err = spool.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		spool.Close()
+		os.Remove(spool.Name())
+		return nil, err
+	}
+	return
+}
+
+func (gcs *gcsAdapter) putObject(ctx context.Context, bucketName string, filepath string, file io.Reader, contentType string, meta map[string]string) (err error) {
+	// cancelling the context is the only way to abort an upload without creating the object.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// the retries are made by PutObject, a retry of the client would multiply its attempts.
+	object := gcs.client.Bucket(bucketName).Object(filepath).Retryer(gcstorage.WithPolicy(gcstorage.RetryNever))
+
+	w := object.NewWriter(ctx)
 	w.ContentType = contentType
 	w.Metadata = meta
 
-	io.Copy(w, file)
+	crc := crc32.New(crc32cTable)
+	digest := md5.New()
+	if _, err = io.Copy(w, io.TeeReader(file, io.MultiWriter(crc, digest))); err != nil {
+		cancel()
+		w.Close()
+		return
+	}
+
+	if err = w.Close(); err != nil {
+		return
+	}
+
+	attrs := w.Attrs()
+	switch gcs.checksum {
+	case ChecksumCRC32C:
+		if attrs.CRC32C == crc.Sum32() {
+			return nil
+		}
+	case ChecksumMD5:
+		if bytes.Equal(attrs.MD5, digest.Sum(nil)) {
+			return nil
+		}
+	default:
+		return nil
+	}
 
-	return w.Close()
+	// the object is already written, it is removed so nobody can read the corrupted content.
+	if errDelete := object.Generation(attrs.Generation).Delete(context.WithoutCancel(ctx)); errDelete != nil {
+		return fmt.Errorf("%w, and it cannot be deleted: %v", ErrChecksumMismatch, errDelete)
+	}
+	return ErrChecksumMismatch
+}
+
+func (gcs *gcsAdapter) GetObject(ctx context.Context, bucketName string, filepath string) (file io.ReadCloser, info ObjectInfo, err error) {
+	object := gcs.client.Bucket(bucketName).Object(filepath)
+
+	attrs, err := object.Attrs(ctx)
+	if err != nil {
+		err = gcsError(err)
+		return
+	}
+
+	// the reader is bound to the generation read above, so the content always matches its info.
+	file, err = object.Generation(attrs.Generation).NewReader(ctx)
+	if err != nil {
+		err = gcsError(err)
+		return
+	}
+
+	info = gcsObjectInfo(attrs)
+	return
+}
+
+func (gcs *gcsAdapter) DeleteObject(ctx context.Context, bucketName string, filepath string) (err error) {
+	return gcsError(gcs.client.Bucket(bucketName).Object(filepath).Delete(ctx))
+}
+
+func (gcs *gcsAdapter) ListObjects(ctx context.Context, bucketName string, prefix string) (objects []ObjectInfo, err error) {
+	it := gcs.client.Bucket(bucketName).Objects(ctx, &gcstorage.Query{Prefix: prefix})
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, gcsObjectInfo(attrs))
+	}
+	return
 }
 
 func (gcs *gcsAdapter) SignURL(ctx context.Context, method, bucketName, filepath string, expiresIn time.Duration) (url string, err error) {
@@ -45,3 +253,20 @@ func (gcs *gcsAdapter) SignURL(ctx context.Context, method, bucketName, filepath
 
 	return
 }
+
+func gcsObjectInfo(attrs *gcstorage.ObjectAttrs) ObjectInfo {
+	return ObjectInfo{
+		Path:        attrs.Name,
+		ContentType: attrs.ContentType,
+		Size:        attrs.Size,
+		Meta:        attrs.Metadata,
+		UpdatedAt:   attrs.Updated,
+	}
+}
+
+func gcsError(err error) error {
+	if errors.Is(err, gcstorage.ErrObjectNotExist) {
+		return ErrObjectNotFound
+	}
+	return err
+}
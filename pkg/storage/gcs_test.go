@@ -0,0 +1,144 @@
+package storage_test
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"hash/crc32"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	gcstorage "cloud.google.com/go/storage"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+
+	"pii-encrypt-example/pkg/storage"
+)
+
+// fakeGCS serves the multipart uploads and the deletes of the json api. The first unavailable uploads fail,
+// the first corrupt uploads after them are stored with a flipped byte so their checksum does not match.
+type fakeGCS struct {
+	mu          sync.Mutex
+	objects     map[string]string
+	unavailable int
+	corrupt     int
+	uploads     int
+	deletes     int
+}
+
+func (f *fakeGCS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch {
+	case r.Method == http.MethodPost && strings.HasPrefix(r.URL.Path, "/upload/storage/v1/b/"):
+		_, params, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		reader := multipart.NewReader(r.Body, params["boundary"])
+		if _, err := reader.NextPart(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		part, err := reader.NextPart()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		content, _ := io.ReadAll(part)
+
+		f.uploads++
+		if f.uploads <= f.unavailable {
+			http.Error(w, "backend error", http.StatusServiceUnavailable)
+			return
+		}
+		if f.uploads <= f.unavailable+f.corrupt {
+			content[0] ^= 0xff
+		}
+		name := r.URL.Query().Get("name")
+		f.objects[name] = string(content)
+
+		crc := make([]byte, 4)
+		binary.BigEndian.PutUint32(crc, crc32.Checksum(content, crc32.MakeTable(crc32.Castagnoli)))
+		json.NewEncoder(w).Encode(map[string]string{
+			"name":       name,
+			"generation": "1",
+			"crc32c":     base64.StdEncoding.EncodeToString(crc),
+		})
+	case r.Method == http.MethodDelete && strings.HasPrefix(r.URL.Path, "/storage/v1/b/"):
+		f.deletes++
+		delete(f.objects, r.URL.Path[strings.Index(r.URL.Path, "/o/")+len("/o/"):])
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "unexpected request", http.StatusNotImplemented)
+	}
+}
+
+func TestGCSAdapterPutObject(t *testing.T) {
+	cases := []struct {
+		name        string
+		maxAttempts int
+		unavailable int
+		corrupt     int
+		wantErr     error
+		wantUploads int
+	}{
+		{"uploaded", 3, 0, 0, nil, 1},
+		{"retried after a checksum mismatch", 3, 0, 2, nil, 3},
+		{"mismatch without retries", 1, 0, 1, storage.ErrChecksumMismatch, 1},
+		{"mismatch of every attempt", 2, 0, 2, storage.ErrChecksumMismatch, 2},
+		{"retried after a transient failure", 3, 1, 1, nil, 3},
+		{"transient failure of every attempt", 3, 3, 0, nil, 3},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ctx := context.Background()
+			fake := &fakeGCS{objects: make(map[string]string), unavailable: c.unavailable, corrupt: c.corrupt}
+			srv := httptest.NewServer(fake)
+			defer srv.Close()
+
+			client, err := gcstorage.NewClient(ctx, option.WithEndpoint(srv.URL+"/storage/v1/"), option.WithoutAuthentication())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer client.Close()
+			s := storage.NewGCSAdapter(client, "", "", storage.WithRetry(c.maxAttempts, 0, 0))
+
+			// the callers stream through a pipe, which cannot be read twice
+			pr, pw := io.Pipe()
+			go func() {
+				io.WriteString(pw, "encrypted content")
+				pw.Close()
+			}()
+
+			err = s.PutObject(ctx, "bucket", "exports/a.enc", pr, "application/octet-stream", nil)
+			var apiErr *googleapi.Error
+			if c.unavailable >= c.maxAttempts {
+				if !errors.As(err, &apiErr) || apiErr.Code != http.StatusServiceUnavailable {
+					t.Fatalf("PutObject() = %v, want the failure of the last attempt", err)
+				}
+			} else if !errors.Is(err, c.wantErr) {
+				t.Fatalf("PutObject() = %v, want %v", err, c.wantErr)
+			}
+			if fake.uploads != c.wantUploads || fake.deletes != c.corrupt {
+				t.Errorf("PutObject() made %d uploads and %d deletes, want %d and %d", fake.uploads, fake.deletes, c.wantUploads, c.corrupt)
+			}
+
+			content, ok := fake.objects["exports/a.enc"]
+			failed := c.wantErr != nil || c.unavailable >= c.maxAttempts
+			if !failed && content != "encrypted content" {
+				t.Errorf("stored object = %q, want the content sent", content)
+			}
+			if failed && ok {
+				t.Error("expected no object to be left by the failed upload")
+			}
+		})
+	}
+}
@@ -0,0 +1,75 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"pii-encrypt-example/pkg/exception"
+	"pii-encrypt-example/pkg/response"
+)
+
+// SignedURLHandler serves the urls signed by URLSigner for the storages without their own url signing,
+// i.e. GET downloads and PUT uploads an object. It expects the request path to be /{bucket}/{path},
+// so it has to be mounted with http.StripPrefix.
+type SignedURLHandler struct {
+	storage Storage
+	signer  *URLSigner
+}
+
+// NewSignedURLHandler is a constructor.
+func NewSignedURLHandler(storage Storage, signer *URLSigner) *SignedURLHandler {
+	return &SignedURLHandler{
+		storage: storage,
+		signer:  signer,
+	}
+}
+
+func (h *SignedURLHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	bucketName, filepath, ok := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if !ok || bucketName == "" || filepath == "" {
+		response.JSON(w, response.NewErrorResponse(exception.ErrNotFound, http.StatusNotFound, nil, response.StatNotFound, ""))
+		return
+	}
+
+	if err := h.signer.Verify(r.Method, bucketName, filepath, r.URL.Query()); err != nil {
+		response.JSON(w, response.NewErrorResponse(exception.ErrForbidden, http.StatusForbidden, nil, response.StatForbidden, err.Error()))
+		return
+	}
+
+	ctx := r.Context()
+	switch r.Method {
+	case http.MethodGet:
+		file, info, err := h.storage.GetObject(ctx, bucketName, filepath)
+		if err != nil {
+			h.respondError(w, err)
+			return
+		}
+		defer file.Close()
+
+		if info.ContentType != "" {
+			w.Header().Set("Content-Type", info.ContentType)
+		}
+		w.Header().Set("Content-Length", strconv.FormatInt(info.Size, 10))
+		w.WriteHeader(http.StatusOK)
+		io.Copy(w, file)
+	case http.MethodPut:
+		if err := h.storage.PutObject(ctx, bucketName, filepath, r.Body, r.Header.Get("Content-Type"), nil); err != nil {
+			h.respondError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	default:
+		response.JSON(w, response.NewErrorResponse(exception.ErrBadRequest, http.StatusMethodNotAllowed, nil, response.StatBadRequest, ""))
+	}
+}
+
+func (h *SignedURLHandler) respondError(w http.ResponseWriter, err error) {
+	if errors.Is(err, ErrObjectNotFound) {
+		response.JSON(w, response.NewErrorResponse(exception.ErrNotFound, http.StatusNotFound, nil, response.StatNotFound, ""))
+		return
+	}
+	response.JSON(w, response.NewErrorResponse(exception.ErrInternalServer, http.StatusInternalServerError, nil, response.StatUnexpectedError, ""))
+}
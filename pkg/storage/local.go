@@ -0,0 +1,238 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// metaDir is the directory, under the root, keeping the content type and metadata of every object.
+const metaDir = ".meta"
+
+type localAdapter struct {
+	root   string
+	signer *URLSigner
+}
+
+type localMeta struct {
+	ContentType string            `json:"contentType"`
+	Meta        map[string]string `json:"meta"`
+}
+
+// NewLocalAdapter stores the objects as files under root, one directory per bucket.
+// Its signed urls are served by SignedURLHandler.
+func NewLocalAdapter(root string, signer *URLSigner) Storage {
+	return &localAdapter{
+		root:   root,
+		signer: signer,
+	}
+}
+
+func (l *localAdapter) PutObject(ctx context.Context, bucketName string, filepath string, file io.Reader, contentType string, meta map[string]string) (err error) {
+	objectPath, metaPath, err := l.paths(bucketName, filepath)
+	if err != nil {
+		return
+	}
+
+	// the object is written to a temporary file first, so a failed upload never leaves a truncated object behind.
+	if err = writeFileAtomic(objectPath, func(w io.Writer) error {
+		_, err := io.Copy(w, contextReader{ctx, file})
+		return err
+	}); err != nil {
+		return
+	}
+
+	return writeFileAtomic(metaPath, func(w io.Writer) error {
+		return json.NewEncoder(w).Encode(localMeta{ContentType: contentType, Meta: meta})
+	})
+}
+
+func (l *localAdapter) GetObject(ctx context.Context, bucketName string, filepath string) (file io.ReadCloser, info ObjectInfo, err error) {
+	objectPath, metaPath, err := l.paths(bucketName, filepath)
+	if err != nil {
+		return
+	}
+
+	f, err := os.Open(objectPath)
+	if err != nil {
+		err = localError(err)
+		return
+	}
+
+	info, err = l.info(filepath, objectPath, metaPath)
+	if err != nil {
+		f.Close()
+		return
+	}
+
+	return f, info, nil
+}
+
+func (l *localAdapter) DeleteObject(ctx context.Context, bucketName string, filepath string) (err error) {
+	objectPath, metaPath, err := l.paths(bucketName, filepath)
+	if err != nil {
+		return
+	}
+
+	if err = os.Remove(objectPath); err != nil {
+		return localError(err)
+	}
+	if err = os.Remove(metaPath); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return
+	}
+	return nil
+}
+
+func (l *localAdapter) ListObjects(ctx context.Context, bucketName string, prefix string) (objects []ObjectInfo, err error) {
+	bucketPath, _, err := l.paths(bucketName, "")
+	if err != nil {
+		return
+	}
+
+	err = filepath.WalkDir(bucketPath, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() || strings.HasPrefix(d.Name(), ".tmp-") {
+			return nil
+		}
+
+		rel, err := filepath.Rel(bucketPath, p)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+		if !strings.HasPrefix(name, prefix) {
+			return nil
+		}
+
+		_, metaPath, err := l.paths(bucketName, name)
+		if err != nil {
+			return err
+		}
+		info, err := l.info(name, p, metaPath)
+		if err != nil {
+			return err
+		}
+		objects = append(objects, info)
+		return nil
+	})
+	return
+}
+
+func (l *localAdapter) SignURL(ctx context.Context, method, bucketName, filepath string, expiresIn time.Duration) (url string, err error) {
+	if _, _, err = l.paths(bucketName, filepath); err != nil {
+		return
+	}
+	return l.signer.Sign(method, bucketName, filepath, expiresIn), nil
+}
+
+// paths returns where an object and its metadata are kept, refusing any path escaping its bucket.
+func (l *localAdapter) paths(bucketName string, filepath string) (objectPath string, metaPath string, err error) {
+	if err = validObjectPath(bucketName, filepath); err != nil {
+		return
+	}
+
+	objectPath = path.Join(l.root, bucketName, filepath)
+	metaPath = path.Join(l.root, metaDir, bucketName, filepath+".json")
+	return
+}
+
+func (l *localAdapter) info(name string, objectPath string, metaPath string) (info ObjectInfo, err error) {
+	stat, err := os.Stat(objectPath)
+	if err != nil {
+		return info, localError(err)
+	}
+
+	info = ObjectInfo{
+		Path:      name,
+		Size:      stat.Size(),
+		UpdatedAt: stat.ModTime(),
+	}
+
+	b, err := os.ReadFile(metaPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return info, nil
+	}
+	if err != nil {
+		return
+	}
+
+	var meta localMeta
+	if err = json.Unmarshal(b, &meta); err != nil {
+		return
+	}
+	info.ContentType = meta.ContentType
+	info.Meta = meta.Meta
+	return
+}
+
+func writeFileAtomic(name string, write func(w io.Writer) error) (err error) {
+	dir := filepath.Dir(name)
+	if err = os.MkdirAll(dir, 0o750); err != nil {
+		return
+	}
+
+	tmp, err := os.CreateTemp(dir, ".tmp-*")
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	if err = write(tmp); err != nil {
+		return
+	}
+	if err = tmp.Close(); err != nil {
+		return
+	}
+	return os.Rename(tmp.Name(), name)
+}
+
+func validObjectPath(bucketName string, filepath string) error {
+	if bucketName == "" || strings.ContainsAny(bucketName, `/\`) || bucketName == "." || bucketName == ".." || bucketName == metaDir {
+		return fmt.Errorf("storage: invalid bucket name %q", bucketName)
+	}
+	if filepath == "" {
+		return nil
+	}
+	if strings.HasPrefix(filepath, "/") || path.Clean(filepath) != filepath || strings.HasPrefix(filepath, "../") || filepath == ".." {
+		return fmt.Errorf("storage: invalid object path %q", filepath)
+	}
+	return nil
+}
+
+func localError(err error) error {
+	if errors.Is(err, fs.ErrNotExist) {
+		return ErrObjectNotFound
+	}
+	return err
+}
+
+// contextReader stops reading once its context is done.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c contextReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
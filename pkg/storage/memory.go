@@ -0,0 +1,111 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type memoryAdapter struct {
+	mu      sync.RWMutex
+	objects map[string]memoryObject
+	signer  *URLSigner
+}
+
+type memoryObject struct {
+	data []byte
+	info ObjectInfo
+}
+
+// NewMemoryAdapter keeps the objects in memory, it is meant for development and tests.
+// Its signed urls are served by SignedURLHandler.
+func NewMemoryAdapter(signer *URLSigner) Storage {
+	return &memoryAdapter{
+		objects: make(map[string]memoryObject),
+		signer:  signer,
+	}
+}
+
+func (m *memoryAdapter) PutObject(ctx context.Context, bucketName string, filepath string, file io.Reader, contentType string, meta map[string]string) (err error) {
+	if err = validObjectPath(bucketName, filepath); err != nil {
+		return
+	}
+
+	data, err := io.ReadAll(contextReader{ctx, file})
+	if err != nil {
+		return
+	}
+
+	copiedMeta := make(map[string]string, len(meta))
+	for k, v := range meta {
+		copiedMeta[k] = v
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.objects[memoryKey(bucketName, filepath)] = memoryObject{
+		data: data,
+		info: ObjectInfo{
+			Path:        filepath,
+			ContentType: contentType,
+			Size:        int64(len(data)),
+			Meta:        copiedMeta,
+			UpdatedAt:   time.Now(),
+		},
+	}
+	return
+}
+
+func (m *memoryAdapter) GetObject(ctx context.Context, bucketName string, filepath string) (file io.ReadCloser, info ObjectInfo, err error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	object, ok := m.objects[memoryKey(bucketName, filepath)]
+	if !ok {
+		err = ErrObjectNotFound
+		return
+	}
+	return io.NopCloser(bytes.NewReader(object.data)), object.info, nil
+}
+
+func (m *memoryAdapter) DeleteObject(ctx context.Context, bucketName string, filepath string) (err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := memoryKey(bucketName, filepath)
+	if _, ok := m.objects[key]; !ok {
+		return ErrObjectNotFound
+	}
+	delete(m.objects, key)
+	return
+}
+
+func (m *memoryAdapter) ListObjects(ctx context.Context, bucketName string, prefix string) (objects []ObjectInfo, err error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	bucketPrefix := memoryKey(bucketName, prefix)
+	for key, object := range m.objects {
+		if strings.HasPrefix(key, bucketPrefix) {
+			objects = append(objects, object.info)
+		}
+	}
+
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Path < objects[j].Path })
+	return
+}
+
+func (m *memoryAdapter) SignURL(ctx context.Context, method, bucketName, filepath string, expiresIn time.Duration) (url string, err error) {
+	if err = validObjectPath(bucketName, filepath); err != nil {
+		return
+	}
+	return m.signer.Sign(method, bucketName, filepath, expiresIn), nil
+}
+
+func memoryKey(bucketName string, filepath string) string {
+	return bucketName + "/" + filepath
+}
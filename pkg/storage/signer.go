@@ -0,0 +1,97 @@
+package storage
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Errors returned by URLSigner.Verify.
+var (
+	ErrInvalidSignature = errors.New("storage: invalid url signature")
+	ErrExpiredURL       = errors.New("storage: url has expired")
+)
+
+// minSigningSecretSize is the shortest secret accepted by NewURLSigner, in bytes.
+const minSigningSecretSize = 32
+
+// placeholderSigningSecrets are the secrets found in the examples, which anybody could sign urls with.
+var placeholderSigningSecrets = []string{"change-me", "changeme", "secret"}
+
+// URLSigner signs the urls served by SignedURLHandler with HMAC-SHA256, the signature covers the method,
+// the bucket, the path and the expiry time, so a signed url cannot be reused for anything else.
+type URLSigner struct {
+	baseURL string
+	secret  []byte
+}
+
+// NewURLSigner is a constructor, baseURL is where SignedURLHandler is mounted, e.g. http://localhost:9091/storage.
+// The secret must be at least 32 bytes and not one of the placeholders of the examples.
+func NewURLSigner(baseURL string, secret []byte) (*URLSigner, error) {
+	for _, placeholder := range placeholderSigningSecrets {
+		if strings.EqualFold(string(secret), placeholder) {
+			return nil, errors.New("storage: the url signing secret is a placeholder")
+		}
+	}
+	if len(secret) < minSigningSecretSize {
+		return nil, fmt.Errorf("storage: the url signing secret must be at least %d bytes", minSigningSecretSize)
+	}
+	return &URLSigner{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		secret:  secret,
+	}, nil
+}
+
+// Sign returns the url granting method on the object until expiresIn has elapsed.
+func (s *URLSigner) Sign(method, bucketName, filepath string, expiresIn time.Duration) string {
+	expires := strconv.FormatInt(time.Now().Add(expiresIn).Unix(), 10)
+
+	query := url.Values{}
+	query.Set("expires", expires)
+	query.Set("signature", s.signature(method, bucketName, filepath, expires))
+
+	return fmt.Sprintf("%s/%s/%s?%s", s.baseURL, url.PathEscape(bucketName), escapePath(filepath), query.Encode())
+}
+
+// Verify checks the query of a signed url against the request it is used for.
+func (s *URLSigner) Verify(method, bucketName, filepath string, query url.Values) error {
+	expires := query.Get("expires")
+	signature, err := hex.DecodeString(query.Get("signature"))
+	if err != nil || expires == "" {
+		return ErrInvalidSignature
+	}
+
+	expected, _ := hex.DecodeString(s.signature(method, bucketName, filepath, expires))
+	if !hmac.Equal(signature, expected) {
+		return ErrInvalidSignature
+	}
+
+	unix, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+	if time.Now().After(time.Unix(unix, 0)) {
+		return ErrExpiredURL
+	}
+	return nil
+}
+
+func (s *URLSigner) signature(method, bucketName, filepath, expires string) string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(strings.Join([]string{strings.ToUpper(method), bucketName, filepath, expires}, "\n")))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func escapePath(filepath string) string {
+	segments := strings.Split(filepath, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return strings.Join(segments, "/")
+}
@@ -2,11 +2,27 @@ package storage
 
 import (
 	"context"
+	"errors"
 	"io"
 	"time"
 )
 
+// ErrObjectNotFound is returned when the requested object does not exist.
+var ErrObjectNotFound = errors.New("storage: object not found")
+
 type Storage interface {
 	PutObject(ctx context.Context, bucketName string, filepath string, file io.Reader, contentType string, meta map[string]string) (err error)
+	GetObject(ctx context.Context, bucketName string, filepath string) (file io.ReadCloser, info ObjectInfo, err error)
+	DeleteObject(ctx context.Context, bucketName string, filepath string) (err error)
+	ListObjects(ctx context.Context, bucketName string, prefix string) (objects []ObjectInfo, err error)
 	SignURL(ctx context.Context, method, bucketName, filepath string, expiresIn time.Duration) (url string, err error)
 }
+
+// ObjectInfo describes a stored object.
+type ObjectInfo struct {
+	Path        string
+	ContentType string
+	Size        int64
+	Meta        map[string]string
+	UpdatedAt   time.Time
+}
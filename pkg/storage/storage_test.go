@@ -0,0 +1,113 @@
+package storage_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"pii-encrypt-example/pkg/storage"
+)
+
+// testSigningSecret is a secret of the size NewURLSigner requires.
+const testSigningSecret = "0123456789abcdef0123456789abcdef"
+
+func TestAdapters(t *testing.T) {
+	adapters := map[string]func(signer *storage.URLSigner) storage.Storage{
+		"local": func(signer *storage.URLSigner) storage.Storage {
+			return storage.NewLocalAdapter(t.TempDir(), signer)
+		},
+		"memory": storage.NewMemoryAdapter,
+	}
+
+	for name, newAdapter := range adapters {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			mux := http.NewServeMux()
+			srv := httptest.NewServer(mux)
+			defer srv.Close()
+
+			signer, err := storage.NewURLSigner(srv.URL+"/storage", []byte(testSigningSecret))
+			if err != nil {
+				t.Fatal(err)
+			}
+			s := newAdapter(signer)
+			mux.Handle("/storage/", http.StripPrefix("/storage", storage.NewSignedURLHandler(s, signer)))
+
+			meta := map[string]string{"format": "csv"}
+			if err := s.PutObject(ctx, "bucket", "exports/a.csv", strings.NewReader("a,b"), "text/csv", meta); err != nil {
+				t.Fatal(err)
+			}
+			s.PutObject(ctx, "bucket", "documents/b.png", strings.NewReader("png"), "image/png", nil)
+
+			file, info, err := s.GetObject(ctx, "bucket", "exports/a.csv")
+			if err != nil {
+				t.Fatal(err)
+			}
+			content, _ := io.ReadAll(file)
+			file.Close()
+			if string(content) != "a,b" || info.ContentType != "text/csv" || info.Size != 3 || info.Meta["format"] != "csv" {
+				t.Errorf("unexpected object %q %+v", content, info)
+			}
+
+			objects, err := s.ListObjects(ctx, "bucket", "exports/")
+			if err != nil || len(objects) != 1 || objects[0].Path != "exports/a.csv" {
+				t.Errorf("unexpected listing %+v (%v)", objects, err)
+			}
+
+			url, err := s.SignURL(ctx, http.MethodGet, "bucket", "exports/a.csv", time.Minute)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp, err := http.Get(url)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK || string(body) != "a,b" {
+				t.Errorf("unexpected signed url response %d %q", resp.StatusCode, body)
+			}
+
+			resp, _ = http.Get(strings.Replace(url, "a.csv", "b.csv", 1))
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusForbidden {
+				t.Errorf("expected a tampered url to be forbidden, got %d", resp.StatusCode)
+			}
+
+			expired, _ := s.SignURL(ctx, http.MethodGet, "bucket", "exports/a.csv", -time.Minute)
+			resp, _ = http.Get(expired)
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusForbidden {
+				t.Errorf("expected an expired url to be forbidden, got %d", resp.StatusCode)
+			}
+
+			if err := s.DeleteObject(ctx, "bucket", "exports/a.csv"); err != nil {
+				t.Fatal(err)
+			}
+			if _, _, err := s.GetObject(ctx, "bucket", "exports/a.csv"); !errors.Is(err, storage.ErrObjectNotFound) {
+				t.Errorf("expected ErrObjectNotFound, got %v", err)
+			}
+		})
+	}
+}
+
+func TestLocalAdapterRejectsPathTraversal(t *testing.T) {
+	signer, _ := storage.NewURLSigner("http://localhost", []byte(testSigningSecret))
+	s := storage.NewLocalAdapter(t.TempDir(), signer)
+	if err := s.PutObject(context.Background(), "bucket", "../outside", strings.NewReader("x"), "", nil); err == nil {
+		t.Error("expected a path escaping the bucket to be rejected")
+	}
+}
+
+func TestNewURLSignerRejectsWeakSecrets(t *testing.T) {
+	for _, secret := range []string{"", "change-me", "CHANGE-ME", "0123456789"} {
+		if _, err := storage.NewURLSigner("http://localhost", []byte(secret)); err == nil {
+			t.Errorf("NewURLSigner() with the secret %q, want an error", secret)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package validator
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+
+	"pii-encrypt-example/pkg/response"
+)
+
+// messages are the human readable explanation of every rule, "%s" is replaced by the rule parameter.
+var messages = map[string]string{
+	"required":          "is required",
+	"email":             "must be a valid email address",
+	"default-name":      "must only contain letters, spaces, dots, commas, apostrophes and dashes, without leading or trailing spaces",
+	"idn-mobile-number": "must be an Indonesian mobile number, e.g. 081234567890",
+	"ISO8601date":       "must be a date in YYYY-MM-DD format",
+	"nik":               "must be a valid 16 digits NIK",
+	"npwp":              "must be a valid NPWP",
+	"numeric":           "must only contain digits",
+	"len":               "must be exactly %s characters long",
+	"min":               "must be at least %s characters long",
+	"max":               "must be at most %s characters long",
+	"oneof":             "must be one of [%s]",
+}
+
+const defaultMessage = "is invalid"
+
+// FieldErrors translates the error returned by validator.Struct into one FieldError per failing field and rule.
+// The submitted values are never part of the result.
+func FieldErrors(err error) (fieldErrors []response.FieldError) {
+	validationErrors, ok := err.(validator.ValidationErrors)
+	if !ok {
+		return nil
+	}
+
+	fieldErrors = make([]response.FieldError, len(validationErrors))
+	for i, e := range validationErrors {
+		field := e.Namespace()
+		// drop the struct name, e.g. "UserRequest.email" becomes "email".
+		if _, rest, found := strings.Cut(field, "."); found {
+			field = rest
+		}
+
+		message, ok := messages[e.Tag()]
+		if !ok {
+			message = defaultMessage
+		}
+		if strings.Contains(message, "%s") {
+			message = fmt.Sprintf(message, e.Param())
+		}
+
+		fieldErrors[i] = response.FieldError{
+			Field:   field,
+			Rule:    e.Tag(),
+			Message: fmt.Sprintf("%s %s", field, message),
+		}
+	}
+
+	return
+}
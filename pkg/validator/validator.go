@@ -0,0 +1,140 @@
+package validator
+
+import (
+	"net/mail"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+)
+
+const iso8601DateLayout = "2006-01-02"
+
+var (
+	defaultNameRegex     = regexp.MustCompile(`^[\p{L}][\p{L}\p{M} .,'-]*$`)
+	idnMobileNumberRegex = regexp.MustCompile(`^(\+62|62|0)8[1-9][0-9]{6,11}$`)
+	digitsRegex          = regexp.MustCompile(`^[0-9]+$`)
+)
+
+// SetTagName reports the json name of a field instead of its go name.
+func SetTagName(field reflect.StructField) string {
+	name := strings.SplitN(field.Tag.Get("json"), ",", 2)[0]
+	if name == "-" {
+		return ""
+	}
+	if name == "" {
+		return field.Name
+	}
+	return name
+}
+
+// SetDefaultName validates a person name: letters, spaces and the punctuation common in names, at most 100 characters.
+// Leading and trailing spaces are rejected, the name is hashed as given and " John " would never be found by "John".
+func SetDefaultName(fl validator.FieldLevel) bool {
+	name := fl.Field().String()
+	return name == strings.TrimSpace(name) && len([]rune(name)) <= 100 && defaultNameRegex.MatchString(name)
+}
+
+// SetIDNMobileNumber validates an Indonesian mobile number written as 08xx, 628xx or +628xx.
+// Spaces and dashes used as separators are allowed.
+func SetIDNMobileNumber(fl validator.FieldLevel) bool {
+	number := strings.NewReplacer(" ", "", "-", "").Replace(fl.Field().String())
+	return idnMobileNumberRegex.MatchString(number)
+}
+
+// SetISO8601dateFormat validates a calendar date in the ISO 8601 extended format, e.g. 1990-01-31.
+func SetISO8601dateFormat(fl validator.FieldLevel) bool {
+	_, err := time.Parse(iso8601DateLayout, fl.Field().String())
+	return err == nil
+}
+
+// SetEmail validates a bare email address, display names such as "John <john@mail.com>" are rejected
+// and the domain must contain at least one dot.
+func SetEmail(fl validator.FieldLevel) bool {
+	email := fl.Field().String()
+	address, err := mail.ParseAddress(email)
+	if err != nil || address.Address != email || address.Name != "" {
+		return false
+	}
+
+	at := strings.LastIndex(email, "@")
+	domain := email[at+1:]
+	return strings.Contains(domain, ".") && !strings.HasPrefix(domain, ".") && !strings.HasSuffix(domain, ".")
+}
+
+// SetNIK validates an Indonesian national identity number (Nomor Induk Kependudukan).
+func SetNIK(fl validator.FieldLevel) bool {
+	return IsNIK(fl.Field().String())
+}
+
+// SetNPWP validates an Indonesian taxpayer identification number (Nomor Pokok Wajib Pajak),
+// either the 15 digits format or the 16 digits format introduced in 2024, with or without its dots and dash.
+func SetNPWP(fl validator.FieldLevel) bool {
+	return IsNPWP(fl.Field().String())
+}
+
+// IsNIK reports whether s is a well formed NIK: 16 digits made of a known province code, regency and district codes,
+// the date of birth as DDMMYY, where women have 40 added to the day, and a non zero registration sequence.
+func IsNIK(s string) bool {
+	if len(s) != 16 || !digitsRegex.MatchString(s) {
+		return false
+	}
+
+	if _, ok := provinceCodes[s[0:2]]; !ok {
+		return false
+	}
+	if s[2:4] == "00" || s[4:6] == "00" {
+		return false
+	}
+
+	day := atoi(s[6:8])
+	if day > 40 {
+		day -= 40
+	}
+	month := atoi(s[8:10])
+	year := atoi(s[10:12])
+	dateOfBirth := time.Date(2000+year, time.Month(month), day, 0, 0, 0, 0, time.UTC)
+	if day < 1 || month < 1 || month > 12 || dateOfBirth.Day() != day || dateOfBirth.Month() != time.Month(month) {
+		return false
+	}
+
+	return s[12:16] != "0000"
+}
+
+// IsNPWP reports whether s is a well formed NPWP.
+func IsNPWP(s string) bool {
+	digits := strings.NewReplacer(".", "", "-", "").Replace(s)
+	if !digitsRegex.MatchString(digits) {
+		return false
+	}
+
+	switch len(digits) {
+	case 15:
+		return true
+	case 16:
+		// a 16 digits NPWP is either the NIK of its holder or the 15 digits NPWP prefixed by a zero.
+		return digits[0] == '0' || IsNIK(digits)
+	}
+	return false
+}
+
+func atoi(s string) (n int) {
+	for _, r := range s {
+		n = n*10 + int(r-'0')
+	}
+	return
+}
+
+// provinceCodes are the first two digits of a NIK, as defined by Kemendagri.
+var provinceCodes = map[string]struct{}{
+	"11": {}, "12": {}, "13": {}, "14": {}, "15": {}, "16": {}, "17": {}, "18": {}, "19": {},
+	"21": {},
+	"31": {}, "32": {}, "33": {}, "34": {}, "35": {}, "36": {},
+	"51": {}, "52": {}, "53": {},
+	"61": {}, "62": {}, "63": {}, "64": {}, "65": {},
+	"71": {}, "72": {}, "73": {}, "74": {}, "75": {}, "76": {},
+	"81": {}, "82": {},
+	"91": {}, "92": {}, "93": {}, "94": {}, "95": {}, "96": {},
+}
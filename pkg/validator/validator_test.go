@@ -0,0 +1,123 @@
+package validator_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/go-playground/validator/v10"
+
+	customvalidator "pii-encrypt-example/pkg/validator"
+)
+
+type payload struct {
+	Name        string `json:"name" validate:"required,default-name"`
+	Email       string `json:"email" validate:"required,email"`
+	PhoneNumber string `json:"phoneNumber" validate:"omitempty,idn-mobile-number"`
+	NIK         string `json:"nik" validate:"omitempty,nik"`
+	NPWP        string `json:"npwp" validate:"omitempty,npwp"`
+	DateOfBirth string `json:"dateOfBirth" validate:"omitempty,ISO8601date"`
+}
+
+func newValidator() *validator.Validate {
+	v := validator.New()
+	v.RegisterTagNameFunc(customvalidator.SetTagName)
+	v.RegisterValidation("default-name", customvalidator.SetDefaultName)
+	v.RegisterValidation("idn-mobile-number", customvalidator.SetIDNMobileNumber)
+	v.RegisterValidation("ISO8601date", customvalidator.SetISO8601dateFormat)
+	v.RegisterValidation("email", customvalidator.SetEmail)
+	v.RegisterValidation("nik", customvalidator.SetNIK)
+	v.RegisterValidation("npwp", customvalidator.SetNPWP)
+	return v
+}
+
+func TestValidator(t *testing.T) {
+	v := newValidator()
+
+	valid := payload{
+		Name:        "Siti Nur'aini",
+		Email:       "siti@mail.co.id",
+		PhoneNumber: "+62 812-3456-7890",
+		NIK:         "3201015108900001",
+		NPWP:        "01.234.567.8-901.000",
+		DateOfBirth: "1990-08-11",
+	}
+	if err := v.Struct(valid); err != nil {
+		t.Fatalf("expected valid payload, got %v", err)
+	}
+
+	invalid := payload{
+		Name:        "R2D2",
+		Email:       "John <john@mail.com>",
+		PhoneNumber: "021-555-1234",
+		NIK:         "3201013213900001",
+		NPWP:        "1234",
+		DateOfBirth: "11-08-1990",
+	}
+	err := v.Struct(invalid)
+	errs, ok := err.(validator.ValidationErrors)
+	if !ok {
+		t.Fatalf("expected validation errors, got %v", err)
+	}
+
+	failed := map[string]bool{}
+	for _, e := range errs {
+		failed[e.Field()] = true
+	}
+	for _, field := range []string{"name", "email", "phoneNumber", "nik", "npwp", "dateOfBirth"} {
+		if !failed[field] {
+			t.Errorf("expected %s to fail", field)
+		}
+	}
+}
+
+func TestSetDefaultName(t *testing.T) {
+	v := newValidator()
+
+	cases := map[string]bool{
+		"Siti Nur'aini":   true,
+		"Jean-Luc Picard": true,
+		" John":           false,
+		"John ":           false,
+		"R2D2":            false,
+	}
+	for name, expected := range cases {
+		if got := v.Var(name, "default-name") == nil; got != expected {
+			t.Errorf("default-name of %q = %v, expected %v", name, got, expected)
+		}
+	}
+}
+
+func TestIsNIK(t *testing.T) {
+	cases := map[string]bool{
+		"3201015108900001": true,  // woman born 11 August 1990
+		"3201011108900001": true,  // man born 11 August 1990
+		"9901011108900001": false, // unknown province
+		"3201013002900001": false, // 30 February
+		"3201011108900000": false, // zero sequence
+		"320101110890000":  false,
+		"32010111089000a1": false,
+	}
+	for nik, expected := range cases {
+		if got := customvalidator.IsNIK(nik); got != expected {
+			t.Errorf("IsNIK(%q) = %v, expected %v", nik, got, expected)
+		}
+	}
+}
+
+func TestFieldErrors(t *testing.T) {
+	v := newValidator()
+
+	err := v.Struct(payload{Name: "Siti", Email: "siti-at-mail.com"})
+	fieldErrors := customvalidator.FieldErrors(err)
+	if len(fieldErrors) != 1 {
+		t.Fatalf("expected one field error, got %+v", fieldErrors)
+	}
+
+	fieldError := fieldErrors[0]
+	if fieldError.Field != "email" || fieldError.Rule != "email" {
+		t.Errorf("unexpected field error %+v", fieldError)
+	}
+	if strings.Contains(fieldError.Message, "siti-at-mail.com") {
+		t.Errorf("expected message not to echo the submitted value, got %q", fieldError.Message)
+	}
+}
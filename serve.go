@@ -0,0 +1,284 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"expvar"
+	"net/http"
+	"os"
+	"os/signal"
+	auditv1 "pii-encrypt-example/cmd/audit/v1"
+	"pii-encrypt-example/cmd/document/v1"
+	"pii-encrypt-example/cmd/user/v1"
+	"pii-encrypt-example/configs"
+	"pii-encrypt-example/server"
+	"syscall"
+	"time"
+
+	gcstorage "cloud.google.com/go/storage"
+	"github.com/Shopify/sarama"
+	"github.com/go-redis/redis/v8"
+	"github.com/gorilla/mux"
+	"github.com/rs/cors"
+	"github.com/sirupsen/logrus"
+
+	"pii-encrypt-example/pkg/audit"
+	"pii-encrypt-example/pkg/crypto"
+	"pii-encrypt-example/pkg/enumeration"
+	"pii-encrypt-example/pkg/middleware"
+	"pii-encrypt-example/pkg/outbox"
+	"pii-encrypt-example/pkg/ratelimit"
+	"pii-encrypt-example/pkg/response"
+	"pii-encrypt-example/pkg/storage"
+)
+
+// serve runs the http server and the kafka workers until SIGINT or SIGTERM.
+func serve(logger *logrus.Logger, keyring *crypto.Keyring) {
+	router := mux.NewRouter()
+	router.HandleFunc("/todo", index)
+
+	basicAuthMiddleware := middleware.NewBasicAuth(cfg.BasicAuth.Username, cfg.BasicAuth.Password)
+
+	router.HandleFunc("/debug/vars", basicAuthMiddleware.Verify(expvar.Handler().ServeHTTP)).Methods(http.MethodGet)
+
+	validator := newValidator()
+
+	// set the database of the users
+	databases := openUserDatabases(logger)
+	userRepository := databases.userRepository
+
+	// set redis cache of the users, it holds the encrypted rows only
+	var redisClient *redis.Client
+	if cfg.Redis.CacheEnable || (cfg.RateLimit.Enable && cfg.RateLimit.Driver == "redis") || (cfg.Enumeration.Enable && cfg.Enumeration.Driver == "redis") {
+		redisClient = redis.NewClient(cfg.Redis.Options)
+		if err := redisClient.Ping(context.Background()).Err(); err != nil {
+			logger.Fatal(err)
+		}
+	}
+	if cfg.Redis.CacheEnable {
+		userRepository = user.NewCachedUserRepository(logger, redisClient, cfg.Redis.CacheTTL, cfg.Redis.CacheReplicaLag, userRepository)
+	}
+
+	// rate limit the api per client ip before the authentication and per principal after it,
+	// the search queries have a budget of their own
+	userAuthMiddleware, searchAuthMiddleware := basicAuthMiddleware, basicAuthMiddleware
+	if cfg.RateLimit.Enable {
+		limiter := newRateLimiter(logger, redisClient)
+		apiClientIPRateLimit := middleware.NewClientIPRateLimit(logger, limiter, "api", rateLimitRule(cfg.RateLimit.ClientIP))
+		apiPrincipalRateLimit := middleware.NewPrincipalRateLimit(logger, limiter, "api", rateLimitRule(cfg.RateLimit.Principal))
+		searchClientIPRateLimit := middleware.NewClientIPRateLimit(logger, limiter, "search", rateLimitRule(cfg.RateLimit.SearchClientIP))
+		searchPrincipalRateLimit := middleware.NewPrincipalRateLimit(logger, limiter, "search", rateLimitRule(cfg.RateLimit.SearchPrincipal))
+		userAuthMiddleware = middleware.Chain(apiClientIPRateLimit, basicAuthMiddleware, apiPrincipalRateLimit)
+		searchAuthMiddleware = middleware.Chain(apiClientIPRateLimit, searchClientIPRateLimit, basicAuthMiddleware, apiPrincipalRateLimit, searchPrincipalRateLimit)
+	} else {
+		logger.Warn("rate limit is disabled")
+	}
+
+	// detect the enumeration of the users, the principals crossing the thresholds are asked a captcha or locked out
+	if cfg.Enumeration.Enable {
+		detector := enumeration.NewDetector(logger, newEnumerationStore(logger, redisClient), enumerationKey(logger), cfg.Enumeration.LockoutDuration, enumerationWindows()...)
+		var captcha middleware.RecaptchaRouteMiddleware
+		if cfg.Captcha.Status == "active" {
+			captcha = middleware.NewRecaptcha(logger, cfg.Captcha.Host, cfg.Captcha.Secret, cfg.Captcha.Status, cfg.Captcha.AllowedOrigins)
+		} else {
+			logger.Warn("captcha is inactive, the principals to step up are refused")
+		}
+		searchAuthMiddleware = middleware.Chain(searchAuthMiddleware, middleware.NewEnumerationGuard(logger, detector, captcha))
+	} else {
+		logger.Warn("enumeration detection is disabled")
+	}
+
+	// record every plaintext access, the audit log is only readable with the admin credentials
+	auditor := audit.NewAuditor(logger, cfg.Application.Timezone, databases.auditRepository)
+	if cfg.AdminBasicAuth.Username != "" && cfg.AdminBasicAuth.Password != "" {
+		adminAuthMiddleware := middleware.NewBasicAuth(cfg.AdminBasicAuth.Username, cfg.AdminBasicAuth.Password)
+		auditUsecase := auditv1.NewAuditUsecase(logger, databases.auditRepository)
+		auditv1.NewAuditHTTPHandler(logger, router, adminAuthMiddleware, auditUsecase)
+	} else {
+		logger.Warn("admin basic auth is not configured, the audit log endpoint is disabled")
+	}
+
+	// sign the head of the audit log chain periodically
+	ctxWorker, stopWorkers := context.WithCancel(context.Background())
+	signingKey, err := auditSigningKey()
+	if err != nil {
+		logger.Fatal(err)
+	}
+	if signingKey != nil {
+		checkpointer := audit.NewCheckpointer(logger, cfg.Application.Timezone, databases.auditRepository, signingKey, cfg.Audit.CheckpointInterval)
+		go checkpointer.Run(ctxWorker)
+	} else {
+		logger.Warn("audit signing key is not configured, the audit log checkpoints are not signed")
+	}
+
+	userUsecase := user.NewUserUsecase(logger, cfg.Application.Timezone, keyring, validator, auditor, userRepository, databases.outboxRepository)
+	user.NewUserHTTPHandler(logger, router, userAuthMiddleware, searchAuthMiddleware, validator, userUsecase)
+
+	// relay the outbox events to kafka and consume the submitted users, without brokers the events are kept in the outbox until a relay runs
+	var producer sarama.SyncProducer
+	var consumerGroup sarama.ConsumerGroup
+	if len(cfg.SaramaKafka.Addresses) > 0 && cfg.SaramaKafka.Addresses[0] != "" {
+		producer, err = sarama.NewSyncProducer(cfg.SaramaKafka.Addresses, cfg.SaramaKafka.Config)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		relay := outbox.NewRelay(logger, databases.outboxRepository, producer, cfg.SaramaKafka.UserEventTopic, time.Second, 100)
+		go relay.Run(ctxWorker)
+
+		if cfg.SaramaKafka.UserCreateTopic != "" {
+			consumerGroup, err = sarama.NewConsumerGroup(cfg.SaramaKafka.Addresses, cfg.SaramaKafka.ConsumerGroup, cfg.SaramaKafka.Config)
+			if err != nil {
+				logger.Fatal(err)
+			}
+			userConsumer := user.NewUserConsumer(logger, validator, userUsecase, producer, cfg.SaramaKafka.UserCreateDeadLetterTopic)
+			go userConsumer.Run(ctxWorker, consumerGroup, cfg.SaramaKafka.UserCreateTopic)
+		}
+	} else {
+		logger.Warn("kafka brokers are not configured, the user events are not published")
+	}
+
+	// set storage, the export and the documents are only available when a storage driver is configured
+	var gcsClient *gcstorage.Client
+	var objectStorage storage.Storage
+	switch cfg.Storage.Driver {
+	case "gcs":
+		gcsClient, err = gcstorage.NewClient(context.Background())
+		if err != nil {
+			logger.Fatal(err)
+		}
+		checksum, err := storage.ParseChecksum(cfg.GCPStorage.Checksum)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		objectStorage = storage.NewGCSAdapter(gcsClient, cfg.GCPStorage.AccessID, cfg.GCPStorage.PrivateKey,
+			storage.WithChecksum(checksum),
+			storage.WithRetry(cfg.GCPStorage.RetryMaxAttempts, time.Millisecond*500, time.Second*10),
+		)
+	case "local", "memory":
+		signer, err := storage.NewURLSigner(cfg.Storage.BaseURL, []byte(cfg.Storage.SigningSecret))
+		if err != nil {
+			logger.Fatal(err)
+		}
+		objectStorage = storage.NewMemoryAdapter(signer)
+		if cfg.Storage.Driver == "local" {
+			objectStorage = storage.NewLocalAdapter(cfg.Storage.LocalRoot, signer)
+		}
+		router.PathPrefix("/storage/").Handler(http.StripPrefix("/storage", storage.NewSignedURLHandler(objectStorage, signer)))
+	}
+	var userExportUsecase user.UserExportUsecase
+	if objectStorage != nil {
+		userExportUsecase = user.NewUserExportUsecase(logger, cfg.Application.Timezone, keyring, objectStorage, cfg.Storage.Bucket, auditor, userRepository)
+		user.NewUserExportHTTPHandler(logger, router, userAuthMiddleware, validator, userExportUsecase)
+
+		// the documents reference the users with a foreign key, they are only kept in the sql databases
+		if databases.dbReadWrite != nil {
+			documentRepository := document.NewDocumentRepository(logger, databases.dialect, databases.dbReadOnly, databases.dbReadWrite, "user_document", "user_encrypt")
+			documentUsecase := document.NewDocumentUsecase(logger, cfg.Application.Timezone, keyring, objectStorage, cfg.Storage.Bucket, auditor, documentRepository)
+			document.NewDocumentHTTPHandler(logger, router, userAuthMiddleware, validator, documentUsecase)
+		}
+	}
+
+	trustedProxies, err := middleware.ParseTrustedProxies(cfg.Application.TrustedProxies)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	handler := middleware.NewClientDevice(trustedProxies).Handler(router)
+	// set cors
+	handler = cors.New(cors.Options{
+		AllowedOrigins:   cfg.Application.AllowedOrigins,
+		AllowedMethods:   []string{http.MethodPost, http.MethodGet, http.MethodPut, http.MethodDelete},
+		AllowedHeaders:   []string{"Origin", "Accept", "Content-Type", "X-Requested-With", "Authorization", response.HeaderRequestID, middleware.HeaderRecaptchaToken},
+		ExposedHeaders:   []string{response.HeaderRequestID},
+		AllowCredentials: true,
+	}).Handler(handler)
+	handler = middleware.NewRecovery(logger, true).Handler(handler)
+	handler = middleware.RequestIDMiddleware(handler)
+
+	// initiate server
+	srv := server.NewServer(logger, handler, cfg.Application.Port)
+	srv.Start()
+
+	sigterm := make(chan os.Signal, 1)
+	signal.Notify(sigterm, syscall.SIGINT, syscall.SIGTERM)
+	<-sigterm
+
+	srv.Close()
+	stopWorkers()
+	if userExportUsecase != nil {
+		// the running exports are recorded as failed before the storage is closed
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*15)
+		if err := userExportUsecase.Shutdown(ctx); err != nil {
+			logger.Error(err)
+		}
+		cancel()
+	}
+	if consumerGroup != nil {
+		consumerGroup.Close()
+	}
+	if producer != nil {
+		producer.Close()
+	}
+	if gcsClient != nil {
+		gcsClient.Close()
+	}
+	if redisClient != nil {
+		redisClient.Close()
+	}
+	databases.Close()
+}
+
+// newRateLimiter returns the limiter of the configured driver, the redis one shares the buckets between the instances.
+func newRateLimiter(logger *logrus.Logger, redisClient *redis.Client) ratelimit.Limiter {
+	switch cfg.RateLimit.Driver {
+	case "redis":
+		return ratelimit.NewRedisLimiter(redisClient, "ratelimit:")
+	case "memory":
+		return ratelimit.NewMemoryLimiter()
+	}
+	logger.Fatalf("unknown rate limit driver %q", cfg.RateLimit.Driver)
+	return nil
+}
+
+func rateLimitRule(rule configs.RateLimitRule) ratelimit.Rule {
+	return ratelimit.PerMinute(rule.PerMinute, rule.Burst)
+}
+
+// newEnumerationStore returns the store of the configured driver, the redis one tracks the principals across the instances.
+func newEnumerationStore(logger *logrus.Logger, redisClient *redis.Client) enumeration.Store {
+	switch cfg.Enumeration.Driver {
+	case "redis":
+		return enumeration.NewRedisStore(redisClient, "enumeration:")
+	case "memory":
+		return enumeration.NewMemoryStore()
+	}
+	logger.Fatalf("unknown enumeration driver %q", cfg.Enumeration.Driver)
+	return nil
+}
+
+// enumerationKey returns the key of the queries, a random one when unset which the instances cannot share.
+func enumerationKey(logger *logrus.Logger) []byte {
+	if cfg.Enumeration.Key != "" {
+		return []byte(cfg.Enumeration.Key)
+	}
+	if cfg.Enumeration.Driver == "redis" {
+		logger.Warn("enumeration key is not configured, the instances count the same queries apart")
+	}
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		logger.Fatal(err)
+	}
+	return key
+}
+
+func enumerationWindows() []enumeration.Window {
+	windows := make([]enumeration.Window, 0, len(cfg.Enumeration.Windows))
+	for _, window := range cfg.Enumeration.Windows {
+		windows = append(windows, enumeration.Window{Duration: window.Duration, Alert: window.Alert, StepUp: window.StepUp, Lockout: window.Lockout})
+	}
+	return windows
+}
+
+func index(w http.ResponseWriter, r *http.Request) {
+	resp := response.NewSuccessResponse(nil, response.StatOK, indexMessage)
+	response.JSON(w, resp)
+}